@@ -34,6 +34,17 @@ type AgentEvent struct {
 
 	// For turn_complete events - populated when a MetricsCollector is configured
 	TurnMetrics *TurnMetrics
+
+	// For approval_required events
+	Approvals []ApprovalRequest
+
+	// For message_delivered events: the QueuedMessage ID. Content holds
+	// the message.
+	MessageID string
+
+	// For content_filter events: annotations from the provider's content
+	// filter that did not block the response (Azure OpenAI).
+	ContentFilter []ContentFilterResult
 }
 
 // AgentEventType categorizes agent events.
@@ -42,6 +53,7 @@ type AgentEventType string
 const (
 	AgentEventMessageStart   AgentEventType = "message_start"
 	AgentEventContentDelta   AgentEventType = "content_delta"
+	AgentEventReasoningDelta AgentEventType = "reasoning_delta"
 	AgentEventMessageEnd     AgentEventType = "message_end"
 	AgentEventToolUseStart   AgentEventType = "tool_use_start"
 	AgentEventToolUseDelta   AgentEventType = "tool_use_delta"
@@ -52,6 +64,15 @@ const (
 	AgentEventComplete       AgentEventType = "complete"
 	AgentEventSkillsSelected AgentEventType = "skills_selected"
 	AgentEventTodosUpdated   AgentEventType = "todos_updated"
+	// AgentEventApprovalRequired is the last event of a run paused by a
+	// PermissionDecision with Ask; Approvals lists the calls to decide.
+	AgentEventApprovalRequired AgentEventType = "approval_required"
+	// AgentEventMessageDelivered acknowledges that a steering message was
+	// added to the conversation sent to the model.
+	AgentEventMessageDelivered AgentEventType = "message_delivered"
+	// AgentEventContentFilter reports content filter annotations on the
+	// current response; ContentFilter holds them.
+	AgentEventContentFilter AgentEventType = "content_filter"
 )
 
 // Agent orchestrates Claude with custom tools in an agentic loop.
@@ -67,6 +88,7 @@ type Agent struct {
 	metrics        *MetricsCollector
 	parallelTools  bool
 	retry          *RetryConfig
+	repairInput    bool
 	budget         *BudgetConfig
 	history        *HistoryConfig
 	todoStore      *TodoStore
@@ -74,6 +96,7 @@ type Agent struct {
 	mu       sync.Mutex
 	running  bool
 	cancelFn context.CancelFunc
+	steering *SteeringQueue
 }
 
 // AgentConfig configures an Agent.
@@ -117,6 +140,14 @@ type AgentConfig struct {
 	// Per-tool RetryConfig on ToolDefinition takes precedence over this global setting.
 	Retry *RetryConfig
 
+	// RepairToolInput fixes malformed tool input JSON from the model
+	// (trailing commas, single quotes, unquoted keys, truncated objects)
+	// before ValidateInput and the handler run. Repairs are recorded in
+	// ToolResultMetadata and ToolStats. Input that cannot be repaired, or
+	// that does not decode into the handler's type, gets an error that
+	// quotes the tool's input schema instead of Go's unmarshal error.
+	RepairToolInput bool
+
 	// Budget sets resource limits (tokens, cost, time) for the session.
 	// The session stops with BudgetExceededError when any limit is hit.
 	Budget *BudgetConfig
@@ -159,6 +190,7 @@ func NewAgent(cfg AgentConfig) *Agent {
 		metrics:        cfg.Metrics,
 		parallelTools:  cfg.ParallelTools,
 		retry:          cfg.Retry,
+		repairInput:    cfg.RepairToolInput,
 		budget:         cfg.Budget,
 		history:        cfg.History,
 	}
@@ -188,11 +220,17 @@ func (a *Agent) Run(ctx context.Context, prompt string) (<-chan AgentEvent, erro
 
 	ctx, cancel := context.WithCancel(ctx)
 	a.cancelFn = cancel
+	a.steering = steeringFor(ctx)
+	if a.steering == nil {
+		a.steering = NewSteeringQueue()
+	}
+	a.steering.open()
+	steering := a.steering
 	a.mu.Unlock()
 
 	events := make(chan AgentEvent, 100)
 
-	go a.runLoop(ctx, prompt, events)
+	go a.runLoop(ctx, prompt, steering, events)
 
 	return events, nil
 }
@@ -207,9 +245,10 @@ func (a *Agent) Stop() {
 }
 
 // runLoop is the main agent execution loop.
-func (a *Agent) runLoop(ctx context.Context, prompt string, events chan<- AgentEvent) {
+func (a *Agent) runLoop(ctx context.Context, prompt string, steering *SteeringQueue, events chan<- AgentEvent) {
 	defer close(events)
 	defer func() {
+		steering.close()
 		a.mu.Lock()
 		a.running = false
 		a.mu.Unlock()
@@ -258,8 +297,17 @@ func (a *Agent) runLoop(ctx context.Context, prompt string, events chan<- AgentE
 			return
 		}
 
+		// Deliver messages the user sent during the previous turn.
+		if msgs := steering.drain(); len(msgs) > 0 {
+			for _, m := range msgs {
+				history = append(history, ConversationMessage{Role: "user", Content: m.Content})
+			}
+			emitDelivered(msgs, events)
+		}
+
 		// Apply history compaction before sending to the LLM
 		llmHistory := compactHistory(ctx, history, a.history)
+		llmHistory = fitHistoryToBudget(ctx, llmHistory, a.tools, a.history)
 
 		// Stream response from Claude, tracking LLM latency
 		llmStart := time.Now()
@@ -278,7 +326,13 @@ func (a *Agent) runLoop(ctx context.Context, prompt string, events chan<- AgentE
 			}
 		}
 
-		// No tool calls = we're done
+		// No tool calls = we're done, unless the user sent more while the
+		// model was replying.
+		if len(toolCalls) == 0 && !steering.closeIfEmpty() {
+			history = append(history, ConversationMessage{Role: "assistant", Content: assistantContent})
+			events <- AgentEvent{Type: AgentEventTurnComplete}
+			continue
+		}
 		if len(toolCalls) == 0 {
 			if result != nil && result.StopReason == "" {
 				result.StopReason = "end_turn"
@@ -484,7 +538,7 @@ func (a *Agent) executeTools(
 	toolCalls []ToolCall,
 	events chan<- AgentEvent,
 ) []ToolResponse {
-	return runToolsSmart(ctx, toolCalls, a.tools, a.hooks, a.canUseTool, a.retry, a.metrics, events, a.parallelTools)
+	return runToolsSmart(ctx, toolCalls, a.tools, a.hooks, a.canUseTool, a.retry, a.repairInput, a.metrics, events, a.parallelTools)
 }
 
 // historyToMessages converts conversation history to Message types for CLI communication.
@@ -517,23 +571,13 @@ func (a *Agent) historyToMessages(history []ConversationMessage) []Message {
 	return messages
 }
 
-// RunSync executes the agent and collects all text output.
+// RunSync executes the agent and returns the assistant's text output.
 func (a *Agent) RunSync(ctx context.Context, prompt string) (string, error) {
 	events, err := a.Run(ctx, prompt)
 	if err != nil {
 		return "", err
 	}
-
-	var content string
-	for event := range events {
-		if event.Error != nil {
-			return content, event.Error
-		}
-		if event.Content != "" {
-			content += event.Content
-		}
-	}
-	return content, nil
+	return collectText(events)
 }
 
 // Close gracefully shuts down the agent, sending SIGINT then SIGKILL after timeout.
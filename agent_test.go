@@ -310,3 +310,23 @@ func TestAgentSendCancelledContext(t *testing.T) {
 		t.Fatalf("expected context.Canceled, got: %v", err)
 	}
 }
+
+func TestRunSyncReturnsOnlyContent(t *testing.T) {
+	tools := NewToolRegistry()
+	tools.Register(ToolDefinition{Name: "lookup"}, func(context.Context, json.RawMessage) (string, error) {
+		return "tool output", nil
+	})
+	provider := &cassetteScriptProvider{responses: []ChatResponse{
+		{Reasoning: "I should look it up.", ToolCalls: []ToolCall{{ID: "t1", Name: "lookup", Input: json.RawMessage(`{}`)}}, StopReason: "tool_use"},
+		{Reasoning: "Now answer.", Content: "Paris.", StopReason: "end_turn"},
+	}}
+	agent := NewAPIAgent(APIAgentConfig{Provider: provider, Tools: tools})
+
+	got, err := agent.RunSync(context.Background(), "Capital of France?")
+	if err != nil {
+		t.Fatalf("RunSync: %v", err)
+	}
+	if got != "Paris." {
+		t.Errorf("RunSync = %q, want only the content deltas", got)
+	}
+}
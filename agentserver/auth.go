@@ -0,0 +1,30 @@
+package agentserver
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var errUnauthorized = errors.New("unauthorized")
+
+// BearerAuth returns Middleware that rejects requests without an
+// "Authorization: Bearer <token>" header matching one of tokens.
+func BearerAuth(tokens ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if ok {
+				for _, token := range tokens {
+					if token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="agent"`)
+			writeError(w, http.StatusUnauthorized, errUnauthorized)
+		})
+	}
+}
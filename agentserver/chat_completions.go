@@ -0,0 +1,432 @@
+package agentserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	claude "github.com/character-ai/claude-agent-sdk-go"
+)
+
+// ChatCompletionsConfig configures a ChatCompletions handler.
+type ChatCompletionsConfig struct {
+	// Agent runs every request, with its tools, hooks and subagents.
+	Agent *claude.APIAgent
+	// Middleware wraps every route, outermost first.
+	Middleware []Middleware
+}
+
+// ChatCompletions is an http.Handler that serves an APIAgent over the
+// OpenAI Chat Completions protocol, so that any OpenAI client can use it:
+//
+//	POST /v1/chat/completions
+//
+// Each request runs the agent loop on the request's messages; the agent's
+// own tool calls happen on the server and only its text reaches the
+// client. Streaming requests get chat.completion.chunk events. Tools
+// declared in the request are offered to the model, and a call to one
+// ends the response with finish_reason "tool_calls" for the client to run
+// it and send the result in a follow-up request.
+//
+// The agent's configuration decides the model and sampling parameters;
+// the request's model is echoed back and other parameters are ignored.
+// Runs cannot pause for approvals, so the agent's CanUseTool should not
+// ask. Create it with NewChatCompletions.
+type ChatCompletions struct {
+	agent   *claude.APIAgent
+	handler http.Handler
+}
+
+// NewChatCompletions creates a ChatCompletions handler.
+func NewChatCompletions(cfg ChatCompletionsConfig) *ChatCompletions {
+	c := &ChatCompletions{agent: cfg.Agent}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", c.complete)
+
+	var h http.Handler = mux
+	for i := len(cfg.Middleware) - 1; i >= 0; i-- {
+		h = cfg.Middleware[i](h)
+	}
+	c.handler = h
+	return c
+}
+
+// ServeHTTP implements http.Handler.
+func (c *ChatCompletions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.handler.ServeHTTP(w, r)
+}
+
+// chatRequest is the supported subset of a Chat Completions request.
+type chatRequest struct {
+	Model         string        `json:"model"`
+	Messages      []chatMessage `json:"messages"`
+	Tools         []chatTool    `json:"tools,omitempty"`
+	Stream        bool          `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+}
+
+type chatMessage struct {
+	Role       string         `json:"role"`
+	Content    chatContent    `json:"content"`
+	ToolCalls  []chatToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
+}
+
+// chatContent is message content: a string, null, or an array of text and
+// image_url parts.
+type chatContent struct {
+	text   string
+	images []claude.ChatImage
+}
+
+func (c *chatContent) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &c.text)
+	}
+	var parts []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		ImageURL struct {
+			URL string `json:"url"`
+		} `json:"image_url"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	var text []string
+	for _, p := range parts {
+		switch p.Type {
+		case "text":
+			text = append(text, p.Text)
+		case "image_url":
+			img, err := chatImage(p.ImageURL.URL)
+			if err != nil {
+				return err
+			}
+			c.images = append(c.images, img)
+		default:
+			return fmt.Errorf("unsupported content part type %q", p.Type)
+		}
+	}
+	c.text = strings.Join(text, "\n")
+	return nil
+}
+
+// chatImage converts an image_url, either a base64 data URL or a link.
+func chatImage(url string) (claude.ChatImage, error) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return claude.ChatImage{URL: url}, nil
+	}
+	mediaType, encoded, ok := strings.Cut(rest, ";base64,")
+	if !ok {
+		return claude.ChatImage{}, errors.New("image data URLs must be base64")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return claude.ChatImage{}, fmt.Errorf("image data URL: %w", err)
+	}
+	return claude.ChatImage{MediaType: mediaType, Data: data}, nil
+}
+
+type chatTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Parameters  map[string]any `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type chatToolCall struct {
+	// Index is set in streamed deltas.
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// history converts the request's messages to the agent's format.
+func (req *chatRequest) history() ([]claude.ChatMessage, error) {
+	history := make([]claude.ChatMessage, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		msg := claude.ChatMessage{Content: m.Content.text}
+		switch m.Role {
+		case "system", "developer":
+			msg.Role = claude.ChatRoleSystem
+		case "user":
+			msg.Role, msg.Images = claude.ChatRoleUser, m.Content.images
+		case "assistant":
+			msg.Role = claude.ChatRoleAssistant
+			for _, tc := range m.ToolCalls {
+				// Clients send "" for calls without arguments.
+				args := strings.TrimSpace(tc.Function.Arguments)
+				if args == "" {
+					args = "{}"
+				}
+				if !json.Valid([]byte(args)) {
+					return nil, fmt.Errorf("tool call %s has invalid JSON arguments", tc.ID)
+				}
+				msg.ToolCalls = append(msg.ToolCalls, claude.ToolCall{
+					ID: tc.ID, Name: tc.Function.Name, Input: json.RawMessage(args),
+				})
+			}
+		case "tool":
+			msg.Role, msg.ToolCallID = claude.ChatRoleTool, m.ToolCallID
+		default:
+			return nil, fmt.Errorf("unsupported message role %q", m.Role)
+		}
+		history = append(history, msg)
+	}
+	return history, nil
+}
+
+// clientTools converts the request's tools.
+func (req *chatRequest) clientTools() ([]claude.ToolDefinition, error) {
+	defs := make([]claude.ToolDefinition, 0, len(req.Tools))
+	for _, t := range req.Tools {
+		if t.Type != "function" || t.Function.Name == "" {
+			return nil, errors.New("tools must be named functions")
+		}
+		schema := t.Function.Parameters
+		if schema == nil {
+			schema = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		defs = append(defs, claude.ToolDefinition{
+			Name: t.Function.Name, Description: t.Function.Description, InputSchema: schema,
+		})
+	}
+	return defs, nil
+}
+
+// chatCompletion is a chat.completion response, or a chat.completion.chunk
+// when streaming.
+type chatCompletion struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices []chatChoice `json:"choices"`
+	Usage   *chatUsage   `json:"usage,omitempty"`
+}
+
+type chatChoice struct {
+	Index        int         `json:"index"`
+	Message      *chatOutput `json:"message,omitempty"`
+	Delta        *chatOutput `json:"delta,omitempty"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+// chatOutput is an assistant message, or a delta of one.
+type chatOutput struct {
+	Role             string         `json:"role,omitempty"`
+	Content          *string        `json:"content,omitempty"`
+	ReasoningContent string         `json:"reasoning_content,omitempty"`
+	ToolCalls        []chatToolCall `json:"tool_calls,omitempty"`
+}
+
+type chatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (c *ChatCompletions) complete(w http.ResponseWriter, r *http.Request) {
+	var req chatRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&req)
+	if errors.Is(err, io.EOF) {
+		err = errors.New("empty body")
+	}
+	if err != nil {
+		writeChatError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	history, err := req.history()
+	if err != nil {
+		writeChatError(w, http.StatusBadRequest, err)
+		return
+	}
+	tools, err := req.clientTools()
+	if err != nil {
+		writeChatError(w, http.StatusBadRequest, err)
+		return
+	}
+	// RunHistory only fails when it rejects the conversation or tools.
+	events, err := c.agent.RunHistory(r.Context(), history, tools...)
+	if err != nil {
+		writeChatError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	out := chatCompletion{
+		ID:      "chatcmpl-" + randomHex(12),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+	}
+	if req.Stream {
+		c.stream(w, events, out, req.StreamOptions != nil && req.StreamOptions.IncludeUsage)
+		return
+	}
+
+	var b completionBuilder
+	for e := range events {
+		b.add(e)
+	}
+	if b.err != nil {
+		writeChatError(w, http.StatusInternalServerError, b.err)
+		return
+	}
+	msg := &chatOutput{Role: "assistant", ReasoningContent: b.reasoning.String(), ToolCalls: b.toolCalls}
+	if text := b.content.String(); text != "" || len(b.toolCalls) == 0 {
+		msg.Content = &text
+	}
+	for i := range msg.ToolCalls {
+		msg.ToolCalls[i].Index = nil
+	}
+	out.Choices = []chatChoice{{Message: msg, FinishReason: &b.finish}}
+	out.Usage = &b.usage
+	writeJSON(w, http.StatusOK, out)
+}
+
+// stream writes the run's events as chat.completion.chunk events, ending
+// with "[DONE]".
+func (c *ChatCompletions) stream(w http.ResponseWriter, events <-chan claude.AgentEvent, chunk chatCompletion, includeUsage bool) {
+	sse, err := claude.NewSSEWriter(w)
+	if err != nil {
+		for range events {
+		}
+		writeChatError(w, http.StatusInternalServerError, err)
+		return
+	}
+	chunk.Object = "chat.completion.chunk"
+	write := func(delta chatOutput, finish *string) error {
+		chunk.Choices = []chatChoice{{Delta: &delta, FinishReason: finish}}
+		return sse.WriteData(chunk)
+	}
+
+	err = write(chatOutput{Role: "assistant"}, nil)
+	var b completionBuilder
+	for e := range events {
+		// Keep draining after a failed write so the run can finish.
+		if delta := b.add(e); delta != nil && err == nil {
+			err = write(*delta, nil)
+		}
+	}
+	if err != nil {
+		return
+	}
+	if b.err != nil {
+		_ = sse.WriteData(chatErrorBody(b.err, "server_error"))
+	} else {
+		err = write(chatOutput{}, &b.finish)
+	}
+	if err == nil && includeUsage {
+		chunk.Choices, chunk.Usage = []chatChoice{}, &b.usage
+		err = sse.WriteData(chunk)
+	}
+	if err == nil {
+		_ = sse.WriteData("[DONE]")
+	}
+}
+
+// completionBuilder accumulates a run's events into a chat completion.
+type completionBuilder struct {
+	content   strings.Builder
+	reasoning strings.Builder
+	toolCalls []chatToolCall
+	finish    string
+	usage     chatUsage
+	err       error
+	// turnEnded separates the text of successive turns.
+	turnEnded bool
+}
+
+// add records an event and returns the delta to stream for it, if any.
+// Events of subagents and of the agent's own tool calls are not sent.
+func (b *completionBuilder) add(e claude.AgentEvent) *chatOutput {
+	if e.ParentToolUseID != "" {
+		return nil
+	}
+	if e.Result != nil {
+		b.usage = chatUsage{
+			PromptTokens:     e.Result.InputTokens,
+			CompletionTokens: e.Result.OutputTokens,
+			TotalTokens:      e.Result.InputTokens + e.Result.OutputTokens,
+		}
+	}
+	switch e.Type {
+	case claude.AgentEventContentDelta:
+		text := e.Content
+		if b.turnEnded && b.content.Len() > 0 {
+			text = "\n\n" + text
+		}
+		b.turnEnded = false
+		b.content.WriteString(text)
+		return &chatOutput{Content: &text}
+	case claude.AgentEventReasoningDelta:
+		b.reasoning.WriteString(e.Content)
+		return &chatOutput{ReasoningContent: e.Content}
+	case claude.AgentEventTurnComplete:
+		b.turnEnded = true
+	case claude.AgentEventApprovalRequired:
+		var calls []chatToolCall
+		for _, req := range e.Approvals {
+			if !req.ClientTool {
+				b.err = fmt.Errorf("tool call %s (%s) needs approval, which chat completions cannot give", req.ToolCallID, req.ToolName)
+				return nil
+			}
+			i := len(calls)
+			tc := chatToolCall{Index: &i, ID: req.ToolCallID, Type: "function"}
+			tc.Function.Name, tc.Function.Arguments = req.ToolName, string(req.Input)
+			calls = append(calls, tc)
+		}
+		b.toolCalls, b.finish = calls, "tool_calls"
+		return &chatOutput{ToolCalls: calls}
+	case claude.AgentEventComplete:
+		b.finish = "stop"
+		if e.Result != nil && e.Result.StopReason == "max_tokens" {
+			b.finish = "length"
+		}
+	case claude.AgentEventError:
+		if b.err == nil {
+			b.err = e.Error
+		}
+	}
+	return nil
+}
+
+// chatErrorBody is an error in the OpenAI format.
+func chatErrorBody(err error, typ string) map[string]any {
+	return map[string]any{"error": map[string]string{"message": err.Error(), "type": typ}}
+}
+
+func writeChatError(w http.ResponseWriter, status int, err error) {
+	typ := "invalid_request_error"
+	if status >= http.StatusInternalServerError {
+		typ = "server_error"
+	}
+	writeJSON(w, status, chatErrorBody(err, typ))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,262 @@
+package agentserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	claude "github.com/character-ai/claude-agent-sdk-go"
+	"github.com/character-ai/claude-agent-sdk-go/claudeagenttest"
+)
+
+func newChatServer(t *testing.T, cfg claude.APIAgentConfig) string {
+	ts := httptest.NewServer(NewChatCompletions(ChatCompletionsConfig{
+		Agent:      claude.NewAPIAgent(cfg),
+		Middleware: []Middleware{BearerAuth("secret")},
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func postChat(t *testing.T, base string, body any) *http.Response {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", base+"/v1/chat/completions", strings.NewReader(string(b)))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// readChunks reads a streamed response's data lines up to [DONE].
+func readChunks(t *testing.T, resp *http.Response) []map[string]any {
+	t.Helper()
+	var chunks []map[string]any
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "event:") {
+			t.Errorf("chunks should be unnamed events, got %q", line)
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			return chunks
+		}
+		var chunk map[string]any
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	t.Fatal("stream ended without [DONE]")
+	return nil
+}
+
+func TestChatCompletions(t *testing.T) {
+	tools := claude.NewToolRegistry()
+	tools.Register(claude.ToolDefinition{Name: "lookup"}, func(context.Context, json.RawMessage) (string, error) {
+		return "Paris", nil
+	})
+	provider := claudeagenttest.NewScriptedProvider(
+		claudeagenttest.ToolCallTurn(claudeagenttest.ToolCall("lookup", map[string]any{})),
+		claudeagenttest.TextTurn("Paris."),
+	)
+	base := newChatServer(t, claude.APIAgentConfig{Provider: provider, Tools: tools})
+
+	resp := postChat(t, base, map[string]any{
+		"model": "travel-agent",
+		"messages": []map[string]any{
+			{"role": "system", "content": "Be brief."},
+			{"role": "user", "content": []map[string]any{{"type": "text", "text": "Capital of France?"}}},
+		},
+	})
+	var out struct {
+		Object  string `json:"object"`
+		Model   string `json:"model"`
+		Choices []struct {
+			Message struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage *chatUsage `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || out.Object != "chat.completion" || out.Model != "travel-agent" ||
+		len(out.Choices) != 1 || out.Choices[0].Message.Content != "Paris." || out.Choices[0].FinishReason != "stop" || out.Usage == nil {
+		t.Fatalf("unexpected completion: %d %+v", resp.StatusCode, out)
+	}
+	if reqs := provider.Requests(); len(reqs) != 2 || reqs[0].SystemPrompt != "Be brief." {
+		t.Errorf("the agent should run its own tools with the client's system prompt, got %+v", reqs)
+	}
+}
+
+func TestChatCompletionsStreamingClientTools(t *testing.T) {
+	provider := claudeagenttest.NewScriptedProvider(
+		claudeagenttest.ToolCallTurn(claude.ToolCall{ID: "call_1", Name: "get_weather", Input: json.RawMessage(`{"city":"Paris"}`)}),
+		claudeagenttest.StreamedTextTurn("Sunny ", "today."),
+	)
+	base := newChatServer(t, claude.APIAgentConfig{Provider: provider})
+	weather := map[string]any{"type": "function", "function": map[string]any{
+		"name": "get_weather", "parameters": map[string]any{"type": "object"},
+	}}
+	messages := []map[string]any{{"role": "user", "content": "Weather in Paris?"}}
+
+	chunks := readChunks(t, postChat(t, base, map[string]any{
+		"model": "agent", "stream": true, "messages": messages, "tools": []any{weather},
+		"stream_options": map[string]any{"include_usage": true},
+	}))
+	if len(chunks) != 4 {
+		t.Fatalf("expected role, tool call, finish and usage chunks, got %v", chunks)
+	}
+	delta := chunks[1]["choices"].([]any)[0].(map[string]any)["delta"].(map[string]any)
+	call := delta["tool_calls"].([]any)[0].(map[string]any)
+	if call["id"] != "call_1" || call["index"] != 0.0 || call["function"].(map[string]any)["arguments"] != `{"city":"Paris"}` {
+		t.Errorf("unexpected tool call delta: %v", call)
+	}
+	if finish := chunks[2]["choices"].([]any)[0].(map[string]any)["finish_reason"]; finish != "tool_calls" {
+		t.Errorf("finish_reason = %v", finish)
+	}
+	if chunks[3]["usage"] == nil || len(chunks[3]["choices"].([]any)) != 0 {
+		t.Errorf("unexpected usage chunk: %v", chunks[3])
+	}
+
+	messages = append(messages,
+		map[string]any{"role": "assistant", "content": nil, "tool_calls": []any{map[string]any{
+			"id": "call_1", "type": "function", "function": map[string]any{"name": "get_weather", "arguments": `{"city":"Paris"}`},
+		}}},
+		map[string]any{"role": "tool", "tool_call_id": "call_1", "content": "sunny"},
+	)
+	chunks = readChunks(t, postChat(t, base, map[string]any{
+		"model": "agent", "stream": true, "messages": messages, "tools": []any{weather},
+	}))
+	var text string
+	for _, c := range chunks {
+		if content, ok := c["choices"].([]any)[0].(map[string]any)["delta"].(map[string]any)["content"].(string); ok {
+			text += content
+		}
+	}
+	if text != "Sunny today." {
+		t.Errorf("text = %q", text)
+	}
+	last := chunks[len(chunks)-1]["choices"].([]any)[0].(map[string]any)
+	if last["finish_reason"] != "stop" {
+		t.Errorf("finish_reason = %v", last["finish_reason"])
+	}
+}
+
+func TestChatCompletionsMixedToolTurn(t *testing.T) {
+	var looked int
+	tools := claude.NewToolRegistry()
+	tools.Register(claude.ToolDefinition{Name: "lookup"}, func(context.Context, json.RawMessage) (string, error) {
+		looked++
+		return "Paris", nil
+	})
+	provider := claudeagenttest.NewScriptedProvider(claudeagenttest.ToolCallTurn(
+		claude.ToolCall{ID: "call_1", Name: "lookup", Input: json.RawMessage(`{}`)},
+		claude.ToolCall{ID: "call_2", Name: "get_weather", Input: json.RawMessage(`{"city":"Paris"}`)},
+	))
+	base := newChatServer(t, claude.APIAgentConfig{Provider: provider, Tools: tools})
+
+	resp := postChat(t, base, map[string]any{
+		"messages": []map[string]any{{"role": "user", "content": "Weather in the capital of France?"}},
+		"tools": []any{map[string]any{"type": "function", "function": map[string]any{
+			"name": "get_weather", "parameters": map[string]any{"type": "object"},
+		}}},
+	})
+	var out struct {
+		Choices []struct {
+			Message struct {
+				ToolCalls []struct {
+					ID string `json:"id"`
+				} `json:"tool_calls"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(out.Choices) != 1 || out.Choices[0].FinishReason != "tool_calls" ||
+		len(out.Choices[0].Message.ToolCalls) != 1 || out.Choices[0].Message.ToolCalls[0].ID != "call_2" {
+		t.Fatalf("expected only the client tool call, got %d %+v", resp.StatusCode, out)
+	}
+	if looked != 0 {
+		t.Errorf("the agent's tool should not run in a turn that pauses for the client, ran %d times", looked)
+	}
+}
+
+// toolTurn is a conversation ending with the result of a lookup call made
+// with args.
+func toolTurn(args string) []map[string]any {
+	return []map[string]any{
+		{"role": "user", "content": "Weather in Paris?"},
+		{"role": "assistant", "tool_calls": []any{map[string]any{
+			"id": "call_1", "type": "function", "function": map[string]any{"name": "get_weather", "arguments": args},
+		}}},
+		{"role": "tool", "tool_call_id": "call_1", "content": "Sunny"},
+	}
+}
+
+func TestChatCompletionsEmptyToolArguments(t *testing.T) {
+	provider := claudeagenttest.NewScriptedProvider(claudeagenttest.TextTurn("It is sunny."))
+	base := newChatServer(t, claude.APIAgentConfig{Provider: provider})
+
+	resp := postChat(t, base, map[string]any{"messages": toolTurn("")})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	msgs := provider.Requests()[0].Messages
+	if got := string(msgs[1].ToolCalls[0].Input); got != "{}" {
+		t.Errorf("empty arguments should be sent as {}, got %q", got)
+	}
+}
+
+func TestChatCompletionsErrors(t *testing.T) {
+	tools := claude.NewToolRegistry()
+	tools.Register(claude.ToolDefinition{Name: "lookup"}, func(context.Context, json.RawMessage) (string, error) {
+		return "", nil
+	})
+	base := newChatServer(t, claude.APIAgentConfig{
+		Provider: claudeagenttest.NewScriptedProvider(), Tools: tools,
+	})
+	user := []map[string]any{{"role": "user", "content": "hi"}}
+
+	for name, body := range map[string]any{
+		"bad role":      map[string]any{"messages": []map[string]any{{"role": "robot", "content": "hi"}}},
+		"no user":       map[string]any{"messages": []map[string]any{{"role": "system", "content": "hi"}}},
+		"tool conflict": map[string]any{"messages": user, "tools": []any{map[string]any{"type": "function", "function": map[string]any{"name": "lookup"}}}},
+		"bad arguments": map[string]any{"messages": toolTurn("{not json")},
+	} {
+		resp := postChat(t, base, body)
+		var out struct {
+			Error struct {
+				Message string `json:"message"`
+				Type    string `json:"type"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		if resp.StatusCode != http.StatusBadRequest || out.Error.Type != "invalid_request_error" || out.Error.Message == "" {
+			t.Errorf("%s: %d %+v", name, resp.StatusCode, out)
+		}
+	}
+
+	// The scripted provider has no turns left, so the run fails.
+	resp := postChat(t, base, map[string]any{"messages": user})
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("failed run: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,57 @@
+package agentserver
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	claude "github.com/character-ai/claude-agent-sdk-go"
+)
+
+// finishedRunTTL is how long a finished run's events stay available to
+// clients reconnecting with Last-Event-ID.
+const finishedRunTTL = time.Minute
+
+// run is a session's run and the buffer its events are streamed from.
+type run struct {
+	cancel context.CancelFunc
+	events *claude.EventBuffer
+}
+
+// newRunLocked creates a run of session id. Event IDs start at the current time
+// in microseconds, or after the session's previous run, so they keep
+// increasing across runs and servers; they stay exact in JavaScript.
+func (s *Server) newRunLocked(id string, cancel context.CancelFunc) *run {
+	first := time.Now().UnixMicro()
+	if prev := s.finished[id]; prev != nil {
+		first = max(first, prev.events.LastID()+1)
+	}
+	return &run{
+		cancel: cancel,
+		events: claude.NewEventBuffer(claude.EventBufferConfig{Capacity: s.bufferSize, FirstID: first}),
+	}
+}
+
+// finishLocked keeps a finished run for reconnecting clients until
+// finishedRunTTL passes or the session runs again.
+func (s *Server) finishLocked(id string, rn *run) {
+	s.finished[id] = rn
+	time.AfterFunc(finishedRunTTL, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.finished[id] == rn {
+			delete(s.finished, id)
+		}
+	})
+}
+
+// stream writes the run's events after lastEventID to w as SSE until the
+// run ends or the client goes away.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, rn *run, lastEventID int64) {
+	sse, err := claude.NewSSEWriter(w)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	_ = rn.events.ServeSSE(r.Context(), sse, lastEventID, s.heartbeat)
+}
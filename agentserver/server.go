@@ -0,0 +1,652 @@
+// Package agentserver serves APIAgent sessions over a JSON HTTP API, with
+// agent events streamed as Server-Sent Events.
+//
+//	srv := agentserver.New(agentserver.Config{
+//	    Agent:      agent,
+//	    Middleware: []agentserver.Middleware{agentserver.BearerAuth(token)},
+//	})
+//	http.Handle("/agent/", http.StripPrefix("/agent", srv))
+//
+// Routes:
+//
+//	POST   /sessions                        create a session
+//	GET    /sessions                        list sessions
+//	GET    /sessions/{id}                   session state
+//	DELETE /sessions/{id}                   delete an idle session
+//	POST   /sessions/{id}/messages          send a message, or steer a running session
+//	GET    /sessions/{id}/events            stream the running session's events
+//	POST   /sessions/{id}/cancel            cancel the running session
+//	GET    /sessions/{id}/approvals         list pending tool approvals
+//	POST   /sessions/{id}/approvals         approve or deny pending tool calls
+//	PUT    /sessions/{id}/permission_mode   change the permission mode
+//	GET    /sessions/{id}/todos             todo list
+//	GET    /sessions/{id}/artifacts         artifacts
+//	GET    /sessions/{id}/metrics           session usage
+//	GET    /sessions/{id}/ws                WebSocket connection
+//	GET    /metrics                         agent loop metrics
+//
+// Requests that start a run (messages and approvals) return 202 Accepted
+// and the run continues in the background; send "Accept: text/event-stream"
+// to stream its events in the response instead. Errors are JSON objects
+// with an "error" field.
+//
+// The WebSocket carries both directions over one connection. The server
+// sends every event of the session's runs, whoever started them, as the
+// JSON of claude.AgentEventData plus an "id"; a client reconnecting with
+// ?last_event_id= receives the events it missed. The client sends
+// ClientMessages (user message, cancel, approval, permission mode) and
+// gets an Ack for each.
+//
+// NewChatCompletions serves an agent over the OpenAI Chat Completions
+// protocol instead, for existing OpenAI clients.
+package agentserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	claude "github.com/character-ai/claude-agent-sdk-go"
+)
+
+// maxBodyBytes limits request bodies.
+const maxBodyBytes = 1 << 20
+
+// Middleware wraps a handler, e.g. to authenticate requests.
+type Middleware func(http.Handler) http.Handler
+
+// Config configures a Server.
+type Config struct {
+	// Agent runs every session. If it has a SessionStore, sessions are
+	// persisted there and any server sharing the store can serve them;
+	// otherwise they live in this server's memory until deleted.
+	Agent *claude.APIAgent
+	// Middleware wraps every route, outermost first.
+	Middleware []Middleware
+	// EventBufferSize is the number of each run's most recent events kept
+	// for clients that reconnect with Last-Event-ID. Defaults to
+	// claude.DefaultEventBufferCapacity.
+	EventBufferSize int
+	// Heartbeat is the interval of SSE heartbeat comments on idle streams
+	// and of WebSocket pings. Defaults to claude.DefaultSSEHeartbeat;
+	// negative disables them.
+	Heartbeat time.Duration
+	// CheckOrigin reports whether a WebSocket upgrade may proceed. The
+	// default rejects browser requests from other hosts.
+	CheckOrigin func(r *http.Request) bool
+}
+
+// Server is an http.Handler for agent sessions. Create it with New.
+type Server struct {
+	agent       *claude.APIAgent
+	store       claude.SessionStore
+	handler     http.Handler
+	bufferSize  int
+	heartbeat   time.Duration
+	checkOrigin func(r *http.Request) bool
+
+	mu sync.Mutex
+	// live holds sessions kept in memory: all of them without a
+	// SessionStore, and only running ones with a store.
+	live map[string]*liveSession
+	// finished holds recently finished runs by session ID.
+	finished map[string]*run
+	// runStarted is closed, and replaced, whenever a run starts.
+	runStarted chan struct{}
+	// locks holds the per-session locks of requests in flight.
+	locks map[string]*sessionLock
+}
+
+// sessionLock serializes the requests that load and start one session.
+// refs is guarded by Server.mu.
+type sessionLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// liveSession is a session held in memory and its running run, if any.
+// run is guarded by Server.mu.
+type liveSession struct {
+	session *claude.Session
+	run     *run
+}
+
+// New creates a Server.
+func New(cfg Config) *Server {
+	s := &Server{
+		agent:       cfg.Agent,
+		store:       cfg.Agent.SessionStore(),
+		bufferSize:  cfg.EventBufferSize,
+		heartbeat:   cfg.Heartbeat,
+		checkOrigin: cfg.CheckOrigin,
+		live:        make(map[string]*liveSession),
+		finished:    make(map[string]*run),
+		runStarted:  make(chan struct{}),
+		locks:       make(map[string]*sessionLock),
+	}
+	if s.heartbeat == 0 {
+		s.heartbeat = claude.DefaultSSEHeartbeat
+	}
+	if s.checkOrigin == nil {
+		s.checkOrigin = sameOrigin
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /sessions", s.createSession)
+	mux.HandleFunc("GET /sessions", s.listSessions)
+	mux.HandleFunc("GET /sessions/{id}", s.getSession)
+	mux.HandleFunc("DELETE /sessions/{id}", s.deleteSession)
+	mux.HandleFunc("POST /sessions/{id}/messages", s.postMessage)
+	mux.HandleFunc("GET /sessions/{id}/events", s.streamEvents)
+	mux.HandleFunc("POST /sessions/{id}/cancel", s.cancelRun)
+	mux.HandleFunc("GET /sessions/{id}/approvals", s.getApprovals)
+	mux.HandleFunc("POST /sessions/{id}/approvals", s.resolveApprovals)
+	mux.HandleFunc("PUT /sessions/{id}/permission_mode", s.setPermissionModeHandler)
+	mux.HandleFunc("GET /sessions/{id}/ws", s.serveWebSocket)
+	mux.HandleFunc("GET /sessions/{id}/todos", s.getTodos)
+	mux.HandleFunc("GET /sessions/{id}/artifacts", s.getArtifacts)
+	mux.HandleFunc("GET /sessions/{id}/metrics", s.getSessionMetrics)
+	mux.HandleFunc("GET /metrics", s.getMetrics)
+
+	var h http.Handler = mux
+	for i := len(cfg.Middleware) - 1; i >= 0; i-- {
+		h = cfg.Middleware[i](h)
+	}
+	s.handler = h
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// sessionResponse is a session's state and whether it is running. A
+// running session's history and usage are those from before the run.
+type sessionResponse struct {
+	claude.SessionState
+	Running bool `json:"running"`
+}
+
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+	if !decodeBody(w, r, &req, true) {
+		return
+	}
+
+	session := s.agent.NewSession()
+	for k, v := range req.Metadata {
+		session.SetMetadata(k, v)
+	}
+	if s.store != nil {
+		if err := session.Save(r.Context()); err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+	} else {
+		s.mu.Lock()
+		s.live[session.ID()] = &liveSession{session: session}
+		s.mu.Unlock()
+	}
+	writeJSON(w, http.StatusCreated, sessionResponse{SessionState: session.State()})
+}
+
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	if s.store != nil {
+		infos, err := s.store.List(r.Context())
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		if infos == nil {
+			infos = []claude.SessionInfo{}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"sessions": infos})
+		return
+	}
+
+	s.mu.Lock()
+	infos := make([]claude.SessionInfo, 0, len(s.live))
+	for _, ls := range s.live {
+		state := ls.session.State()
+		infos = append(infos, claude.SessionInfo{
+			ID:        state.ID,
+			Version:   state.Version,
+			Metadata:  state.Metadata,
+			CreatedAt: state.CreatedAt,
+			UpdatedAt: state.UpdatedAt,
+			Messages:  len(state.History),
+		})
+	}
+	s.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	writeJSON(w, http.StatusOK, map[string]any{"sessions": infos})
+}
+
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request) {
+	ls, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	running := ls.run != nil
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, sessionResponse{SessionState: ls.session.State(), Running: running})
+}
+
+func (s *Server) deleteSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	// Hold the session's lock so a concurrent startRun cannot load the
+	// session from the store while it is being deleted.
+	defer s.lockSession(id)()
+	s.mu.Lock()
+	ls, live := s.live[id]
+	if live && ls.run != nil {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, fmt.Errorf("%w: cancel the run first", claude.ErrSessionBusy))
+		return
+	}
+	delete(s.live, id)
+	delete(s.finished, id)
+	s.mu.Unlock()
+
+	if s.store != nil {
+		if err := s.store.Delete(r.Context(), id); err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+	} else if !live {
+		writeError(w, http.StatusNotFound, claude.ErrSessionNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// postMessage sends a message to an idle session, or queues it as a
+// steering message for a running one.
+func (s *Server) postMessage(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Content string `json:"content"`
+	}
+	if !decodeBody(w, r, &req, false) {
+		return
+	}
+	if req.Content == "" {
+		writeError(w, http.StatusBadRequest, errors.New("content is required"))
+		return
+	}
+
+	rn, queued, err := s.sendMessage(r.Context(), r.PathValue("id"), req.Content)
+	switch {
+	case err != nil:
+		writeError(w, errorStatus(err), err)
+	case queued != nil:
+		writeJSON(w, http.StatusAccepted, map[string]any{"status": "queued", "message": queued})
+	default:
+		s.respondRun(w, r, rn)
+	}
+}
+
+// sendMessage starts a run with content, or, if the session is running,
+// queues content as a steering message and returns it.
+func (s *Server) sendMessage(ctx context.Context, id, content string) (*run, *claude.QueuedMessage, error) {
+	ls, rn, err := s.startRun(ctx, id, func(ctx context.Context, session *claude.Session) (<-chan claude.AgentEvent, error) {
+		return session.Send(ctx, content)
+	})
+	if errors.Is(err, claude.ErrSessionBusy) {
+		msg, err := ls.session.Steer(content)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &msg, nil
+	}
+	return rn, nil, err
+}
+
+// streamEvents streams the running session's events. A client reconnecting
+// with Last-Event-ID gets the events it missed, including those of a run
+// that finished in the meantime.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	lastEventID := claude.LastEventID(r)
+	s.mu.Lock()
+	var rn *run
+	if ls := s.live[id]; ls != nil {
+		rn = ls.run
+	}
+	if rn == nil && lastEventID > 0 {
+		if f := s.finished[id]; f != nil && f.events.LastID() > lastEventID {
+			rn = f
+		}
+	}
+	s.mu.Unlock()
+	if rn == nil {
+		// Tells EventSource clients to stop reconnecting.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.stream(w, r, rn, lastEventID)
+}
+
+func (s *Server) cancelRun(w http.ResponseWriter, r *http.Request) {
+	if err := s.cancel(r.PathValue("id")); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "canceling"})
+}
+
+// cancel cancels the session's run. It returns claude.ErrNotRunning if
+// the session is idle.
+func (s *Server) cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ls := s.live[id]
+	if ls == nil || ls.run == nil {
+		return claude.ErrNotRunning
+	}
+	ls.run.cancel()
+	return nil
+}
+
+func (s *Server) getApprovals(w http.ResponseWriter, r *http.Request) {
+	ls, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	approvals := ls.session.PendingApprovals()
+	if approvals == nil {
+		approvals = []claude.ApprovalRequest{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"approvals": approvals})
+}
+
+// Decision is the JSON form of a claude.PermissionDecision.
+type Decision struct {
+	Allow         bool            `json:"allow"`
+	Reason        string          `json:"reason,omitempty"`
+	ModifiedInput json.RawMessage `json:"modified_input,omitempty"`
+}
+
+func (s *Server) resolveApprovals(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Decisions map[string]Decision `json:"decisions"`
+	}
+	if !decodeBody(w, r, &req, false) {
+		return
+	}
+	rn, err := s.resolve(r.Context(), r.PathValue("id"), req.Decisions)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	s.respondRun(w, r, rn)
+}
+
+// resolve continues a session paused for approvals with a decision for
+// every pending call.
+func (s *Server) resolve(ctx context.Context, id string, decisions map[string]Decision) (*run, error) {
+	resolved := make(map[string]claude.PermissionDecision, len(decisions))
+	for callID, d := range decisions {
+		resolved[callID] = claude.PermissionDecision{Allow: d.Allow, Reason: d.Reason, ModifiedInput: d.ModifiedInput}
+	}
+	_, rn, err := s.startRun(ctx, id, func(ctx context.Context, session *claude.Session) (<-chan claude.AgentEvent, error) {
+		pending := session.PendingApprovals()
+		if len(pending) == 0 {
+			return nil, &requestError{errors.New("session is not waiting for tool approval")}
+		}
+		for _, p := range pending {
+			if _, ok := resolved[p.ToolCallID]; !ok {
+				return nil, &requestError{fmt.Errorf("no decision for tool call %s (%s)", p.ToolCallID, p.ToolName)}
+			}
+		}
+		return session.Resolve(ctx, resolved)
+	})
+	return rn, err
+}
+
+func (s *Server) setPermissionModeHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Mode claude.PermissionMode `json:"mode"`
+	}
+	if !decodeBody(w, r, &req, false) {
+		return
+	}
+	if err := s.setPermissionMode(r.Context(), r.PathValue("id"), req.Mode); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]claude.PermissionMode{"permission_mode": req.Mode})
+}
+
+// setPermissionMode changes the session's permission mode. A running
+// session uses it from its next tool call and saves it when the run ends;
+// an idle one is saved now.
+func (s *Server) setPermissionMode(ctx context.Context, id string, mode claude.PermissionMode) error {
+	defer s.lockSession(id)()
+	ls, err := s.load(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := ls.session.SetPermissionMode(mode); err != nil {
+		return &requestError{err}
+	}
+	s.mu.Lock()
+	running := ls.run != nil
+	s.mu.Unlock()
+	if s.store != nil && !running {
+		return ls.session.Save(ctx)
+	}
+	return nil
+}
+
+func (s *Server) getTodos(w http.ResponseWriter, r *http.Request) {
+	ls, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	todos := ls.session.Todos()
+	if todos == nil {
+		todos = []claude.TodoItem{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"todos": todos})
+}
+
+func (s *Server) getArtifacts(w http.ResponseWriter, r *http.Request) {
+	ls, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	artifacts := ls.session.Artifacts()
+	if artifacts == nil {
+		artifacts = []claude.Artifact{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"artifacts": artifacts})
+}
+
+func (s *Server) getSessionMetrics(w http.ResponseWriter, r *http.Request) {
+	ls, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"usage": ls.session.Usage()})
+}
+
+func (s *Server) getMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics := s.agent.Metrics()
+	if metrics == nil {
+		writeError(w, http.StatusNotFound, errors.New("agent has no MetricsCollector"))
+		return
+	}
+	writeJSON(w, http.StatusOK, metrics.Snapshot())
+}
+
+// lookup finds the session named in the path, loading it from the store
+// if it is not in memory. It writes an error response on failure.
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (*liveSession, bool) {
+	ls, err := s.load(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return nil, false
+	}
+	return ls, true
+}
+
+// load returns the session from memory, or loads it from the store. The
+// store is read without holding s.mu.
+func (s *Server) load(ctx context.Context, id string) (*liveSession, error) {
+	s.mu.Lock()
+	ls, ok := s.live[id]
+	s.mu.Unlock()
+	if ok {
+		return ls, nil
+	}
+	if s.store == nil {
+		return nil, claude.ErrSessionNotFound
+	}
+	session, err := s.agent.LoadSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &liveSession{session: session}, nil
+}
+
+// lockSession locks session id against other requests that load, start
+// or delete it, and returns the unlock function. Unlike s.mu, it may be
+// held during store I/O, since it only delays requests for the same
+// session.
+func (s *Server) lockSession(id string) (unlock func()) {
+	s.mu.Lock()
+	l := s.locks[id]
+	if l == nil {
+		l = &sessionLock{}
+		s.locks[id] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		s.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(s.locks, id)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// startRun starts a run of session id and tracks it until it ends. It
+// returns claude.ErrSessionBusy, along with the session, if the session is
+// already running.
+//
+// The lookup and start happen under the session's lock so that two
+// requests cannot start runs of the same session from separate copies of
+// it; s.mu is only held to update the maps.
+func (s *Server) startRun(ctx context.Context, id string, start func(context.Context, *claude.Session) (<-chan claude.AgentEvent, error)) (*liveSession, *run, error) {
+	defer s.lockSession(id)()
+	ls, err := s.load(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	running := ls.run != nil
+	s.mu.Unlock()
+	if running {
+		return ls, nil, claude.ErrSessionBusy
+	}
+
+	// The run outlives the request but keeps its values, such as the
+	// authenticated user.
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	events, err := start(runCtx, ls.session)
+	if err != nil {
+		cancel()
+		return ls, nil, err
+	}
+	s.mu.Lock()
+	rn := s.newRunLocked(id, cancel)
+	ls.run = rn
+	s.live[id] = ls
+	delete(s.finished, id)
+	close(s.runStarted)
+	s.runStarted = make(chan struct{})
+	s.mu.Unlock()
+
+	go func() {
+		rn.events.Pump(events)
+		cancel()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		ls.run = nil
+		s.finishLocked(id, rn)
+		if s.store != nil && s.live[id] == ls {
+			// The store has the result; reload it on the next request.
+			delete(s.live, id)
+		}
+	}()
+	return ls, rn, nil
+}
+
+// respondRun streams a run's events if the client asked for SSE, and
+// otherwise acknowledges that it started.
+func (s *Server) respondRun(w http.ResponseWriter, r *http.Request, rn *run) {
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		s.stream(w, r, rn, 0)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "running"})
+}
+
+// decodeBody decodes a JSON request body into v. An empty body is an
+// error unless optional. It writes an error response on failure.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any, optional bool) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(v)
+	if err == nil || (optional && errors.Is(err, io.EOF)) {
+		return true
+	}
+	writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// requestError is an error caused by an invalid request.
+type requestError struct{ error }
+
+func (e *requestError) Unwrap() error { return e.error }
+
+// errorStatus maps SDK errors to HTTP status codes.
+func errorStatus(err error) int {
+	var reqErr *requestError
+	switch {
+	case errors.As(err, &reqErr):
+		return http.StatusBadRequest
+	case errors.Is(err, claude.ErrSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, claude.ErrSessionBusy),
+		errors.Is(err, claude.ErrSessionConflict),
+		errors.Is(err, claude.ErrApprovalPending),
+		errors.Is(err, claude.ErrNotRunning):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
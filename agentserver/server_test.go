@@ -0,0 +1,477 @@
+package agentserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	claude "github.com/character-ai/claude-agent-sdk-go"
+	"github.com/character-ai/claude-agent-sdk-go/claudeagenttest"
+)
+
+// client calls a test server with a bearer token.
+type client struct {
+	t     *testing.T
+	base  string
+	token string
+}
+
+// do sends a JSON request and decodes the JSON response into out, if set.
+func (c *client) do(method, path string, body any, out any) int {
+	c.t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		r = strings.NewReader(string(b))
+	}
+	req, _ := http.NewRequest(method, c.base+path, r)
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			c.t.Fatalf("%s %s: decode: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// sseResponse is a streamed response split into its fields.
+type sseResponse struct {
+	code             int
+	ids, names, data []string
+}
+
+// stream sends a request accepting SSE, resuming after lastEventID if set,
+// and reads the stream to its end.
+func (c *client) stream(method, path string, body any, lastEventID string) sseResponse {
+	c.t.Helper()
+	b, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, c.base+path, strings.NewReader(string(b)))
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	out := sseResponse{code: resp.StatusCode}
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		if id, ok := strings.CutPrefix(sc.Text(), "id: "); ok {
+			out.ids = append(out.ids, id)
+		}
+		if name, ok := strings.CutPrefix(sc.Text(), "event: "); ok {
+			out.names = append(out.names, name)
+		}
+		if d, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+			out.data = append(out.data, d)
+		}
+	}
+	return out
+}
+
+// waitIdle polls a session until its run ends.
+func (c *client) waitIdle(id string) {
+	c.t.Helper()
+	for i := 0; i < 200; i++ {
+		var s struct {
+			Running bool `json:"running"`
+		}
+		c.do("GET", "/sessions/"+id, nil, &s)
+		if !s.Running {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.t.Fatalf("session %s did not finish", id)
+}
+
+func newTestServer(t *testing.T, cfg claude.APIAgentConfig) *client {
+	srv := New(Config{
+		Agent:      claude.NewAPIAgent(cfg),
+		Middleware: []Middleware{BearerAuth("secret")},
+	})
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return &client{t: t, base: ts.URL, token: "secret"}
+}
+
+func TestServerSessionLifecycle(t *testing.T) {
+	provider := claudeagenttest.NewScriptedProvider(
+		claudeagenttest.ToolCallTurn(claudeagenttest.ToolCall("write_todos", map[string]any{
+			"todos": []map[string]any{{"id": "1", "description": "say hi", "status": "completed", "priority": "low"}},
+		})),
+		claudeagenttest.StreamedTextTurn("Hi ", "Ada."),
+	)
+	c := newTestServer(t, claude.APIAgentConfig{
+		Provider:     provider,
+		EnableTodos:  true,
+		Metrics:      claude.NewMetricsCollector(),
+		SessionStore: claude.NewMemorySessionStore(),
+	})
+
+	var created struct {
+		ID       string            `json:"id"`
+		Metadata map[string]string `json:"metadata"`
+	}
+	if code := c.do("POST", "/sessions", map[string]any{"metadata": map[string]string{"user": "ada"}}, &created); code != http.StatusCreated {
+		t.Fatalf("create: %d", code)
+	}
+	if created.ID == "" || created.Metadata["user"] != "ada" {
+		t.Fatalf("unexpected session: %+v", created)
+	}
+	path := "/sessions/" + created.ID
+
+	resp := c.stream("POST", path+"/messages", map[string]string{"content": "hello"}, "")
+	if resp.code != http.StatusOK {
+		t.Fatalf("messages: %d", resp.code)
+	}
+	names := resp.names
+	if names[len(names)-1] != "close" || names[len(names)-2] != string(claude.AgentEventComplete) {
+		t.Errorf("stream should end with complete and close, got %v", names)
+	}
+	if !strings.Contains(strings.Join(resp.data, "\n"), `"content":"Ada."`) {
+		t.Errorf("stream missing content deltas: %v", resp.data)
+	}
+	c.waitIdle(created.ID)
+
+	// A client that dropped after the first event catches up on the rest.
+	if len(resp.ids) != len(names)-1 {
+		t.Fatalf("every agent event should have an ID: %v %v", resp.ids, names)
+	}
+	replay := c.stream("GET", path+"/events", nil, resp.ids[0])
+	if replay.code != http.StatusOK || strings.Join(replay.ids, ",") != strings.Join(resp.ids[1:], ",") ||
+		replay.names[len(replay.names)-1] != "close" {
+		t.Errorf("reconnect should replay events after %s, got %d %v", resp.ids[0], replay.code, replay.ids)
+	}
+	if none := c.stream("GET", path+"/events", nil, ""); none.code != http.StatusNoContent {
+		t.Errorf("events of an idle session without Last-Event-ID: %d", none.code)
+	}
+
+	var state struct {
+		History []claude.ChatMessage `json:"history"`
+		Usage   claude.SessionUsage  `json:"usage"`
+	}
+	c.do("GET", path, nil, &state)
+	if len(state.History) != 4 || state.History[3].Content != "Hi Ada." {
+		t.Errorf("unexpected history: %+v", state.History)
+	}
+	var todos struct {
+		Todos []claude.TodoItem `json:"todos"`
+	}
+	c.do("GET", path+"/todos", nil, &todos)
+	if len(todos.Todos) != 1 || todos.Todos[0].Description != "say hi" {
+		t.Errorf("unexpected todos: %+v", todos)
+	}
+	var artifacts map[string][]claude.Artifact
+	c.do("GET", path+"/artifacts", nil, &artifacts)
+	if a, ok := artifacts["artifacts"]; !ok || len(a) != 0 {
+		t.Errorf("expected an empty artifact list, got %v", artifacts)
+	}
+	var usage struct {
+		Usage claude.SessionUsage `json:"usage"`
+	}
+	c.do("GET", path+"/metrics", nil, &usage)
+	if usage.Usage.Turns != 2 {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+	var metrics claude.LoopMetrics
+	if code := c.do("GET", "/metrics", nil, &metrics); code != http.StatusOK || len(metrics.Turns) == 0 {
+		t.Errorf("agent metrics: %d %+v", code, metrics)
+	}
+
+	var list struct {
+		Sessions []claude.SessionInfo `json:"sessions"`
+	}
+	c.do("GET", "/sessions", nil, &list)
+	if len(list.Sessions) != 1 || list.Sessions[0].Messages != 4 {
+		t.Errorf("unexpected list: %+v", list)
+	}
+	if code := c.do("DELETE", path, nil, nil); code != http.StatusNoContent {
+		t.Errorf("delete: %d", code)
+	}
+	if code := c.do("GET", path, nil, nil); code != http.StatusNotFound {
+		t.Errorf("deleted session: %d", code)
+	}
+
+	c.token = "wrong"
+	if code := c.do("GET", "/sessions", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("bad token: %d", code)
+	}
+}
+
+func TestServerApprovals(t *testing.T) {
+	var charged []string
+	tools := claude.NewToolRegistry()
+	tools.Register(claude.ToolDefinition{Name: "charge"}, func(_ context.Context, input json.RawMessage) (string, error) {
+		charged = append(charged, string(input))
+		return "charged", nil
+	})
+	c := newTestServer(t, claude.APIAgentConfig{
+		Provider: claudeagenttest.NewScriptedProvider(
+			claudeagenttest.ToolCallTurn(claude.ToolCall{ID: "c1", Name: "charge", Input: json.RawMessage(`{"amount":50}`)}),
+			claudeagenttest.TextTurn("Charged 40."),
+		),
+		Tools: tools,
+		CanUseTool: func(context.Context, string, string, json.RawMessage) claude.PermissionDecision {
+			return claude.PermissionDecision{Ask: true, Reason: "needs sign-off"}
+		},
+	})
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	c.do("POST", "/sessions", nil, &created)
+	path := "/sessions/" + created.ID
+	if code := c.do("POST", path+"/messages", map[string]string{"content": "pay"}, nil); code != http.StatusAccepted {
+		t.Fatalf("messages: %d", code)
+	}
+	c.waitIdle(created.ID)
+
+	var pending struct {
+		Approvals []claude.ApprovalRequest `json:"approvals"`
+	}
+	c.do("GET", path+"/approvals", nil, &pending)
+	if len(pending.Approvals) != 1 || pending.Approvals[0].ToolCallID != "c1" || pending.Approvals[0].Reason != "needs sign-off" {
+		t.Fatalf("unexpected approvals: %+v", pending)
+	}
+	if code := c.do("POST", path+"/messages", map[string]string{"content": "hello?"}, nil); code != http.StatusConflict {
+		t.Errorf("message while waiting for approval: %d", code)
+	}
+	if code := c.do("POST", path+"/approvals", map[string]any{"decisions": map[string]any{}}, nil); code != http.StatusBadRequest {
+		t.Errorf("missing decision: %d", code)
+	}
+
+	resp := c.stream("POST", path+"/approvals", map[string]any{
+		"decisions": map[string]any{"c1": map[string]any{"allow": true, "modified_input": map[string]int{"amount": 40}}},
+	}, "")
+	if resp.code != http.StatusOK || resp.names[len(resp.names)-1] != "close" {
+		t.Fatalf("resolve: %d %v", resp.code, resp.names)
+	}
+	if len(charged) != 1 || charged[0] != `{"amount":40}` {
+		t.Errorf("approved charge should run with the edited input, got %v", charged)
+	}
+}
+
+func TestServerCancelAndSteer(t *testing.T) {
+	started := make(chan struct{})
+	tools := claude.NewToolRegistry()
+	tools.Register(claude.ToolDefinition{Name: "wait"}, func(ctx context.Context, _ json.RawMessage) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	c := newTestServer(t, claude.APIAgentConfig{
+		Provider: claudeagenttest.NewScriptedProvider(
+			claudeagenttest.ToolCallTurn(claudeagenttest.ToolCall("wait", map[string]any{})),
+		),
+		Tools: tools,
+	})
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	c.do("POST", "/sessions", nil, &created)
+	path := "/sessions/" + created.ID
+	if code := c.do("POST", path+"/cancel", nil, nil); code != http.StatusConflict {
+		t.Errorf("cancel while idle: %d", code)
+	}
+	c.do("POST", path+"/messages", map[string]string{"content": "wait"}, nil)
+	<-started
+
+	var queued struct {
+		Status  string               `json:"status"`
+		Message claude.QueuedMessage `json:"message"`
+	}
+	if code := c.do("POST", path+"/messages", map[string]string{"content": "never mind"}, &queued); code != http.StatusAccepted ||
+		queued.Status != "queued" || queued.Message.Content != "never mind" {
+		t.Errorf("message while running should be queued: %d %+v", code, queued)
+	}
+	if code := c.do("DELETE", path, nil, nil); code != http.StatusConflict {
+		t.Errorf("delete while running: %d", code)
+	}
+
+	done := make(chan []string)
+	go func() {
+		done <- c.stream("GET", path+"/events", nil, "").names
+	}()
+	if code := c.do("POST", path+"/cancel", nil, nil); code != http.StatusAccepted {
+		t.Errorf("cancel: %d", code)
+	}
+	c.waitIdle(created.ID)
+	if names := <-done; len(names) > 0 && names[len(names)-1] != "close" {
+		t.Errorf("event stream should close when the run ends, got %v", names)
+	}
+
+	req, _ := http.NewRequest("GET", c.base+path+"/events", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("events of an idle session: %d", resp.StatusCode)
+	}
+}
+
+func TestServerPermissionMode(t *testing.T) {
+	var charged int
+	tools := claude.NewToolRegistry()
+	tools.Register(claude.ToolDefinition{Name: "charge"}, func(context.Context, json.RawMessage) (string, error) {
+		charged++
+		return "charged", nil
+	})
+	c := newTestServer(t, claude.APIAgentConfig{
+		Provider: claudeagenttest.NewScriptedProvider(
+			claudeagenttest.ToolCallTurn(claudeagenttest.ToolCall("charge", map[string]any{})),
+			claudeagenttest.TextTurn("Planned."),
+		),
+		Tools: tools,
+	})
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	c.do("POST", "/sessions", nil, &created)
+	path := "/sessions/" + created.ID
+	if code := c.do("PUT", path+"/permission_mode", map[string]string{"mode": "yolo"}, nil); code != http.StatusBadRequest {
+		t.Errorf("invalid mode: %d", code)
+	}
+	var set struct {
+		PermissionMode claude.PermissionMode `json:"permission_mode"`
+	}
+	if code := c.do("PUT", path+"/permission_mode", map[string]string{"mode": "plan"}, &set); code != http.StatusOK ||
+		set.PermissionMode != claude.PermissionPlan {
+		t.Fatalf("set mode: %d %+v", code, set)
+	}
+
+	resp := c.stream("POST", path+"/messages", map[string]string{"content": "pay"}, "")
+	if !strings.Contains(strings.Join(resp.data, "\n"), "plan mode") {
+		t.Errorf("charge should be denied in plan mode, got %v", resp.data)
+	}
+	if charged != 0 {
+		t.Errorf("charge ran %d times in plan mode", charged)
+	}
+	c.do("GET", path, nil, &set)
+	if set.PermissionMode != claude.PermissionPlan {
+		t.Errorf("session state permission mode = %q", set.PermissionMode)
+	}
+}
+
+// gatedStore blocks the first save of one session until release is closed.
+type gatedStore struct {
+	claude.SessionStore
+	id      string
+	once    sync.Once
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (s *gatedStore) Save(ctx context.Context, state *claude.SessionState) error {
+	if state.ID == s.id {
+		s.once.Do(func() {
+			close(s.saving)
+			<-s.release
+		})
+	}
+	return s.SessionStore.Save(ctx, state)
+}
+
+func TestServerStoreIODoesNotBlockOtherSessions(t *testing.T) {
+	store := &gatedStore{SessionStore: claude.NewMemorySessionStore(), saving: make(chan struct{}), release: make(chan struct{})}
+	c := newTestServer(t, claude.APIAgentConfig{
+		Provider:     claudeagenttest.NewScriptedProvider(claudeagenttest.TextTurn("Hi.")),
+		SessionStore: store,
+	})
+	var slow, fast struct {
+		ID string `json:"id"`
+	}
+	c.do("POST", "/sessions", nil, &slow)
+	c.do("POST", "/sessions", nil, &fast)
+
+	// The slow session's claim save blocks while its run starts.
+	store.id = slow.ID
+	sent := make(chan int)
+	go func() {
+		sent <- c.do("POST", "/sessions/"+slow.ID+"/messages", map[string]string{"content": "hi"}, nil)
+	}()
+	<-store.saving
+
+	got := make(chan int)
+	go func() { got <- c.do("GET", "/sessions/"+fast.ID, nil, nil) }()
+	select {
+	case code := <-got:
+		if code != http.StatusOK {
+			t.Errorf("GET other session: %d", code)
+		}
+	case <-time.After(2 * time.Second):
+		close(store.release)
+		t.Fatal("a slow store save for one session blocked requests for another")
+	}
+
+	close(store.release)
+	if code := <-sent; code != http.StatusAccepted {
+		t.Errorf("POST message: %d", code)
+	}
+	c.waitIdle(slow.ID)
+}
+
+func TestServerDeleteWaitsForRunStart(t *testing.T) {
+	store := &gatedStore{SessionStore: claude.NewMemorySessionStore(), saving: make(chan struct{}), release: make(chan struct{})}
+	c := newTestServer(t, claude.APIAgentConfig{
+		Provider:     claudeagenttest.NewScriptedProvider(claudeagenttest.TextTurn("Hi.")),
+		SessionStore: store,
+	})
+	var sess struct {
+		ID string `json:"id"`
+	}
+	c.do("POST", "/sessions", nil, &sess)
+	path := "/sessions/" + sess.ID
+
+	// The run's claim save blocks while startRun holds the session's lock.
+	store.id = sess.ID
+	sent := make(chan int)
+	go func() {
+		sent <- c.do("POST", path+"/messages", map[string]string{"content": "hi"}, nil)
+	}()
+	<-store.saving
+
+	deleted := make(chan int)
+	go func() { deleted <- c.do("DELETE", path, nil, nil) }()
+	select {
+	case code := <-deleted:
+		close(store.release)
+		t.Fatalf("DELETE returned %d while the run was starting", code)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(store.release)
+	if code := <-sent; code != http.StatusAccepted {
+		t.Errorf("POST message: %d", code)
+	}
+	switch code := <-deleted; code {
+	case http.StatusConflict:
+		c.waitIdle(sess.ID)
+	case http.StatusNoContent:
+		if code := c.do("GET", path, nil, nil); code != http.StatusNotFound {
+			t.Errorf("GET deleted session: %d", code)
+		}
+	default:
+		t.Errorf("DELETE: %d", code)
+	}
+}
@@ -0,0 +1,211 @@
+package agentserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	claude "github.com/character-ai/claude-agent-sdk-go"
+)
+
+var errForbiddenOrigin = errors.New("origin not allowed")
+
+// ClientMessageType is the type of a message a WebSocket client sends.
+type ClientMessageType string
+
+const (
+	// ClientUserMessage sends Content, or steers the running session.
+	ClientUserMessage ClientMessageType = "user_message"
+	// ClientCancel cancels the running session.
+	ClientCancel ClientMessageType = "cancel"
+	// ClientApproval resolves pending tool approvals with Decisions.
+	ClientApproval ClientMessageType = "approval"
+	// ClientSetPermissionMode changes the session's permission mode to Mode.
+	ClientSetPermissionMode ClientMessageType = "set_permission_mode"
+)
+
+// ClientMessage is a message from a WebSocket client.
+type ClientMessage struct {
+	Type ClientMessageType `json:"type"`
+	// ID is echoed in the Ack so the client can match it to the request.
+	ID        string                `json:"id,omitempty"`
+	Content   string                `json:"content,omitempty"`
+	Decisions map[string]Decision   `json:"decisions,omitempty"`
+	Mode      claude.PermissionMode `json:"mode,omitempty"`
+}
+
+// Ack answers a ClientMessage. Its Type is "ack".
+type Ack struct {
+	Type  string `json:"type"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Status is "running" for a started run, "queued" for a steering
+	// message, "canceling" or "ok".
+	Status string `json:"status,omitempty"`
+	// Message is the queued steering message.
+	Message *claude.QueuedMessage `json:"message,omitempty"`
+}
+
+// serveWebSocket handles GET /sessions/{id}/ws.
+//
+// A new connection starts at the running run, if any. Events are read
+// from the run's buffer at the connection's pace, so a slow client never
+// blocks the agent: one that falls more than Config.EventBufferSize
+// events behind gets an "events_dropped" message, and one that stops
+// reading is disconnected by the write timeout. The server pings every
+// Config.Heartbeat and drops connections that stay silent, pongs
+// included, for two intervals.
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.lookup(w, r); !ok {
+		return
+	}
+	if !s.checkOrigin(r) {
+		writeError(w, http.StatusForbidden, errForbiddenOrigin)
+		return
+	}
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	if s.heartbeat > 0 {
+		ws.readTimeout = 2 * s.heartbeat
+	}
+	defer ws.Close(wsCloseNormal, "")
+
+	id := r.PathValue("id")
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		s.readClientMessages(ctx, ws, id)
+	}()
+	s.writeRunEvents(ctx, ws, id, claude.LastEventID(r))
+}
+
+// readClientMessages handles client messages until the connection fails.
+func (s *Server) readClientMessages(ctx context.Context, ws *wsConn, id string) {
+	for {
+		data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg ClientMessage
+		ack := Ack{Type: "ack"}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			ack.Error = "invalid message: " + err.Error()
+		} else {
+			ack = s.handleClientMessage(ctx, id, msg)
+		}
+		if err := writeWSJSON(ws, ack); err != nil {
+			return
+		}
+	}
+}
+
+// handleClientMessage performs msg, sharing the REST handlers' logic.
+func (s *Server) handleClientMessage(ctx context.Context, id string, msg ClientMessage) Ack {
+	ack := Ack{Type: "ack", ID: msg.ID}
+	var err error
+	switch msg.Type {
+	case ClientUserMessage:
+		var queued *claude.QueuedMessage
+		if msg.Content == "" {
+			ack.Error = "content is required"
+			return ack
+		}
+		_, queued, err = s.sendMessage(ctx, id, msg.Content)
+		ack.Status, ack.Message = "running", queued
+		if queued != nil {
+			ack.Status = "queued"
+		}
+	case ClientCancel:
+		err = s.cancel(id)
+		ack.Status = "canceling"
+	case ClientApproval:
+		_, err = s.resolve(ctx, id, msg.Decisions)
+		ack.Status = "running"
+	case ClientSetPermissionMode:
+		err = s.setPermissionMode(ctx, id, msg.Mode)
+		ack.Status = "ok"
+	default:
+		ack.Error = "unknown message type " + string(msg.Type)
+		return ack
+	}
+	if err != nil {
+		return Ack{Type: "ack", ID: msg.ID, Error: err.Error()}
+	}
+	return ack
+}
+
+// writeRunEvents sends the events of the session's runs after lastEventID
+// until ctx is done or a write fails, pinging the client while idle.
+func (s *Server) writeRunEvents(ctx context.Context, ws *wsConn, id string, lastEventID int64) {
+	var tick <-chan time.Time
+	if s.heartbeat > 0 {
+		t := time.NewTicker(s.heartbeat)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	next := lastEventID
+	s.mu.Lock()
+	if ls := s.live[id]; next == 0 && (ls == nil || ls.run == nil) && s.finished[id] != nil {
+		// A new connection starts with the next run.
+		next = s.finished[id].events.LastID()
+	}
+	s.mu.Unlock()
+
+	for {
+		s.mu.Lock()
+		rn := s.finished[id]
+		if ls := s.live[id]; ls != nil && ls.run != nil {
+			rn = ls.run
+		}
+		var wait <-chan struct{} = s.runStarted
+		s.mu.Unlock()
+
+		if rn != nil {
+			events, dropped, closed, changed := rn.events.After(next)
+			if dropped {
+				if err := writeWSJSON(ws, map[string]any{
+					"type":          "events_dropped",
+					"last_event_id": next,
+					"resume_from":   events[0].ID,
+				}); err != nil {
+					return
+				}
+			}
+			for _, e := range events {
+				data := claude.AgentEventData(e.Event)
+				data["id"] = e.ID
+				if err := writeWSJSON(ws, data); err != nil {
+					return
+				}
+				next = e.ID
+			}
+			if !closed {
+				wait = changed
+			}
+		}
+
+		select {
+		case <-wait:
+		case <-tick:
+			if err := ws.Ping(); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func writeWSJSON(ws *wsConn, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.WriteText(data)
+}
@@ -0,0 +1,334 @@
+package agentserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1" //nolint:gosec // WebSocket handshake
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	claude "github.com/character-ai/claude-agent-sdk-go"
+	"github.com/character-ai/claude-agent-sdk-go/claudeagenttest"
+)
+
+// wsClient is a minimal WebSocket client for tests.
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWS(t *testing.T, base, path string, header http.Header) (*wsClient, *http.Response) {
+	t.Helper()
+	u, _ := url.Parse(base + path)
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	if header != nil {
+		req.Header = header.Clone()
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		sum := sha1.Sum([]byte("dGhlIHNhbXBsZSBub25jZQ==" + wsGUID)) //nolint:gosec // see import
+		if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+			t.Fatalf("bad Sec-WebSocket-Accept %q", resp.Header.Get("Sec-WebSocket-Accept"))
+		}
+	}
+	return &wsClient{t: t, conn: conn, br: br}, resp
+}
+
+// sendFrame writes a masked frame.
+func (c *wsClient) sendFrame(fin bool, op byte, payload []byte) {
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) send(v any) {
+	data, _ := json.Marshal(v)
+	c.sendFrame(true, wsText, data)
+}
+
+// readFrame reads an unmasked server frame.
+func (c *wsClient) readFrame() (byte, []byte, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.br, ext[:])
+		n = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, n)
+	_, err := io.ReadFull(c.br, payload)
+	return h[0] & 0x0F, payload, err
+}
+
+// next returns the next JSON message, answering pings.
+func (c *wsClient) next() map[string]any {
+	c.t.Helper()
+	for {
+		op, payload, err := c.readFrame()
+		if err != nil {
+			c.t.Fatalf("read: %v", err)
+		}
+		switch op {
+		case wsPing:
+			c.sendFrame(true, wsPong, payload)
+		case wsText:
+			var m map[string]any
+			if err := json.Unmarshal(payload, &m); err != nil {
+				c.t.Fatal(err)
+			}
+			return m
+		default:
+			c.t.Fatalf("unexpected opcode %d", op)
+		}
+	}
+}
+
+// until reads messages up to and including one of the given type.
+func (c *wsClient) until(typ string) []map[string]any {
+	c.t.Helper()
+	var msgs []map[string]any
+	for {
+		m := c.next()
+		msgs = append(msgs, m)
+		if m["type"] == typ {
+			return msgs
+		}
+	}
+}
+
+func newWSServer(t *testing.T, cfg Config) (*client, string) {
+	cfg.Middleware = []Middleware{BearerAuth("secret")}
+	ts := httptest.NewServer(New(cfg))
+	t.Cleanup(ts.Close)
+	c := &client{t: t, base: ts.URL, token: "secret"}
+	var created struct {
+		ID string `json:"id"`
+	}
+	c.do("POST", "/sessions", nil, &created)
+	return c, created.ID
+}
+
+func TestWebSocketSession(t *testing.T) {
+	started := make(chan struct{})
+	tools := claude.NewToolRegistry()
+	tools.Register(claude.ToolDefinition{Name: "wait"}, func(ctx context.Context, _ json.RawMessage) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	tools.Register(claude.ToolDefinition{Name: "charge"}, func(context.Context, json.RawMessage) (string, error) {
+		return "charged", nil
+	})
+	c, id := newWSServer(t, Config{Agent: claude.NewAPIAgent(claude.APIAgentConfig{
+		Provider: claudeagenttest.NewScriptedProvider(
+			claudeagenttest.TextTurn("Hello."),
+			claudeagenttest.ToolCallTurn(claudeagenttest.ToolCall("wait", map[string]any{})),
+			claudeagenttest.ToolCallTurn(claude.ToolCall{ID: "c1", Name: "charge", Input: json.RawMessage(`{}`)}),
+			claudeagenttest.TextTurn("Charged."),
+		),
+		Tools: tools,
+		CanUseTool: func(_ context.Context, name, _ string, _ json.RawMessage) claude.PermissionDecision {
+			return claude.PermissionDecision{Ask: name == "charge", Allow: true}
+		},
+	})})
+	ws, resp := dialWS(t, c.base, "/sessions/"+id+"/ws", nil)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade: %d", resp.StatusCode)
+	}
+
+	ws.send(ClientMessage{Type: ClientCancel, ID: "0"})
+	if ack := ws.next(); ack["type"] != "ack" || ack["id"] != "0" || ack["error"] == nil {
+		t.Errorf("cancel while idle should fail: %v", ack)
+	}
+
+	// A message, fragmented across frames.
+	data, _ := json.Marshal(ClientMessage{Type: ClientUserMessage, ID: "1", Content: "hi"})
+	ws.sendFrame(false, wsText, data[:5])
+	ws.sendFrame(true, wsContinuation, data[5:])
+	msgs := ws.until(string(claude.AgentEventComplete))
+	if msgs[0]["type"] != "ack" || msgs[0]["status"] != "running" {
+		t.Errorf("expected a running ack first, got %v", msgs[0])
+	}
+	var content string
+	for _, m := range msgs[1:] {
+		if m["id"] == nil {
+			t.Errorf("event without id: %v", m)
+		}
+		if m["type"] == string(claude.AgentEventContentDelta) {
+			content += m["content"].(string)
+		}
+	}
+	if content != "Hello." {
+		t.Errorf("content = %q", content)
+	}
+
+	// Steer and cancel a running turn.
+	ws.send(ClientMessage{Type: ClientUserMessage, ID: "2", Content: "wait"})
+	<-started
+	ws.send(ClientMessage{Type: ClientUserMessage, ID: "3", Content: "hurry up"})
+	ws.send(ClientMessage{Type: ClientCancel, ID: "4"})
+	acks := map[string]map[string]any{}
+	for len(acks) < 3 {
+		if m := ws.next(); m["type"] == "ack" {
+			acks[m["id"].(string)] = m
+		}
+	}
+	if acks["3"]["status"] != "queued" || acks["4"]["status"] != "canceling" {
+		t.Errorf("unexpected acks: %v", acks)
+	}
+	c.waitIdle(id)
+
+	// Approvals.
+	ws.send(ClientMessage{Type: ClientUserMessage, ID: "5", Content: "pay"})
+	msgs = ws.until(string(claude.AgentEventApprovalRequired))
+	if approvals := msgs[len(msgs)-1]["approvals"].([]any); len(approvals) != 1 {
+		t.Fatalf("unexpected approvals: %v", approvals)
+	}
+	c.waitIdle(id)
+	ws.send(ClientMessage{Type: ClientApproval, ID: "6", Decisions: map[string]Decision{"c1": {Allow: true}}})
+	msgs = ws.until(string(claude.AgentEventComplete))
+	var result string
+	for _, m := range msgs {
+		if m["type"] == string(claude.AgentEventToolResult) {
+			result = m["tool_response"].(map[string]any)["content"].(string)
+		}
+	}
+	if result != "charged" {
+		t.Errorf("approved call result = %q", result)
+	}
+
+	// Permission mode changes are validated and saved.
+	ws.send(ClientMessage{Type: ClientSetPermissionMode, ID: "7", Mode: "yolo"})
+	ws.send(ClientMessage{Type: ClientSetPermissionMode, ID: "8", Mode: claude.PermissionAcceptEdits})
+	for _, want := range []string{"7", "8"} {
+		ack := ws.until("ack")
+		if last := ack[len(ack)-1]; last["id"] != want || (want == "7") != (last["error"] != nil) {
+			t.Errorf("unexpected ack: %v", last)
+		}
+	}
+	var state struct {
+		PermissionMode claude.PermissionMode `json:"permission_mode"`
+	}
+	c.do("GET", "/sessions/"+id, nil, &state)
+	if state.PermissionMode != claude.PermissionAcceptEdits {
+		t.Errorf("permission mode = %q", state.PermissionMode)
+	}
+}
+
+func TestWebSocketReplayAndOrigin(t *testing.T) {
+	c, id := newWSServer(t, Config{
+		Agent: claude.NewAPIAgent(claude.APIAgentConfig{Provider: claudeagenttest.NewScriptedProvider(
+			claudeagenttest.StreamedTextTurn("a", "b", "c", "d"),
+		)}),
+		EventBufferSize: 2,
+	})
+	c.do("POST", "/sessions/"+id+"/messages", map[string]string{"content": "go"}, nil)
+	c.waitIdle(id)
+
+	ws, _ := dialWS(t, c.base, "/sessions/"+id+"/ws?last_event_id=1", nil)
+	dropped := ws.next()
+	if dropped["type"] != "events_dropped" {
+		t.Fatalf("expected events_dropped, got %v", dropped)
+	}
+	if last := ws.until(string(claude.AgentEventComplete)); len(last) != 2 {
+		t.Errorf("expected only the buffered events, got %v", last)
+	}
+
+	_, resp := dialWS(t, c.base, "/sessions/"+id+"/ws", http.Header{"Origin": {"https://evil.example"}})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin upgrade: %d", resp.StatusCode)
+	}
+	_, resp = dialWS(t, c.base, "/sessions/missing/ws", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown session: %d", resp.StatusCode)
+	}
+}
+
+func TestWebSocketKeepalive(t *testing.T) {
+	c, id := newWSServer(t, Config{
+		Agent:     claude.NewAPIAgent(claude.APIAgentConfig{Provider: claudeagenttest.NewScriptedProvider()}),
+		Heartbeat: 20 * time.Millisecond,
+	})
+	ws, _ := dialWS(t, c.base, "/sessions/"+id+"/ws", nil)
+
+	ws.sendFrame(true, wsPing, []byte("hi"))
+	for {
+		op, payload, err := ws.readFrame()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if op == wsPing {
+			continue
+		}
+		if op != wsPong || string(payload) != "hi" {
+			t.Fatalf("expected a pong, got %d %q", op, payload)
+		}
+		break
+	}
+
+	// A client that never answers pings is disconnected.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		op, _, err := ws.readFrame()
+		if errors.Is(err, io.EOF) || op == wsClose {
+			return
+		}
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+	}
+	t.Error("silent client was not disconnected")
+}
@@ -0,0 +1,291 @@
+package agentserver
+
+import (
+	"bufio"
+	"crypto/sha1" //nolint:gosec // required by the WebSocket handshake (RFC 6455)
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal RFC 6455 server: no extensions or subprotocols, client frames
+// must be masked, and messages are limited to maxBodyBytes.
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsWriteTimeout bounds every frame write. A client that stops reading
+// fails the write and is disconnected instead of holding the connection.
+const wsWriteTimeout = 10 * time.Second
+
+// WebSocket opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// WebSocket close codes.
+const (
+	wsCloseNormal   = 1000
+	wsCloseProtocol = 1002
+	wsCloseTooBig   = 1009
+)
+
+var (
+	errWSProtocol = errors.New("websocket protocol error")
+	errWSTooBig   = errors.New("websocket message too big")
+)
+
+// wsConn is a server-side WebSocket connection. Reads happen on one
+// goroutine; writes are safe from any goroutine.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	// readTimeout, if set, is how long to wait for the next frame. Pongs
+	// to our pings count, so it also detects dead clients.
+	readTimeout time.Duration
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+// upgradeWebSocket completes the opening handshake. On failure it writes
+// an error response.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		err := errors.New("expected a WebSocket upgrade request")
+		writeError(w, http.StatusBadRequest, err)
+		return nil, err
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		err := errors.New("unsupported WebSocket version")
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, http.StatusUpgradeRequired, err)
+		return nil, err
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		err := errors.New("missing Sec-WebSocket-Key")
+		writeError(w, http.StatusBadRequest, err)
+		return nil, err
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		err := errors.New("response writer does not support hijacking")
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, err
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID)) //nolint:gosec // see import
+	_, err = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// headerHasToken reports whether a comma-separated header contains token.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether a browser request comes from the server's
+// own host. Requests without an Origin header are not from browsers.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// ReadMessage returns the next text or binary message. It answers pings
+// and the closing handshake itself, returning io.EOF when the client
+// closes the connection.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			switch {
+			case errors.Is(err, errWSTooBig):
+				c.Close(wsCloseTooBig, "message too big")
+			case errors.Is(err, errWSProtocol):
+				c.Close(wsCloseProtocol, "protocol error")
+			}
+			return nil, err
+		}
+
+		switch op {
+		case wsPing:
+			if err := c.write(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.Close(wsCloseNormal, "")
+			return nil, io.EOF
+		case wsText, wsBinary:
+			if started {
+				return nil, c.fail(errWSProtocol)
+			}
+			started = true
+			msg = payload
+		case wsContinuation:
+			if !started {
+				return nil, c.fail(errWSProtocol)
+			}
+			if len(msg)+len(payload) > maxBodyBytes {
+				c.Close(wsCloseTooBig, "message too big")
+				return nil, errWSTooBig
+			}
+			msg = append(msg, payload...)
+		default:
+			return nil, c.fail(errWSProtocol)
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *wsConn) fail(err error) error {
+	c.Close(wsCloseProtocol, "protocol error")
+	return err
+}
+
+// readFrame reads and unmasks one frame.
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	if c.readTimeout > 0 {
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = h[0]&0x80 != 0, h[0]&0x0F
+	if h[0]&0x70 != 0 || h[1]&0x80 == 0 {
+		// Reserved bits need an extension; client frames must be masked.
+		return false, 0, nil, errWSProtocol
+	}
+
+	n := uint64(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= wsClose && (n > 125 || !fin) {
+		return false, 0, nil, errWSProtocol
+	}
+	if n > maxBodyBytes {
+		return false, 0, nil, errWSTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// WriteText sends a text message.
+func (c *wsConn) WriteText(data []byte) error {
+	return c.write(wsText, data)
+}
+
+// Ping sends a ping; the client's pong extends the read deadline.
+func (c *wsConn) Ping() error {
+	return c.write(wsPing, nil)
+}
+
+func (c *wsConn) write(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return c.writeLocked(op, payload)
+}
+
+func (c *wsConn) writeLocked(op byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|op)
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame, if the connection is still open, and closes
+// it. It is safe to call more than once.
+func (c *wsConn) Close(code uint16, reason string) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	_ = c.writeLocked(wsClose, append(binary.BigEndian.AppendUint16(nil, code), reason...))
+	c.conn.Close()
+}
@@ -2,8 +2,11 @@ package claudeagent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -130,10 +133,31 @@ type APIAgent struct {
 	metrics           *MetricsCollector
 	parallelTools     bool
 	retry             *RetryConfig
+	repairInput       bool
 	budget            *BudgetConfig
 	history           *HistoryConfig
 	todoStore         *TodoStore
+	artifacts         *ArtifactRegistry
+	sessionStore      SessionStore
+	sessionLease      time.Duration
+	journal           Journal
+	unfinishedTools   UnfinishedToolPolicy
 	maxTokensRecovery *MaxTokensRecovery
+	pricing           *PricingTable
+
+	temperature            *float64
+	topP                   *float64
+	topK                   *int
+	stopSequences          []string
+	toolChoice             *ToolChoice
+	disableParallelToolUse bool
+	metadata               *RequestMetadata
+	promptCache            *PromptCacheConfig
+
+	// stopAfterTools, if set, is called after each turn's tool results;
+	// when it returns true the run ends as complete. RunStructured uses it
+	// to stop once the final answer is in.
+	stopAfterTools func() bool
 }
 
 // APIAgentConfig configures an API-based agent.
@@ -160,6 +184,30 @@ type APIAgentConfig struct {
 	// Defaults to 4096.
 	MaxTokens int
 
+	// Temperature, TopP, TopK and StopSequences are sent with every request.
+	// Nil or empty values use the provider's defaults.
+	Temperature   *float64
+	TopP          *float64
+	TopK          *int
+	StopSequences []string
+
+	// ToolChoice controls tool use on every turn. Nil lets the model decide.
+	// To change it for a single turn, use Hooks.OnPreRequest (see
+	// ForceToolOnTurn).
+	ToolChoice *ToolChoice
+
+	// DisableParallelToolUse limits the model to one tool call per response.
+	DisableParallelToolUse bool
+
+	// Metadata is sent with every request (e.g., an end-user ID).
+	Metadata *RequestMetadata
+
+	// PromptCache places prompt-cache breakpoints on the tool list, system
+	// prompt and end of the history each turn, so long sessions read the
+	// conversation from the cache instead of re-paying for it. Only applied
+	// by providers with prompt caching (Anthropic). Nil disables it.
+	PromptCache *PromptCacheConfig
+
 	// CanUseTool is called before tool execution to get permission.
 	// It is invoked before hooks.
 	CanUseTool CanUseToolFunc
@@ -187,11 +235,25 @@ type APIAgentConfig struct {
 	// Per-tool RetryConfig on ToolDefinition takes precedence over this global setting.
 	Retry *RetryConfig
 
-	// Budget sets resource limits (tokens, time) for the session.
+	// RepairToolInput fixes malformed tool input JSON from the model
+	// (trailing commas, single quotes, unquoted keys, truncated objects)
+	// before ValidateInput and the handler run. Repairs are recorded in
+	// ToolResultMetadata and ToolStats. Input that cannot be repaired, or
+	// that does not decode into the handler's type, gets an error that
+	// quotes the tool's input schema instead of Go's unmarshal error.
+	RepairToolInput bool
+
+	// Budget sets resource limits (tokens, cost, time) for the session.
 	// The session stops with BudgetExceededError when any limit is hit.
-	// Note: MaxCostUSD is not populated for APIAgent (use MaxTokens instead).
+	// MaxCostUSD is enforced using Pricing.
 	Budget *BudgetConfig
 
+	// Pricing prices each response for Budget.MaxCostUSD, ResultMessage.Cost
+	// and TurnMetrics.CostUSD, keyed by provider name and the model reported
+	// in ChatResponse.Model (falling back to the requested model).
+	// Defaults to DefaultPricingTable().
+	Pricing *PricingTable
+
 	// History controls conversation history compaction before each LLM call.
 	History *HistoryConfig
 
@@ -209,6 +271,30 @@ type APIAgentConfig struct {
 	// EnableTodos is true, a new store is created automatically.
 	TodoStore *TodoStore
 
+	// Artifacts registers the artifact tools and gives each Session its own
+	// artifacts, saved with the session. Run uses this registry directly.
+	Artifacts *ArtifactRegistry
+
+	// SessionStore persists sessions. With a store, LoadSession resumes a
+	// session by ID and Session.Send saves it before and after each run,
+	// failing with ErrSessionConflict if another worker changed it first.
+	SessionStore SessionStore
+	// SessionLease is how long a stored session stays claimed by the worker
+	// running it; other workers get ErrSessionBusy meanwhile. The run
+	// renews the lease every third of it and releases it when it ends, so
+	// only a crashed worker's lease expires and lets the session be taken
+	// over. Default: 10 minutes.
+	SessionLease time.Duration
+
+	// Journal records each step of every run so an interrupted run can be
+	// continued with ResumeRun. Use RunWithID to choose the run ID.
+	Journal Journal
+
+	// UnfinishedToolPolicy decides whether ResumeRun re-runs a tool call
+	// that started but did not finish. Nil skips such calls unless the tool
+	// is annotated Idempotent or ReadOnly.
+	UnfinishedToolPolicy UnfinishedToolPolicy
+
 	// MaxTokensRecovery, if non-nil, enables automatic retry with increased
 	// max_tokens when output is truncated.
 	MaxTokensRecovery *MaxTokensRecovery
@@ -237,6 +323,12 @@ func NewAPIAgent(cfg APIAgentConfig) *APIAgent {
 	if cfg.MaxTokens == 0 {
 		cfg.MaxTokens = 4096
 	}
+	if cfg.Pricing == nil {
+		cfg.Pricing = DefaultPricingTable()
+	}
+	if cfg.SessionLease == 0 {
+		cfg.SessionLease = 10 * time.Minute
+	}
 
 	tools := cfg.Tools
 	if tools == nil {
@@ -276,9 +368,25 @@ func NewAPIAgent(cfg APIAgentConfig) *APIAgent {
 		metrics:           cfg.Metrics,
 		parallelTools:     cfg.ParallelTools,
 		retry:             cfg.Retry,
+		repairInput:       cfg.RepairToolInput,
 		budget:            cfg.Budget,
 		history:           cfg.History,
 		maxTokensRecovery: cfg.MaxTokensRecovery,
+		artifacts:         cfg.Artifacts,
+		sessionStore:      cfg.SessionStore,
+		sessionLease:      cfg.SessionLease,
+		journal:           cfg.Journal,
+		unfinishedTools:   cfg.UnfinishedToolPolicy,
+		pricing:           cfg.Pricing,
+
+		temperature:            cfg.Temperature,
+		topP:                   cfg.TopP,
+		topK:                   cfg.TopK,
+		stopSequences:          cfg.StopSequences,
+		toolChoice:             cfg.ToolChoice,
+		disableParallelToolUse: cfg.DisableParallelToolUse,
+		metadata:               cfg.Metadata,
+		promptCache:            cfg.PromptCache,
 	}
 
 	// Register Task tool if subagents are configured
@@ -294,17 +402,156 @@ func NewAPIAgent(cfg APIAgentConfig) *APIAgent {
 		a.todoStore = initTodoStore(a.tools, cfg.TodoStore)
 	}
 
+	if cfg.Artifacts != nil {
+		a.tools.Merge(cfg.Artifacts.Tools())
+	}
+
 	return a
 }
 
 // Run executes the agent loop and streams events.
+// It returns a *CapabilityError without starting the loop when the provider
+// reports that it cannot serve the agent's configuration (e.g., tools are
+// registered but the model has no tool calling).
 func (a *APIAgent) Run(ctx context.Context, prompt string) (<-chan AgentEvent, error) {
+	return a.RunWithImages(ctx, prompt)
+}
+
+// RunWithImages is Run with images attached to the initial user message.
+// The provider must support vision.
+func (a *APIAgent) RunWithImages(ctx context.Context, prompt string, images ...ChatImage) (<-chan AgentEvent, error) {
+	return a.RunWithID(ctx, "run_"+randomID(24), prompt, images...)
+}
+
+// RunWithID is RunWithImages with a caller-chosen run ID. With a Journal
+// configured, the run is journaled under runID and can be continued with
+// ResumeRun after a crash.
+func (a *APIAgent) RunWithID(ctx context.Context, runID, prompt string, images ...ChatImage) (<-chan AgentEvent, error) {
+	if err := a.checkCapabilities(images); err != nil {
+		return nil, err
+	}
+	return a.start(ctx, runID, &runState{
+		history: []ChatMessage{{Role: ChatRoleUser, Content: prompt, Images: images}},
+	})
+}
+
+// RunHistory runs the agent loop from a conversation instead of a single
+// prompt, e.g. one kept by the caller, as chat completions clients do.
+// history must end with a user message or with the tool results of the
+// last assistant message. System messages in history are appended to the
+// agent's system prompt.
+//
+// clientTools are offered to the model alongside the agent's tools but
+// are run by the caller. When the model calls one, the run ends with an
+// AgentEventApprovalRequired whose requests have ClientTool set; run the
+// calls and call RunHistory again with their results appended. Calls to
+// the agent's own tools in that turn are dropped without running, since
+// their results could not reach the next run; the model sees only the
+// client calls in its history and can make the others again.
+func (a *APIAgent) RunHistory(ctx context.Context, history []ChatMessage, clientTools ...ToolDefinition) (<-chan AgentEvent, error) {
+	st := &runState{clientTools: clientTools}
+	var system []string
+	var images []ChatImage
+	for _, m := range history {
+		if m.Role == ChatRoleSystem {
+			system = append(system, m.Content)
+			continue
+		}
+		images = append(images, m.Images...)
+		st.history = append(st.history, m)
+	}
+	st.system = strings.Join(system, "\n\n")
+	if len(st.history) == 0 {
+		return nil, errors.New("history has no messages")
+	}
+	if last := st.history[len(st.history)-1].Role; last != ChatRoleUser && last != ChatRoleTool {
+		return nil, fmt.Errorf("history must end with a user or tool message, not %s", last)
+	}
+	for _, def := range clientTools {
+		if a.tools != nil && a.tools.Has(def.Name) {
+			return nil, fmt.Errorf("client tool %s conflicts with an agent tool", def.Name)
+		}
+	}
+	if err := a.checkCapabilities(images); err != nil {
+		return nil, err
+	}
+	return a.start(ctx, "run_"+randomID(24), st)
+}
+
+// start starts a new run of st, which holds the run's history.
+func (a *APIAgent) start(ctx context.Context, runID string, st *runState) (<-chan AgentEvent, error) {
+	st.todos = a.todoStore
+	st.budget = newBudgetTracker(a.budget)
+	st.steering = steeringFor(ctx)
+	st.steering.open()
+	if a.journal != nil {
+		st.journal = &runJournal{journal: a.journal, runID: runID}
+		if err := st.journal.record(ctx, JournalEntry{Type: JournalRunStart, Messages: st.history}); err != nil {
+			return nil, err
+		}
+	}
 	events := make(chan AgentEvent, 100)
-	go a.runLoop(ctx, prompt, events)
+	go a.runLoop(ctx, st, events)
 	return events, nil
 }
 
-func (a *APIAgent) runLoop(ctx context.Context, prompt string, events chan<- AgentEvent) {
+// runState is the conversation a run continues and updates. Run starts a
+// fresh one for every call; Session keeps one across Send calls.
+type runState struct {
+	// history ends with the user message that starts the run, or with
+	// tool results for RunHistory.
+	history []ChatMessage
+	todos   *TodoStore
+	budget  *budgetTracker
+	// usage accumulates the run's token usage and cost.
+	usage     SessionUsage
+	artifacts *ArtifactRegistry
+	// startTurn and pending are set when resuming from a journal or
+	// after approvals; approved holds the decisions made by a person.
+	startTurn int
+	pending   *pendingTools
+	approved  map[string]PermissionDecision
+	journal   *runJournal
+	// paused is set when the run stops to wait for approvals.
+	paused   *PendingApproval
+	steering *SteeringQueue
+	// permissionMode, if set, returns the session's current permission
+	// mode.
+	permissionMode func() PermissionMode
+	// system is appended to the agent's system prompt.
+	system string
+	// clientTools are offered to the model but run by the caller.
+	clientTools []ToolDefinition
+	// done, if set, is called with the final state before the event
+	// channel closes. An error it returns is sent as an AgentEventError.
+	done func(*runState) error
+}
+
+// checkCapabilities fails fast when the agent's configuration needs a
+// capability the provider's descriptor lacks. Providers without a
+// descriptor are assumed to support everything.
+func (a *APIAgent) checkCapabilities(images []ChatImage) error {
+	model := a.modelSel.currentModel()
+	caps, ok := CapabilitiesOf(a.provider, model)
+	if !ok {
+		return nil
+	}
+	if n := len(a.tools.Definitions()); n > 0 && !caps.Tools {
+		return &CapabilityError{
+			Provider: a.provider.Name(), Model: model, Capability: CapabilityTools,
+			Reason: fmt.Sprintf("%d tools registered", n),
+		}
+	}
+	if len(images) > 0 && !caps.Vision {
+		return &CapabilityError{
+			Provider: a.provider.Name(), Model: model, Capability: CapabilityVision,
+			Reason: fmt.Sprintf("%d images in prompt", len(images)),
+		}
+	}
+	return nil
+}
+
+func (a *APIAgent) runLoop(ctx context.Context, st *runState, events chan<- AgentEvent) {
 	defer close(events)
 	defer func() {
 		if a.metrics != nil {
@@ -316,22 +563,77 @@ func (a *APIAgent) runLoop(ctx context.Context, prompt string, events chan<- Age
 		a.metrics.recordSessionStart()
 	}
 
-	// Build initial chat history with canonical ChatMessage types.
-	history := []ChatMessage{{Role: ChatRoleUser, Content: prompt}}
+	history := st.history
+	defer func() {
+		st.steering.close()
+		st.history = history
+		if st.done != nil {
+			if err := st.done(st); err != nil {
+				events <- AgentEvent{Type: AgentEventError, Error: err}
+			}
+		}
+	}()
+	if st.todos != nil && st.todos != a.todoStore {
+		ctx = withTodoStore(ctx, st.todos)
+	}
+	if st.artifacts != nil && st.artifacts != a.artifacts {
+		ctx = withArtifacts(ctx, st.artifacts)
+	}
+	if st.journal != nil {
+		ctx = withRunJournal(ctx, st.journal)
+	}
+	if st.approved != nil {
+		ctx = withApprovals(ctx, st.approved)
+	}
+	if st.permissionMode != nil {
+		ctx = withPermissionMode(ctx, st.permissionMode)
+	}
+	// Always set, so subagents do not inherit the caller's client tools.
+	ctx = withClientTools(ctx, st.clientTools)
+	system, systemBlocks := a.system, a.systemBlocks
+	switch {
+	case st.system == "":
+	case len(systemBlocks) > 0:
+		systemBlocks = append(slices.Clip(systemBlocks), SystemPromptBlock{Text: st.system})
+	case system != "":
+		system += "\n\n" + st.system
+	default:
+		system = st.system
+	}
 
-	// Select tools for the first turn.
-	lastQuery := prompt
-	toolDefs := a.selectTools(ctx, lastQuery, events)
+	// Usage from before a resume counts towards the result.
+	totalInputTokens, totalOutputTokens := st.usage.InputTokens, st.usage.OutputTokens
+	var totalCacheCreation, totalCacheRead int
+	totalCost := st.usage.CostUSD
 
-	budget := newBudgetTracker(a.budget)
+	lastQuery := history[len(history)-1].Content
+	if st.pending != nil {
+		// Finish the tool calls of the turn the run was interrupted in.
+		results := a.resumeTools(ctx, st.pending, events)
+		if st.paused = pauseForApproval(results); st.paused != nil {
+			events <- AgentEvent{
+				Type:      AgentEventApprovalRequired,
+				Approvals: st.paused.Requests,
+				Result:    st.result(buildAPIResult(st.startTurn, "approval_required", totalInputTokens, totalOutputTokens, 0, 0, totalCost)),
+			}
+			return
+		}
+		var err error
+		if history, lastQuery, err = a.appendToolResults(ctx, st, history, results, lastQuery, events); err != nil {
+			events <- AgentEvent{Type: AgentEventError, Error: err}
+			return
+		}
+	}
 
-	var totalInputTokens, totalOutputTokens int
-	var totalCacheCreation, totalCacheRead int
+	// Select tools for the first turn.
+	toolDefs := append(a.selectTools(ctx, lastQuery, events), st.clientTools...)
 
-	for turn := 0; turn < a.maxTurns; turn++ {
+	budget := st.budget
+
+	for turn := st.startTurn; turn < a.maxTurns; turn++ {
 		select {
 		case <-ctx.Done():
-			events <- AgentEvent{Type: AgentEventError, Error: ctx.Err()}
+			events <- AgentEvent{Type: AgentEventError, Error: context.Cause(ctx)}
 			return
 		default:
 		}
@@ -341,9 +643,17 @@ func (a *APIAgent) runLoop(ctx context.Context, prompt string, events chan<- Age
 			return
 		}
 
+		// Deliver messages the user sent during the previous turn, after
+		// its tool results.
+		var err error
+		if history, _, err = a.deliverSteering(ctx, st, history, turn, events); err != nil {
+			events <- AgentEvent{Type: AgentEventError, Error: err}
+			return
+		}
+
 		// Rebuild tools if context builder is configured (dynamic selection per turn).
-		if a.contextBuilder != nil && turn > 0 {
-			toolDefs = a.selectTools(ctx, lastQuery, events)
+		if a.contextBuilder != nil && turn > st.startTurn {
+			toolDefs = append(a.selectTools(ctx, lastQuery, events), st.clientTools...)
 		}
 
 		// Compact history before sending to the LLM.
@@ -354,36 +664,69 @@ func (a *APIAgent) runLoop(ctx context.Context, prompt string, events chan<- Age
 			Model:        a.modelSel.currentModel(),
 			Messages:     llmHistory,
 			Tools:        toolDefs,
-			SystemPrompt: a.system,
-			SystemBlocks: a.systemBlocks,
+			SystemPrompt: system,
+			SystemBlocks: systemBlocks,
 			MaxTokens:    a.maxTokens,
+
+			Temperature:            a.temperature,
+			TopP:                   a.topP,
+			TopK:                   a.topK,
+			StopSequences:          a.stopSequences,
+			ToolChoice:             a.toolChoice,
+			DisableParallelToolUse: a.disableParallelToolUse,
+			Metadata:               a.metadata,
+			PromptCache:            a.promptCache,
 		}
+		req.Messages = fitChatHistoryToBudget(ctx, req, a.history, a.maxTokens)
+		a.hooks.RunPreRequestHooks(ctx, turn, &req)
 
 		// Translate streaming events to AgentEvents.
 		onEvent := func(se ChatStreamEvent) {
 			switch se.Type {
 			case ChatStreamContentDelta:
 				events <- AgentEvent{Type: AgentEventContentDelta, Content: se.Content}
+			case ChatStreamReasoningDelta:
+				events <- AgentEvent{Type: AgentEventReasoningDelta, Content: se.Content}
 			case ChatStreamToolUseStart:
 				events <- AgentEvent{Type: AgentEventToolUseStart, ToolCall: se.ToolCall}
 			case ChatStreamToolUseDelta:
 				events <- AgentEvent{Type: AgentEventToolUseDelta, Content: se.Content}
 			case ChatStreamToolUseEnd:
 				events <- AgentEvent{Type: AgentEventToolUseEnd, ToolCall: se.ToolCall}
+			case ChatStreamContentFilter:
+				events <- AgentEvent{Type: AgentEventContentFilter, ContentFilter: se.ContentFilter}
 			}
 		}
 
+		// Adapt to the provider's descriptor for this turn's model, which
+		// may change when FallbackModel kicks in.
+		caps, hasCaps := CapabilitiesOf(a.provider, req.Model)
+
 		// Call provider with max_tokens recovery retry loop.
-		turnMaxTokens := a.maxTokens
+		turnMaxTokens := req.MaxTokens
 		var resp ChatResponse
 		var llmLatency time.Duration
+		var turnCost float64
+		var turnUsage ChatUsage
 
 		for attempt := 0; ; attempt++ {
 			req.MaxTokens = turnMaxTokens
+			sendReq := req
+			if hasCaps {
+				var err error
+				if sendReq, err = adaptChatRequest(req, caps, a.provider.Name()); err != nil {
+					events <- AgentEvent{Type: AgentEventError, Error: err}
+					return
+				}
+			}
+			if err := st.journal.record(ctx, JournalEntry{Type: JournalLLMRequest, Turn: turn, Model: sendReq.Model}); err != nil {
+				events <- AgentEvent{Type: AgentEventError, Error: err}
+				return
+			}
 			events <- AgentEvent{Type: AgentEventMessageStart}
 			llmStart := time.Now()
 			var err error
-			resp, err = a.provider.Complete(ctx, req, onEvent)
+			resp, err = a.provider.Complete(ctx, sendReq, onEvent)
 			llmLatency = time.Since(llmStart)
 
 			if err != nil {
@@ -394,6 +737,39 @@ func (a *APIAgent) runLoop(ctx context.Context, prompt string, events chan<- Age
 			events <- AgentEvent{Type: AgentEventMessageEnd}
 			a.modelSel.recordSuccess()
 
+			// Every attempt is billed, including truncated ones that are retried.
+			cost, err := a.responseCost(sendReq.Model, resp)
+			if err != nil {
+				events <- AgentEvent{Type: AgentEventError, Error: err}
+				return
+			}
+			if err := st.journal.record(ctx, JournalEntry{Type: JournalLLMResponse, Turn: turn, Response: &resp, CostUSD: cost}); err != nil {
+				events <- AgentEvent{Type: AgentEventError, Error: err}
+				return
+			}
+			turnCost += cost
+			totalCost += cost
+			totalInputTokens += resp.Usage.InputTokens
+			totalOutputTokens += resp.Usage.OutputTokens
+			totalCacheCreation += resp.Usage.CacheCreationInputTokens
+			totalCacheRead += resp.Usage.CacheReadInputTokens
+			st.usage.InputTokens += resp.Usage.InputTokens
+			st.usage.OutputTokens += resp.Usage.OutputTokens
+			st.usage.CostUSD += cost
+			turnUsage.InputTokens += resp.Usage.InputTokens
+			turnUsage.OutputTokens += resp.Usage.OutputTokens
+			turnUsage.CacheCreationInputTokens += resp.Usage.CacheCreationInputTokens
+			turnUsage.CacheCreation1hInputTokens += resp.Usage.CacheCreation1hInputTokens
+			turnUsage.CacheReadInputTokens += resp.Usage.CacheReadInputTokens
+			if a.metrics != nil {
+				a.metrics.recordPromptCache(resp.Usage, a.cacheSavings(sendReq.Model, resp))
+			}
+
+			if err := budget.record(resp.Usage.InputTokens, resp.Usage.OutputTokens, cost); err != nil {
+				events <- AgentEvent{Type: AgentEventError, Error: err}
+				return
+			}
+
 			if shouldRecoverMaxTokens(resp.StopReason, resp.ToolCalls, a.maxTokensRecovery, attempt) {
 				defaults := a.maxTokensRecovery.withDefaults()
 				turnMaxTokens = defaults.nextMaxTokens(turnMaxTokens)
@@ -402,67 +778,60 @@ func (a *APIAgent) runLoop(ctx context.Context, prompt string, events chan<- Age
 			break
 		}
 
-		totalInputTokens += resp.Usage.InputTokens
-		totalOutputTokens += resp.Usage.OutputTokens
-		totalCacheCreation += resp.Usage.CacheCreationInputTokens
-		totalCacheRead += resp.Usage.CacheReadInputTokens
-
-		if err := budget.record(resp.Usage.InputTokens, resp.Usage.OutputTokens, 0); err != nil {
-			events <- AgentEvent{Type: AgentEventError, Error: err}
-			return
-		}
+		st.usage.Turns++
 
 		if len(resp.ToolCalls) == 0 {
+			reply := ChatMessage{Role: ChatRoleAssistant, Content: resp.Content}
+			history = append(history, reply)
 			stopReason := resp.StopReason
 			if stopReason == "" {
 				stopReason = "end_turn"
 			}
+			if err := st.journal.record(ctx, JournalEntry{Type: JournalMessages, Turn: turn, Messages: []ChatMessage{reply}}); err != nil {
+				events <- AgentEvent{Type: AgentEventError, Error: err}
+				return
+			}
+			// Continue if the user sent more while the model was replying.
+			if !st.steering.closeIfEmpty() {
+				events <- AgentEvent{Type: AgentEventTurnComplete}
+				continue
+			}
+			if err := st.journal.record(ctx, JournalEntry{Type: JournalRunEnd, Turn: turn, StopReason: stopReason}); err != nil {
+				events <- AgentEvent{Type: AgentEventError, Error: err}
+				return
+			}
 			events <- AgentEvent{
 				Type:   AgentEventComplete,
-				Result: buildAPIResult(turn+1, stopReason, totalInputTokens, totalOutputTokens, totalCacheCreation, totalCacheRead),
+				Result: st.result(buildAPIResult(turn+1, stopReason, totalInputTokens, totalOutputTokens, totalCacheCreation, totalCacheRead, totalCost)),
 			}
 			return
 		}
 
 		// Append assistant message with tool calls to history.
-		history = append(history, ChatMessage{
+		resp.ToolCalls = clientCallsOnly(ctx, resp.ToolCalls)
+		assistant := ChatMessage{
 			Role:      ChatRoleAssistant,
 			Content:   resp.Content,
 			ToolCalls: resp.ToolCalls,
-		})
-
-		toolResults := a.executeTools(ctx, resp.ToolCalls, events)
-
-		emitTodoEvents(a.todoStore, resp.ToolCalls, toolResults, events)
-
-		var resultContext string
-		var injectedMessages []ConversationMessage
-		for _, tr := range toolResults {
-			history = append(history, ChatMessage{
-				Role:       ChatRoleTool,
-				Content:    tr.Content,
-				ToolCallID: tr.ToolUseID,
-				IsError:    tr.IsError,
-			})
-			if !tr.IsError && tr.Content != "" {
-				resultContext += tr.Content + " "
-			}
-			if tr.Metadata != nil {
-				injectedMessages = append(injectedMessages, tr.Metadata.InjectMessages...)
-			}
 		}
-		if resultContext != "" {
-			lastQuery = resultContext
+		history = append(history, assistant)
+		if err := st.journal.record(ctx, JournalEntry{Type: JournalMessages, Turn: turn, Messages: []ChatMessage{assistant}}); err != nil {
+			events <- AgentEvent{Type: AgentEventError, Error: err}
+			return
 		}
 
-		// Inject any metadata messages from structured tool handlers.
-		for _, msg := range injectedMessages {
-			switch msg.Role {
-			case "user":
-				history = append(history, ChatMessage{Role: ChatRoleUser, Content: msg.Content})
-			case "assistant":
-				history = append(history, ChatMessage{Role: ChatRoleAssistant, Content: msg.Content})
+		toolResults := a.executeTools(ctx, resp.ToolCalls, events)
+		if st.paused = pauseForApproval(toolResults); st.paused != nil {
+			events <- AgentEvent{
+				Type:      AgentEventApprovalRequired,
+				Approvals: st.paused.Requests,
+				Result:    st.result(buildAPIResult(turn+1, "approval_required", totalInputTokens, totalOutputTokens, totalCacheCreation, totalCacheRead, totalCost)),
 			}
+			return
+		}
+		if history, lastQuery, err = a.appendToolResults(ctx, st, history, toolResults, lastQuery, events); err != nil {
+			events <- AgentEvent{Type: AgentEventError, Error: err}
+			return
 		}
 
 		var tm *TurnMetrics
@@ -475,25 +844,102 @@ func (a *APIAgent) runLoop(ctx context.Context, prompt string, events chan<- Age
 				TurnIndex:    turn,
 				LLMLatency:   llmLatency,
 				ToolsInvoked: toolNames,
+				CostUSD:      turnCost,
+				Usage:        turnUsage,
 			}
 			a.metrics.recordTurn(recorded)
 			tm = &recorded
 		}
 		events <- AgentEvent{Type: AgentEventTurnComplete, TurnMetrics: tm}
+
+		if a.stopAfterTools != nil && a.stopAfterTools() {
+			if err := st.journal.record(ctx, JournalEntry{Type: JournalRunEnd, Turn: turn, StopReason: "tool_use"}); err != nil {
+				events <- AgentEvent{Type: AgentEventError, Error: err}
+				return
+			}
+			events <- AgentEvent{
+				Type:   AgentEventComplete,
+				Result: st.result(buildAPIResult(turn+1, "tool_use", totalInputTokens, totalOutputTokens, totalCacheCreation, totalCacheRead, totalCost)),
+			}
+			return
+		}
 	}
 
+	if err := st.journal.record(ctx, JournalEntry{Type: JournalRunEnd, Turn: a.maxTurns, StopReason: "max_turns"}); err != nil {
+		events <- AgentEvent{Type: AgentEventError, Error: err}
+	}
 	events <- AgentEvent{
 		Type:   AgentEventError,
 		Error:  fmt.Errorf("max turns (%d) reached", a.maxTurns),
-		Result: buildAPIResult(a.maxTurns, "max_turns", totalInputTokens, totalOutputTokens, totalCacheCreation, totalCacheRead),
+		Result: st.result(buildAPIResult(a.maxTurns, "max_turns", totalInputTokens, totalOutputTokens, totalCacheCreation, totalCacheRead, totalCost)),
 	}
 }
 
+// appendToolResults adds a turn's tool results, and any messages the tools
+// inject, to history. The assistant message with the calls must be last in
+// history. It returns the new history and the query for tool selection.
+func (a *APIAgent) appendToolResults(
+	ctx context.Context,
+	st *runState,
+	history []ChatMessage,
+	toolResults []ToolResponse,
+	lastQuery string,
+	events chan<- AgentEvent,
+) ([]ChatMessage, string, error) {
+	calls := history[len(history)-1].ToolCalls
+	repairToolCallInputs(calls, toolResults)
+
+	emitTodoEvents(st.todos, calls, toolResults, events)
+
+	start := len(history)
+	var resultContext string
+	var injectedMessages []ConversationMessage
+	for _, tr := range toolResults {
+		history = append(history, ChatMessage{
+			Role:       ChatRoleTool,
+			Content:    tr.Content,
+			ToolCallID: tr.ToolUseID,
+			IsError:    tr.IsError,
+		})
+		if !tr.IsError && tr.Content != "" {
+			resultContext += tr.Content + " "
+		}
+		if tr.Metadata != nil {
+			injectedMessages = append(injectedMessages, tr.Metadata.InjectMessages...)
+		}
+	}
+	if resultContext != "" {
+		lastQuery = resultContext
+	}
+
+	// Inject any metadata messages from structured tool handlers.
+	for _, msg := range injectedMessages {
+		switch msg.Role {
+		case "user":
+			history = append(history, ChatMessage{Role: ChatRoleUser, Content: msg.Content})
+		case "assistant":
+			history = append(history, ChatMessage{Role: ChatRoleAssistant, Content: msg.Content})
+		}
+	}
+
+	err := st.journal.record(ctx, JournalEntry{Type: JournalMessages, Messages: history[start:]})
+	return history, lastQuery, err
+}
+
+// result tags a run's result with its journal run ID.
+func (st *runState) result(r *ResultMessage) *ResultMessage {
+	if st.journal != nil {
+		r.SessionID = st.journal.runID
+	}
+	return r
+}
+
 // buildAPIResult constructs a ResultMessage with accumulated token usage.
-func buildAPIResult(numTurns int, stopReason string, inputTokens, outputTokens, cacheCreation, cacheRead int) *ResultMessage {
+func buildAPIResult(numTurns int, stopReason string, inputTokens, outputTokens, cacheCreation, cacheRead int, cost float64) *ResultMessage {
 	return &ResultMessage{
 		Type:         "result",
 		Subtype:      "success",
+		Cost:         cost,
 		NumTurns:     numTurns,
 		StopReason:   stopReason,
 		InputTokens:  inputTokens,
@@ -507,6 +953,34 @@ func buildAPIResult(numTurns int, stopReason string, inputTokens, outputTokens,
 	}
 }
 
+// responseCost prices one response with the agent's pricing table. A model
+// without pricing costs 0, unless a cost budget needs it.
+func (a *APIAgent) responseCost(requestModel string, resp ChatResponse) (float64, error) {
+	model := resp.Model
+	if model == "" {
+		model = requestModel
+	}
+	cost, ok := a.pricing.Cost(a.provider.Name(), model, resp.Usage)
+	if !ok && a.budget != nil && a.budget.MaxCostUSD > 0 {
+		return 0, &PricingNotFoundError{Provider: a.provider.Name(), Model: model}
+	}
+	return cost, nil
+}
+
+// cacheSavings prices the prompt-cache saving of resp, or 0 if the model
+// has no pricing.
+func (a *APIAgent) cacheSavings(requestModel string, resp ChatResponse) float64 {
+	model := resp.Model
+	if model == "" {
+		model = requestModel
+	}
+	p, ok := a.pricing.Lookup(a.provider.Name(), model)
+	if !ok {
+		return 0
+	}
+	return p.CacheSavings(resp.Usage)
+}
+
 // selectTools returns the tool definitions to send on a given turn.
 // When a ContextBuilder is configured it does semantic selection; otherwise all tools.
 func (a *APIAgent) selectTools(ctx context.Context, query string, events chan<- AgentEvent) []ToolDefinition {
@@ -554,19 +1028,11 @@ func compactChatHistory(ctx context.Context, history []ChatMessage, cfg *History
 	// Optionally summarize dropped messages.
 	dropped := history[1 : cutIdx+1]
 	if cfg.Summarizer != nil && len(dropped) > cfg.SummarizeThreshold {
-		// Convert ChatMessages to ConversationMessages for the summarizer.
-		conv := make([]ConversationMessage, 0, len(dropped))
-		for _, m := range dropped {
-			conv = append(conv, ConversationMessage{
-				Role:    string(m.Role),
-				Content: m.Content,
-			})
-		}
-		if summary, err := cfg.Summarizer(ctx, conv); err == nil && summary != "" {
+		if summary, err := cfg.Summarizer(ctx, chatToConversation(dropped)); err == nil && summary != "" {
 			out := make([]ChatMessage, 0, 2+(len(history)-(cutIdx+1)))
 			out = append(out, history[0], ChatMessage{
 				Role:    ChatRoleUser,
-				Content: "[Previous conversation summary]\n" + summary,
+				Content: historySummaryPrefix + summary,
 			})
 			return append(out, history[cutIdx+1:]...)
 		}
@@ -583,7 +1049,18 @@ func (a *APIAgent) executeTools(
 	toolCalls []ToolCall,
 	events chan<- AgentEvent,
 ) []ToolResponse {
-	return runToolsSmart(ctx, toolCalls, a.tools, a.hooks, a.canUseTool, a.retry, a.metrics, events, a.parallelTools)
+	return runToolsSmart(ctx, toolCalls, a.tools, a.hooks, a.canUseTool, a.retry, a.repairInput, a.metrics, events, a.parallelTools)
+}
+
+// repairToolCallInputs replaces the input of each tool call whose result
+// reports a repair, so the history sent back to the provider holds valid
+// JSON.
+func repairToolCallInputs(calls []ToolCall, results []ToolResponse) {
+	for i, tr := range results {
+		if i < len(calls) && tr.Metadata != nil && tr.Metadata.RepairedInput != nil {
+			calls[i].Input = tr.Metadata.RepairedInput
+		}
+	}
 }
 
 // TodoStore returns the agent's TodoStore, or nil if todos are not enabled.
@@ -591,33 +1068,38 @@ func (a *APIAgent) TodoStore() *TodoStore {
 	return a.todoStore
 }
 
+// SessionStore returns the agent's SessionStore, or nil.
+func (a *APIAgent) SessionStore() SessionStore {
+	return a.sessionStore
+}
+
+// Metrics returns the agent's MetricsCollector, or nil.
+func (a *APIAgent) Metrics() *MetricsCollector {
+	return a.metrics
+}
+
 // SystemPromptBlock is a section of the system prompt with optional cache control.
 type SystemPromptBlock struct {
 	// Text is the content of this system prompt section.
-	Text string
+	Text string `json:"text"`
 	// CacheControl, when non-nil, enables Anthropic prompt caching for this block.
 	// Set to &CacheControl{Type: "ephemeral"} for standard caching behavior.
-	CacheControl *CacheControl
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
 }
 
 // CacheControl configures prompt caching for a system prompt block.
 type CacheControl struct {
-	Type string // "ephemeral"
+	Type string `json:"type"` // "ephemeral"
+	// TTL is CacheTTL5m (the default when empty) or CacheTTL1h.
+	TTL string `json:"ttl,omitempty"`
 }
 
-// RunSync executes the agent and returns all text output.
+// RunSync executes the agent and returns the assistant's text output.
+// Reasoning, tool results and other events are not included.
 func (a *APIAgent) RunSync(ctx context.Context, prompt string) (string, error) {
 	events, err := a.Run(ctx, prompt)
 	if err != nil {
 		return "", err
 	}
-
-	var content string
-	for event := range events {
-		if event.Error != nil {
-			return content, event.Error
-		}
-		content += event.Content
-	}
-	return content, nil
+	return collectText(events)
 }
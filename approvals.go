@@ -0,0 +1,139 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrApprovalPending is returned by Session.Send while the session is
+// waiting for tool approvals; call Session.Resolve first.
+var ErrApprovalPending = errors.New("session is waiting for tool approval")
+
+// awaitingApprovalResult is the result content of a call waiting for
+// approval, seen only by callers that do not support pausing.
+const awaitingApprovalResult = "Tool execution denied: approval required"
+
+// ApprovalRequest is a tool call waiting for a person to approve it.
+type ApprovalRequest struct {
+	ToolCallID string          `json:"tool_call_id"`
+	ToolName   string          `json:"tool_name"`
+	Input      json.RawMessage `json:"input"`
+	// Reason is the PermissionDecision's reason for asking.
+	Reason string `json:"reason,omitempty"`
+	// ClientTool is set for calls of a client tool passed to RunHistory,
+	// which the caller runs instead of approving.
+	ClientTool bool `json:"client_tool,omitempty"`
+}
+
+// PendingApproval is the state of a run paused for approval. The last
+// message of the session history is the assistant message with the calls.
+type PendingApproval struct {
+	// Requests are the calls waiting for a decision.
+	Requests []ApprovalRequest `json:"requests"`
+	// Results are the results of the turn's other calls, which already ran.
+	Results []ToolResponse `json:"results,omitempty"`
+}
+
+// pauseForApproval reports whether results include calls waiting for
+// approval, and if so returns the PendingApproval for them.
+func pauseForApproval(results []ToolResponse) *PendingApproval {
+	var p PendingApproval
+	for _, tr := range results {
+		if tr.approval != nil {
+			p.Requests = append(p.Requests, *tr.approval)
+		} else {
+			p.Results = append(p.Results, tr)
+		}
+	}
+	if len(p.Requests) == 0 {
+		return nil
+	}
+	return &p
+}
+
+// resolvedPending turns a PendingApproval and decisions for its requests
+// into the pending tools of a resumed turn. Denied calls get an error
+// result; approved ones are returned for execution without asking again.
+func resolvedPending(calls []ToolCall, p *PendingApproval, decisions map[string]PermissionDecision) (*pendingTools, map[string]PermissionDecision, error) {
+	pending := &pendingTools{calls: calls, results: make(map[string]ToolResponse)}
+	for _, tr := range p.Results {
+		pending.results[tr.ToolUseID] = tr
+	}
+	approved := make(map[string]PermissionDecision)
+	for _, req := range p.Requests {
+		d, ok := decisions[req.ToolCallID]
+		switch {
+		case !ok:
+			return nil, nil, fmt.Errorf("no decision for tool call %s (%s)", req.ToolCallID, req.ToolName)
+		case d.Ask:
+			return nil, nil, fmt.Errorf("decision for tool call %s must allow or deny", req.ToolCallID)
+		case d.Allow:
+			approved[req.ToolCallID] = d
+		default:
+			reason := d.Reason
+			if reason == "" {
+				reason = "permission denied"
+			}
+			pending.results[req.ToolCallID] = ToolResponse{
+				ToolUseID: req.ToolCallID,
+				Content:   fmt.Sprintf("Tool execution denied: %s", reason),
+				IsError:   true,
+			}
+		}
+	}
+	return pending, approved, nil
+}
+
+// approvalsKey is the context key for decisions made by Session.Resolve.
+type approvalsKey struct{}
+
+func withApprovals(ctx context.Context, approved map[string]PermissionDecision) context.Context {
+	return context.WithValue(ctx, approvalsKey{}, approved)
+}
+
+// approvalFor returns the decision a person made for the call, if any.
+func approvalFor(ctx context.Context, toolUseID string) (PermissionDecision, bool) {
+	approved, _ := ctx.Value(approvalsKey{}).(map[string]PermissionDecision)
+	d, ok := approved[toolUseID]
+	return d, ok
+}
+
+// PendingApprovals returns the tool calls the session is waiting on, or nil.
+func (s *Session) PendingApprovals() []ApprovalRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil {
+		return nil
+	}
+	return append([]ApprovalRequest(nil), s.pending.Requests...)
+}
+
+// Resolve continues a session paused with AgentEventApprovalRequired.
+// decisions maps every pending ToolCallID to a PermissionDecision: Allow
+// runs the call, with ModifiedInput if set, and otherwise the call is
+// denied with Reason. Approved calls skip CanUseTool but still go through
+// hooks and validation. Events stream as with Send.
+//
+// With a SessionStore the pause survives restarts: a different process can
+// LoadSession and Resolve it.
+func (s *Session) Resolve(ctx context.Context, decisions map[string]PermissionDecision) (<-chan AgentEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busyLocked() {
+		return nil, ErrSessionBusy
+	}
+	if s.pending == nil || len(s.history) == 0 {
+		return nil, errors.New("session is not waiting for tool approval")
+	}
+	calls := s.history[len(s.history)-1].ToolCalls
+	pending, approved, err := resolvedPending(calls, s.pending, decisions)
+	if err != nil {
+		return nil, err
+	}
+	st := s.newRunState(ctx, append([]ChatMessage(nil), s.history...))
+	st.pending = pending
+	st.approved = approved
+	return s.startLocked(ctx, st)
+}
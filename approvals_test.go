@@ -0,0 +1,129 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func askForCharges(_ context.Context, toolName, _ string, _ json.RawMessage) PermissionDecision {
+	if toolName == "charge" {
+		return PermissionDecision{Ask: true, Reason: "charges need sign-off"}
+	}
+	return PermissionDecision{Allow: true}
+}
+
+func TestSessionPausesForApproval(t *testing.T) {
+	var inputs []string
+	tools := NewToolRegistry()
+	tools.Register(ToolDefinition{Name: "lookup"}, func(context.Context, json.RawMessage) (string, error) {
+		return "balance 100", nil
+	})
+	tools.Register(ToolDefinition{Name: "charge"}, func(_ context.Context, input json.RawMessage) (string, error) {
+		inputs = append(inputs, string(input))
+		return "charged", nil
+	})
+	calls := []ToolCall{
+		{ID: "c1", Name: "charge", Input: json.RawMessage(`{"amount":50}`)},
+		{ID: "l1", Name: "lookup", Input: json.RawMessage(`{}`)},
+		{ID: "c2", Name: "charge", Input: json.RawMessage(`{"amount":5}`)},
+	}
+	store := NewMemorySessionStore()
+	ctx := context.Background()
+
+	// The first worker runs until the model wants to charge.
+	first := NewAPIAgent(APIAgentConfig{
+		Provider: &cassetteScriptProvider{responses: []ChatResponse{
+			{ToolCalls: calls, StopReason: "tool_use"},
+		}},
+		Tools:        tools,
+		CanUseTool:   askForCharges,
+		SessionStore: store,
+	})
+	session := first.NewSession()
+	events, err := session.Send(ctx, "settle the bill")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	var last AgentEvent
+	for e := range events {
+		if e.Error != nil {
+			t.Fatalf("run error: %v", e.Error)
+		}
+		last = e
+	}
+	if last.Type != AgentEventApprovalRequired || len(last.Approvals) != 2 ||
+		last.Approvals[0].ToolCallID != "c1" || last.Approvals[1].Reason != "charges need sign-off" {
+		t.Fatalf("run should end waiting for the two charges, got %+v", last)
+	}
+	if last.Result == nil || last.Result.StopReason != "approval_required" {
+		t.Errorf("unexpected result: %+v", last.Result)
+	}
+	if len(inputs) != 0 {
+		t.Error("charges ran before approval")
+	}
+	if _, err := session.Send(ctx, "hello?"); !errors.Is(err, ErrApprovalPending) {
+		t.Errorf("Send while paused: %v", err)
+	}
+
+	// A person decides later, in another worker.
+	provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{Content: "Charged 40.", StopReason: "end_turn"},
+	}}}
+	second := NewAPIAgent(APIAgentConfig{
+		Provider:     provider,
+		Tools:        tools,
+		CanUseTool:   askForCharges,
+		SessionStore: store,
+	})
+	resumed, err := second.LoadSession(ctx, session.ID())
+	if err != nil {
+		t.Fatalf("LoadSession: %v", err)
+	}
+	if pending := resumed.PendingApprovals(); len(pending) != 2 {
+		t.Fatalf("pending approvals not persisted: %+v", pending)
+	}
+	if _, err := resumed.Resolve(ctx, map[string]PermissionDecision{"c1": {Allow: true}}); err == nil {
+		t.Error("Resolve should require a decision for every pending call")
+	}
+
+	reply, err := drainSession(resumed.Resolve(ctx, map[string]PermissionDecision{
+		"c1": {Allow: true, ModifiedInput: json.RawMessage(`{"amount":40}`)},
+		"c2": {Reason: "duplicate"},
+	}))
+	if err != nil || reply != "Charged 40." {
+		t.Fatalf("Resolve: %q, %v", reply, err)
+	}
+	if len(inputs) != 1 || inputs[0] != `{"amount":40}` {
+		t.Errorf("approved charge should run once with the edited input, got %v", inputs)
+	}
+	msgs := provider.requests[0].Messages
+	if len(msgs) != 5 || msgs[2].Content != "charged" || msgs[3].Content != "balance 100" ||
+		!msgs[4].IsError || msgs[4].Content != "Tool execution denied: duplicate" {
+		t.Errorf("tool results should follow call order, got %+v", msgs)
+	}
+	if resumed.PendingApprovals() != nil {
+		t.Error("approvals should be cleared after Resolve")
+	}
+	if _, err := resumed.Resolve(ctx, nil); err == nil {
+		t.Error("Resolve without pending approvals should fail")
+	}
+}
+
+// drainSession collects the text of a Send or Resolve.
+func drainSession(events <-chan AgentEvent, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	var content string
+	for e := range events {
+		if e.Error != nil && err == nil {
+			err = e.Error
+		}
+		if e.Type == AgentEventContentDelta {
+			content += e.Content
+		}
+	}
+	return content, err
+}
@@ -90,7 +90,8 @@ type updateInput struct {
 	Content string `json:"content"`
 }
 
-func (r *ArtifactRegistry) handleCreate(_ context.Context, input createInput) (string, error) {
+func (r *ArtifactRegistry) handleCreate(ctx context.Context, input createInput) (string, error) {
+	r = artifactsFor(ctx, r)
 	switch input.Type {
 	case ArtifactHTML, ArtifactJSX, ArtifactText:
 	default:
@@ -124,7 +125,8 @@ func (r *ArtifactRegistry) handleCreate(_ context.Context, input createInput) (s
 	return fmt.Sprintf("Created artifact %q (id: %s, type: %s, %d bytes)", a.Title, id, input.Type, len(input.Content)), nil
 }
 
-func (r *ArtifactRegistry) handleUpdate(_ context.Context, input updateInput) (string, error) {
+func (r *ArtifactRegistry) handleUpdate(ctx context.Context, input updateInput) (string, error) {
+	r = artifactsFor(ctx, r)
 	if input.Content == "" {
 		return "", fmt.Errorf("content is required")
 	}
@@ -170,6 +172,42 @@ func (r *ArtifactRegistry) All() []Artifact {
 	return result
 }
 
+// restore replaces the registry's artifacts with items, in order, and
+// continues ID numbering after the highest restored ID.
+func (r *ArtifactRegistry) restore(items []Artifact) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.artifacts = make(map[string]*Artifact, len(items))
+	r.order = r.order[:0]
+	r.nextID = 0
+	for _, a := range items {
+		a := a
+		r.artifacts[a.ID] = &a
+		r.order = append(r.order, a.ID)
+		var n int
+		if _, err := fmt.Sscanf(a.ID, "artifact_%d", &n); err == nil && n > r.nextID {
+			r.nextID = n
+		}
+	}
+}
+
+// artifactsKey is the context key for a run's ArtifactRegistry.
+type artifactsKey struct{}
+
+// withArtifacts directs the artifact tools to r for calls made with ctx, so
+// each Session keeps its own artifacts while sharing the agent's registry.
+func withArtifacts(ctx context.Context, r *ArtifactRegistry) context.Context {
+	return context.WithValue(ctx, artifactsKey{}, r)
+}
+
+// artifactsFor returns the ArtifactRegistry set on ctx, or fallback.
+func artifactsFor(ctx context.Context, fallback *ArtifactRegistry) *ArtifactRegistry {
+	if r, ok := ctx.Value(artifactsKey{}).(*ArtifactRegistry); ok {
+		return r
+	}
+	return fallback
+}
+
 // Count returns the number of artifacts.
 func (r *ArtifactRegistry) Count() int {
 	r.mu.RLock()
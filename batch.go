@@ -0,0 +1,365 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/packages/param"
+)
+
+// maxBatchRequests is the Message Batches API limit on requests per batch.
+const maxBatchRequests = 100_000
+
+// defaultBatchMaxTokens is used for requests without MaxTokens, matching
+// APIAgentConfig.MaxTokens; the API rejects max_tokens of 0.
+const defaultBatchMaxTokens = 4096
+
+// batchIDPattern matches valid custom_id values.
+var batchIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// BatchRequest is one request in a batch.
+type BatchRequest struct {
+	// ID identifies the request in the results. It must be unique within the
+	// batch and consist of 1-64 letters, digits, underscores or hyphens.
+	ID string
+	// Request is sent as a single non-streaming Messages API call. A zero
+	// MaxTokens defaults to 4096.
+	Request ChatRequest
+}
+
+// BatchResultStatus is the outcome of one batch request.
+type BatchResultStatus string
+
+const (
+	// BatchSucceeded means Response holds the model's reply.
+	BatchSucceeded BatchResultStatus = "succeeded"
+	// BatchErrored means the request failed; see Error and ErrorType.
+	BatchErrored BatchResultStatus = "errored"
+	// BatchCanceled means the batch was canceled before the request ran.
+	BatchCanceled BatchResultStatus = "canceled"
+	// BatchExpired means the batch expired before the request ran.
+	BatchExpired BatchResultStatus = "expired"
+)
+
+// BatchResult is the outcome of one BatchRequest.
+type BatchResult struct {
+	// ID is the BatchRequest.ID.
+	ID string `json:"id"`
+	// Status is the request's outcome.
+	Status BatchResultStatus `json:"status"`
+	// Response is set when Status is BatchSucceeded.
+	Response *ChatResponse `json:"response,omitempty"`
+	// ErrorType and Error describe the failure when Status is BatchErrored
+	// (e.g., "invalid_request_error").
+	ErrorType string `json:"error_type,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// BatchStatus is a point-in-time view of a batch.
+type BatchStatus struct {
+	// ID is the batch ID. Save it to resume with BatchRunner.Resume.
+	ID string `json:"id"`
+	// ProcessingStatus is "in_progress", "canceling" or "ended".
+	ProcessingStatus string `json:"processing_status"`
+	// Request counts by state. Only Processing is non-zero until the batch ends.
+	Processing int       `json:"processing"`
+	Succeeded  int       `json:"succeeded"`
+	Errored    int       `json:"errored"`
+	Canceled   int       `json:"canceled"`
+	Expired    int       `json:"expired"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	EndedAt    time.Time `json:"ended_at,omitempty"`
+}
+
+// Ended reports whether the batch has finished processing.
+func (s BatchStatus) Ended() bool {
+	return s.ProcessingStatus == string(anthropic.MessageBatchProcessingStatusEnded)
+}
+
+// BatchResults holds the results of a finished batch, keyed by request ID.
+type BatchResults struct {
+	BatchID string
+	Results map[string]BatchResult
+}
+
+// Get returns the result for a request ID.
+func (r *BatchResults) Get(id string) (BatchResult, bool) {
+	res, ok := r.Results[id]
+	return res, ok
+}
+
+// Failed returns the results that did not succeed, sorted by ID.
+func (r *BatchResults) Failed() []BatchResult {
+	var out []BatchResult
+	for _, res := range r.Results {
+		if res.Status != BatchSucceeded {
+			out = append(out, res)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Err returns a *BatchFailuresError listing the requests that did not
+// succeed, or nil if all of them did.
+func (r *BatchResults) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	return &BatchFailuresError{BatchID: r.BatchID, Total: len(r.Results), Failed: failed}
+}
+
+// BatchFailuresError reports the requests in a batch that did not succeed.
+// The batch itself completed; the successful results are still available.
+type BatchFailuresError struct {
+	BatchID string
+	Total   int
+	Failed  []BatchResult
+}
+
+func (e *BatchFailuresError) Error() string {
+	counts := map[BatchResultStatus]int{}
+	for _, f := range e.Failed {
+		counts[f.Status]++
+	}
+	var parts []string
+	for _, s := range []BatchResultStatus{BatchErrored, BatchCanceled, BatchExpired} {
+		if counts[s] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[s], s))
+		}
+	}
+	return fmt.Sprintf("batch %s: %d of %d requests failed (%s)",
+		e.BatchID, len(e.Failed), e.Total, strings.Join(parts, ", "))
+}
+
+// BatchConfig configures a BatchRunner.
+type BatchConfig struct {
+	// PollInterval is the delay before the first status check. It doubles
+	// after each check that finds the batch still running, up to
+	// MaxPollInterval. Default: 5s.
+	PollInterval time.Duration
+	// MaxPollInterval caps the polling delay. Default: 1m.
+	MaxPollInterval time.Duration
+	// OnSubmit is called with the batch ID right after submission. Persist
+	// it to resume with BatchRunner.Resume if the process stops.
+	OnSubmit func(batchID string)
+	// OnPoll is called after every status check, for progress reporting.
+	OnPoll func(BatchStatus)
+}
+
+// BatchRunner runs ChatRequests through the Anthropic Message Batches API,
+// which processes them asynchronously (usually within an hour, at most 24
+// hours) at half the price of the standard API. Use it for offline,
+// single-turn workloads such as classification and extraction; requests are
+// sent without streaming and tool calls are returned, not executed.
+type BatchRunner struct {
+	client anthropic.Client
+	cfg    BatchConfig
+}
+
+// NewBatchRunner creates a batch runner that shares provider's client and
+// credentials.
+func NewBatchRunner(provider *AnthropicProvider, cfg BatchConfig) *BatchRunner {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 5 * time.Second
+	}
+	if cfg.MaxPollInterval <= 0 {
+		cfg.MaxPollInterval = time.Minute
+	}
+	if cfg.MaxPollInterval < cfg.PollInterval {
+		cfg.MaxPollInterval = cfg.PollInterval
+	}
+	return &BatchRunner{client: provider.client, cfg: cfg}
+}
+
+// Run submits reqs as one batch, waits for it to finish and returns the
+// results. Per-request failures are reported in the results (see
+// BatchResults.Err), not as an error. If Run is interrupted after
+// submission, pass the ID given to BatchConfig.OnSubmit to Resume.
+func (b *BatchRunner) Run(ctx context.Context, reqs []BatchRequest) (*BatchResults, error) {
+	id, err := b.Submit(ctx, reqs)
+	if err != nil {
+		return nil, err
+	}
+	return b.Resume(ctx, id)
+}
+
+// Resume waits for a previously submitted batch to finish and returns its
+// results.
+func (b *BatchRunner) Resume(ctx context.Context, batchID string) (*BatchResults, error) {
+	if _, err := b.Wait(ctx, batchID); err != nil {
+		return nil, err
+	}
+	return b.Results(ctx, batchID)
+}
+
+// Submit creates a batch from reqs and returns its ID without waiting.
+func (b *BatchRunner) Submit(ctx context.Context, reqs []BatchRequest) (string, error) {
+	if len(reqs) == 0 {
+		return "", errors.New("batch: no requests")
+	}
+	if len(reqs) > maxBatchRequests {
+		return "", fmt.Errorf("batch: %d requests exceeds the limit of %d", len(reqs), maxBatchRequests)
+	}
+
+	seen := make(map[string]bool, len(reqs))
+	params := anthropic.MessageBatchNewParams{Requests: make([]anthropic.MessageBatchNewParamsRequest, len(reqs))}
+	for i, r := range reqs {
+		if !batchIDPattern.MatchString(r.ID) {
+			return "", fmt.Errorf("batch: invalid request ID %q: use 1-64 letters, digits, '_' or '-'", r.ID)
+		}
+		if seen[r.ID] {
+			return "", fmt.Errorf("batch: duplicate request ID %q", r.ID)
+		}
+		seen[r.ID] = true
+
+		// The batch request params mirror MessageNewParams; reuse the
+		// regular conversion and send its JSON.
+		req := r.Request
+		if req.MaxTokens <= 0 {
+			req.MaxTokens = defaultBatchMaxTokens
+		}
+		body, err := json.Marshal(buildAnthropicParams(req))
+		if err != nil {
+			return "", fmt.Errorf("batch: encode request %q: %w", r.ID, err)
+		}
+		params.Requests[i] = anthropic.MessageBatchNewParamsRequest{
+			CustomID: r.ID,
+			Params:   param.Override[anthropic.MessageBatchNewParamsRequestParams](json.RawMessage(body)),
+		}
+	}
+
+	batch, err := b.client.Messages.Batches.New(ctx, params)
+	if err != nil {
+		return "", fmt.Errorf("batch: submit: %w", err)
+	}
+	if b.cfg.OnSubmit != nil {
+		b.cfg.OnSubmit(batch.ID)
+	}
+	return batch.ID, nil
+}
+
+// Status fetches the current status of a batch.
+func (b *BatchRunner) Status(ctx context.Context, batchID string) (BatchStatus, error) {
+	batch, err := b.client.Messages.Batches.Get(ctx, batchID)
+	if err != nil {
+		return BatchStatus{}, fmt.Errorf("batch %s: get status: %w", batchID, err)
+	}
+	return BatchStatus{
+		ID:               batch.ID,
+		ProcessingStatus: string(batch.ProcessingStatus),
+		Processing:       int(batch.RequestCounts.Processing),
+		Succeeded:        int(batch.RequestCounts.Succeeded),
+		Errored:          int(batch.RequestCounts.Errored),
+		Canceled:         int(batch.RequestCounts.Canceled),
+		Expired:          int(batch.RequestCounts.Expired),
+		CreatedAt:        batch.CreatedAt,
+		ExpiresAt:        batch.ExpiresAt,
+		EndedAt:          batch.EndedAt,
+	}, nil
+}
+
+// Wait polls a batch with exponential backoff until it ends or ctx is done.
+func (b *BatchRunner) Wait(ctx context.Context, batchID string) (BatchStatus, error) {
+	delay := b.cfg.PollInterval
+	for {
+		status, err := b.Status(ctx, batchID)
+		if err != nil {
+			return status, err
+		}
+		if b.cfg.OnPoll != nil {
+			b.cfg.OnPoll(status)
+		}
+		if status.Ended() {
+			return status, nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return status, ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+		if delay > b.cfg.MaxPollInterval {
+			delay = b.cfg.MaxPollInterval
+		}
+	}
+}
+
+// Results downloads the results of an ended batch.
+func (b *BatchRunner) Results(ctx context.Context, batchID string) (*BatchResults, error) {
+	stream := b.client.Messages.Batches.ResultsStreaming(ctx, batchID)
+	defer func() { _ = stream.Close() }()
+
+	out := &BatchResults{BatchID: batchID, Results: make(map[string]BatchResult)}
+	for stream.Next() {
+		item := stream.Current()
+		res := BatchResult{ID: item.CustomID, Status: BatchResultStatus(item.Result.Type)}
+		switch res.Status {
+		case BatchSucceeded:
+			resp := convertAnthropicMessage(item.Result.Message)
+			res.Response = &resp
+		case BatchErrored:
+			res.ErrorType = item.Result.Error.Error.Type
+			res.Error = item.Result.Error.Error.Message
+		case BatchCanceled, BatchExpired:
+		}
+		out.Results[res.ID] = res
+	}
+	if err := stream.Err(); err != nil {
+		return nil, fmt.Errorf("batch %s: read results: %w", batchID, err)
+	}
+	return out, nil
+}
+
+// Cancel asks the API to stop processing a batch. Requests that already
+// ran keep their results; the rest end as BatchCanceled.
+func (b *BatchRunner) Cancel(ctx context.Context, batchID string) error {
+	if _, err := b.client.Messages.Batches.Cancel(ctx, batchID); err != nil {
+		return fmt.Errorf("batch %s: cancel: %w", batchID, err)
+	}
+	return nil
+}
+
+// convertAnthropicMessage converts a complete (non-streamed) Messages API
+// response to a ChatResponse.
+func convertAnthropicMessage(msg anthropic.Message) ChatResponse {
+	resp := ChatResponse{
+		StopReason: string(msg.StopReason),
+		Model:      string(msg.Model),
+		Usage: ChatUsage{
+			InputTokens:                int(msg.Usage.InputTokens),
+			OutputTokens:               int(msg.Usage.OutputTokens),
+			CacheCreationInputTokens:   int(msg.Usage.CacheCreationInputTokens),
+			CacheCreation1hInputTokens: int(msg.Usage.CacheCreation.Ephemeral1hInputTokens),
+			CacheReadInputTokens:       int(msg.Usage.CacheReadInputTokens),
+		},
+	}
+	for _, block := range msg.Content {
+		switch b := block.AsAny().(type) {
+		case anthropic.TextBlock:
+			resp.Content += b.Text
+		case anthropic.ToolUseBlock:
+			input := b.Input
+			if len(input) == 0 {
+				input = json.RawMessage("{}")
+			}
+			resp.ToolCalls = append(resp.ToolCalls, ToolCall{ID: b.ID, Name: b.Name, Input: input})
+		}
+	}
+	if resp.StopReason == "" {
+		resp.StopReason = "end_turn"
+	}
+	return resp
+}
@@ -0,0 +1,188 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBatchAPI is an httptest stand-in for the Message Batches endpoints.
+type fakeBatchAPI struct {
+	mu        sync.Mutex
+	submitted []map[string]any
+	polls     int
+	endAfter  int
+	results   []string
+}
+
+func (f *fakeBatchAPI) batchJSON(status string) string {
+	return fmt.Sprintf(`{"id":"msgbatch_1","type":"message_batch","processing_status":%q,`+
+		`"request_counts":{"processing":%d,"succeeded":%d,"errored":1,"canceled":0,"expired":0},`+
+		`"created_at":"2025-01-01T00:00:00Z","expires_at":"2025-01-02T00:00:00Z"}`,
+		status, map[bool]int{true: 3, false: 0}[status != "ended"], map[bool]int{true: 0, false: 2}[status != "ended"])
+}
+
+func (f *fakeBatchAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/messages/batches":
+		var body struct {
+			Requests []map[string]any `json:"requests"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.submitted = body.Requests
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, f.batchJSON("in_progress"))
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/messages/batches/msgbatch_1":
+		f.polls++
+		status := "in_progress"
+		if f.polls >= f.endAfter {
+			status = "ended"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, f.batchJSON(status))
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/messages/batches/msgbatch_1/results":
+		w.Header().Set("Content-Type", "application/x-jsonl")
+		fmt.Fprint(w, strings.Join(f.results, "\n")+"\n")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestBatchRunner(t *testing.T, api *fakeBatchAPI, cfg BatchConfig) *BatchRunner {
+	t.Helper()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	provider := NewAnthropicProvider(AnthropicProviderConfig{APIKey: "test", BaseURL: srv.URL})
+	cfg.PollInterval = time.Millisecond
+	return NewBatchRunner(provider, cfg)
+}
+
+func TestBatchRunnerRun(t *testing.T) {
+	api := &fakeBatchAPI{endAfter: 3, results: []string{
+		`{"custom_id":"b","result":{"type":"succeeded","message":{"id":"m2","type":"message","role":"assistant","model":"claude-haiku-4-5","stop_reason":"end_turn","content":[{"type":"text","text":"negative"}],"usage":{"input_tokens":5,"output_tokens":1}}}}`,
+		`{"custom_id":"a","result":{"type":"succeeded","message":{"id":"m1","type":"message","role":"assistant","model":"claude-haiku-4-5","stop_reason":"tool_use","content":[{"type":"tool_use","id":"tu_1","name":"label","input":{"v":"pos"}}],"usage":{"input_tokens":7,"output_tokens":3}}}}`,
+		`{"custom_id":"c","result":{"type":"errored","error":{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: too large"}}}}`,
+	}}
+
+	var submittedID string
+	var polls []BatchStatus
+	runner := newTestBatchRunner(t, api, BatchConfig{
+		OnSubmit: func(id string) { submittedID = id },
+		OnPoll:   func(s BatchStatus) { polls = append(polls, s) },
+	})
+
+	reqs := []BatchRequest{
+		{ID: "a", Request: ChatRequest{Model: "claude-haiku-4-5", MaxTokens: 10, Messages: []ChatMessage{{Role: ChatRoleUser, Content: "great!"}}}},
+		{ID: "b", Request: ChatRequest{Model: "claude-haiku-4-5", MaxTokens: 10, SystemPrompt: "classify", Messages: []ChatMessage{{Role: ChatRoleUser, Content: "awful"}}}},
+		{ID: "c", Request: ChatRequest{Model: "claude-haiku-4-5", MaxTokens: 1 << 30, Messages: []ChatMessage{{Role: ChatRoleUser, Content: "x"}}}},
+	}
+	results, err := runner.Run(context.Background(), reqs)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if submittedID != "msgbatch_1" {
+		t.Errorf("OnSubmit got %q", submittedID)
+	}
+	if len(polls) != 3 || !polls[2].Ended() || polls[2].Succeeded != 2 || polls[0].Processing != 3 {
+		t.Errorf("unexpected polls: %+v", polls)
+	}
+
+	if len(api.submitted) != 3 || api.submitted[1]["custom_id"] != "b" {
+		t.Fatalf("unexpected submission: %+v", api.submitted)
+	}
+	params := api.submitted[1]["params"].(map[string]any)
+	if params["model"] != "claude-haiku-4-5" || params["max_tokens"] != float64(10) {
+		t.Errorf("params not converted: %v", params)
+	}
+	if sys, _ := params["system"].([]any); len(sys) != 1 {
+		t.Errorf("system prompt not converted: %v", params["system"])
+	}
+
+	a, _ := results.Get("a")
+	if a.Status != BatchSucceeded || a.Response.StopReason != "tool_use" || len(a.Response.ToolCalls) != 1 ||
+		string(a.Response.ToolCalls[0].Input) != `{"v":"pos"}` || a.Response.Usage.InputTokens != 7 {
+		t.Errorf("unexpected result a: %+v %+v", a, a.Response)
+	}
+	if b, _ := results.Get("b"); b.Response == nil || b.Response.Content != "negative" {
+		t.Errorf("unexpected result b: %+v", b)
+	}
+
+	failed := results.Failed()
+	if len(failed) != 1 || failed[0].ID != "c" || failed[0].ErrorType != "invalid_request_error" {
+		t.Errorf("unexpected failures: %+v", failed)
+	}
+	var bf *BatchFailuresError
+	if err := results.Err(); !errors.As(err, &bf) || !strings.Contains(err.Error(), "1 of 3 requests failed (1 errored)") {
+		t.Errorf("unexpected Err: %v", err)
+	}
+}
+
+func TestBatchRunnerDefaultMaxTokens(t *testing.T) {
+	api := &fakeBatchAPI{}
+	runner := newTestBatchRunner(t, api, BatchConfig{})
+
+	_, err := runner.Submit(context.Background(), []BatchRequest{
+		{ID: "a", Request: ChatRequest{Messages: []ChatMessage{{Role: ChatRoleUser, Content: "hi"}}}},
+	})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	params := api.submitted[0]["params"].(map[string]any)
+	if params["max_tokens"] != float64(4096) {
+		t.Errorf("max_tokens = %v, want the 4096 default", params["max_tokens"])
+	}
+}
+
+func TestBatchRunnerResume(t *testing.T) {
+	api := &fakeBatchAPI{endAfter: 1, results: []string{
+		`{"custom_id":"x","result":{"type":"expired"}}`,
+	}}
+	runner := newTestBatchRunner(t, api, BatchConfig{})
+
+	results, err := runner.Resume(context.Background(), "msgbatch_1")
+	if err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if x, _ := results.Get("x"); x.Status != BatchExpired {
+		t.Errorf("unexpected result: %+v", x)
+	}
+	if api.submitted != nil {
+		t.Error("Resume should not resubmit")
+	}
+}
+
+func TestBatchRunnerWaitHonorsContext(t *testing.T) {
+	api := &fakeBatchAPI{endAfter: 1 << 30}
+	runner := newTestBatchRunner(t, api, BatchConfig{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := runner.Wait(ctx, "msgbatch_1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestBatchRunnerValidatesIDs(t *testing.T) {
+	runner := newTestBatchRunner(t, &fakeBatchAPI{}, BatchConfig{})
+	req := ChatRequest{MaxTokens: 1, Messages: []ChatMessage{{Role: ChatRoleUser, Content: "x"}}}
+
+	for _, reqs := range [][]BatchRequest{
+		nil,
+		{{ID: "has space", Request: req}},
+		{{ID: "dup", Request: req}, {ID: "dup", Request: req}},
+	} {
+		if _, err := runner.Submit(context.Background(), reqs); err == nil {
+			t.Errorf("expected error for %+v", reqs)
+		}
+	}
+}
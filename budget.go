@@ -13,7 +13,8 @@ type BudgetConfig struct {
 	MaxTokens int
 
 	// MaxCostUSD stops the session once cumulative cost exceeds this value (USD).
-	// Populated only for the CLI-based Agent via ResultMessage.Cost.
+	// The CLI-based Agent uses ResultMessage.Cost; APIAgent prices each
+	// response with APIAgentConfig.Pricing.
 	MaxCostUSD float64
 
 	// MaxDuration stops the session once wall-clock time since session start
@@ -49,6 +50,19 @@ func newBudgetTracker(cfg *BudgetConfig) *budgetTracker {
 	}
 }
 
+// resumeBudgetTracker returns a tracker that counts usage already spent by
+// a Session, or nil when cfg is nil.
+func resumeBudgetTracker(cfg *BudgetConfig, used SessionUsage) *budgetTracker {
+	b := newBudgetTracker(cfg)
+	if b == nil {
+		return nil
+	}
+	b.tokens = used.InputTokens + used.OutputTokens
+	b.costUSD = used.CostUSD
+	b.sessionStart = b.sessionStart.Add(-used.Duration)
+	return b
+}
+
 // record adds usage from a completed turn and returns an error if any limit
 // is now exceeded. Safe to call with a nil receiver.
 func (b *budgetTracker) record(inputTokens, outputTokens int, costUSD float64) error {
@@ -0,0 +1,272 @@
+package claudeagent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Capability names a feature a provider or backend must support to serve a request.
+type Capability string
+
+const (
+	// CapabilityTools means the backend supports tool (function) calling.
+	CapabilityTools Capability = "tools"
+	// CapabilityParallelToolCalls means the model may return several tool
+	// calls in one turn.
+	CapabilityParallelToolCalls Capability = "parallel_tool_calls"
+	// CapabilityVision means the backend accepts image input.
+	CapabilityVision Capability = "vision"
+	// CapabilityPromptCaching means the backend honors cache control directives.
+	CapabilityPromptCaching Capability = "prompt_caching"
+	// CapabilitySystemBlocks means the backend accepts structured system
+	// prompt blocks rather than a single system string.
+	CapabilitySystemBlocks Capability = "system_blocks"
+	// CapabilityThinking means the backend supports extended thinking / reasoning.
+	CapabilityThinking Capability = "thinking"
+)
+
+// ProviderCapabilities describes what a provider supports for a given model.
+// Zero limits mean "unknown" and are never enforced.
+type ProviderCapabilities struct {
+	Tools             bool
+	ParallelToolCalls bool
+	Vision            bool
+	PromptCaching     bool
+	SystemBlocks      bool
+	Thinking          bool
+
+	// ContextWindow is the maximum input + output tokens, or 0 if unknown.
+	ContextWindow int
+	// MaxOutputTokens is the largest accepted max_tokens, or 0 if unknown.
+	MaxOutputTokens int
+}
+
+// Has reports whether a capability is supported.
+func (c ProviderCapabilities) Has(want Capability) bool {
+	switch want {
+	case CapabilityTools:
+		return c.Tools
+	case CapabilityParallelToolCalls:
+		return c.ParallelToolCalls
+	case CapabilityVision:
+		return c.Vision
+	case CapabilityPromptCaching:
+		return c.PromptCaching
+	case CapabilitySystemBlocks:
+		return c.SystemBlocks
+	case CapabilityThinking:
+		return c.Thinking
+	}
+	return false
+}
+
+// set marks a capability as supported.
+func (c *ProviderCapabilities) set(want Capability) {
+	switch want {
+	case CapabilityTools:
+		c.Tools = true
+	case CapabilityParallelToolCalls:
+		c.ParallelToolCalls = true
+	case CapabilityVision:
+		c.Vision = true
+	case CapabilityPromptCaching:
+		c.PromptCaching = true
+	case CapabilitySystemBlocks:
+		c.SystemBlocks = true
+	case CapabilityThinking:
+		c.Thinking = true
+	}
+}
+
+// allCapabilities lists every Capability in a stable order.
+var allCapabilities = []Capability{
+	CapabilityTools, CapabilityParallelToolCalls, CapabilityVision,
+	CapabilityPromptCaching, CapabilitySystemBlocks, CapabilityThinking,
+}
+
+// List returns the supported capabilities.
+func (c ProviderCapabilities) List() []Capability {
+	var out []Capability
+	for _, want := range allCapabilities {
+		if c.Has(want) {
+			out = append(out, want)
+		}
+	}
+	return out
+}
+
+// CapabilityReporter is implemented by providers that can describe what
+// they support. APIAgent uses it to adapt requests and to fail early when
+// its configuration needs something the provider lacks. Providers that do
+// not implement it are assumed to support everything.
+type CapabilityReporter interface {
+	// Capabilities returns the descriptor for model. An empty model means
+	// the provider's default model.
+	Capabilities(model string) ProviderCapabilities
+}
+
+// CapabilitiesOf returns the provider's descriptor for model, and false if
+// the provider does not report capabilities.
+func CapabilitiesOf(p LLMProvider, model string) (ProviderCapabilities, bool) {
+	r, ok := p.(CapabilityReporter)
+	if !ok {
+		return ProviderCapabilities{}, false
+	}
+	return r.Capabilities(model), true
+}
+
+// wrappedCapabilities is the descriptor a provider wrapper reports: the
+// wrapped provider's, or everything if it has none, so wrapping never hides
+// a capability.
+func wrappedCapabilities(inner LLMProvider, model string) ProviderCapabilities {
+	if caps, ok := CapabilitiesOf(inner, model); ok {
+		return caps
+	}
+	var all ProviderCapabilities
+	for _, c := range allCapabilities {
+		all.set(c)
+	}
+	return all
+}
+
+// CapabilityError is returned when a request or agent configuration needs a
+// capability the provider does not have.
+type CapabilityError struct {
+	Provider   string
+	Model      string
+	Capability Capability
+	// Reason explains what needed the capability (e.g., "3 tools registered").
+	Reason string
+}
+
+func (e *CapabilityError) Error() string {
+	model := e.Model
+	if model == "" {
+		model = "default model"
+	}
+	msg := fmt.Sprintf("provider %s (%s) does not support %s", e.Provider, model, e.Capability)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// adaptChatRequest rewrites req to fit caps: system blocks are flattened
+// for providers that only take a system string, cache control and
+// PromptCache are dropped where caching is unsupported, and MaxTokens is capped at the model's
+// output limit. Tools and images cannot be adapted away and produce a
+// *CapabilityError instead. req is not modified.
+func adaptChatRequest(req ChatRequest, caps ProviderCapabilities, provider string) (ChatRequest, error) {
+	if len(req.Tools) > 0 && !caps.Tools {
+		return req, &CapabilityError{
+			Provider: provider, Model: req.Model, Capability: CapabilityTools,
+			Reason: fmt.Sprintf("%d tools in request", len(req.Tools)),
+		}
+	}
+	if n := countImages(req.Messages); n > 0 && !caps.Vision {
+		return req, &CapabilityError{
+			Provider: provider, Model: req.Model, Capability: CapabilityVision,
+			Reason: fmt.Sprintf("%d images in request", n),
+		}
+	}
+
+	if len(req.SystemBlocks) > 0 {
+		switch {
+		case !caps.SystemBlocks:
+			if req.SystemPrompt == "" {
+				req.SystemPrompt = flattenSystemBlocks(req.SystemBlocks)
+			}
+			req.SystemBlocks = nil
+		case !caps.PromptCaching:
+			blocks := make([]SystemPromptBlock, len(req.SystemBlocks))
+			for i, b := range req.SystemBlocks {
+				blocks[i] = SystemPromptBlock{Text: b.Text}
+			}
+			req.SystemBlocks = blocks
+		}
+	}
+	if !caps.PromptCaching {
+		req.PromptCache = nil
+	}
+
+	// Providers without parallel tool calls reject the parameter that
+	// turns them off; there is nothing to disable.
+	if !caps.ParallelToolCalls {
+		req.DisableParallelToolUse = false
+	}
+
+	if caps.MaxOutputTokens > 0 && req.MaxTokens > caps.MaxOutputTokens {
+		req.MaxTokens = caps.MaxOutputTokens
+	}
+	return req, nil
+}
+
+// flattenSystemBlocks joins block text with blank lines.
+func flattenSystemBlocks(blocks []SystemPromptBlock) string {
+	parts := make([]string, len(blocks))
+	for i, b := range blocks {
+		parts[i] = b.Text
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// countImages returns the number of images across messages.
+func countImages(msgs []ChatMessage) int {
+	n := 0
+	for _, m := range msgs {
+		n += len(m.Images)
+	}
+	return n
+}
+
+// modelLimits is a row in a prefix-matched model table.
+type modelLimits struct {
+	prefix          string
+	contextWindow   int
+	maxOutputTokens int
+	vision          bool
+	thinking        bool
+}
+
+// lookupModel returns the first row whose prefix matches model. Tables are
+// ordered most-specific first.
+func lookupModel(table []modelLimits, model string) (modelLimits, bool) {
+	for _, row := range table {
+		if strings.HasPrefix(model, row.prefix) {
+			return row, true
+		}
+	}
+	return modelLimits{}, false
+}
+
+// anthropicModels lists known Claude model limits.
+var anthropicModels = []modelLimits{
+	{prefix: "claude-opus-4-5", contextWindow: 200000, maxOutputTokens: 64000, vision: true, thinking: true},
+	{prefix: "claude-opus-4", contextWindow: 200000, maxOutputTokens: 32000, vision: true, thinking: true},
+	{prefix: "claude-sonnet-4", contextWindow: 200000, maxOutputTokens: 64000, vision: true, thinking: true},
+	{prefix: "claude-haiku-4", contextWindow: 200000, maxOutputTokens: 64000, vision: true, thinking: true},
+	{prefix: "claude-3-7-sonnet", contextWindow: 200000, maxOutputTokens: 64000, vision: true, thinking: true},
+	{prefix: "claude-3-5-sonnet", contextWindow: 200000, maxOutputTokens: 8192, vision: true},
+	{prefix: "claude-3-5-haiku", contextWindow: 200000, maxOutputTokens: 8192, vision: true},
+	{prefix: "claude-3-", contextWindow: 200000, maxOutputTokens: 4096, vision: true},
+}
+
+// openAIModels lists known limits for common OpenAI-compatible models.
+var openAIModels = []modelLimits{
+	{prefix: "gpt-4.1", contextWindow: 1047576, maxOutputTokens: 32768, vision: true},
+	{prefix: "gpt-4o", contextWindow: 128000, maxOutputTokens: 16384, vision: true},
+	{prefix: "gpt-4-turbo", contextWindow: 128000, maxOutputTokens: 4096, vision: true},
+	{prefix: "gpt-3.5-turbo", contextWindow: 16385, maxOutputTokens: 4096},
+	{prefix: "gpt-5", contextWindow: 400000, maxOutputTokens: 128000, vision: true, thinking: true},
+	{prefix: "o1-mini", contextWindow: 128000, maxOutputTokens: 65536, thinking: true},
+	{prefix: "o1-preview", contextWindow: 128000, maxOutputTokens: 32768, thinking: true},
+	{prefix: "o1", contextWindow: 200000, maxOutputTokens: 100000, vision: true, thinking: true},
+	{prefix: "o3", contextWindow: 200000, maxOutputTokens: 100000, vision: true, thinking: true},
+	{prefix: "o4-mini", contextWindow: 200000, maxOutputTokens: 100000, vision: true, thinking: true},
+	{prefix: "deepseek-reasoner", contextWindow: 128000, maxOutputTokens: 64000, thinking: true},
+	{prefix: "deepseek-chat", contextWindow: 128000, maxOutputTokens: 8192},
+	{prefix: "mistral-large", contextWindow: 128000},
+	{prefix: "pixtral", contextWindow: 128000, vision: true},
+	{prefix: "qwen-vl", contextWindow: 32000, vision: true},
+	{prefix: "qwen-max", contextWindow: 32000, maxOutputTokens: 8192},
+}
@@ -0,0 +1,211 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// capsFakeProvider is a routerFakeProvider that reports a fixed descriptor.
+type capsFakeProvider struct {
+	routerFakeProvider
+	caps ProviderCapabilities
+}
+
+func (p *capsFakeProvider) Capabilities(string) ProviderCapabilities { return p.caps }
+
+func TestAdaptChatRequest(t *testing.T) {
+	req := ChatRequest{
+		Model: "m",
+		SystemBlocks: []SystemPromptBlock{
+			{Text: "one", CacheControl: &CacheControl{Type: "ephemeral"}},
+			{Text: "two"},
+		},
+		MaxTokens: 10000,
+	}
+
+	flat, err := adaptChatRequest(req, ProviderCapabilities{MaxOutputTokens: 4096}, "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flat.SystemPrompt != "one\n\ntwo" || flat.SystemBlocks != nil {
+		t.Errorf("expected flattened system prompt, got %q / %v", flat.SystemPrompt, flat.SystemBlocks)
+	}
+	if flat.MaxTokens != 4096 {
+		t.Errorf("expected MaxTokens capped to 4096, got %d", flat.MaxTokens)
+	}
+
+	noCache, err := adaptChatRequest(req, ProviderCapabilities{SystemBlocks: true}, "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(noCache.SystemBlocks) != 2 || noCache.SystemBlocks[0].CacheControl != nil {
+		t.Errorf("expected cache control stripped, got %+v", noCache.SystemBlocks)
+	}
+	if req.SystemBlocks[0].CacheControl == nil {
+		t.Error("adaptChatRequest must not modify the input request")
+	}
+
+	full := ProviderCapabilities{SystemBlocks: true, PromptCaching: true}
+	same, _ := adaptChatRequest(req, full, "p")
+	if same.SystemBlocks[0].CacheControl == nil || same.MaxTokens != 10000 {
+		t.Errorf("expected request unchanged for a fully capable provider, got %+v", same)
+	}
+
+	_, err = adaptChatRequest(ChatRequest{Tools: []ToolDefinition{{Name: "x"}}}, ProviderCapabilities{}, "p")
+	var capErr *CapabilityError
+	if !errors.As(err, &capErr) || capErr.Capability != CapabilityTools {
+		t.Errorf("expected tools CapabilityError, got %v", err)
+	}
+
+	imgReq := ChatRequest{Messages: []ChatMessage{{Role: ChatRoleUser, Images: []ChatImage{{URL: "https://x/y.png"}}}}}
+	_, err = adaptChatRequest(imgReq, ProviderCapabilities{Tools: true}, "p")
+	if !errors.As(err, &capErr) || capErr.Capability != CapabilityVision {
+		t.Errorf("expected vision CapabilityError, got %v", err)
+	}
+}
+
+func TestAPIAgent_FailsEarlyOnMissingCapability(t *testing.T) {
+	p := &capsFakeProvider{routerFakeProvider: routerFakeProvider{name: "textonly"}}
+	tools := NewToolRegistry()
+	tools.Register(ToolDefinition{Name: "search"}, func(context.Context, json.RawMessage) (string, error) { return "", nil })
+
+	agent := NewAPIAgent(APIAgentConfig{Provider: p, Model: "tiny", Tools: tools})
+	_, err := agent.Run(context.Background(), "hi")
+	var capErr *CapabilityError
+	if !errors.As(err, &capErr) || capErr.Capability != CapabilityTools {
+		t.Fatalf("expected tools CapabilityError from Run, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "textonly (tiny) does not support tools") {
+		t.Errorf("unexpected message: %v", err)
+	}
+
+	p.caps.Tools = true
+	_, err = agent.RunWithImages(context.Background(), "what is this?", ChatImage{MediaType: "image/png", Data: []byte{1}})
+	if !errors.As(err, &capErr) || capErr.Capability != CapabilityVision {
+		t.Fatalf("expected vision CapabilityError, got %v", err)
+	}
+	if p.calls != 0 {
+		t.Errorf("provider should not be called, got %d calls", p.calls)
+	}
+}
+
+func TestAPIAgent_AdaptsRequestToProvider(t *testing.T) {
+	p := &capsFakeProvider{
+		routerFakeProvider: routerFakeProvider{name: "compat"},
+		caps:               ProviderCapabilities{Tools: true, Vision: true, MaxOutputTokens: 2048},
+	}
+	agent := NewAPIAgent(APIAgentConfig{
+		Provider:           p,
+		MaxTokens:          8192,
+		SystemPromptBlocks: []SystemPromptBlock{{Text: "a"}, {Text: "b"}},
+	})
+	events, err := agent.RunWithImages(context.Background(), "look", ChatImage{URL: "https://x/y.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range events {
+	}
+
+	got := p.lastReq
+	if got.MaxTokens != 2048 {
+		t.Errorf("expected MaxTokens capped to 2048, got %d", got.MaxTokens)
+	}
+	if got.SystemPrompt != "a\n\nb" || len(got.SystemBlocks) != 0 {
+		t.Errorf("expected flattened system prompt, got %q / %v", got.SystemPrompt, got.SystemBlocks)
+	}
+	if len(got.Messages[0].Images) != 1 {
+		t.Errorf("expected image on first message, got %+v", got.Messages[0])
+	}
+}
+
+func TestBuiltinProviderCapabilities(t *testing.T) {
+	anth := NewAnthropicProvider(AnthropicProviderConfig{APIKey: "k"})
+	if c := anth.Capabilities("claude-3-5-haiku-20241022"); c.MaxOutputTokens != 8192 || c.Thinking || !c.PromptCaching {
+		t.Errorf("unexpected claude-3-5-haiku caps: %+v", c)
+	}
+	if c := anth.Capabilities(""); c.MaxOutputTokens != 64000 || !c.Thinking {
+		t.Errorf("unexpected default model caps: %+v", c)
+	}
+
+	if c := OpenAIProvider("gpt-4o-mini").(CapabilityReporter).Capabilities(""); !c.Vision || c.SystemBlocks || c.MaxOutputTokens != 16384 {
+		t.Errorf("unexpected gpt-4o-mini caps: %+v", c)
+	}
+	for model, want := range map[string]int{"o1-mini-2024-09-12": 65536, "o1-preview": 32768} {
+		if c := OpenAIProvider(model).(CapabilityReporter).Capabilities(""); c.Vision || c.ContextWindow != 128000 || c.MaxOutputTokens != want {
+			t.Errorf("unexpected %s caps: %+v", model, c)
+		}
+	}
+	if c := OpenAIProvider("o1").(CapabilityReporter).Capabilities(""); !c.Vision || c.ContextWindow != 200000 {
+		t.Errorf("unexpected o1 caps: %+v", c)
+	}
+	if c := DeepSeekProvider("deepseek-chat").(CapabilityReporter).Capabilities(""); c.Vision {
+		t.Errorf("deepseek-chat should not report vision: %+v", c)
+	}
+
+	override := &ProviderCapabilities{Tools: true, Vision: true}
+	local := NewOpenAICompatProvider(OpenAICompatConfig{Model: "llava", Capabilities: override})
+	if c := local.Capabilities(""); !c.Vision {
+		t.Errorf("expected override to be used, got %+v", c)
+	}
+}
+
+func TestConvertMessages_Images(t *testing.T) {
+	p := NewOpenAICompatProvider(OpenAICompatConfig{})
+	msgs := p.convertMessages(ChatRequest{Messages: []ChatMessage{{
+		Role:    ChatRoleUser,
+		Content: "describe",
+		Images:  []ChatImage{{MediaType: "image/png", Data: []byte("png")}, {URL: "https://x/y.jpg"}},
+	}}})
+	data, err := json.Marshal(msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"role":"user","content":[{"type":"text","text":"describe"},` +
+		`{"type":"image_url","image_url":{"url":"data:image/png;base64,cG5n"}},` +
+		`{"type":"image_url","image_url":{"url":"https://x/y.jpg"}}]}`
+	if string(data) != want {
+		t.Errorf("unexpected message JSON:\n got %s\nwant %s", data, want)
+	}
+
+	anth := convertMessagesToAnthropic([]ChatMessage{{Role: ChatRoleUser, Content: "describe", Images: []ChatImage{{URL: "https://x/y.jpg"}}}})
+	if blocks := anth[0].Content; len(blocks) != 2 || blocks[0].OfImage == nil || blocks[1].OfText == nil {
+		t.Errorf("expected image then text block, got %+v", blocks)
+	}
+}
+
+func TestRouterProvider_UsesProviderDescriptors(t *testing.T) {
+	text := &capsFakeProvider{
+		routerFakeProvider: routerFakeProvider{name: "text"},
+		caps:               ProviderCapabilities{Tools: true},
+	}
+	vision := &capsFakeProvider{
+		routerFakeProvider: routerFakeProvider{name: "vision"},
+		caps:               ProviderCapabilities{Tools: true, Vision: true, MaxOutputTokens: 1000},
+	}
+	r := NewRouterProvider(RouterConfig{Backends: []RouterBackend{{Provider: text}, {Provider: vision}}})
+
+	req := ChatRequest{
+		Messages:     []ChatMessage{{Role: ChatRoleUser, Images: []ChatImage{{URL: "https://x/y.png"}}}},
+		SystemBlocks: []SystemPromptBlock{{Text: "sys"}},
+		MaxTokens:    4096,
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := r.Complete(context.Background(), req, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if text.calls != 0 || vision.calls != 3 {
+		t.Errorf("expected all image requests on vision backend, got text=%d vision=%d", text.calls, vision.calls)
+	}
+	if vision.lastReq.MaxTokens != 1000 || vision.lastReq.SystemPrompt != "sys" {
+		t.Errorf("expected request adapted to backend, got %+v", vision.lastReq)
+	}
+
+	caps := r.Capabilities("")
+	if !caps.Vision || !caps.Tools || caps.SystemBlocks || caps.MaxOutputTokens != 0 {
+		t.Errorf("unexpected router caps: %+v", caps)
+	}
+}
@@ -0,0 +1,504 @@
+package claudeagent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// cassetteVersion is the on-disk format version written by RecordingProvider.
+const cassetteVersion = 1
+
+// Cassette is a recorded sequence of LLM interactions that a ReplayProvider
+// can serve back without network access.
+type Cassette struct {
+	Version      int                   `json:"version"`
+	Interactions []CassetteInteraction `json:"interactions"`
+}
+
+// CassetteInteraction is one recorded Complete call.
+type CassetteInteraction struct {
+	// RequestHash is the canonical hash of Request (see hashChatRequest).
+	RequestHash string `json:"request_hash"`
+	// Provider is the name of the provider that served the request.
+	Provider string `json:"provider,omitempty"`
+	// Request is the request as sent to the provider.
+	Request ChatRequest `json:"request"`
+	// Response is the provider's response. Nil when Error is set.
+	Response *ChatResponse `json:"response,omitempty"`
+	// Events are the stream events delivered to onEvent, in order.
+	Events []ChatStreamEvent `json:"events,omitempty"`
+	// Error is the error message returned by the provider, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// LoadCassette reads a cassette file written by RecordingProvider.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- caller-supplied cassette path
+	if err != nil {
+		return nil, fmt.Errorf("read cassette: %w", err)
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("decode cassette: %w", err)
+	}
+	if c.Version != cassetteVersion {
+		return nil, fmt.Errorf("unsupported cassette version %d", c.Version)
+	}
+	c.compactToolInputs()
+	return &c, nil
+}
+
+// compactToolInputs undoes the indentation Save applies to raw tool inputs,
+// so replayed tool calls carry the same bytes the provider originally returned
+// (modulo insignificant whitespace).
+func (c *Cassette) compactToolInputs() {
+	compact := func(calls []ToolCall) {
+		for i := range calls {
+			calls[i].Input = json.RawMessage(compactJSON(calls[i].Input))
+		}
+	}
+	for i := range c.Interactions {
+		in := &c.Interactions[i]
+		for j := range in.Request.Messages {
+			compact(in.Request.Messages[j].ToolCalls)
+		}
+		if in.Response != nil {
+			compact(in.Response.ToolCalls)
+		}
+		for j := range in.Events {
+			if tc := in.Events[j].ToolCall; tc != nil && tc.Input != nil {
+				tc.Input = json.RawMessage(compactJSON(tc.Input))
+			}
+		}
+	}
+}
+
+// Save writes the cassette to path atomically (write to a temp file, then rename).
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode cassette: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return fmt.Errorf("create cassette dir: %w", err)
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write cassette: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// RecordingProvider wraps a real LLMProvider and records every request,
+// response and stream event to a cassette file. The file is rewritten after
+// each interaction, so a run that crashes midway still leaves a usable cassette.
+//
+//	rec := claude.NewRecordingProvider(claude.NewAnthropicProvider(cfg), "testdata/run.json")
+//	agent := claude.NewAPIAgent(claude.APIAgentConfig{Provider: rec})
+type RecordingProvider struct {
+	inner LLMProvider
+	path  string
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecordingProvider creates a provider that records inner's traffic to path.
+// Any existing file at path is overwritten on the first interaction.
+func NewRecordingProvider(inner LLMProvider, path string) *RecordingProvider {
+	return &RecordingProvider{
+		inner:    inner,
+		path:     path,
+		cassette: Cassette{Version: cassetteVersion},
+	}
+}
+
+// Name returns the wrapped provider's name.
+func (p *RecordingProvider) Name() string { return p.inner.Name() }
+
+// Capabilities forwards to the wrapped provider's descriptor. Providers
+// without one are reported as supporting everything.
+func (p *RecordingProvider) Capabilities(model string) ProviderCapabilities {
+	return wrappedCapabilities(p.inner, model)
+}
+
+// Complete forwards to the wrapped provider and records the interaction.
+// A failure to write the cassette is returned as the call's error.
+func (p *RecordingProvider) Complete(ctx context.Context, req ChatRequest, onEvent ChatStreamCallback) (ChatResponse, error) {
+	var events []ChatStreamEvent
+	record := func(e ChatStreamEvent) {
+		events = append(events, cloneStreamEvent(e))
+		if onEvent != nil {
+			onEvent(e)
+		}
+	}
+
+	hash, err := hashChatRequest(req)
+	if err != nil {
+		return ChatResponse{}, fmt.Errorf("record cassette: %w", err)
+	}
+
+	resp, err := p.inner.Complete(ctx, req, record)
+
+	interaction := CassetteInteraction{
+		RequestHash: hash,
+		Provider:    p.inner.Name(),
+		Request:     req,
+		Events:      events,
+	}
+	if err != nil {
+		interaction.Error = err.Error()
+	} else {
+		interaction.Response = &resp
+	}
+
+	p.mu.Lock()
+	p.cassette.Interactions = append(p.cassette.Interactions, interaction)
+	saveErr := p.cassette.Save(p.path)
+	p.mu.Unlock()
+
+	if err != nil {
+		return resp, err
+	}
+	if saveErr != nil {
+		return resp, fmt.Errorf("record cassette: %w", saveErr)
+	}
+	return resp, nil
+}
+
+// Cassette returns a copy of everything recorded so far.
+func (p *RecordingProvider) Cassette() Cassette {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := Cassette{Version: p.cassette.Version}
+	out.Interactions = append(out.Interactions, p.cassette.Interactions...)
+	return out
+}
+
+// cloneStreamEvent copies an event so later mutation of the provider's
+// ToolCall pointer cannot change what was recorded.
+func cloneStreamEvent(e ChatStreamEvent) ChatStreamEvent {
+	if e.ToolCall != nil {
+		tc := *e.ToolCall
+		e.ToolCall = &tc
+	}
+	return e
+}
+
+// ReplayMatching controls how strictly a replayed request must match the recording.
+type ReplayMatching string
+
+const (
+	// ReplayStrict requires the request to be identical to the recorded one
+	// (model, messages, tools, system prompt and sampling parameters).
+	ReplayStrict ReplayMatching = "strict"
+	// ReplayLenient only compares the conversation: message roles, text,
+	// tool calls and tool results, plus the names of the offered tools.
+	// Model, system prompt, tool schemas and sampling parameters may differ.
+	ReplayLenient ReplayMatching = "lenient"
+)
+
+// ReplayOrder controls which recorded interaction answers a request.
+type ReplayOrder string
+
+const (
+	// ReplaySequential serves interactions in recorded order; the Nth request
+	// must match the Nth recording.
+	ReplaySequential ReplayOrder = "sequential"
+	// ReplayByHash serves the first unused interaction whose request matches,
+	// regardless of position. Useful when several agents (e.g. subagents)
+	// share one cassette and their requests interleave nondeterministically.
+	ReplayByHash ReplayOrder = "by_hash"
+)
+
+// ReplayConfig configures a ReplayProvider.
+type ReplayConfig struct {
+	// Matching defaults to ReplayStrict.
+	Matching ReplayMatching
+	// Order defaults to ReplaySequential.
+	Order ReplayOrder
+}
+
+// ErrCassetteExhausted is returned when a ReplayProvider has served every
+// recorded interaction and receives another request.
+var ErrCassetteExhausted = errors.New("cassette exhausted: no recorded interactions left")
+
+// CassetteMismatchError is returned when a request does not match the recording.
+type CassetteMismatchError struct {
+	// Index is the interaction the request was compared against, or -1 when
+	// no interaction matched under ReplayByHash.
+	Index int
+	// Diff is a line diff of the recorded (-) and actual (+) requests.
+	Diff string
+}
+
+func (e *CassetteMismatchError) Error() string {
+	if e.Index < 0 {
+		return "cassette mismatch: no recorded interaction matches request\n" + e.Diff
+	}
+	return fmt.Sprintf("cassette mismatch at interaction %d:\n%s", e.Index, e.Diff)
+}
+
+// ReplayProvider implements LLMProvider by serving interactions from a
+// Cassette. Recorded stream events are replayed through onEvent so the agent
+// loop behaves exactly as it did during recording.
+//
+//	replay, err := claude.NewReplayProviderFromFile("testdata/run.json", claude.ReplayConfig{})
+//	agent := claude.NewAPIAgent(claude.APIAgentConfig{Provider: replay})
+type ReplayProvider struct {
+	cfg      ReplayConfig
+	cassette *Cassette
+
+	mu   sync.Mutex
+	next int
+	used []bool
+}
+
+// NewReplayProvider creates a provider that serves the given cassette.
+func NewReplayProvider(c *Cassette, cfg ReplayConfig) *ReplayProvider {
+	if cfg.Matching == "" {
+		cfg.Matching = ReplayStrict
+	}
+	if cfg.Order == "" {
+		cfg.Order = ReplaySequential
+	}
+	return &ReplayProvider{
+		cfg:      cfg,
+		cassette: c,
+		used:     make([]bool, len(c.Interactions)),
+	}
+}
+
+// NewReplayProviderFromFile loads a cassette file and creates a ReplayProvider.
+func NewReplayProviderFromFile(path string, cfg ReplayConfig) (*ReplayProvider, error) {
+	c, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayProvider(c, cfg), nil
+}
+
+// Name returns "replay".
+func (p *ReplayProvider) Name() string { return "replay" }
+
+// Complete serves the next matching recorded interaction.
+func (p *ReplayProvider) Complete(ctx context.Context, req ChatRequest, onEvent ChatStreamCallback) (ChatResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ChatResponse{}, err
+	}
+
+	interaction, err := p.take(req)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+
+	if onEvent != nil {
+		for _, e := range interaction.Events {
+			onEvent(cloneStreamEvent(e))
+		}
+	}
+	if interaction.Error != "" {
+		return ChatResponse{}, errors.New(interaction.Error)
+	}
+	if interaction.Response == nil {
+		return ChatResponse{}, nil
+	}
+	return *interaction.Response, nil
+}
+
+// Remaining returns the number of interactions not yet served.
+func (p *ReplayProvider) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, u := range p.used {
+		if !u {
+			n++
+		}
+	}
+	return n
+}
+
+// take finds, marks as used, and returns the interaction for req.
+func (p *ReplayProvider) take(req ChatRequest) (*CassetteInteraction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	want, err := p.matchKey(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.cfg.Order == ReplayByHash {
+		for i := range p.cassette.Interactions {
+			if p.used[i] {
+				continue
+			}
+			got, err := p.matchKey(p.cassette.Interactions[i].Request)
+			if err != nil {
+				return nil, err
+			}
+			if got == want {
+				p.used[i] = true
+				return &p.cassette.Interactions[i], nil
+			}
+		}
+		for i := range p.cassette.Interactions {
+			if !p.used[i] {
+				return nil, &CassetteMismatchError{
+					Index: -1,
+					Diff:  p.diff(p.cassette.Interactions[i].Request, req),
+				}
+			}
+		}
+		return nil, ErrCassetteExhausted
+	}
+
+	if p.next >= len(p.cassette.Interactions) {
+		return nil, ErrCassetteExhausted
+	}
+	rec := &p.cassette.Interactions[p.next]
+	got, err := p.matchKey(rec.Request)
+	if err != nil {
+		return nil, err
+	}
+	if got != want {
+		return nil, &CassetteMismatchError{Index: p.next, Diff: p.diff(rec.Request, req)}
+	}
+	p.used[p.next] = true
+	p.next++
+	return rec, nil
+}
+
+// matchKey hashes the parts of req that matter under the configured matching.
+func (p *ReplayProvider) matchKey(req ChatRequest) (string, error) {
+	if p.cfg.Matching == ReplayLenient {
+		return hashJSON(lenientRequestView(req))
+	}
+	return hashChatRequest(req)
+}
+
+// diff renders the recorded and actual requests as a line diff, using the
+// same view of the request that matching uses.
+func (p *ReplayProvider) diff(recorded, actual ChatRequest) string {
+	var a, b any = recorded, actual
+	if p.cfg.Matching == ReplayLenient {
+		a, b = lenientRequestView(recorded), lenientRequestView(actual)
+	}
+	ra, _ := json.MarshalIndent(a, "", "  ")
+	rb, _ := json.MarshalIndent(b, "", "  ")
+	return lineDiff(string(ra), string(rb))
+}
+
+// hashChatRequest returns a canonical SHA-256 hash of a request. encoding/json
+// sorts map keys, so tool schemas hash identically regardless of map order.
+func hashChatRequest(req ChatRequest) (string, error) {
+	return hashJSON(req)
+}
+
+// hashJSON returns the SHA-256 hash of v's JSON encoding. It fails for
+// values json.Marshal rejects, such as a tool schema holding a func or NaN.
+func hashJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("hash request: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lenientMessage is the subset of a ChatMessage compared by ReplayLenient.
+type lenientMessage struct {
+	Role      ChatRole `json:"role"`
+	Content   string   `json:"content,omitempty"`
+	ToolCalls []string `json:"tool_calls,omitempty"`
+	IsError   bool     `json:"is_error,omitempty"`
+}
+
+// lenientRequestView reduces a request to what ReplayLenient compares.
+// Tool call IDs are dropped because providers generate them randomly.
+func lenientRequestView(req ChatRequest) any {
+	msgs := make([]lenientMessage, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		lm := lenientMessage{Role: m.Role, Content: m.Content, IsError: m.IsError}
+		for _, tc := range m.ToolCalls {
+			lm.ToolCalls = append(lm.ToolCalls, tc.Name+" "+compactJSON(tc.Input))
+		}
+		msgs = append(msgs, lm)
+	}
+	tools := make([]string, 0, len(req.Tools))
+	for _, t := range req.Tools {
+		tools = append(tools, t.Name)
+	}
+	return struct {
+		Messages []lenientMessage `json:"messages"`
+		Tools    []string         `json:"tools,omitempty"`
+	}{msgs, tools}
+}
+
+// compactJSON strips insignificant whitespace from raw JSON; invalid JSON is
+// returned as-is.
+func compactJSON(raw json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+// lineDiff returns a minimal line diff of a and b: removed lines are prefixed
+// with "- ", added lines with "+ ", and unchanged lines with "  ".
+func lineDiff(a, b string) string {
+	al := strings.Split(a, "\n")
+	bl := strings.Split(b, "\n")
+
+	// Longest common subsequence table.
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(al) && j < len(bl) {
+		switch {
+		case al[i] == bl[j]:
+			sb.WriteString("  " + al[i] + "\n")
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			sb.WriteString("- " + al[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+ " + bl[j] + "\n")
+			j++
+		}
+	}
+	for ; i < len(al); i++ {
+		sb.WriteString("- " + al[i] + "\n")
+	}
+	for ; j < len(bl); j++ {
+		sb.WriteString("+ " + bl[j] + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,217 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cassetteScriptProvider returns scripted responses in order, streaming
+// content and tool events like a real provider would.
+type cassetteScriptProvider struct {
+	responses []ChatResponse
+	calls     int
+}
+
+func (p *cassetteScriptProvider) Name() string { return "script" }
+
+func (p *cassetteScriptProvider) Complete(_ context.Context, _ ChatRequest, onEvent ChatStreamCallback) (ChatResponse, error) {
+	if p.calls >= len(p.responses) {
+		return ChatResponse{}, errors.New("script exhausted")
+	}
+	resp := p.responses[p.calls]
+	p.calls++
+	if onEvent != nil {
+		if resp.Reasoning != "" {
+			onEvent(ChatStreamEvent{Type: ChatStreamReasoningDelta, Content: resp.Reasoning})
+		}
+		if resp.Content != "" {
+			onEvent(ChatStreamEvent{Type: ChatStreamContentDelta, Content: resp.Content})
+		}
+		for i := range resp.ToolCalls {
+			tc := resp.ToolCalls[i]
+			onEvent(ChatStreamEvent{Type: ChatStreamToolUseStart, ToolCall: &ToolCall{ID: tc.ID, Name: tc.Name}})
+			onEvent(ChatStreamEvent{Type: ChatStreamToolUseEnd, ToolCall: &tc})
+		}
+	}
+	return resp, nil
+}
+
+func cassetteTestAgent(provider LLMProvider) *APIAgent {
+	tools := NewToolRegistry()
+	tools.Register(ToolDefinition{
+		Name:        "lookup",
+		Description: "look up a value",
+		InputSchema: ObjectSchema(map[string]any{"key": StringParam("key")}, "key"),
+	}, func(_ context.Context, input json.RawMessage) (string, error) {
+		return "value-for-" + string(input), nil
+	})
+	return NewAPIAgent(APIAgentConfig{Provider: provider, Tools: tools})
+}
+
+func collectContent(t *testing.T, agent *APIAgent) string {
+	t.Helper()
+	out, err := agent.RunSync(context.Background(), "find the key")
+	if err != nil {
+		t.Fatalf("RunSync: %v", err)
+	}
+	return out
+}
+
+func TestRecordAndReplayAgentLoop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassettes", "run.json")
+
+	script := &cassetteScriptProvider{responses: []ChatResponse{
+		{
+			Content:    "Looking it up.",
+			ToolCalls:  []ToolCall{{ID: "call_1", Name: "lookup", Input: json.RawMessage(`{"key":"a"}`)}},
+			StopReason: "tool_use",
+			Usage:      ChatUsage{InputTokens: 10, OutputTokens: 5},
+		},
+		{Content: "The answer is A.", StopReason: "end_turn", Usage: ChatUsage{InputTokens: 20, OutputTokens: 6}},
+	}}
+
+	recorded := collectContent(t, cassetteTestAgent(NewRecordingProvider(script, path)))
+
+	c, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("LoadCassette: %v", err)
+	}
+	if len(c.Interactions) != 2 {
+		t.Fatalf("expected 2 interactions, got %d", len(c.Interactions))
+	}
+	if c.Interactions[1].Request.Messages[2].Role != ChatRoleTool {
+		t.Errorf("expected tool result in second request, got %+v", c.Interactions[1].Request.Messages)
+	}
+	if len(c.Interactions[0].Events) != 3 {
+		t.Errorf("expected 3 recorded stream events, got %d", len(c.Interactions[0].Events))
+	}
+
+	for _, matching := range []ReplayMatching{ReplayStrict, ReplayLenient} {
+		replay, err := NewReplayProviderFromFile(path, ReplayConfig{Matching: matching})
+		if err != nil {
+			t.Fatalf("NewReplayProviderFromFile: %v", err)
+		}
+		replayed := collectContent(t, cassetteTestAgent(replay))
+		if replayed != recorded {
+			t.Errorf("%s: replayed output %q != recorded %q", matching, replayed, recorded)
+		}
+		if replay.Remaining() != 0 {
+			t.Errorf("%s: expected cassette fully consumed, %d left", matching, replay.Remaining())
+		}
+	}
+}
+
+func TestReplayStrictMismatchDiff(t *testing.T) {
+	c := &Cassette{Version: cassetteVersion, Interactions: []CassetteInteraction{{
+		Request:  ChatRequest{Model: "m", Messages: []ChatMessage{{Role: ChatRoleUser, Content: "hello"}}},
+		Response: &ChatResponse{Content: "hi"},
+	}}}
+	p := NewReplayProvider(c, ReplayConfig{})
+
+	_, err := p.Complete(context.Background(), ChatRequest{
+		Model:    "m",
+		Messages: []ChatMessage{{Role: ChatRoleUser, Content: "goodbye"}},
+	}, nil)
+
+	var mm *CassetteMismatchError
+	if !errors.As(err, &mm) {
+		t.Fatalf("expected CassetteMismatchError, got %v", err)
+	}
+	if mm.Index != 0 {
+		t.Errorf("expected index 0, got %d", mm.Index)
+	}
+	if !strings.Contains(mm.Diff, `- `) || !strings.Contains(mm.Diff, `"hello"`) || !strings.Contains(mm.Diff, `"goodbye"`) {
+		t.Errorf("diff should show both versions, got:\n%s", mm.Diff)
+	}
+}
+
+func TestReplayLenientIgnoresModelAndToolIDs(t *testing.T) {
+	rec := ChatRequest{
+		Model: "recorded-model",
+		Messages: []ChatMessage{
+			{Role: ChatRoleUser, Content: "q"},
+			{Role: ChatRoleAssistant, ToolCalls: []ToolCall{{ID: "call_abc", Name: "t", Input: json.RawMessage(`{"a": 1}`)}}},
+		},
+	}
+	c := &Cassette{Version: cassetteVersion, Interactions: []CassetteInteraction{{Request: rec, Response: &ChatResponse{Content: "ok"}}}}
+
+	req := rec
+	req.Model = "other-model"
+	req.Messages = []ChatMessage{
+		rec.Messages[0],
+		{Role: ChatRoleAssistant, ToolCalls: []ToolCall{{ID: "call_xyz", Name: "t", Input: json.RawMessage(`{"a":1}`)}}},
+	}
+
+	if _, err := NewReplayProvider(c, ReplayConfig{Matching: ReplayStrict}).Complete(context.Background(), req, nil); err == nil {
+		t.Error("strict matching should reject a different model")
+	}
+	resp, err := NewReplayProvider(c, ReplayConfig{Matching: ReplayLenient}).Complete(context.Background(), req, nil)
+	if err != nil || resp.Content != "ok" {
+		t.Errorf("lenient matching should accept, got %q, %v", resp.Content, err)
+	}
+}
+
+func TestReplayByHashAndExhaustion(t *testing.T) {
+	reqA := ChatRequest{Messages: []ChatMessage{{Role: ChatRoleUser, Content: "a"}}}
+	reqB := ChatRequest{Messages: []ChatMessage{{Role: ChatRoleUser, Content: "b"}}}
+	c := &Cassette{Version: cassetteVersion, Interactions: []CassetteInteraction{
+		{Request: reqA, Response: &ChatResponse{Content: "A"}},
+		{Request: reqB, Error: "recorded failure"},
+	}}
+	p := NewReplayProvider(c, ReplayConfig{Order: ReplayByHash})
+
+	if _, err := p.Complete(context.Background(), reqB, nil); err == nil || err.Error() != "recorded failure" {
+		t.Errorf("expected recorded error, got %v", err)
+	}
+	resp, err := p.Complete(context.Background(), reqA, nil)
+	if err != nil || resp.Content != "A" {
+		t.Errorf("expected A, got %q, %v", resp.Content, err)
+	}
+	if _, err := p.Complete(context.Background(), reqA, nil); !errors.Is(err, ErrCassetteExhausted) {
+		t.Errorf("expected ErrCassetteExhausted, got %v", err)
+	}
+}
+
+func TestCassetteUnencodableRequest(t *testing.T) {
+	req := ChatRequest{
+		Messages: []ChatMessage{{Role: ChatRoleUser, Content: "a"}},
+		Tools:    []ToolDefinition{{Name: "bad", InputSchema: map[string]any{"default": math.NaN()}}},
+	}
+	inner := &cassetteScriptProvider{responses: []ChatResponse{{Content: "A"}}}
+	rec := NewRecordingProvider(inner, filepath.Join(t.TempDir(), "run.json"))
+	if _, err := rec.Complete(context.Background(), req, nil); err == nil {
+		t.Error("recording an unencodable request should fail")
+	}
+	if inner.calls != 0 {
+		t.Error("an unencodable request should not be sent")
+	}
+
+	replay := NewReplayProvider(&Cassette{Version: cassetteVersion}, ReplayConfig{})
+	if _, err := replay.Complete(context.Background(), req, nil); err == nil || errors.Is(err, ErrCassetteExhausted) {
+		t.Errorf("replaying an unencodable request should fail to hash it, got %v", err)
+	}
+}
+
+func TestRecordingProviderCapabilities(t *testing.T) {
+	inner := &modelCapsProvider{routerFakeProvider: routerFakeProvider{name: "inner"}, visionModel: "big"}
+	rec := NewRecordingProvider(inner, filepath.Join(t.TempDir(), "run.json"))
+	if caps, ok := CapabilitiesOf(rec, "small"); !ok || caps.Vision || !caps.Tools {
+		t.Errorf("capabilities should be the wrapped provider's, got %+v, %v", caps, ok)
+	}
+	if caps, _ := CapabilitiesOf(NewRecordingProvider(&cassetteScriptProvider{}, ""), ""); !caps.Vision || !caps.Tools {
+		t.Errorf("a wrapped provider without a descriptor supports everything, got %+v", caps)
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	got := lineDiff("a\nb\nc", "a\nx\nc")
+	want := "  a\n- b\n+ x\n  c\n"
+	if got != want {
+		t.Errorf("lineDiff = %q, want %q", got, want)
+	}
+}
@@ -2,17 +2,22 @@ package claudeagent
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/anthropics/anthropic-sdk-go/packages/param"
 )
 
 // AnthropicProviderConfig configures an Anthropic LLM provider.
 type AnthropicProviderConfig struct {
 	// APIKey is the Anthropic API key. Defaults to ANTHROPIC_API_KEY env var.
 	APIKey string // #nosec G117 -- config field, not a hardcoded secret
+	// BaseURL overrides the API endpoint (e.g., a proxy or test server).
+	// Defaults to ANTHROPIC_BASE_URL or the public API.
+	BaseURL string
 }
 
 // AnthropicProvider implements LLMProvider using the Anthropic Messages API.
@@ -28,6 +33,9 @@ func NewAnthropicProvider(cfg AnthropicProviderConfig) *AnthropicProvider {
 	if cfg.APIKey != "" {
 		opts = append(opts, option.WithAPIKey(cfg.APIKey))
 	}
+	if cfg.BaseURL != "" {
+		opts = append(opts, option.WithBaseURL(cfg.BaseURL))
+	}
 	return &AnthropicProvider{
 		client: anthropic.NewClient(opts...),
 	}
@@ -36,41 +44,35 @@ func NewAnthropicProvider(cfg AnthropicProviderConfig) *AnthropicProvider {
 // Name returns "anthropic".
 func (p *AnthropicProvider) Name() string { return "anthropic" }
 
-// Complete sends the chat request to the Anthropic Messages API with streaming.
-// onEvent is called for each content delta and tool use event as they arrive.
-// defaultAnthropicModel is used when no model is specified in the ChatRequest.
-const defaultAnthropicModel = "claude-sonnet-4-20250514"
-
-func (p *AnthropicProvider) Complete(ctx context.Context, req ChatRequest, onEvent ChatStreamCallback) (ChatResponse, error) {
-	model := req.Model
+// Capabilities reports tools, parallel tool calls, vision, prompt caching and
+// system blocks for every Claude model, plus thinking and output limits for
+// known models.
+func (p *AnthropicProvider) Capabilities(model string) ProviderCapabilities {
 	if model == "" {
 		model = defaultAnthropicModel
 	}
-
-	params := anthropic.MessageNewParams{
-		Model:     anthropic.Model(model),
-		MaxTokens: int64(req.MaxTokens),
-		Messages:  convertMessagesToAnthropic(req.Messages),
+	caps := ProviderCapabilities{
+		Tools:             true,
+		ParallelToolCalls: true,
+		Vision:            true,
+		PromptCaching:     true,
+		SystemBlocks:      true,
+		ContextWindow:     200000,
 	}
-
-	// System prompt: structured blocks take precedence for cache control.
-	if len(req.SystemBlocks) > 0 {
-		blocks := make([]anthropic.TextBlockParam, len(req.SystemBlocks))
-		for i, b := range req.SystemBlocks {
-			blocks[i] = anthropic.TextBlockParam{Text: b.Text}
-			if b.CacheControl != nil {
-				blocks[i].CacheControl = anthropic.CacheControlEphemeralParam{}
-			}
-		}
-		params.System = blocks
-	} else if req.SystemPrompt != "" {
-		params.System = []anthropic.TextBlockParam{{Text: req.SystemPrompt}}
+	if row, ok := lookupModel(anthropicModels, model); ok {
+		caps.Thinking = row.thinking
+		caps.MaxOutputTokens = row.maxOutputTokens
 	}
+	return caps
+}
 
-	// Tools.
-	if len(req.Tools) > 0 {
-		params.Tools = convertToolsToAnthropic(req.Tools)
-	}
+// Complete sends the chat request to the Anthropic Messages API with streaming.
+// onEvent is called for each content delta and tool use event as they arrive.
+// defaultAnthropicModel is used when no model is specified in the ChatRequest.
+const defaultAnthropicModel = "claude-sonnet-4-20250514"
+
+func (p *AnthropicProvider) Complete(ctx context.Context, req ChatRequest, onEvent ChatStreamCallback) (ChatResponse, error) {
+	params := buildAnthropicParams(req)
 
 	stream := p.client.Messages.NewStreaming(ctx, params)
 
@@ -82,6 +84,7 @@ func (p *AnthropicProvider) Complete(ctx context.Context, req ChatRequest, onEve
 		currentToolJSON string
 		usage           ChatUsage
 		stopReason      string
+		model           string
 	)
 
 	for stream.Next() {
@@ -139,8 +142,10 @@ func (p *AnthropicProvider) Complete(ctx context.Context, req ChatRequest, onEve
 			}
 
 		case anthropic.MessageStartEvent:
+			model = string(e.Message.Model)
 			usage.InputTokens = int(e.Message.Usage.InputTokens)
 			usage.CacheCreationInputTokens = int(e.Message.Usage.CacheCreationInputTokens)
+			usage.CacheCreation1hInputTokens = int(e.Message.Usage.CacheCreation.Ephemeral1hInputTokens)
 			usage.CacheReadInputTokens = int(e.Message.Usage.CacheReadInputTokens)
 
 		case anthropic.MessageDeltaEvent:
@@ -173,43 +178,212 @@ func (p *AnthropicProvider) Complete(ctx context.Context, req ChatRequest, onEve
 		ToolCalls:  toolCalls,
 		StopReason: stopReason,
 		Usage:      usage,
+		Model:      model,
 	}, nil
 }
 
-// convertMessagesToAnthropic converts canonical ChatMessages to Anthropic SDK params.
-func convertMessagesToAnthropic(msgs []ChatMessage) []anthropic.MessageParam {
-	out := make([]anthropic.MessageParam, 0, len(msgs))
-	for _, m := range msgs {
-		switch m.Role {
-		case ChatRoleUser:
-			out = append(out, anthropic.NewUserMessage(anthropic.NewTextBlock(m.Content)))
-
-		case ChatRoleAssistant:
-			var blocks []anthropic.ContentBlockParamUnion
-			if m.Content != "" {
-				blocks = append(blocks, anthropic.NewTextBlock(m.Content))
+// buildAnthropicParams converts a ChatRequest to Messages API parameters.
+func buildAnthropicParams(req ChatRequest) anthropic.MessageNewParams {
+	model := req.Model
+	if model == "" {
+		model = defaultAnthropicModel
+	}
+
+	plan := planCacheBreakpoints(req)
+
+	params := anthropic.MessageNewParams{
+		Model:     anthropic.Model(model),
+		MaxTokens: int64(req.MaxTokens),
+	}
+
+	// Messages, with rolling cache breakpoints.
+	marked := make(map[int]bool, len(plan.messages))
+	for _, i := range plan.messages {
+		marked[i] = true
+	}
+	for i, m := range req.Messages {
+		msg, ok := convertMessageToAnthropic(m)
+		if !ok {
+			continue
+		}
+		if marked[i] && len(msg.Content) > 0 {
+			if cc := msg.Content[len(msg.Content)-1].GetCacheControl(); cc != nil {
+				*cc = anthropicCacheControl(plan.ttl)
 			}
-			for _, tc := range m.ToolCalls {
-				var inputData any
-				_ = json.Unmarshal(tc.Input, &inputData)
-				blocks = append(blocks, anthropic.NewToolUseBlock(tc.ID, inputData, tc.Name))
+		}
+		params.Messages = append(params.Messages, msg)
+	}
+
+	// System prompt: structured blocks take precedence for cache control.
+	if len(req.SystemBlocks) > 0 {
+		blocks := make([]anthropic.TextBlockParam, len(req.SystemBlocks))
+		for i, b := range req.SystemBlocks {
+			blocks[i] = anthropic.TextBlockParam{Text: b.Text}
+			if b.CacheControl != nil {
+				blocks[i].CacheControl = anthropicCacheControl(b.CacheControl.TTL)
 			}
-			if len(blocks) > 0 {
-				out = append(out, anthropic.NewAssistantMessage(blocks...))
+		}
+		params.System = blocks
+	} else if req.SystemPrompt != "" {
+		params.System = []anthropic.TextBlockParam{{Text: req.SystemPrompt}}
+	}
+	if plan.system && len(params.System) > 0 {
+		params.System[len(params.System)-1].CacheControl = anthropicCacheControl(plan.ttl)
+	}
+
+	// Tools.
+	if len(req.Tools) > 0 {
+		params.Tools = convertToolsToAnthropic(req.Tools)
+		params.ToolChoice = convertToolChoiceToAnthropic(req.ToolChoice, req.DisableParallelToolUse)
+		if plan.tools {
+			if cc := params.Tools[len(params.Tools)-1].GetCacheControl(); cc != nil {
+				*cc = anthropicCacheControl(plan.toolsTTL)
 			}
+		}
+	}
+
+	// Sampling controls.
+	if req.Temperature != nil {
+		params.Temperature = anthropic.Float(*req.Temperature)
+	}
+	if req.TopP != nil {
+		params.TopP = anthropic.Float(*req.TopP)
+	}
+	if req.TopK != nil {
+		params.TopK = anthropic.Int(int64(*req.TopK))
+	}
+	if len(req.StopSequences) > 0 {
+		params.StopSequences = req.StopSequences
+	}
+	if req.Metadata != nil && req.Metadata.UserID != "" {
+		params.Metadata = anthropic.MetadataParam{UserID: anthropic.String(req.Metadata.UserID)}
+	}
+	return params
+}
+
+// convertToolChoiceToAnthropic maps a ToolChoice to Anthropic's tool_choice.
+// A nil choice with disableParallel set becomes auto with parallel use off;
+// otherwise a nil choice leaves tool_choice unset.
+func convertToolChoiceToAnthropic(tc *ToolChoice, disableParallel bool) anthropic.ToolChoiceUnionParam {
+	choiceType := ToolChoiceAuto
+	if tc != nil {
+		choiceType = tc.Type
+	}
+	var parallel param.Opt[bool]
+	if disableParallel {
+		parallel = anthropic.Bool(true)
+	}
+
+	switch choiceType {
+	case ToolChoiceAny:
+		return anthropic.ToolChoiceUnionParam{OfAny: &anthropic.ToolChoiceAnyParam{DisableParallelToolUse: parallel}}
+	case ToolChoiceNone:
+		return anthropic.ToolChoiceUnionParam{OfNone: &anthropic.ToolChoiceNoneParam{}}
+	case ToolChoiceTool:
+		return anthropic.ToolChoiceUnionParam{OfTool: &anthropic.ToolChoiceToolParam{Name: tc.Name, DisableParallelToolUse: parallel}}
+	case ToolChoiceAuto:
+	}
+	if tc == nil && !disableParallel {
+		return anthropic.ToolChoiceUnionParam{}
+	}
+	return anthropic.ToolChoiceUnionParam{OfAuto: &anthropic.ToolChoiceAutoParam{DisableParallelToolUse: parallel}}
+}
+
+// CountTokens returns the exact input token count for req using the
+// Messages API count_tokens endpoint. It implements TokenCounter.
+func (p *AnthropicProvider) CountTokens(ctx context.Context, req ChatRequest) (int, error) {
+	params := buildAnthropicParams(req)
+	countParams := anthropic.MessageCountTokensParams{
+		Model:    params.Model,
+		Messages: params.Messages,
+	}
+	if len(params.System) > 0 {
+		countParams.System = anthropic.MessageCountTokensParamsSystemUnion{OfTextBlockArray: params.System}
+	}
+	for _, t := range params.Tools {
+		countParams.Tools = append(countParams.Tools, anthropic.MessageCountTokensToolUnionParam{OfTool: t.OfTool})
+	}
+	res, err := p.client.Messages.CountTokens(ctx, countParams)
+	if err != nil {
+		return 0, fmt.Errorf("count tokens: %w", err)
+	}
+	return int(res.InputTokens), nil
+}
 
-		case ChatRoleTool:
-			out = append(out, anthropic.NewUserMessage(
-				anthropic.NewToolResultBlock(m.ToolCallID, m.Content, m.IsError),
-			))
+// anthropicCacheControl returns an ephemeral cache_control with ttl
+// (empty means the API default of 5m).
+func anthropicCacheControl(ttl string) anthropic.CacheControlEphemeralParam {
+	// The constructor sets Type; a zero-value param is omitted when
+	// marshaled, which would drop default-TTL breakpoints entirely.
+	cc := anthropic.NewCacheControlEphemeralParam()
+	cc.TTL = anthropic.CacheControlEphemeralTTL(ttl)
+	return cc
+}
 
-		case ChatRoleSystem:
-			// System prompt is handled separately via params.System — skip here.
+// convertMessagesToAnthropic converts canonical ChatMessages to Anthropic SDK params.
+func convertMessagesToAnthropic(msgs []ChatMessage) []anthropic.MessageParam {
+	out := make([]anthropic.MessageParam, 0, len(msgs))
+	for _, m := range msgs {
+		if msg, ok := convertMessageToAnthropic(m); ok {
+			out = append(out, msg)
 		}
 	}
 	return out
 }
 
+// convertMessageToAnthropic converts one ChatMessage. It returns false for
+// messages with no Anthropic equivalent: system messages (sent via
+// params.System) and empty assistant messages.
+func convertMessageToAnthropic(m ChatMessage) (anthropic.MessageParam, bool) {
+	switch m.Role {
+	case ChatRoleUser:
+		if len(m.Images) == 0 {
+			return anthropic.NewUserMessage(anthropic.NewTextBlock(m.Content)), true
+		}
+		// Images go before the text, as Anthropic recommends.
+		blocks := make([]anthropic.ContentBlockParamUnion, 0, len(m.Images)+1)
+		for _, img := range m.Images {
+			blocks = append(blocks, convertImageToAnthropic(img))
+		}
+		if m.Content != "" {
+			blocks = append(blocks, anthropic.NewTextBlock(m.Content))
+		}
+		return anthropic.NewUserMessage(blocks...), true
+
+	case ChatRoleAssistant:
+		var blocks []anthropic.ContentBlockParamUnion
+		if m.Content != "" {
+			blocks = append(blocks, anthropic.NewTextBlock(m.Content))
+		}
+		for _, tc := range m.ToolCalls {
+			var inputData any
+			_ = json.Unmarshal(tc.Input, &inputData)
+			blocks = append(blocks, anthropic.NewToolUseBlock(tc.ID, inputData, tc.Name))
+		}
+		if len(blocks) == 0 {
+			return anthropic.MessageParam{}, false
+		}
+		return anthropic.NewAssistantMessage(blocks...), true
+
+	case ChatRoleTool:
+		return anthropic.NewUserMessage(
+			anthropic.NewToolResultBlock(m.ToolCallID, m.Content, m.IsError),
+		), true
+
+	case ChatRoleSystem:
+		// System prompt is handled separately via params.System — skip here.
+	}
+	return anthropic.MessageParam{}, false
+}
+
+// convertImageToAnthropic converts a ChatImage to a base64 or URL image block.
+func convertImageToAnthropic(img ChatImage) anthropic.ContentBlockParamUnion {
+	if len(img.Data) > 0 {
+		return anthropic.NewImageBlockBase64(img.MediaType, base64.StdEncoding.EncodeToString(img.Data))
+	}
+	return anthropic.NewImageBlock(anthropic.URLImageSourceParam{URL: img.URL})
+}
+
 // convertToolsToAnthropic converts ToolDefinitions to Anthropic SDK tool params.
 func convertToolsToAnthropic(defs []ToolDefinition) []anthropic.ToolUnionParam {
 	tools := make([]anthropic.ToolUnionParam, 0, len(defs))
@@ -118,3 +118,67 @@ func TestAnthropicProvider_Name(t *testing.T) {
 		t.Errorf("expected 'anthropic', got %q", p.Name())
 	}
 }
+
+func TestBuildAnthropicParams_SamplingAndToolChoice(t *testing.T) {
+	temp, topP, topK := 0.2, 0.9, 40
+	req := ChatRequest{
+		Model:                  "claude-sonnet-4-20250514",
+		Messages:               []ChatMessage{{Role: ChatRoleUser, Content: "hi"}},
+		Tools:                  []ToolDefinition{{Name: "search", InputSchema: ObjectSchema(map[string]any{})}},
+		MaxTokens:              100,
+		Temperature:            &temp,
+		TopP:                   &topP,
+		TopK:                   &topK,
+		StopSequences:          []string{"END"},
+		ToolChoice:             ToolChoiceFor("search"),
+		DisableParallelToolUse: true,
+		Metadata:               &RequestMetadata{UserID: "u-123"},
+	}
+	body, err := json.Marshal(buildAnthropicParams(req))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var got map[string]any
+	_ = json.Unmarshal(body, &got)
+
+	if got["temperature"] != 0.2 || got["top_p"] != 0.9 || got["top_k"] != float64(40) {
+		t.Errorf("sampling not mapped: %s", body)
+	}
+	if stops, _ := got["stop_sequences"].([]any); len(stops) != 1 || stops[0] != "END" {
+		t.Errorf("stop_sequences = %v", got["stop_sequences"])
+	}
+	if md, _ := got["metadata"].(map[string]any); md["user_id"] != "u-123" {
+		t.Errorf("metadata = %v", got["metadata"])
+	}
+	tc, _ := got["tool_choice"].(map[string]any)
+	if tc["type"] != "tool" || tc["name"] != "search" || tc["disable_parallel_tool_use"] != true {
+		t.Errorf("tool_choice = %v", got["tool_choice"])
+	}
+}
+
+func TestConvertToolChoiceToAnthropic(t *testing.T) {
+	cases := []struct {
+		name    string
+		tc      *ToolChoice
+		disable bool
+		want    string
+	}{
+		{"unset", nil, false, ``},
+		{"disable parallel only", nil, true, `{"disable_parallel_tool_use":true,"type":"auto"}`},
+		{"any", &ToolChoice{Type: ToolChoiceAny}, false, `{"type":"any"}`},
+		{"none", &ToolChoice{Type: ToolChoiceNone}, true, `{"type":"none"}`},
+	}
+	for _, c := range cases {
+		u := convertToolChoiceToAnthropic(c.tc, c.disable)
+		if c.want == "" {
+			if u.OfAuto != nil || u.OfAny != nil || u.OfTool != nil || u.OfNone != nil {
+				t.Errorf("%s: expected unset tool_choice", c.name)
+			}
+			continue
+		}
+		b, _ := json.Marshal(u)
+		if string(b) != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, b, c.want)
+		}
+	}
+}
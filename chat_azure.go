@@ -0,0 +1,339 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultAzureAPIVersion is the Azure OpenAI data-plane API version used when
+// AzureOpenAIConfig.APIVersion is empty.
+const defaultAzureAPIVersion = "2024-10-21"
+
+// AzureTokenProvider returns a Microsoft Entra ID bearer token for Azure OpenAI.
+// It is called before every request, so implementations should cache tokens
+// and refresh them before they expire.
+type AzureTokenProvider func(ctx context.Context) (string, error)
+
+// AzureOpenAIConfig configures an Azure OpenAI chat completions provider.
+type AzureOpenAIConfig struct {
+	// Endpoint is the resource endpoint (e.g., "https://my-resource.openai.azure.com").
+	Endpoint string
+
+	// Deployment is the default deployment name. Requests are sent to
+	// {Endpoint}/openai/deployments/{Deployment}/chat/completions.
+	Deployment string
+
+	// Deployments maps model names (ChatRequest.Model) to deployment names,
+	// so FallbackModelConfig and per-request models can target different
+	// deployments. When ChatRequest.Model is not in this map and Deployment
+	// is empty, the model name itself is used as the deployment name.
+	Deployments map[string]string
+
+	// APIVersion is the api-version query parameter. Defaults to "2024-10-21".
+	APIVersion string
+
+	// APIKey is sent in the api-key header. Ignored when TokenProvider is set.
+	APIKey string // #nosec G117 -- config field, not a hardcoded secret
+
+	// TokenProvider supplies Entra ID tokens, sent as "Authorization: Bearer".
+	// Takes precedence over APIKey.
+	TokenProvider AzureTokenProvider
+
+	// HTTPTimeout sets the HTTP client timeout. Defaults to 120 seconds.
+	HTTPTimeout time.Duration
+
+	// Capabilities overrides the built-in model table. Deployment names are
+	// arbitrary, so set this when models are addressed by deployment name.
+	Capabilities *ProviderCapabilities
+}
+
+// AzureOpenAIProvider implements LLMProvider for Azure OpenAI deployments.
+// It shares request building and stream parsing with OpenAICompatProvider,
+// and adds per-deployment URLs, api-version, api-key/Entra ID auth, and
+// content filter handling.
+//
+// Completions stopped by the content filter, and prompts rejected by it,
+// are returned as *ContentFilterError. Non-blocking annotations (e.g. a
+// "low" severity hit that was not filtered) are delivered to onEvent as
+// ChatStreamContentFilter events.
+type AzureOpenAIProvider struct {
+	cfg   AzureOpenAIConfig
+	inner *OpenAICompatProvider
+}
+
+// NewAzureOpenAIProvider creates a provider for an Azure OpenAI resource.
+func NewAzureOpenAIProvider(cfg AzureOpenAIConfig) *AzureOpenAIProvider {
+	if cfg.APIVersion == "" {
+		cfg.APIVersion = defaultAzureAPIVersion
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	return &AzureOpenAIProvider{
+		cfg: cfg,
+		inner: NewOpenAICompatProvider(OpenAICompatConfig{
+			BaseURL:      cfg.Endpoint,
+			APIKey:       cfg.APIKey,
+			Model:        cfg.Deployment,
+			HTTPTimeout:  cfg.HTTPTimeout,
+			Capabilities: cfg.Capabilities,
+		}),
+	}
+}
+
+// Name returns "azure-openai".
+func (p *AzureOpenAIProvider) Name() string { return "azure-openai" }
+
+// Capabilities describes model using AzureOpenAIConfig.Capabilities or the
+// OpenAI model table.
+func (p *AzureOpenAIProvider) Capabilities(model string) ProviderCapabilities {
+	if p.cfg.Capabilities != nil {
+		return *p.cfg.Capabilities
+	}
+	if model == "" {
+		model = p.cfg.Deployment
+	}
+	return openAICompatCapabilities(model)
+}
+
+// Complete sends the request to the deployment's chat completions endpoint.
+func (p *AzureOpenAIProvider) Complete(ctx context.Context, req ChatRequest, onEvent ChatStreamCallback) (ChatResponse, error) {
+	deployment := p.deploymentFor(req.Model)
+	if deployment == "" {
+		return ChatResponse{}, fmt.Errorf("azure openai: no deployment configured for model %q", req.Model)
+	}
+
+	body, err := p.inner.buildRequestBody(req)
+	if err != nil {
+		return ChatResponse{}, fmt.Errorf("build request: %w", err)
+	}
+
+	var bearer string
+	if p.cfg.TokenProvider != nil {
+		bearer, err = p.cfg.TokenProvider(ctx)
+		if err != nil {
+			return ChatResponse{}, fmt.Errorf("azure openai: get token: %w", err)
+		}
+	}
+
+	resp, err := p.inner.post(ctx, p.chatURL(deployment), body, func(h http.Header) {
+		if bearer != "" {
+			h.Set("Authorization", "Bearer "+bearer)
+		} else {
+			h.Set("api-key", p.cfg.APIKey)
+		}
+	})
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			if cfErr := parseAzureFilterError(apiErr); cfErr != nil {
+				return ChatResponse{}, cfErr
+			}
+		}
+		return ChatResponse{}, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	filters := &contentFilterCollector{}
+	out, err := p.inner.parseSSEStream(resp.Body, onEvent, filters)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	if out.StopReason == "content_filter" {
+		return ChatResponse{}, &ContentFilterError{
+			Source:  ContentFilterSourceCompletion,
+			Results: filters.filtered(),
+		}
+	}
+	return out, nil
+}
+
+// deploymentFor resolves the deployment name for a request model.
+func (p *AzureOpenAIProvider) deploymentFor(model string) string {
+	if d, ok := p.cfg.Deployments[model]; ok {
+		return d
+	}
+	if p.cfg.Deployment != "" {
+		return p.cfg.Deployment
+	}
+	return model
+}
+
+// chatURL builds the chat completions URL for a deployment.
+func (p *AzureOpenAIProvider) chatURL(deployment string) string {
+	return fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		p.cfg.Endpoint, url.PathEscape(deployment), url.QueryEscape(p.cfg.APIVersion))
+}
+
+// ContentFilterSource identifies which side of the exchange was filtered.
+type ContentFilterSource string
+
+const (
+	// ContentFilterSourcePrompt means the request prompt was filtered.
+	ContentFilterSourcePrompt ContentFilterSource = "prompt"
+	// ContentFilterSourceCompletion means the generated completion was filtered.
+	ContentFilterSourceCompletion ContentFilterSource = "completion"
+)
+
+// ContentFilterResult is one category annotation from the Azure content filter.
+type ContentFilterResult struct {
+	// Source is whether this annotation applies to the prompt or the completion.
+	Source ContentFilterSource `json:"source"`
+	// Category is the filter category (e.g., "hate", "violence", "jailbreak",
+	// "protected_material_code").
+	Category string `json:"category"`
+	// Filtered reports whether content was blocked for this category.
+	Filtered bool `json:"filtered"`
+	// Severity is "safe", "low", "medium" or "high" for harm categories.
+	// Empty for detection-only categories.
+	Severity string `json:"severity,omitempty"`
+	// Detected is set by detection-only categories (jailbreak, protected material).
+	Detected bool `json:"detected,omitempty"`
+}
+
+// ContentFilterError is returned when the Azure content filter blocks a
+// prompt or truncates a completion.
+type ContentFilterError struct {
+	// Source is whether the prompt or the completion was filtered.
+	Source ContentFilterSource
+	// Results lists the categories that triggered the filter.
+	Results []ContentFilterResult
+	// Message is the service-provided explanation, if any.
+	Message string
+}
+
+func (e *ContentFilterError) Error() string {
+	cats := make([]string, 0, len(e.Results))
+	for _, r := range e.Results {
+		cats = append(cats, r.Category)
+	}
+	msg := fmt.Sprintf("content filter blocked %s", e.Source)
+	if len(cats) > 0 {
+		msg += " (" + strings.Join(cats, ", ") + ")"
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// azureFilterCategory is one entry of Azure's content_filter_results object.
+type azureFilterCategory struct {
+	Filtered bool   `json:"filtered"`
+	Severity string `json:"severity,omitempty"`
+	Detected *bool  `json:"detected,omitempty"`
+}
+
+// azureContentFilterResults maps category name to its annotation.
+type azureContentFilterResults map[string]azureFilterCategory
+
+// azurePromptFilterResult is one entry of Azure's prompt_filter_results array.
+type azurePromptFilterResult struct {
+	PromptIndex          int                       `json:"prompt_index"`
+	ContentFilterResults azureContentFilterResults `json:"content_filter_results"`
+}
+
+// toResults flattens the category map into sorted ContentFilterResults.
+func (r azureContentFilterResults) toResults(source ContentFilterSource) []ContentFilterResult {
+	out := make([]ContentFilterResult, 0, len(r))
+	for cat, v := range r {
+		out = append(out, ContentFilterResult{
+			Source:   source,
+			Category: cat,
+			Filtered: v.Filtered,
+			Severity: v.Severity,
+			Detected: v.Detected != nil && *v.Detected,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Category < out[j].Category })
+	return out
+}
+
+// flagged reports whether an annotation is worth surfacing: anything filtered,
+// detected, or with a severity above "safe".
+func (r ContentFilterResult) flagged() bool {
+	return r.Filtered || r.Detected || (r.Severity != "" && r.Severity != "safe")
+}
+
+// contentFilterCollector accumulates Azure content filter annotations while
+// an SSE stream is parsed.
+type contentFilterCollector struct {
+	results []ContentFilterResult
+}
+
+// collect records flagged annotations from a chunk and forwards them as
+// ChatStreamContentFilter events.
+func (c *contentFilterCollector) collect(chunk openAIChunk, onEvent ChatStreamCallback) {
+	var flagged []ContentFilterResult
+	for _, pf := range chunk.PromptFilterResults {
+		for _, r := range pf.ContentFilterResults.toResults(ContentFilterSourcePrompt) {
+			if r.flagged() {
+				flagged = append(flagged, r)
+			}
+		}
+	}
+	for _, choice := range chunk.Choices {
+		for _, r := range choice.ContentFilterResults.toResults(ContentFilterSourceCompletion) {
+			if r.flagged() {
+				flagged = append(flagged, r)
+			}
+		}
+	}
+	if len(flagged) == 0 {
+		return
+	}
+	c.results = append(c.results, flagged...)
+	if onEvent != nil {
+		onEvent(ChatStreamEvent{Type: ChatStreamContentFilter, ContentFilter: flagged})
+	}
+}
+
+// filtered returns the collected annotations that actually blocked content.
+func (c *contentFilterCollector) filtered() []ContentFilterResult {
+	var out []ContentFilterResult
+	for _, r := range c.results {
+		if r.Filtered {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// parseAzureFilterError converts a 400 content_filter error body into a
+// *ContentFilterError. Returns nil for any other error.
+func parseAzureFilterError(apiErr *APIError) *ContentFilterError {
+	if apiErr.StatusCode != http.StatusBadRequest {
+		return nil
+	}
+	var body struct {
+		Error struct {
+			Code       string `json:"code"`
+			Message    string `json:"message"`
+			InnerError struct {
+				ContentFilterResult azureContentFilterResults `json:"content_filter_result"`
+			} `json:"innererror"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(apiErr.Body), &body); err != nil {
+		return nil
+	}
+	if body.Error.Code != "content_filter" {
+		return nil
+	}
+	var results []ContentFilterResult
+	for _, r := range body.Error.InnerError.ContentFilterResult.toResults(ContentFilterSourcePrompt) {
+		if r.Filtered {
+			results = append(results, r)
+		}
+	}
+	return &ContentFilterError{
+		Source:  ContentFilterSourcePrompt,
+		Results: results,
+		Message: body.Error.Message,
+	}
+}
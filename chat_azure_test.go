@@ -0,0 +1,220 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAzureOpenAIProvider_URLAndAPIKey(t *testing.T) {
+	var gotPath, gotVersion, gotKey, gotAuth, gotModel string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Model string `json:"model"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		gotModel = body.Model
+		gotPath = r.URL.Path
+		gotVersion = r.URL.Query().Get("api-version")
+		gotKey = r.Header.Get("api-key")
+		gotAuth = r.Header.Get("Authorization")
+		mockSSEResponse([]string{sseChunk("", "hi", "stop")})(w, r)
+	}))
+	defer srv.Close()
+
+	p := NewAzureOpenAIProvider(AzureOpenAIConfig{
+		Endpoint:    srv.URL + "/",
+		Deployment:  "default-dep",
+		Deployments: map[string]string{"gpt-4o-mini": "mini-dep"},
+		APIKey:      "secret",
+	})
+	if p.Name() != "azure-openai" {
+		t.Errorf("expected 'azure-openai', got %q", p.Name())
+	}
+
+	resp, err := p.Complete(context.Background(), ChatRequest{
+		Model:    "gpt-4o-mini",
+		Messages: []ChatMessage{{Role: ChatRoleUser, Content: "Hello"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Content != "hi" {
+		t.Errorf("expected 'hi', got %q", resp.Content)
+	}
+	if gotPath != "/openai/deployments/mini-dep/chat/completions" {
+		t.Errorf("unexpected path %q", gotPath)
+	}
+	if gotVersion != defaultAzureAPIVersion {
+		t.Errorf("expected api-version %q, got %q", defaultAzureAPIVersion, gotVersion)
+	}
+	if gotKey != "secret" || gotAuth != "" {
+		t.Errorf("expected api-key auth only, got api-key=%q Authorization=%q", gotKey, gotAuth)
+	}
+
+	// Unmapped model falls back to the default deployment.
+	_, _ = p.Complete(context.Background(), ChatRequest{Model: "other"}, nil)
+	if gotPath != "/openai/deployments/default-dep/chat/completions" {
+		t.Errorf("unexpected fallback path %q", gotPath)
+	}
+
+	// Without a model, the body names the default deployment.
+	_, _ = p.Complete(context.Background(), ChatRequest{}, nil)
+	if gotModel != "default-dep" {
+		t.Errorf("expected model default-dep in the body, got %q", gotModel)
+	}
+}
+
+func TestAzureOpenAIProvider_EntraToken(t *testing.T) {
+	var gotKey, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("api-key")
+		gotAuth = r.Header.Get("Authorization")
+		mockSSEResponse([]string{sseChunk("", "ok", "stop")})(w, r)
+	}))
+	defer srv.Close()
+
+	p := NewAzureOpenAIProvider(AzureOpenAIConfig{
+		Endpoint:   srv.URL,
+		Deployment: "dep",
+		APIKey:     "ignored",
+		TokenProvider: func(ctx context.Context) (string, error) {
+			return "entra-token", nil
+		},
+	})
+	if _, err := p.Complete(context.Background(), ChatRequest{}, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotAuth != "Bearer entra-token" {
+		t.Errorf("expected bearer token, got %q", gotAuth)
+	}
+	if gotKey != "" {
+		t.Errorf("expected no api-key header with token provider, got %q", gotKey)
+	}
+
+	p.cfg.TokenProvider = func(ctx context.Context) (string, error) {
+		return "", errors.New("no credentials")
+	}
+	if _, err := p.Complete(context.Background(), ChatRequest{}, nil); err == nil {
+		t.Fatal("expected token provider error")
+	}
+}
+
+func TestAzureOpenAIProvider_CompletionFiltered(t *testing.T) {
+	chunks := []string{
+		`{"choices":[],"prompt_filter_results":[{"prompt_index":0,"content_filter_results":{"hate":{"filtered":false,"severity":"low"},"violence":{"filtered":false,"severity":"safe"}}}]}`,
+		sseChunk("", "partial", ""),
+		`{"choices":[{"delta":{},"finish_reason":"content_filter","content_filter_results":{"violence":{"filtered":true,"severity":"high"},"hate":{"filtered":false,"severity":"safe"}}}]}`,
+	}
+	srv := httptest.NewServer(mockSSEResponse(chunks))
+	defer srv.Close()
+
+	p := NewAzureOpenAIProvider(AzureOpenAIConfig{Endpoint: srv.URL, Deployment: "dep"})
+
+	var annotations []ContentFilterResult
+	_, err := p.Complete(context.Background(), ChatRequest{}, func(e ChatStreamEvent) {
+		if e.Type == ChatStreamContentFilter {
+			annotations = append(annotations, e.ContentFilter...)
+		}
+	})
+
+	var cfErr *ContentFilterError
+	if !errors.As(err, &cfErr) {
+		t.Fatalf("expected *ContentFilterError, got %v", err)
+	}
+	if cfErr.Source != ContentFilterSourceCompletion {
+		t.Errorf("expected completion source, got %q", cfErr.Source)
+	}
+	if len(cfErr.Results) != 1 || cfErr.Results[0].Category != "violence" {
+		t.Errorf("expected violence result, got %+v", cfErr.Results)
+	}
+	if len(annotations) != 2 {
+		t.Fatalf("expected 2 flagged annotations (prompt hate/low, completion violence), got %+v", annotations)
+	}
+	if annotations[0].Source != ContentFilterSourcePrompt || annotations[0].Category != "hate" {
+		t.Errorf("unexpected first annotation: %+v", annotations[0])
+	}
+}
+
+func TestAzureOpenAIProvider_AgentContentFilterEvent(t *testing.T) {
+	chunks := []string{
+		`{"choices":[],"prompt_filter_results":[{"prompt_index":0,"content_filter_results":{"hate":{"filtered":false,"severity":"low"}}}]}`,
+		sseChunk("assistant", "hello", ""),
+		sseChunk("", "", "stop"),
+	}
+	srv := httptest.NewServer(mockSSEResponse(chunks))
+	defer srv.Close()
+
+	agent := NewAPIAgent(APIAgentConfig{
+		Provider: NewAzureOpenAIProvider(AzureOpenAIConfig{Endpoint: srv.URL, Deployment: "dep"}),
+	})
+	events, err := agent.Run(context.Background(), "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var annotations []ContentFilterResult
+	var runErr error
+	for e := range events {
+		if e.Type == AgentEventContentFilter {
+			annotations = append(annotations, e.ContentFilter...)
+		}
+		if e.Error != nil && runErr == nil {
+			runErr = e.Error
+		}
+	}
+	if runErr != nil {
+		t.Fatalf("unexpected run error: %v", runErr)
+	}
+	if len(annotations) != 1 || annotations[0].Category != "hate" || annotations[0].Source != ContentFilterSourcePrompt {
+		t.Errorf("expected prompt hate annotation event, got %+v", annotations)
+	}
+}
+
+func TestAzureOpenAIProvider_PromptFiltered(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"code":"content_filter","message":"The prompt was filtered.",`+
+			`"innererror":{"code":"ResponsibleAIPolicyViolation","content_filter_result":{`+
+			`"jailbreak":{"filtered":true,"detected":true},"hate":{"filtered":false,"severity":"safe"}}}}}`)
+	}))
+	defer srv.Close()
+
+	p := NewAzureOpenAIProvider(AzureOpenAIConfig{Endpoint: srv.URL, Deployment: "dep"})
+	_, err := p.Complete(context.Background(), ChatRequest{}, nil)
+
+	var cfErr *ContentFilterError
+	if !errors.As(err, &cfErr) {
+		t.Fatalf("expected *ContentFilterError, got %v", err)
+	}
+	if cfErr.Source != ContentFilterSourcePrompt {
+		t.Errorf("expected prompt source, got %q", cfErr.Source)
+	}
+	if len(cfErr.Results) != 1 || cfErr.Results[0].Category != "jailbreak" || !cfErr.Results[0].Detected {
+		t.Errorf("unexpected results: %+v", cfErr.Results)
+	}
+	if !strings.Contains(err.Error(), "jailbreak") {
+		t.Errorf("expected category in error message, got %q", err.Error())
+	}
+}
+
+func TestAzureOpenAIProvider_OtherHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"code":"429","message":"rate limited"}}`)
+	}))
+	defer srv.Close()
+
+	p := NewAzureOpenAIProvider(AzureOpenAIConfig{Endpoint: srv.URL, Deployment: "dep"})
+	_, err := p.Complete(context.Background(), ChatRequest{}, nil)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected *APIError with 429, got %v", err)
+	}
+}
@@ -22,6 +22,37 @@ type OpenAICompatConfig struct {
 	Model string
 	// HTTPTimeout sets the HTTP client timeout. Defaults to 120 seconds.
 	HTTPTimeout time.Duration
+	// Capabilities overrides the built-in model table, for self-hosted or
+	// otherwise unknown models. When nil, known models are looked up by name
+	// and unknown models are assumed to support tools but not vision.
+	Capabilities *ProviderCapabilities
+	// DisableStreaming sends requests with "stream": false and reads a single
+	// JSON response, for servers that do not support streaming. Content,
+	// reasoning and tool call events are still delivered to the stream
+	// callback, each in one piece.
+	DisableStreaming bool
+	// ParallelToolCalls sets parallel_tool_calls on requests with tools.
+	// Nil leaves it to the server. ChatRequest.DisableParallelToolUse
+	// overrides it for a single request.
+	ParallelToolCalls *bool
+	// IncludeUsage sets stream_options.include_usage so the server reports
+	// token usage in a final streaming chunk. OpenAI only reports streaming
+	// usage when asked; some other servers reject the option.
+	IncludeUsage bool
+	// SendTopK forwards ChatRequest.TopK as top_k. It is not part of the
+	// OpenAI API, which rejects it; enable it for servers that accept it
+	// (vLLM, Together, Ollama). When false, TopK is dropped.
+	SendTopK bool
+	// TextToolCalls extracts tool calls that the model writes into its text
+	// (e.g., HermesToolFormat, LlamaToolFormat, MistralToolFormat), for
+	// servers that do not parse them into structured tool_calls. The calls
+	// are removed from Content and reported like structured ones.
+	TextToolCalls TextToolFormat
+	// ToolsInPrompt describes tools in the system prompt using
+	// TextToolCalls instead of sending the tools parameter, and replays tool
+	// calls and results as message text. Use it for servers that reject
+	// tools. Ignored when TextToolCalls is nil.
+	ToolsInPrompt bool
 }
 
 // OpenAICompatProvider implements LLMProvider for any /v1/chat/completions endpoint.
@@ -55,6 +86,32 @@ func (p *OpenAICompatProvider) WithName(name string) *OpenAICompatProvider {
 	return &cp
 }
 
+// Capabilities describes model, falling back to the configured model when
+// empty. Chat completions APIs take a single system string and ignore cache
+// control, so SystemBlocks and PromptCaching are always false unless
+// overridden by OpenAICompatConfig.Capabilities.
+func (p *OpenAICompatProvider) Capabilities(model string) ProviderCapabilities {
+	if p.cfg.Capabilities != nil {
+		return *p.cfg.Capabilities
+	}
+	if model == "" {
+		model = p.cfg.Model
+	}
+	return openAICompatCapabilities(model)
+}
+
+// openAICompatCapabilities looks up model in the OpenAI-compatible model table.
+func openAICompatCapabilities(model string) ProviderCapabilities {
+	caps := ProviderCapabilities{Tools: true, ParallelToolCalls: true}
+	if row, ok := lookupModel(openAIModels, model); ok {
+		caps.Vision = row.vision
+		caps.Thinking = row.thinking
+		caps.ContextWindow = row.contextWindow
+		caps.MaxOutputTokens = row.maxOutputTokens
+	}
+	return caps
+}
+
 // Complete sends the request to the OpenAI-compatible chat completions endpoint.
 func (p *OpenAICompatProvider) Complete(ctx context.Context, req ChatRequest, onEvent ChatStreamCallback) (ChatResponse, error) {
 	body, err := p.buildRequestBody(req)
@@ -62,37 +119,89 @@ func (p *OpenAICompatProvider) Complete(ctx context.Context, req ChatRequest, on
 		return ChatResponse{}, fmt.Errorf("build request: %w", err)
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
-		p.cfg.BaseURL+"/chat/completions", bytes.NewReader(body))
+	resp, err := p.post(ctx, p.cfg.BaseURL+"/chat/completions", body, func(h http.Header) {
+		h.Set("Authorization", "Bearer "+p.cfg.APIKey)
+	})
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if p.cfg.DisableStreaming {
+		return p.parseCompletion(resp.Body, onEvent)
+	}
+	return p.parseSSEStream(resp.Body, onEvent, nil)
+}
+
+// post sends a JSON request body to url and returns the response when the
+// status is 200. Any other status is returned as an *APIError.
+// setAuth sets the provider-specific authentication headers.
+func (p *OpenAICompatProvider) post(ctx context.Context, url string, body []byte, setAuth func(http.Header)) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
-		return ChatResponse{}, fmt.Errorf("create request: %w", err)
+		return nil, fmt.Errorf("create request: %w", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+p.cfg.APIKey)
-	httpReq.Header.Set("Accept", "text/event-stream")
+	if p.cfg.DisableStreaming {
+		httpReq.Header.Set("Accept", "application/json")
+	} else {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+	if setAuth != nil {
+		setAuth(httpReq.Header)
+	}
 
 	resp, err := p.client.Do(httpReq)
 	if err != nil {
-		return ChatResponse{}, fmt.Errorf("http request: %w", err)
+		return nil, fmt.Errorf("http request: %w", err)
 	}
-	defer resp.Body.Close() //nolint:errcheck
 
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close() //nolint:errcheck
 		body, _ := io.ReadAll(resp.Body)
-		return ChatResponse{}, fmt.Errorf("api error %d: %s", resp.StatusCode, string(body))
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
-
-	return p.parseSSEStream(resp.Body, onEvent)
+	return resp, nil
 }
 
 // openAIChatRequest is the JSON body for /v1/chat/completions.
 type openAIChatRequest struct {
-	Model       string          `json:"model"`
-	Messages    []openAIMessage `json:"messages"`
-	Tools       []openAITool    `json:"tools,omitempty"`
-	Stream      bool            `json:"stream"`
-	MaxTokens   int             `json:"max_tokens,omitempty"`
-	Temperature *float64        `json:"temperature,omitempty"`
+	Model             string               `json:"model"`
+	Messages          []openAIMessage      `json:"messages"`
+	Tools             []openAITool         `json:"tools,omitempty"`
+	ToolChoice        any                  `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool                `json:"parallel_tool_calls,omitempty"`
+	Stream            bool                 `json:"stream"`
+	StreamOptions     *openAIStreamOptions `json:"stream_options,omitempty"`
+	MaxTokens         int                  `json:"max_tokens,omitempty"`
+	Temperature       *float64             `json:"temperature,omitempty"`
+	TopP              *float64             `json:"top_p,omitempty"`
+	TopK              *int                 `json:"top_k,omitempty"`
+	Stop              []string             `json:"stop,omitempty"`
+	User              string               `json:"user,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// openAIToolChoice maps a ToolChoice to the OpenAI tool_choice value: a
+// string mode, or an object naming a function. Returns nil for nil.
+func openAIToolChoice(tc *ToolChoice) any {
+	if tc == nil {
+		return nil
+	}
+	switch tc.Type {
+	case ToolChoiceAny:
+		return "required"
+	case ToolChoiceTool:
+		return map[string]any{
+			"type":     "function",
+			"function": map[string]string{"name": tc.Name},
+		}
+	case ToolChoiceAuto, ToolChoiceNone:
+	}
+	return string(tc.Type)
 }
 
 type openAIMessage struct {
@@ -100,6 +209,44 @@ type openAIMessage struct {
 	Content    string           `json:"content,omitempty"`
 	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
 	ToolCallID string           `json:"tool_call_id,omitempty"`
+	// Parts, when set, replaces Content with an array of content parts
+	// (used for image input).
+	Parts []openAIContentPart `json:"-"`
+}
+
+// MarshalJSON emits Parts as the content array when present.
+func (m openAIMessage) MarshalJSON() ([]byte, error) {
+	type plain openAIMessage
+	if len(m.Parts) == 0 {
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		plain
+		Content []openAIContentPart `json:"content"`
+	}{plain: plain(m), Content: m.Parts})
+}
+
+// openAIContentPart is one element of a multi-part user message.
+type openAIContentPart struct {
+	Type     string          `json:"type"` // "text" or "image_url"
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+// convertImagesToOpenAI builds content parts for a user message with images.
+func convertImagesToOpenAI(text string, images []ChatImage) []openAIContentPart {
+	parts := make([]openAIContentPart, 0, len(images)+1)
+	if text != "" {
+		parts = append(parts, openAIContentPart{Type: "text", Text: text})
+	}
+	for _, img := range images {
+		parts = append(parts, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: img.dataURL()}})
+	}
+	return parts
 }
 
 type openAIToolCall struct {
@@ -128,16 +275,9 @@ type openAIFunctionDef struct {
 func (p *OpenAICompatProvider) buildRequestBody(req ChatRequest) ([]byte, error) {
 	messages := p.convertMessages(req)
 
-	tools := make([]openAITool, 0, len(req.Tools))
-	for _, def := range req.Tools {
-		tools = append(tools, openAITool{
-			Type: "function",
-			Function: openAIFunctionDef{
-				Name:        def.Name,
-				Description: def.Description,
-				Parameters:  def.InputSchema,
-			},
-		})
+	var tools []openAITool
+	if !p.toolsInPrompt() {
+		tools = openAITools(req.Tools)
 	}
 
 	model := req.Model
@@ -149,15 +289,41 @@ func (p *OpenAICompatProvider) buildRequestBody(req ChatRequest) ([]byte, error)
 		Model:       model,
 		Messages:    messages,
 		Tools:       tools,
-		Stream:      true,
+		Stream:      !p.cfg.DisableStreaming,
 		MaxTokens:   req.MaxTokens,
 		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stop:        req.StopSequences,
+	}
+	if p.cfg.SendTopK {
+		body.TopK = req.TopK
+	}
+	if body.Stream && p.cfg.IncludeUsage {
+		body.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+	if len(tools) > 0 {
+		body.ToolChoice = openAIToolChoice(req.ToolChoice)
+		body.ParallelToolCalls = p.cfg.ParallelToolCalls
+		if req.DisableParallelToolUse {
+			parallel := false
+			body.ParallelToolCalls = &parallel
+		}
+	}
+	if req.Metadata != nil {
+		body.User = req.Metadata.UserID
 	}
 	return json.Marshal(body)
 }
 
+// toolsInPrompt reports whether tools are described in the prompt text.
+func (p *OpenAICompatProvider) toolsInPrompt() bool {
+	return p.cfg.ToolsInPrompt && p.cfg.TextToolCalls != nil
+}
+
 // convertMessages converts canonical ChatMessages to OpenAI message format.
 // System prompt is prepended as a system message; tool results use "tool" role.
+// With ToolsInPrompt, tools are appended to the system prompt, and tool calls
+// and results become assistant and user text.
 func (p *OpenAICompatProvider) convertMessages(req ChatRequest) []openAIMessage {
 	var out []openAIMessage
 
@@ -173,17 +339,53 @@ func (p *OpenAICompatProvider) convertMessages(req ChatRequest) []openAIMessage
 		}
 		systemText = sb.String()
 	}
+	textTools := p.toolsInPrompt()
+	if textTools && len(req.Tools) > 0 {
+		if systemText != "" {
+			systemText += "\n\n"
+		}
+		systemText += p.cfg.TextToolCalls.FormatTools(req.Tools)
+	}
 	if systemText != "" {
 		out = append(out, openAIMessage{Role: "system", Content: systemText})
 	}
 
+	calls := map[string]ToolCall{}
 	for _, m := range req.Messages {
+		if textTools {
+			switch {
+			case m.Role == ChatRoleAssistant && len(m.ToolCalls) > 0:
+				for _, tc := range m.ToolCalls {
+					calls[tc.ID] = tc
+				}
+				content := p.cfg.TextToolCalls.FormatCalls(m.ToolCalls)
+				if m.Content != "" {
+					content = m.Content + "\n" + content
+				}
+				out = append(out, openAIMessage{Role: "assistant", Content: content})
+				continue
+			case m.Role == ChatRoleTool:
+				result := p.cfg.TextToolCalls.FormatResult(calls[m.ToolCallID], m.Content, m.IsError)
+				// Consecutive results share one user message.
+				if last := len(out) - 1; last >= 0 && out[last].Role == "user" && out[last].Parts == nil {
+					out[last].Content += "\n" + result
+				} else {
+					out = append(out, openAIMessage{Role: "user", Content: result})
+				}
+				continue
+			}
+		}
+
 		switch m.Role {
 		case ChatRoleSystem:
 			// Already handled above; skip system messages in the history.
 			continue
 		case ChatRoleUser:
-			out = append(out, openAIMessage{Role: "user", Content: m.Content})
+			msg := openAIMessage{Role: "user", Content: m.Content}
+			if len(m.Images) > 0 {
+				msg.Parts = convertImagesToOpenAI(m.Content, m.Images)
+			}
+			out = append(out, msg)
 		case ChatRoleAssistant:
 			msg := openAIMessage{Role: "assistant", Content: m.Content}
 			for _, tc := range m.ToolCalls {
@@ -210,19 +412,37 @@ func (p *OpenAICompatProvider) convertMessages(req ChatRequest) []openAIMessage
 
 // openAIChunk is a single SSE delta from the streaming response.
 type openAIChunk struct {
+	Model   string         `json:"model,omitempty"`
 	Choices []openAIChoice `json:"choices"`
 	Usage   *openAIUsage   `json:"usage,omitempty"`
+	// PromptFilterResults is Azure-specific: content filter annotations for the prompt.
+	PromptFilterResults []azurePromptFilterResult `json:"prompt_filter_results,omitempty"`
 }
 
 type openAIChoice struct {
 	Delta        openAIDelta `json:"delta"`
 	FinishReason string      `json:"finish_reason"`
+	// ContentFilterResults is Azure-specific: content filter annotations for the completion.
+	ContentFilterResults azureContentFilterResults `json:"content_filter_results,omitempty"`
 }
 
 type openAIDelta struct {
-	Role      string                `json:"role,omitempty"`
-	Content   string                `json:"content,omitempty"`
-	ToolCalls []openAIToolCallDelta `json:"tool_calls,omitempty"`
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+	// ReasoningContent is the reasoning trace streamed by DeepSeek, Qwen and
+	// other reasoning models; Reasoning is the same under the name some
+	// servers (e.g., vLLM, OpenRouter) use.
+	ReasoningContent string                `json:"reasoning_content,omitempty"`
+	Reasoning        string                `json:"reasoning,omitempty"`
+	ToolCalls        []openAIToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+// reasoning returns the delta's reasoning text under either field name.
+func (d openAIDelta) reasoning() string {
+	if d.ReasoningContent != "" {
+		return d.ReasoningContent
+	}
+	return d.Reasoning
 }
 
 // openAIToolCallDelta includes the streaming index for parallel tool calls.
@@ -234,20 +454,53 @@ type openAIToolCallDelta struct {
 }
 
 type openAIUsage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details,omitempty"`
+}
+
+// chatUsage converts the usage block. OpenAI counts cached tokens inside
+// prompt_tokens, so they are moved to CacheReadInputTokens to match how
+// Anthropic reports them and keep cost estimates from billing them twice.
+func (u *openAIUsage) chatUsage() ChatUsage {
+	out := ChatUsage{InputTokens: u.PromptTokens, OutputTokens: u.CompletionTokens}
+	if d := u.PromptTokensDetails; d != nil && d.CachedTokens > 0 {
+		out.CacheReadInputTokens = d.CachedTokens
+		out.InputTokens -= d.CachedTokens
+	}
+	return out
 }
 
 // parseSSEStream reads the streaming response and accumulates into a ChatResponse.
-func (p *OpenAICompatProvider) parseSSEStream(body io.Reader, onEvent ChatStreamCallback) (ChatResponse, error) {
+// filters, if non-nil, collects Azure content filter annotations from each chunk.
+func (p *OpenAICompatProvider) parseSSEStream(body io.Reader, onEvent ChatStreamCallback, filters *contentFilterCollector) (ChatResponse, error) {
 	scanner := bufio.NewScanner(body)
 
-	var content strings.Builder
+	var content, reasoning strings.Builder
+	var textCalls []ToolCall
+	var textParser TextToolCallParser
+	if p.cfg.TextToolCalls != nil {
+		textParser = p.cfg.TextToolCalls.NewParser()
+	}
+	// emitText records visible content, after removing text tool calls.
+	emitText := func(text string, calls []ToolCall) {
+		if text != "" {
+			content.WriteString(text)
+			if onEvent != nil {
+				onEvent(ChatStreamEvent{Type: ChatStreamContentDelta, Content: text})
+			}
+		}
+		textCalls = append(textCalls, calls...)
+		emitWholeToolCalls(calls, onEvent)
+	}
 	// toolCalls accumulates by index (parallel tool calls have an index field).
 	toolCallsByIdx := map[int]*ToolCall{}
 	toolCallArgs := map[int]*strings.Builder{}
 	var finishReason string
 	var usage ChatUsage
+	var model string
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -264,9 +517,15 @@ func (p *OpenAICompatProvider) parseSSEStream(body io.Reader, onEvent ChatStream
 			continue // skip malformed chunks
 		}
 
+		if filters != nil {
+			filters.collect(chunk, onEvent)
+		}
+		if model == "" {
+			model = chunk.Model
+		}
+
 		if chunk.Usage != nil {
-			usage.InputTokens = chunk.Usage.PromptTokens
-			usage.OutputTokens = chunk.Usage.CompletionTokens
+			usage = chunk.Usage.chatUsage()
 		}
 
 		if len(chunk.Choices) == 0 {
@@ -277,11 +536,20 @@ func (p *OpenAICompatProvider) parseSSEStream(body io.Reader, onEvent ChatStream
 			finishReason = choice.FinishReason
 		}
 
+		// Reasoning delta, streamed before the answer by reasoning models.
+		if r := choice.Delta.reasoning(); r != "" {
+			reasoning.WriteString(r)
+			if onEvent != nil {
+				onEvent(ChatStreamEvent{Type: ChatStreamReasoningDelta, Content: r})
+			}
+		}
+
 		// Text delta.
 		if choice.Delta.Content != "" {
-			content.WriteString(choice.Delta.Content)
-			if onEvent != nil {
-				onEvent(ChatStreamEvent{Type: ChatStreamContentDelta, Content: choice.Delta.Content})
+			if textParser != nil {
+				emitText(textParser.Feed(choice.Delta.Content))
+			} else {
+				emitText(choice.Delta.Content, nil)
 			}
 		}
 
@@ -316,12 +584,15 @@ func (p *OpenAICompatProvider) parseSSEStream(body io.Reader, onEvent ChatStream
 	if err := scanner.Err(); err != nil {
 		return ChatResponse{}, fmt.Errorf("stream read: %w", err)
 	}
+	if textParser != nil {
+		emitText(textParser.Close())
+	}
 
 	// Finalize tool calls in index order to preserve the model's declared order.
 	toolCalls := make([]ToolCall, 0, len(toolCallsByIdx))
 	for i := 0; i < len(toolCallsByIdx); i++ {
 		tc := toolCallsByIdx[i]
-		tc.Input = json.RawMessage(toolCallArgs[i].String())
+		tc.Input = toolArguments(toolCallArgs[i].String())
 		completed := *tc // copy before emitting to avoid shared pointer
 		toolCalls = append(toolCalls, completed)
 		if onEvent != nil {
@@ -329,14 +600,102 @@ func (p *OpenAICompatProvider) parseSSEStream(body io.Reader, onEvent ChatStream
 		}
 	}
 
+	toolCalls = append(toolCalls, textCalls...)
+	stopReason := mapFinishReason(finishReason)
+	if len(textCalls) > 0 && stopReason == "end_turn" {
+		stopReason = "tool_use"
+	}
+
 	return ChatResponse{
 		Content:    content.String(),
+		Reasoning:  reasoning.String(),
 		ToolCalls:  toolCalls,
-		StopReason: mapFinishReason(finishReason),
+		StopReason: stopReason,
 		Usage:      usage,
+		Model:      model,
 	}, nil
 }
 
+// toolArguments returns a tool call's arguments as input. Some servers
+// send "" for calls without arguments, which is not valid JSON.
+func toolArguments(args string) json.RawMessage {
+	if strings.TrimSpace(args) == "" {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(args)
+}
+
+// emitWholeToolCalls sends start, delta and end events for tool calls that
+// arrived complete rather than streamed.
+func emitWholeToolCalls(calls []ToolCall, onEvent ChatStreamCallback) {
+	if onEvent == nil {
+		return
+	}
+	for _, call := range calls {
+		onEvent(ChatStreamEvent{Type: ChatStreamToolUseStart, ToolCall: &ToolCall{ID: call.ID, Name: call.Name}})
+		onEvent(ChatStreamEvent{Type: ChatStreamToolUseDelta, Content: string(call.Input)})
+		completed := call
+		onEvent(ChatStreamEvent{Type: ChatStreamToolUseEnd, ToolCall: &completed})
+	}
+}
+
+// openAICompletion is a non-streaming chat completions response.
+type openAICompletion struct {
+	Model   string `json:"model,omitempty"`
+	Choices []struct {
+		Message      openAIDelta `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage,omitempty"`
+}
+
+// parseCompletion reads a non-streaming response. onEvent receives the same
+// events as a stream would, with each reasoning trace, text and tool call
+// delivered whole.
+func (p *OpenAICompatProvider) parseCompletion(body io.Reader, onEvent ChatStreamCallback) (ChatResponse, error) {
+	var completion openAICompletion
+	if err := json.NewDecoder(body).Decode(&completion); err != nil {
+		return ChatResponse{}, fmt.Errorf("decode response: %w", err)
+	}
+
+	out := ChatResponse{Model: completion.Model, StopReason: mapFinishReason("")}
+	if completion.Usage != nil {
+		out.Usage = completion.Usage.chatUsage()
+	}
+	if len(completion.Choices) == 0 {
+		return out, nil
+	}
+	choice := completion.Choices[0]
+	out.StopReason = mapFinishReason(choice.FinishReason)
+	out.Reasoning = choice.Message.reasoning()
+	out.Content = choice.Message.Content
+	for _, tc := range choice.Message.ToolCalls {
+		out.ToolCalls = append(out.ToolCalls, ToolCall{ID: tc.ID, Name: tc.Function.Name, Input: toolArguments(tc.Function.Arguments)})
+	}
+
+	var textCalls []ToolCall
+	if p.cfg.TextToolCalls != nil && out.Content != "" {
+		parser := p.cfg.TextToolCalls.NewParser()
+		text, calls := parser.Feed(out.Content)
+		rest, more := parser.Close()
+		out.Content = text + rest
+		textCalls = append(calls, more...)
+		out.ToolCalls = append(out.ToolCalls, textCalls...)
+		if len(textCalls) > 0 && out.StopReason == "end_turn" {
+			out.StopReason = "tool_use"
+		}
+	}
+
+	if onEvent != nil && out.Reasoning != "" {
+		onEvent(ChatStreamEvent{Type: ChatStreamReasoningDelta, Content: out.Reasoning})
+	}
+	if onEvent != nil && out.Content != "" {
+		onEvent(ChatStreamEvent{Type: ChatStreamContentDelta, Content: out.Content})
+	}
+	emitWholeToolCalls(out.ToolCalls, onEvent)
+	return out, nil
+}
+
 // mapFinishReason normalizes OpenAI finish_reason to our StopReason conventions.
 func mapFinishReason(reason string) string {
 	switch reason {
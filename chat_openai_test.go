@@ -329,3 +329,184 @@ func TestMapFinishReason(t *testing.T) {
 		}
 	}
 }
+
+func TestOpenAICompatProvider_SamplingAndToolChoice(t *testing.T) {
+	p := NewOpenAICompatProvider(OpenAICompatConfig{Model: "qwen3", SendTopK: true})
+	topP, topK := 0.5, 20
+	tools := []ToolDefinition{{Name: "search", InputSchema: ObjectSchema(map[string]any{})}}
+
+	cases := []struct {
+		choice *ToolChoice
+		want   string
+	}{
+		{&ToolChoice{Type: ToolChoiceAny}, `"required"`},
+		{&ToolChoice{Type: ToolChoiceNone}, `"none"`},
+		{ToolChoiceFor("search"), `{"function":{"name":"search"},"type":"function"}`},
+	}
+	for _, c := range cases {
+		body, err := p.buildRequestBody(ChatRequest{
+			Messages:               []ChatMessage{{Role: ChatRoleUser, Content: "hi"}},
+			Tools:                  tools,
+			TopP:                   &topP,
+			TopK:                   &topK,
+			StopSequences:          []string{"\n\n"},
+			ToolChoice:             c.choice,
+			DisableParallelToolUse: true,
+			Metadata:               &RequestMetadata{UserID: "u-1"},
+		})
+		if err != nil {
+			t.Fatalf("buildRequestBody: %v", err)
+		}
+		var got map[string]json.RawMessage
+		_ = json.Unmarshal(body, &got)
+		if string(got["tool_choice"]) != c.want {
+			t.Errorf("tool_choice = %s, want %s", got["tool_choice"], c.want)
+		}
+		if string(got["parallel_tool_calls"]) != "false" || string(got["top_p"]) != "0.5" ||
+			string(got["top_k"]) != "20" || string(got["stop"]) != `["\n\n"]` || string(got["user"]) != `"u-1"` {
+			t.Errorf("controls not mapped: %s", body)
+		}
+	}
+
+	// Without tools, tool controls are omitted.
+	body, _ := p.buildRequestBody(ChatRequest{
+		Messages:               []ChatMessage{{Role: ChatRoleUser, Content: "hi"}},
+		ToolChoice:             &ToolChoice{Type: ToolChoiceAny},
+		DisableParallelToolUse: true,
+	})
+	if strings.Contains(string(body), "tool_choice") || strings.Contains(string(body), "parallel_tool_calls") {
+		t.Errorf("tool controls sent without tools: %s", body)
+	}
+
+	// OpenAI rejects top_k, so it is only sent when enabled.
+	openai := NewOpenAICompatProvider(OpenAICompatConfig{Model: "gpt-4o"})
+	body, _ = openai.buildRequestBody(ChatRequest{
+		Messages: []ChatMessage{{Role: ChatRoleUser, Content: "hi"}},
+		TopK:     &topK,
+	})
+	if strings.Contains(string(body), "top_k") {
+		t.Errorf("top_k sent without SendTopK: %s", body)
+	}
+}
+
+func TestOpenAICompatProvider_ReasoningDeltas(t *testing.T) {
+	chunks := []string{
+		`{"model":"deepseek-reasoner","choices":[{"delta":{"role":"assistant","reasoning_content":"Two plus "}}]}`,
+		`{"choices":[{"delta":{"reasoning_content":"two is four."}}]}`,
+		`{"choices":[{"delta":{"content":"4"}}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"stop"}]}`,
+		`{"choices":[],"usage":{"prompt_tokens":9,"completion_tokens":12,"prompt_tokens_details":{"cached_tokens":4}}}`,
+	}
+	var reqBody map[string]json.RawMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&reqBody)
+		mockSSEResponse(chunks)(w, r)
+	}))
+	defer srv.Close()
+
+	p := NewOpenAICompatProvider(OpenAICompatConfig{BaseURL: srv.URL, Model: "deepseek-reasoner", IncludeUsage: true})
+	var reasoning []string
+	resp, err := p.Complete(context.Background(), ChatRequest{
+		Messages: []ChatMessage{{Role: ChatRoleUser, Content: "2+2?"}},
+	}, func(e ChatStreamEvent) {
+		if e.Type == ChatStreamReasoningDelta {
+			reasoning = append(reasoning, e.Content)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if resp.Content != "4" || resp.Reasoning != "Two plus two is four." {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if len(reasoning) != 2 {
+		t.Errorf("expected 2 reasoning deltas, got %v", reasoning)
+	}
+	if resp.Usage != (ChatUsage{InputTokens: 5, OutputTokens: 12, CacheReadInputTokens: 4}) {
+		t.Errorf("usage not read: %+v", resp.Usage)
+	}
+	if string(reqBody["stream_options"]) != `{"include_usage":true}` {
+		t.Errorf("stream_options = %s", reqBody["stream_options"])
+	}
+}
+
+func TestOpenAICompatProvider_NonStreaming(t *testing.T) {
+	var reqBody map[string]json.RawMessage
+	var accept string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept")
+		_ = json.NewDecoder(r.Body).Decode(&reqBody)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"model":"qwen3","choices":[{"message":{"role":"assistant","content":"Looking it up.",`+
+			`"reasoning":"Need the weather tool.","tool_calls":[{"id":"call_1","type":"function",`+
+			`"function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},"finish_reason":"tool_calls"}],`+
+			`"usage":{"prompt_tokens":20,"completion_tokens":8,"prompt_tokens_details":{"cached_tokens":0}}}`)
+	}))
+	defer srv.Close()
+
+	parallel := true
+	p := NewOpenAICompatProvider(OpenAICompatConfig{
+		BaseURL:           srv.URL,
+		Model:             "qwen3",
+		DisableStreaming:  true,
+		IncludeUsage:      true,
+		ParallelToolCalls: &parallel,
+	})
+	var types []ChatStreamEventType
+	resp, err := p.Complete(context.Background(), ChatRequest{
+		Messages: []ChatMessage{{Role: ChatRoleUser, Content: "weather in Paris?"}},
+		Tools:    []ToolDefinition{{Name: "get_weather", InputSchema: ObjectSchema(map[string]any{})}},
+	}, func(e ChatStreamEvent) { types = append(types, e.Type) })
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	if accept != "application/json" || string(reqBody["stream"]) != "false" {
+		t.Errorf("expected a non-streaming request, got Accept %q stream %s", accept, reqBody["stream"])
+	}
+	if _, ok := reqBody["stream_options"]; ok {
+		t.Error("stream_options sent without streaming")
+	}
+	if string(reqBody["parallel_tool_calls"]) != "true" {
+		t.Errorf("parallel_tool_calls = %s", reqBody["parallel_tool_calls"])
+	}
+
+	if resp.Content != "Looking it up." || resp.Reasoning != "Need the weather tool." || resp.StopReason != "tool_use" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if len(resp.ToolCalls) != 1 || string(resp.ToolCalls[0].Input) != `{"city":"Paris"}` || resp.Usage.InputTokens != 20 {
+		t.Errorf("unexpected tool calls or usage: %+v", resp)
+	}
+	want := []ChatStreamEventType{ChatStreamReasoningDelta, ChatStreamContentDelta, ChatStreamToolUseStart, ChatStreamToolUseDelta, ChatStreamToolUseEnd}
+	if fmt.Sprint(types) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", types, want)
+	}
+}
+
+func TestOpenAICompatProvider_EmptyToolArguments(t *testing.T) {
+	call := `{"id":"call_1","type":"function","function":{"name":"get_time","arguments":""}}`
+	for _, streaming := range []bool{true, false} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if streaming {
+				mockSSEResponse([]string{
+					`{"choices":[{"delta":{"tool_calls":[{"index":0,` + call[1:] + `]}}]}`,
+					`{"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+				})(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","tool_calls":[`+call+`]},"finish_reason":"tool_calls"}]}`)
+		}))
+		p := NewOpenAICompatProvider(OpenAICompatConfig{BaseURL: srv.URL, Model: "gpt-4o", DisableStreaming: !streaming})
+		resp, err := p.Complete(context.Background(), ChatRequest{
+			Messages: []ChatMessage{{Role: ChatRoleUser, Content: "what time is it?"}},
+		}, nil)
+		srv.Close()
+		if err != nil {
+			t.Fatalf("streaming %v: %v", streaming, err)
+		}
+		if len(resp.ToolCalls) != 1 || string(resp.ToolCalls[0].Input) != "{}" {
+			t.Errorf("streaming %v: empty arguments should become {}, got %+v", streaming, resp.ToolCalls)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package claudeagent
 
+import "encoding/base64"
+
 // ChatRole represents a message role in the chat completions format.
 type ChatRole string
 
@@ -17,60 +19,147 @@ const (
 // Both types are intentionally separate — the CLI agent uses string Role; ChatMessage uses typed ChatRole.
 type ChatMessage struct {
 	// Role of the message sender.
-	Role ChatRole
+	Role ChatRole `json:"role"`
 	// Content is the text content of the message.
-	Content string
+	Content string `json:"content,omitempty"`
 	// ToolCalls are tool invocations requested by the assistant.
 	// Only set when Role is ChatRoleAssistant.
-	ToolCalls []ToolCall
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
 	// ToolCallID links a tool result back to the tool call that produced it.
 	// Only set when Role is ChatRoleTool.
-	ToolCallID string
+	ToolCallID string `json:"tool_call_id,omitempty"`
 	// IsError indicates the tool result is an error.
 	// Only set when Role is ChatRoleTool.
-	IsError bool
+	IsError bool `json:"is_error,omitempty"`
+	// Images are attached to the message. Only set when Role is ChatRoleUser,
+	// and only accepted by providers with vision support.
+	Images []ChatImage `json:"images,omitempty"`
+}
+
+// ChatImage is an image input, given either inline or by URL.
+type ChatImage struct {
+	// MediaType is the MIME type of Data (e.g., "image/png", "image/jpeg").
+	MediaType string `json:"media_type,omitempty"`
+	// Data is the raw image bytes. Takes precedence over URL.
+	Data []byte `json:"data,omitempty"`
+	// URL is a publicly reachable image URL.
+	URL string `json:"url,omitempty"`
+}
+
+// dataURL returns the image as a data: URL, or URL when no inline data is set.
+func (img ChatImage) dataURL() string {
+	if len(img.Data) == 0 {
+		return img.URL
+	}
+	return "data:" + img.MediaType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
 }
 
 // ChatRequest is a provider-agnostic request to an LLM.
 type ChatRequest struct {
 	// Model identifier (e.g., "claude-sonnet-4-20250514", "gpt-4o", "mistral-large-latest").
-	Model string
+	Model string `json:"model,omitempty"`
 	// Messages is the conversation history.
-	Messages []ChatMessage
+	Messages []ChatMessage `json:"messages"`
 	// Tools available for the model to call.
-	Tools []ToolDefinition
+	Tools []ToolDefinition `json:"tools,omitempty"`
 	// SystemPrompt is a plain text system prompt.
-	SystemPrompt string
+	SystemPrompt string `json:"system_prompt,omitempty"`
 	// SystemBlocks provides structured system prompt blocks with cache control.
 	// Anthropic-specific; other providers concatenate block text into a single system message.
-	SystemBlocks []SystemPromptBlock
+	SystemBlocks []SystemPromptBlock `json:"system_blocks,omitempty"`
 	// MaxTokens is the maximum number of tokens to generate.
-	MaxTokens int
+	MaxTokens int `json:"max_tokens,omitempty"`
 	// Temperature controls randomness. Nil uses the provider's default.
-	Temperature *float64
+	Temperature *float64 `json:"temperature,omitempty"`
+	// TopP enables nucleus sampling. Nil uses the provider's default.
+	TopP *float64 `json:"top_p,omitempty"`
+	// TopK samples only from the K most likely tokens. Nil uses the
+	// provider's default. Not part of the OpenAI API; OpenAI-compatible
+	// providers send it as top_k only when OpenAICompatConfig.SendTopK is set.
+	TopK *int `json:"top_k,omitempty"`
+	// StopSequences end generation when any of them is produced.
+	StopSequences []string `json:"stop_sequences,omitempty"`
+	// ToolChoice controls whether and which tools the model must call.
+	// Nil leaves the choice to the model. Ignored when Tools is empty.
+	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
+	// DisableParallelToolUse limits the model to at most one tool call per
+	// response. Ignored when Tools is empty.
+	DisableParallelToolUse bool `json:"disable_parallel_tool_use,omitempty"`
+	// Metadata is forwarded to the provider for abuse monitoring.
+	Metadata *RequestMetadata `json:"metadata,omitempty"`
+	// PromptCache enables automatic prompt-cache breakpoints on providers
+	// with CapabilityPromptCaching. Nil places none.
+	PromptCache *PromptCacheConfig `json:"prompt_cache,omitempty"`
+}
+
+// ToolChoiceType selects how the model may use tools.
+type ToolChoiceType string
+
+const (
+	// ToolChoiceAuto lets the model decide whether to call tools (the default).
+	ToolChoiceAuto ToolChoiceType = "auto"
+	// ToolChoiceAny requires the model to call at least one tool.
+	// Sent to OpenAI-compatible providers as "required".
+	ToolChoiceAny ToolChoiceType = "any"
+	// ToolChoiceNone prevents the model from calling tools.
+	ToolChoiceNone ToolChoiceType = "none"
+	// ToolChoiceTool requires the model to call the tool named in ToolChoice.Name.
+	ToolChoiceTool ToolChoiceType = "tool"
+)
+
+// ToolChoice controls tool use for a request.
+type ToolChoice struct {
+	Type ToolChoiceType `json:"type"`
+	// Name is the tool to call. Only set when Type is ToolChoiceTool.
+	Name string `json:"name,omitempty"`
+}
+
+// ToolChoiceFor returns a ToolChoice that forces a call to the named tool.
+func ToolChoiceFor(name string) *ToolChoice {
+	return &ToolChoice{Type: ToolChoiceTool, Name: name}
+}
+
+// RequestMetadata describes the request to the provider.
+type RequestMetadata struct {
+	// UserID is an opaque identifier for the end user, such as a hash of
+	// their account ID. Do not send names, emails or other personal data.
+	// Sent to Anthropic as metadata.user_id and to OpenAI-compatible
+	// providers as user.
+	UserID string `json:"user_id,omitempty"`
 }
 
 // ChatResponse is a provider-agnostic response from an LLM.
 type ChatResponse struct {
 	// Content is the text content of the assistant's response.
-	Content string
+	Content string `json:"content,omitempty"`
+	// Reasoning is the model's reasoning trace, for OpenAI-compatible
+	// reasoning models that return reasoning_content (e.g., DeepSeek, Qwen).
+	// It is not sent back to the model on later turns.
+	Reasoning string `json:"reasoning,omitempty"`
 	// ToolCalls are tool invocations requested by the assistant.
-	ToolCalls []ToolCall
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
 	// StopReason indicates why the model stopped generating.
 	// Normalized to: "end_turn", "tool_use", "max_tokens".
-	StopReason string
+	StopReason string `json:"stop_reason,omitempty"`
 	// Usage contains token consumption metrics.
-	Usage ChatUsage
+	Usage ChatUsage `json:"usage"`
+	// Model is the model that served the request, as reported by the
+	// provider. Empty if the provider does not report it.
+	Model string `json:"model,omitempty"`
 }
 
 // ChatUsage contains token usage information from an LLM response.
 type ChatUsage struct {
-	InputTokens  int
-	OutputTokens int
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
 	// CacheCreationInputTokens is Anthropic-specific; 0 for other providers.
-	CacheCreationInputTokens int
-	// CacheReadInputTokens is Anthropic-specific; 0 for other providers.
-	CacheReadInputTokens int
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	// CacheCreation1hInputTokens is the part of CacheCreationInputTokens
+	// written with CacheTTL1h, which is billed at a higher rate.
+	CacheCreation1hInputTokens int `json:"cache_creation_1h_input_tokens,omitempty"`
+	// CacheReadInputTokens is reported by Anthropic and by OpenAI-compatible
+	// servers that return prompt_tokens_details.cached_tokens.
+	CacheReadInputTokens int `json:"cache_read_input_tokens,omitempty"`
 }
 
 // ChatStreamEventType identifies the kind of streaming event from a provider.
@@ -79,24 +168,30 @@ type ChatStreamEventType string
 const (
 	// ChatStreamContentDelta is a text chunk from the assistant.
 	ChatStreamContentDelta ChatStreamEventType = "content_delta"
+	// ChatStreamReasoningDelta is a chunk of the model's reasoning trace.
+	ChatStreamReasoningDelta ChatStreamEventType = "reasoning_delta"
 	// ChatStreamToolUseStart indicates the model is starting a tool call.
 	ChatStreamToolUseStart ChatStreamEventType = "tool_use_start"
 	// ChatStreamToolUseDelta is a partial JSON chunk of tool input.
 	ChatStreamToolUseDelta ChatStreamEventType = "tool_use_delta"
 	// ChatStreamToolUseEnd indicates the tool call input is complete.
 	ChatStreamToolUseEnd ChatStreamEventType = "tool_use_end"
+	// ChatStreamContentFilter carries content filter annotations (Azure OpenAI).
+	ChatStreamContentFilter ChatStreamEventType = "content_filter"
 )
 
 // ChatStreamEvent carries a streaming delta from a provider.
 type ChatStreamEvent struct {
 	// Type identifies the event kind.
-	Type ChatStreamEventType
-	// Content carries text for ContentDelta and ToolUseDelta events.
-	Content string
+	Type ChatStreamEventType `json:"type"`
+	// Content carries text for ContentDelta, ReasoningDelta and ToolUseDelta events.
+	Content string `json:"content,omitempty"`
 	// ToolCall carries tool information for ToolUseStart and ToolUseEnd events.
 	// On ToolUseStart: ID and Name are set; Input is nil (not yet accumulated).
 	// On ToolUseEnd: ID, Name, and fully-accumulated Input are all set.
-	ToolCall *ToolCall
+	ToolCall *ToolCall `json:"tool_call,omitempty"`
+	// ContentFilter carries flagged annotations for ContentFilter events.
+	ContentFilter []ContentFilterResult `json:"content_filter,omitempty"`
 }
 
 // ChatStreamCallback receives streaming events during LLM completion.
@@ -0,0 +1,138 @@
+package claudeagenttest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	claude "github.com/character-ai/claude-agent-sdk-go"
+)
+
+// recordingTB captures assertion failures instead of failing the real test.
+type recordingTB struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingTB) Fatalf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func searchAgent(provider claude.LLMProvider) *claude.APIAgent {
+	tools := claude.NewToolRegistry()
+	tools.Register(claude.ToolDefinition{
+		Name:        "search",
+		Description: "search the web",
+		InputSchema: claude.ObjectSchema(map[string]any{"q": claude.StringParam("query")}, "q"),
+	}, func(_ context.Context, input json.RawMessage) (string, error) {
+		return "results for " + string(input), nil
+	})
+	return claude.NewAPIAgent(claude.APIAgentConfig{Provider: provider, Tools: tools})
+}
+
+func TestScriptedAgentTrajectory(t *testing.T) {
+	provider := NewScriptedProvider(
+		ToolCallTurn(ToolCall("search", map[string]any{"q": "go"})),
+		StreamedTextTurn("Go is a ", "programming language."),
+	)
+	tr := RunAgent(t, searchAgent(provider), "what is go?")
+
+	tr.AssertCompleted(t)
+	call := tr.AssertToolCalled(t, "search", InputHas("q", "go"))
+	tr.AssertToolCalledBeforeFinalAnswer(t, "search", InputEquals(map[string]any{"q": "go"}))
+	tr.AssertToolNotCalled(t, "delete")
+	tr.AssertFinalText(t, "programming language")
+
+	if call.ID != "toolu_0_0" {
+		t.Errorf("expected generated tool ID, got %q", call.ID)
+	}
+	res, ok := tr.ToolResult(call.ID)
+	if !ok || res.IsError || res.Content != `results for {"q":"go"}` {
+		t.Errorf("unexpected tool result %+v (found=%v)", res, ok)
+	}
+	if provider.Remaining() != 0 {
+		t.Errorf("expected script consumed, %d turns left", provider.Remaining())
+	}
+
+	reqs := provider.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(reqs))
+	}
+	last := reqs[1].Messages[len(reqs[1].Messages)-1]
+	if last.Role != claude.ChatRoleTool || last.ToolCallID != call.ID {
+		t.Errorf("expected tool result in second request, got %+v", last)
+	}
+}
+
+func TestAssertionsReportFailures(t *testing.T) {
+	provider := NewScriptedProvider(
+		ToolCallTurn(ToolCall("search", map[string]any{"q": "rust"})),
+		TextTurn("Rust is a language."),
+	)
+	tr := RunAgent(t, searchAgent(provider), "what is rust?")
+
+	fake := &recordingTB{TB: t}
+	tr.AssertToolCalled(fake, "search", InputHas("q", "go"))
+	tr.AssertToolNotCalled(fake, "search")
+	tr.AssertToolOrder(fake, "search", "search")
+	tr.AssertFinalText(fake, "Go")
+	tr.AssertError(fake, ErrScriptExhausted)
+
+	if len(fake.failures) != 5 {
+		t.Errorf("expected 5 failures, got %d: %q", len(fake.failures), fake.failures)
+	}
+}
+
+func TestScriptedProviderErrors(t *testing.T) {
+	boom := errors.New("boom")
+	provider := NewScriptedProvider(
+		Turn{Chunks: []string{"partial"}, Err: boom},
+	)
+	tr := RunAgent(t, searchAgent(provider), "hi")
+	tr.AssertError(t, boom)
+	if tr.Text() != "partial" {
+		t.Errorf("expected partial text streamed before error, got %q", tr.Text())
+	}
+
+	tr = RunAgent(t, searchAgent(provider), "again")
+	tr.AssertError(t, ErrScriptExhausted)
+}
+
+func TestScriptedProviderExpect(t *testing.T) {
+	provider := NewScriptedProvider(Turn{
+		Chunks: []string{"ok"},
+		Expect: func(req claude.ChatRequest) error {
+			if len(req.Tools) != 1 || req.Tools[0].Name != "search" {
+				return errors.New("search tool not offered")
+			}
+			return nil
+		},
+	})
+	tr := RunAgent(t, searchAgent(provider), "hi")
+	tr.AssertCompleted(t)
+
+	provider.Add(Turn{Expect: func(claude.ChatRequest) error { return errors.New("nope") }})
+	tr = RunAgent(t, searchAgent(provider), "hi")
+	if tr.Err() == nil {
+		t.Error("expected Expect failure to surface as a run error")
+	}
+}
+
+func TestTruncatedTurnStopReason(t *testing.T) {
+	provider := NewScriptedProvider(TruncatedTurn("cut"))
+	resp, err := provider.Complete(context.Background(), claude.ChatRequest{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StopReason != "max_tokens" || resp.Content != "cut" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
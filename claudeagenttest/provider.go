@@ -0,0 +1,192 @@
+// Package claudeagenttest provides a scripted LLMProvider and trajectory
+// assertions for testing agents, tools and hooks without network access.
+//
+//	provider := claudeagenttest.NewScriptedProvider(
+//	    claudeagenttest.ToolCallTurn(claudeagenttest.ToolCall("search", map[string]any{"q": "go"})),
+//	    claudeagenttest.TextTurn("Go is a programming language."),
+//	)
+//	agent := claude.NewAPIAgent(claude.APIAgentConfig{Provider: provider, Tools: tools})
+//
+//	tr := claudeagenttest.RunAgent(t, agent, "what is go?")
+//	tr.AssertToolCalledBeforeFinalAnswer(t, "search", claudeagenttest.InputHas("q", "go"))
+//	tr.AssertFinalText(t, "programming language")
+package claudeagenttest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	claude "github.com/character-ai/claude-agent-sdk-go"
+)
+
+// ErrScriptExhausted is returned when the agent requests more turns than were scripted.
+var ErrScriptExhausted = errors.New("claudeagenttest: scripted provider has no turns left")
+
+// Turn is one scripted LLM response.
+type Turn struct {
+	// Chunks are streamed as consecutive content deltas; the response content
+	// is their concatenation.
+	Chunks []string
+	// ToolCalls are returned after the text. Empty IDs are filled in
+	// automatically ("toolu_<turn>_<index>").
+	ToolCalls []claude.ToolCall
+	// StopReason defaults to "tool_use" when ToolCalls is non-empty and
+	// "end_turn" otherwise.
+	StopReason string
+	// Usage is returned as the response's token usage.
+	Usage claude.ChatUsage
+	// Err, if set, is returned instead of a response, after any Chunks have
+	// been streamed (simulating a mid-stream failure).
+	Err error
+	// Expect, if set, inspects the request before the turn is served. A
+	// non-nil error is returned from Complete, failing the agent run.
+	Expect func(req claude.ChatRequest) error
+}
+
+// TextTurn returns a turn that answers with text in a single delta.
+func TextTurn(text string) Turn {
+	return Turn{Chunks: []string{text}}
+}
+
+// StreamedTextTurn returns a turn that streams text as the given deltas.
+func StreamedTextTurn(chunks ...string) Turn {
+	return Turn{Chunks: chunks}
+}
+
+// ToolCallTurn returns a turn that requests the given tool calls.
+func ToolCallTurn(calls ...claude.ToolCall) Turn {
+	return Turn{ToolCalls: calls}
+}
+
+// ErrorTurn returns a turn that fails with err.
+func ErrorTurn(err error) Turn {
+	return Turn{Err: err}
+}
+
+// TruncatedTurn returns a turn that stops with "max_tokens" after text,
+// as when the model runs out of output budget.
+func TruncatedTurn(text string) Turn {
+	return Turn{Chunks: []string{text}, StopReason: "max_tokens"}
+}
+
+// ToolCall builds a tool call with input marshaled from v. The ID is left
+// empty and assigned by the ScriptedProvider.
+func ToolCall(name string, v any) claude.ToolCall {
+	input, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("claudeagenttest: marshal tool input: %v", err))
+	}
+	return claude.ToolCall{Name: name, Input: input}
+}
+
+// ScriptedProvider implements claude.LLMProvider by serving scripted turns
+// in order. It records every request so tests can inspect what the agent sent.
+// Safe for concurrent use.
+type ScriptedProvider struct {
+	mu       sync.Mutex
+	turns    []Turn
+	next     int
+	requests []claude.ChatRequest
+}
+
+// NewScriptedProvider creates a provider that serves turns in order.
+func NewScriptedProvider(turns ...Turn) *ScriptedProvider {
+	return &ScriptedProvider{turns: turns}
+}
+
+// Name returns "scripted".
+func (p *ScriptedProvider) Name() string { return "scripted" }
+
+// Add appends more turns to the script.
+func (p *ScriptedProvider) Add(turns ...Turn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.turns = append(p.turns, turns...)
+}
+
+// Requests returns the requests received so far.
+func (p *ScriptedProvider) Requests() []claude.ChatRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]claude.ChatRequest(nil), p.requests...)
+}
+
+// Remaining returns the number of turns not yet served.
+func (p *ScriptedProvider) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.turns) - p.next
+}
+
+// Complete serves the next scripted turn, streaming its text and tool calls
+// through onEvent the way a real provider would.
+func (p *ScriptedProvider) Complete(ctx context.Context, req claude.ChatRequest, onEvent claude.ChatStreamCallback) (claude.ChatResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return claude.ChatResponse{}, err
+	}
+
+	p.mu.Lock()
+	p.requests = append(p.requests, req)
+	if p.next >= len(p.turns) {
+		p.mu.Unlock()
+		return claude.ChatResponse{}, ErrScriptExhausted
+	}
+	index := p.next
+	turn := p.turns[index]
+	p.next++
+	p.mu.Unlock()
+
+	if turn.Expect != nil {
+		if err := turn.Expect(req); err != nil {
+			return claude.ChatResponse{}, fmt.Errorf("claudeagenttest: turn %d: %w", index, err)
+		}
+	}
+
+	emit := func(e claude.ChatStreamEvent) {
+		if onEvent != nil {
+			onEvent(e)
+		}
+	}
+
+	var content string
+	for _, chunk := range turn.Chunks {
+		content += chunk
+		emit(claude.ChatStreamEvent{Type: claude.ChatStreamContentDelta, Content: chunk})
+	}
+	if turn.Err != nil {
+		return claude.ChatResponse{}, turn.Err
+	}
+
+	calls := make([]claude.ToolCall, len(turn.ToolCalls))
+	for i, tc := range turn.ToolCalls {
+		if tc.ID == "" {
+			tc.ID = fmt.Sprintf("toolu_%d_%d", index, i)
+		}
+		if len(tc.Input) == 0 {
+			tc.Input = json.RawMessage("{}")
+		}
+		calls[i] = tc
+		emit(claude.ChatStreamEvent{Type: claude.ChatStreamToolUseStart, ToolCall: &claude.ToolCall{ID: tc.ID, Name: tc.Name}})
+		emit(claude.ChatStreamEvent{Type: claude.ChatStreamToolUseDelta, Content: string(tc.Input)})
+		done := tc
+		emit(claude.ChatStreamEvent{Type: claude.ChatStreamToolUseEnd, ToolCall: &done})
+	}
+
+	stop := turn.StopReason
+	if stop == "" {
+		stop = "end_turn"
+		if len(calls) > 0 {
+			stop = "tool_use"
+		}
+	}
+
+	return claude.ChatResponse{
+		Content:    content,
+		ToolCalls:  calls,
+		StopReason: stop,
+		Usage:      turn.Usage,
+	}, nil
+}
@@ -0,0 +1,284 @@
+package claudeagenttest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	claude "github.com/character-ai/claude-agent-sdk-go"
+)
+
+// Trajectory is the full sequence of events from one agent run.
+type Trajectory struct {
+	Events []claude.AgentEvent
+}
+
+// Run executes the runner and collects every event until the channel closes.
+func Run(ctx context.Context, r claude.Runner, prompt string) (*Trajectory, error) {
+	events, err := r.Run(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	tr := &Trajectory{}
+	for e := range events {
+		tr.Events = append(tr.Events, e)
+	}
+	return tr, nil
+}
+
+// RunAgent is Run with a background context that fails the test if the run
+// cannot start.
+func RunAgent(t testing.TB, r claude.Runner, prompt string) *Trajectory {
+	t.Helper()
+	tr, err := Run(context.Background(), r, prompt)
+	if err != nil {
+		t.Fatalf("claudeagenttest: run failed to start: %v", err)
+	}
+	return tr
+}
+
+// Types returns the event types in order.
+func (tr *Trajectory) Types() []claude.AgentEventType {
+	out := make([]claude.AgentEventType, len(tr.Events))
+	for i, e := range tr.Events {
+		out[i] = e.Type
+	}
+	return out
+}
+
+// ToolCalls returns every completed tool call (AgentEventToolUseEnd) in order.
+func (tr *Trajectory) ToolCalls() []claude.ToolCall {
+	var out []claude.ToolCall
+	for _, e := range tr.Events {
+		if e.Type == claude.AgentEventToolUseEnd && e.ToolCall != nil {
+			out = append(out, *e.ToolCall)
+		}
+	}
+	return out
+}
+
+// ToolResults returns every tool result in the order they were emitted.
+func (tr *Trajectory) ToolResults() []claude.ToolResponse {
+	var out []claude.ToolResponse
+	for _, e := range tr.Events {
+		if e.Type == claude.AgentEventToolResult && e.ToolResponse != nil {
+			out = append(out, *e.ToolResponse)
+		}
+	}
+	return out
+}
+
+// ToolResult returns the result for a tool call ID.
+func (tr *Trajectory) ToolResult(toolUseID string) (claude.ToolResponse, bool) {
+	for _, r := range tr.ToolResults() {
+		if r.ToolUseID == toolUseID {
+			return r, true
+		}
+	}
+	return claude.ToolResponse{}, false
+}
+
+// Text returns all streamed assistant text concatenated.
+func (tr *Trajectory) Text() string {
+	var sb strings.Builder
+	for _, e := range tr.Events {
+		if e.Type == claude.AgentEventContentDelta {
+			sb.WriteString(e.Content)
+		}
+	}
+	return sb.String()
+}
+
+// FinalText returns the text of the last model message, i.e. the content
+// streamed after the final AgentEventMessageStart.
+func (tr *Trajectory) FinalText() string {
+	start := tr.finalMessageStart()
+	var sb strings.Builder
+	for _, e := range tr.Events[start+1:] {
+		if e.Type == claude.AgentEventContentDelta {
+			sb.WriteString(e.Content)
+		}
+	}
+	return sb.String()
+}
+
+// Err returns the first error event's error, or nil.
+func (tr *Trajectory) Err() error {
+	for _, e := range tr.Events {
+		if e.Type == claude.AgentEventError && e.Error != nil {
+			return e.Error
+		}
+	}
+	return nil
+}
+
+// Result returns the ResultMessage from the completion (or max-turns) event.
+func (tr *Trajectory) Result() *claude.ResultMessage {
+	for i := len(tr.Events) - 1; i >= 0; i-- {
+		if tr.Events[i].Result != nil {
+			return tr.Events[i].Result
+		}
+	}
+	return nil
+}
+
+// finalMessageStart returns the index of the last AgentEventMessageStart, or -1.
+func (tr *Trajectory) finalMessageStart() int {
+	for i := len(tr.Events) - 1; i >= 0; i-- {
+		if tr.Events[i].Type == claude.AgentEventMessageStart {
+			return i
+		}
+	}
+	return -1
+}
+
+// InputMatcher reports whether a tool call's raw input is acceptable.
+type InputMatcher func(input json.RawMessage) bool
+
+// InputEquals matches input that decodes to the same JSON value as v.
+func InputEquals(v any) InputMatcher {
+	want, err := json.Marshal(v)
+	if err != nil {
+		return func(json.RawMessage) bool { return false }
+	}
+	return func(input json.RawMessage) bool {
+		var a, b any
+		if json.Unmarshal(input, &a) != nil || json.Unmarshal(want, &b) != nil {
+			return false
+		}
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+// InputHas matches object input whose top-level key decodes to the same
+// JSON value as v.
+func InputHas(key string, v any) InputMatcher {
+	eq := InputEquals(v)
+	return func(input json.RawMessage) bool {
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(input, &obj) != nil {
+			return false
+		}
+		field, ok := obj[key]
+		return ok && eq(field)
+	}
+}
+
+// matches reports whether tc has the given name and satisfies every matcher.
+func matches(tc claude.ToolCall, name string, matchers []InputMatcher) bool {
+	if tc.Name != name {
+		return false
+	}
+	for _, m := range matchers {
+		if !m(tc.Input) {
+			return false
+		}
+	}
+	return true
+}
+
+// AssertToolCalled fails the test unless a call to name satisfying all
+// matchers was made. It returns the first matching call.
+func (tr *Trajectory) AssertToolCalled(t testing.TB, name string, matchers ...InputMatcher) claude.ToolCall {
+	t.Helper()
+	for _, tc := range tr.ToolCalls() {
+		if matches(tc, name, matchers) {
+			return tc
+		}
+	}
+	t.Errorf("expected tool %q to be called with matching input; calls were: %s", name, tr.describeCalls())
+	return claude.ToolCall{}
+}
+
+// AssertToolNotCalled fails the test if name was called at all.
+func (tr *Trajectory) AssertToolNotCalled(t testing.TB, name string) {
+	t.Helper()
+	for _, tc := range tr.ToolCalls() {
+		if tc.Name == name {
+			t.Errorf("expected tool %q not to be called, but it was with input %s", name, tc.Input)
+			return
+		}
+	}
+}
+
+// AssertToolCalledBeforeFinalAnswer fails the test unless a matching call to
+// name happened before the final model message began.
+func (tr *Trajectory) AssertToolCalledBeforeFinalAnswer(t testing.TB, name string, matchers ...InputMatcher) {
+	t.Helper()
+	final := tr.finalMessageStart()
+	for i, e := range tr.Events {
+		if i >= final {
+			break
+		}
+		if e.Type == claude.AgentEventToolUseEnd && e.ToolCall != nil && matches(*e.ToolCall, name, matchers) {
+			return
+		}
+	}
+	t.Errorf("expected tool %q to be called with matching input before the final answer; calls were: %s",
+		name, tr.describeCalls())
+}
+
+// AssertToolOrder fails the test unless the named tools were called in this
+// relative order (other calls may be interleaved).
+func (tr *Trajectory) AssertToolOrder(t testing.TB, names ...string) {
+	t.Helper()
+	i := 0
+	for _, tc := range tr.ToolCalls() {
+		if i < len(names) && tc.Name == names[i] {
+			i++
+		}
+	}
+	if i < len(names) {
+		t.Errorf("expected tools in order %v; calls were: %s", names, tr.describeCalls())
+	}
+}
+
+// AssertCompleted fails the test unless the run ended with AgentEventComplete
+// and no errors.
+func (tr *Trajectory) AssertCompleted(t testing.TB) {
+	t.Helper()
+	if err := tr.Err(); err != nil {
+		t.Errorf("expected run to complete, got error: %v", err)
+		return
+	}
+	if len(tr.Events) == 0 || tr.Events[len(tr.Events)-1].Type != claude.AgentEventComplete {
+		t.Errorf("expected run to end with %q, events were %v", claude.AgentEventComplete, tr.Types())
+	}
+}
+
+// AssertError fails the test unless the run emitted an error matching target
+// (compared with errors.Is).
+func (tr *Trajectory) AssertError(t testing.TB, target error) {
+	t.Helper()
+	err := tr.Err()
+	if err == nil {
+		t.Errorf("expected error %v, run emitted none", target)
+		return
+	}
+	if !errors.Is(err, target) {
+		t.Errorf("expected error %v, got %v", target, err)
+	}
+}
+
+// AssertFinalText fails the test unless the final answer contains substr.
+func (tr *Trajectory) AssertFinalText(t testing.TB, substr string) {
+	t.Helper()
+	if got := tr.FinalText(); !strings.Contains(got, substr) {
+		t.Errorf("expected final answer to contain %q, got %q", substr, got)
+	}
+}
+
+func (tr *Trajectory) describeCalls() string {
+	calls := tr.ToolCalls()
+	if len(calls) == 0 {
+		return "(none)"
+	}
+	parts := make([]string, len(calls))
+	for i, tc := range calls {
+		parts[i] = tc.Name + string(tc.Input)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,50 @@
+package claudeagent
+
+import "context"
+
+// awaitingClientResult is the result content of a client tool call, seen
+// only by callers that do not support pausing.
+const awaitingClientResult = "Tool execution denied: the client runs this tool"
+
+// clientToolsKey is the context key for the names of a run's client tools.
+type clientToolsKey struct{}
+
+// withClientTools marks tools as run by the caller of RunHistory; nil
+// clears the marks.
+func withClientTools(ctx context.Context, tools []ToolDefinition) context.Context {
+	if len(tools) == 0 {
+		if ctx.Value(clientToolsKey{}) == nil {
+			return ctx
+		}
+		return context.WithValue(ctx, clientToolsKey{}, map[string]bool(nil))
+	}
+	names := make(map[string]bool, len(tools))
+	for _, def := range tools {
+		names[def.Name] = true
+	}
+	return context.WithValue(ctx, clientToolsKey{}, names)
+}
+
+// isClientTool reports whether the run's caller runs the named tool.
+func isClientTool(ctx context.Context, name string) bool {
+	names, _ := ctx.Value(clientToolsKey{}).(map[string]bool)
+	return names[name]
+}
+
+// clientCallsOnly returns the client tool calls among calls, or calls
+// unchanged if there are none. A turn that calls a client tool ends the
+// run, and the next run starts from the caller's history, so the agent's
+// own calls in that turn are left to the model to make again rather than
+// run with their results lost.
+func clientCallsOnly(ctx context.Context, calls []ToolCall) []ToolCall {
+	var client []ToolCall
+	for _, tc := range calls {
+		if isClientTool(ctx, tc.Name) {
+			client = append(client, tc)
+		}
+	}
+	if len(client) == 0 {
+		return calls
+	}
+	return client
+}
@@ -0,0 +1,109 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRunHistoryClientTools(t *testing.T) {
+	var looked int
+	tools := NewToolRegistry()
+	tools.Register(ToolDefinition{Name: "lookup"}, func(context.Context, json.RawMessage) (string, error) {
+		looked++
+		return "Paris", nil
+	})
+	provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{ToolCalls: []ToolCall{
+			{ID: "t1", Name: "lookup", Input: json.RawMessage(`{}`)},
+			{ID: "t2", Name: "get_weather", Input: json.RawMessage(`{"city":"Paris"}`)},
+		}, StopReason: "tool_use"},
+		{ToolCalls: []ToolCall{{ID: "t3", Name: "lookup", Input: json.RawMessage(`{}`)}}, StopReason: "tool_use"},
+		{Content: "Sunny in Paris.", StopReason: "end_turn"},
+	}}}
+	agent := NewAPIAgent(APIAgentConfig{Provider: provider, Tools: tools, SystemPrompt: "You are a travel agent."})
+	weather := ToolDefinition{Name: "get_weather", InputSchema: map[string]any{"type": "object"}}
+
+	history := []ChatMessage{
+		{Role: ChatRoleSystem, Content: "Answer briefly."},
+		{Role: ChatRoleUser, Content: "Weather in the capital of France?"},
+	}
+	events, err := agent.RunHistory(context.Background(), history, weather)
+	if err != nil {
+		t.Fatalf("RunHistory: %v", err)
+	}
+	var last AgentEvent
+	for e := range events {
+		if e.Type == AgentEventToolResult && e.ToolResponse.ToolUseID == "t2" {
+			t.Error("client tool calls should not report a result")
+		}
+		last = e
+	}
+	if last.Type != AgentEventApprovalRequired || len(last.Approvals) != 1 ||
+		!last.Approvals[0].ClientTool || last.Approvals[0].ToolCallID != "t2" {
+		t.Fatalf("run should pause for the client tool, got %+v", last)
+	}
+	if looked != 0 {
+		t.Errorf("agent tools in a turn with client tools should not run, ran %d times", looked)
+	}
+	req := provider.requests[0]
+	if req.SystemPrompt != "You are a travel agent.\n\nAnswer briefly." {
+		t.Errorf("system messages should extend the system prompt, got %q", req.SystemPrompt)
+	}
+	if len(req.Tools) != 2 || req.Tools[1].Name != "get_weather" {
+		t.Errorf("client tools should be offered to the model, got %+v", req.Tools)
+	}
+
+	// The caller runs the tool and continues the conversation.
+	history = append(history,
+		ChatMessage{Role: ChatRoleAssistant, ToolCalls: []ToolCall{{ID: "t2", Name: "get_weather", Input: json.RawMessage(`{"city":"Paris"}`)}}},
+		ChatMessage{Role: ChatRoleTool, ToolCallID: "t2", Content: "sunny"},
+	)
+	events, err = agent.RunHistory(context.Background(), history, weather)
+	if err != nil {
+		t.Fatalf("RunHistory: %v", err)
+	}
+	var text string
+	for e := range events {
+		if e.Error != nil {
+			t.Fatalf("run error: %v", e.Error)
+		}
+		if e.Type == AgentEventContentDelta {
+			text += e.Content
+		}
+	}
+	if text != "Sunny in Paris." {
+		t.Errorf("text = %q", text)
+	}
+	if looked != 1 {
+		t.Errorf("the model should be able to call the agent tool again, ran %d times", looked)
+	}
+	if msgs := provider.requests[1].Messages; len(msgs) != 3 || msgs[2].Role != ChatRoleTool {
+		t.Errorf("the run should continue from the tool result, got %+v", msgs)
+	}
+}
+
+func TestRunHistoryRejects(t *testing.T) {
+	tools := NewToolRegistry()
+	tools.Register(ToolDefinition{Name: "lookup"}, func(context.Context, json.RawMessage) (string, error) {
+		return "", nil
+	})
+	agent := NewAPIAgent(APIAgentConfig{Provider: &cassetteScriptProvider{}, Tools: tools})
+	user := []ChatMessage{{Role: ChatRoleUser, Content: "hi"}}
+
+	for name, tc := range map[string]struct {
+		history []ChatMessage
+		tools   []ToolDefinition
+		want    string
+	}{
+		"empty":          {nil, nil, "no messages"},
+		"system only":    {[]ChatMessage{{Role: ChatRoleSystem, Content: "x"}}, nil, "no messages"},
+		"assistant last": {append(user, ChatMessage{Role: ChatRoleAssistant, Content: "hello"}), nil, "must end with"},
+		"conflict":       {user, []ToolDefinition{{Name: "lookup"}}, "conflicts"},
+	} {
+		if _, err := agent.RunHistory(context.Background(), tc.history, tc.tools...); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", name, err, tc.want)
+		}
+	}
+}
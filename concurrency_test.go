@@ -49,7 +49,7 @@ func TestRunToolsSmart_SingleTool(t *testing.T) {
 	events := make(chan AgentEvent, 100)
 	go drainEvents(events)
 
-	results := runToolsSmart(context.Background(), makeToolCalls("only"), reg, nil, nil, nil, nil, events, false)
+	results := runToolsSmart(context.Background(), makeToolCalls("only"), reg, nil, nil, nil, false, nil, events, false)
 	if len(results) != 1 {
 		t.Fatalf("expected 1 result, got %d", len(results))
 	}
@@ -69,7 +69,7 @@ func TestRunToolsSmart_SafeToolsRunParallel(t *testing.T) {
 	go drainEvents(events)
 
 	start := time.Now()
-	results := runToolsSmart(context.Background(), makeToolCalls("a", "b", "c"), reg, nil, nil, nil, nil, events, false)
+	results := runToolsSmart(context.Background(), makeToolCalls("a", "b", "c"), reg, nil, nil, nil, false, nil, events, false)
 	elapsed := time.Since(start)
 
 	// Three 50ms tools in parallel should take ~50ms, not ~150ms.
@@ -119,7 +119,7 @@ func TestRunToolsSmart_UnsafeToolsRunSequentially(t *testing.T) {
 	events := make(chan AgentEvent, 100)
 	go drainEvents(events)
 
-	results := runToolsSmart(context.Background(), makeToolCalls("x", "y", "z"), reg, nil, nil, nil, nil, events, false)
+	results := runToolsSmart(context.Background(), makeToolCalls("x", "y", "z"), reg, nil, nil, nil, false, nil, events, false)
 
 	if atomic.LoadInt64(&maxConcurrent) > 1 {
 		t.Fatalf("unsafe tools should not run concurrently, max concurrent was %d", atomic.LoadInt64(&maxConcurrent))
@@ -146,7 +146,7 @@ func TestRunToolsSmart_DefaultParallelTrue(t *testing.T) {
 	go drainEvents(events)
 
 	start := time.Now()
-	results := runToolsSmart(context.Background(), makeToolCalls("p", "q"), reg, nil, nil, nil, nil, events, true)
+	results := runToolsSmart(context.Background(), makeToolCalls("p", "q"), reg, nil, nil, nil, false, nil, events, true)
 	elapsed := time.Since(start)
 
 	// defaultParallel=true => unannotated tools run in parallel.
@@ -170,7 +170,7 @@ func TestRunToolsSmart_DefaultParallelFalse(t *testing.T) {
 	go drainEvents(events)
 
 	start := time.Now()
-	results := runToolsSmart(context.Background(), makeToolCalls("s", "t"), reg, nil, nil, nil, nil, events, false)
+	results := runToolsSmart(context.Background(), makeToolCalls("s", "t"), reg, nil, nil, nil, false, nil, events, false)
 	elapsed := time.Since(start)
 
 	// defaultParallel=false => unannotated tools run sequentially.
@@ -197,7 +197,7 @@ func TestRunToolsSmart_MixedSafeUnsafe_OrderPreserved(t *testing.T) {
 	go drainEvents(events)
 
 	calls := makeToolCalls("s1", "s2", "u1", "s3", "u2")
-	results := runToolsSmart(context.Background(), calls, reg, nil, nil, nil, nil, events, false)
+	results := runToolsSmart(context.Background(), calls, reg, nil, nil, nil, false, nil, events, false)
 
 	expected := []string{"s1:done", "s2:done", "u1:done", "s3:done", "u2:done"}
 	if len(results) != len(expected) {
@@ -1,8 +1,13 @@
 package claudeagent
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/anthropics/anthropic-sdk-go"
 )
 
 var (
@@ -46,3 +51,49 @@ func truncate(s string, maxLen int) string {
 	}
 	return s[:maxLen] + "..."
 }
+
+// APIError is returned by HTTP-based LLM providers when the endpoint
+// responds with a non-200 status code.
+type APIError struct {
+	// StatusCode is the HTTP status code returned by the endpoint.
+	StatusCode int
+	// Body is the raw response body.
+	Body string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error %d: %s", e.StatusCode, e.Body)
+}
+
+// IsRetryableError reports whether an LLM provider error is transient and the
+// request may succeed if retried, possibly against a different provider.
+// Rate limits (429), timeouts (408), conflicts (409), server errors (5xx) and
+// network failures are retryable; cancellation, bad requests and content
+// filter rejections are not.
+func IsRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return isRetryableStatus(apiErr.StatusCode)
+	}
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return isRetryableStatus(anthropicErr.StatusCode)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return true
+	}
+	return code >= 500
+}
@@ -0,0 +1,211 @@
+package claudeagent
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultEventBufferCapacity is the number of events an EventBuffer keeps
+// when EventBufferConfig.Capacity is not set.
+const DefaultEventBufferCapacity = 1024
+
+// DefaultSSEHeartbeat is a heartbeat interval short enough for common
+// proxy idle timeouts.
+const DefaultSSEHeartbeat = 15 * time.Second
+
+// BufferedEvent is an AgentEvent with its stream ID.
+type BufferedEvent struct {
+	ID    int64
+	Event AgentEvent
+}
+
+// EventBufferConfig configures an EventBuffer.
+type EventBufferConfig struct {
+	// Capacity is the number of most recent events kept for replay.
+	// Defaults to DefaultEventBufferCapacity.
+	Capacity int
+	// FirstID is the ID of the first event. Defaults to 1. Start a
+	// stream's next run after the previous run's last ID so that IDs keep
+	// increasing across runs.
+	FirstID int64
+}
+
+// EventBuffer records a run's events in a bounded ring buffer and numbers
+// them with increasing IDs, so that SSE clients that disconnect mid-run can
+// reconnect and receive every event they missed. Any number of clients may
+// stream from one buffer.
+//
+// Example:
+//
+//	buf := claude.NewEventBuffer(claude.EventBufferConfig{})
+//	go buf.Pump(events)
+//
+//	// In each (re)connecting request:
+//	sse, _ := claude.NewSSEWriter(w)
+//	buf.ServeSSE(r.Context(), sse, claude.LastEventID(r), claude.DefaultSSEHeartbeat)
+//
+// An EventBuffer is safe for concurrent use.
+type EventBuffer struct {
+	mu      sync.Mutex
+	ring    []BufferedEvent
+	head    int // index of the oldest event
+	size    int
+	firstID int64
+	nextID  int64
+	closed  bool
+	// changed is closed, and replaced, on every Append and on Close.
+	changed chan struct{}
+}
+
+// NewEventBuffer creates an empty EventBuffer.
+func NewEventBuffer(cfg EventBufferConfig) *EventBuffer {
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = DefaultEventBufferCapacity
+	}
+	if cfg.FirstID <= 0 {
+		cfg.FirstID = 1
+	}
+	return &EventBuffer{
+		ring:    make([]BufferedEvent, cfg.Capacity),
+		firstID: cfg.FirstID,
+		nextID:  cfg.FirstID,
+		changed: make(chan struct{}),
+	}
+}
+
+// Append adds an event, dropping the oldest one if the buffer is full,
+// and returns its ID.
+func (b *EventBuffer) Append(event AgentEvent) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	if b.size < len(b.ring) {
+		b.ring[(b.head+b.size)%len(b.ring)] = BufferedEvent{ID: id, Event: event}
+		b.size++
+	} else {
+		b.ring[b.head] = BufferedEvent{ID: id, Event: event}
+		b.head = (b.head + 1) % len(b.ring)
+	}
+	close(b.changed)
+	b.changed = make(chan struct{})
+	return id
+}
+
+// Close marks the end of the stream. Clients receive the remaining events
+// and a close event.
+func (b *EventBuffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.changed)
+	}
+}
+
+// Closed reports whether Close has been called.
+func (b *EventBuffer) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+// LastID returns the ID of the most recent event, or FirstID-1 if there
+// are none.
+func (b *EventBuffer) LastID() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextID - 1
+}
+
+// Pump appends every event from events and closes the buffer when the
+// channel closes.
+func (b *EventBuffer) Pump(events <-chan AgentEvent) {
+	for e := range events {
+		b.Append(e)
+	}
+	b.Close()
+}
+
+// After returns the buffered events with IDs greater than id. dropped
+// reports that some of those events were evicted from the buffer and are
+// lost. changed is closed when the buffer next changes.
+func (b *EventBuffer) After(id int64) (events []BufferedEvent, dropped bool, closed bool, changed <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	oldest := b.nextID - int64(b.size)
+	// IDs before firstID belong to an earlier stream; only report drops
+	// this buffer caused.
+	dropped = id+1 < oldest && oldest > b.firstID
+	from := max(id+1, oldest)
+	for i := from - oldest; i < int64(b.size); i++ {
+		events = append(events, b.ring[(b.head+int(i))%len(b.ring)])
+	}
+	return events, dropped, b.closed, b.changed
+}
+
+// ServeSSE streams the events after lastEventID to sse, then follows new
+// events until the buffer is closed or ctx is done. While waiting for
+// events, a heartbeat comment is written every heartbeat; 0 disables them.
+//
+// If events the client has not seen were already dropped from the buffer,
+// an "events_dropped" event is written first with the ID streaming resumes
+// from. When the buffer is closed, ServeSSE writes a close event.
+func (b *EventBuffer) ServeSSE(ctx context.Context, sse *SSEWriter, lastEventID int64, heartbeat time.Duration) error {
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		t := time.NewTicker(heartbeat)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	next := lastEventID
+	for {
+		events, dropped, closed, changed := b.After(next)
+		if dropped {
+			if err := sse.WriteEvent("events_dropped", map[string]int64{
+				"last_event_id": next,
+				"resume_from":   events[0].ID,
+			}); err != nil {
+				return err
+			}
+		}
+		for _, e := range events {
+			if err := sse.WriteAgentEventWithID(e.ID, e.Event); err != nil {
+				return err
+			}
+			next = e.ID
+		}
+		if closed {
+			return sse.Close()
+		}
+
+		select {
+		case <-changed:
+		case <-tick:
+			if err := sse.WriteComment("heartbeat"); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// LastEventID returns the event ID a reconnecting SSE client last received,
+// from the Last-Event-ID header or, for clients that cannot set headers, a
+// last_event_id query parameter. It returns 0 if there is none.
+func LastEventID(r *http.Request) int64 {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
@@ -0,0 +1,90 @@
+package claudeagent
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBufferRing(t *testing.T) {
+	buf := NewEventBuffer(EventBufferConfig{Capacity: 3, FirstID: 10})
+	for _, c := range []string{"a", "b", "c", "d", "e"} {
+		buf.Append(AgentEvent{Type: AgentEventContentDelta, Content: c})
+	}
+	if buf.LastID() != 14 {
+		t.Errorf("LastID = %d", buf.LastID())
+	}
+
+	events, dropped, closed, _ := buf.After(12)
+	if dropped || closed || len(events) != 2 || events[0].ID != 13 || events[1].Event.Content != "e" {
+		t.Errorf("After(12) = %+v, dropped=%v", events, dropped)
+	}
+	events, dropped, _, _ = buf.After(10)
+	if !dropped || len(events) != 3 || events[0].ID != 12 {
+		t.Errorf("After(10) should report dropped events, got %+v, dropped=%v", events, dropped)
+	}
+	// IDs from an earlier stream are not drops of this one.
+	if _, dropped, _, _ = buf.After(3); !dropped {
+		t.Error("events 10 and 11 were dropped")
+	}
+	fresh := NewEventBuffer(EventBufferConfig{FirstID: 10})
+	fresh.Append(AgentEvent{})
+	if events, dropped, _, _ := fresh.After(3); dropped || len(events) != 1 {
+		t.Errorf("an earlier stream's ID should replay everything: %+v, dropped=%v", events, dropped)
+	}
+}
+
+func TestEventBufferServeSSE(t *testing.T) {
+	buf := NewEventBuffer(EventBufferConfig{Capacity: 2})
+	events := make(chan AgentEvent)
+	go buf.Pump(events)
+	events <- AgentEvent{Type: AgentEventContentDelta, Content: "one"}
+	events <- AgentEvent{Type: AgentEventContentDelta, Content: "two"}
+	events <- AgentEvent{Type: AgentEventContentDelta, Content: "three"}
+
+	// A client that saw event 1 reconnects while the run is idle.
+	rec := httptest.NewRecorder()
+	sse, _ := NewSSEWriter(rec)
+	done := make(chan error)
+	go func() { done <- buf.ServeSSE(context.Background(), sse, 1, time.Millisecond) }()
+	time.Sleep(20 * time.Millisecond)
+	close(events)
+	if err := <-done; err != nil {
+		t.Fatalf("ServeSSE: %v", err)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{"id: 2\nevent: content_delta\n", `"content":"two"`, "id: 3\n", ": heartbeat\n\n", "event: close"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stream missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `"one"`) || strings.Contains(body, "events_dropped") {
+		t.Errorf("stream should resume after event 1:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	sse, _ = NewSSEWriter(rec)
+	_ = buf.ServeSSE(context.Background(), sse, 0, 0)
+	if !strings.Contains(rec.Body.String(), `event: events_dropped
+data: {"last_event_id":0,"resume_from":2}`) {
+		t.Errorf("expected a dropped-events notice:\n%s", rec.Body.String())
+	}
+}
+
+func TestLastEventID(t *testing.T) {
+	r := httptest.NewRequest("GET", "/events?last_event_id=7", nil)
+	if id := LastEventID(r); id != 7 {
+		t.Errorf("query parameter: %d", id)
+	}
+	r.Header.Set("Last-Event-ID", "42")
+	if id := LastEventID(r); id != 42 {
+		t.Errorf("header: %d", id)
+	}
+	r.Header.Set("Last-Event-ID", "junk")
+	if id := LastEventID(r); id != 0 {
+		t.Errorf("invalid header: %d", id)
+	}
+}
@@ -2,6 +2,8 @@ package claudeagent
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 )
@@ -16,15 +18,56 @@ func executeOneTool(
 	hooks *Hooks,
 	canUseTool CanUseToolFunc,
 	retry *RetryConfig,
+	repair bool,
 	metrics *MetricsCollector,
 	events chan<- AgentEvent,
 ) ToolResponse {
 	response := ToolResponse{ToolUseID: tc.ID}
 	currentInput := tc.Input
 
-	// Permission check
-	if canUseTool != nil {
-		decision := canUseTool(ctx, tc.Name, tc.ID, tc.Input)
+	// Client tools pause the run; the caller runs them.
+	if isClientTool(ctx, tc.Name) {
+		response.Content = awaitingClientResult
+		response.IsError = true
+		response.approval = &ApprovalRequest{ToolCallID: tc.ID, ToolName: tc.Name, Input: currentInput, ClientTool: true}
+		return response
+	}
+
+	// Input repair, before permission checks and hooks see the input.
+	var repairs []string
+	if repair && !json.Valid(currentInput) {
+		fixed, fixes, err := RepairJSON(currentInput)
+		if metrics != nil {
+			metrics.recordInputRepair(tc.Name, err == nil)
+		}
+		if err != nil {
+			response.Content = toolInputError(tools.GetToolDef(tc.Name), currentInput, json.Unmarshal(currentInput, new(any)))
+			response.IsError = true
+			events <- AgentEvent{Type: AgentEventToolResult, ToolResponse: &response}
+			return response
+		}
+		currentInput, repairs = fixed, fixes
+	}
+
+	// Permission check, unless a person already approved the call.
+	skipCheck, denial := applyPermissionMode(ctx, tools, tc.Name)
+	if decision, ok := approvalFor(ctx, tc.ID); ok {
+		if decision.ModifiedInput != nil {
+			currentInput = decision.ModifiedInput
+		}
+	} else if denial != "" {
+		response.Content = fmt.Sprintf("Tool execution denied: %s", denial)
+		response.IsError = true
+		events <- AgentEvent{Type: AgentEventToolResult, ToolResponse: &response}
+		return response
+	} else if canUseTool != nil && !skipCheck {
+		decision := canUseTool(ctx, tc.Name, tc.ID, currentInput)
+		if decision.Ask {
+			response.Content = awaitingApprovalResult
+			response.IsError = true
+			response.approval = &ApprovalRequest{ToolCallID: tc.ID, ToolName: tc.Name, Input: currentInput, Reason: decision.Reason}
+			return response
+		}
 		if !decision.Allow {
 			reason := decision.Reason
 			if reason == "" {
@@ -76,6 +119,16 @@ func executeOneTool(
 		}
 	}
 
+	// Journal the call before it can have side effects.
+	journal := runJournalFor(ctx)
+	started := ToolCall{ID: tc.ID, Name: tc.Name, Input: currentInput}
+	if err := journal.record(ctx, JournalEntry{Type: JournalToolStart, ToolCall: &started}); err != nil {
+		response.Content = err.Error()
+		response.IsError = true
+		events <- AgentEvent{Type: AgentEventToolResult, ToolResponse: &response}
+		return response
+	}
+
 	// Execute
 	if metrics != nil {
 		metrics.recordToolStart(tc.ID)
@@ -96,18 +149,37 @@ func executeOneTool(
 			meta = m
 			return r, e
 		})
-		if err != nil {
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case err != nil && repair && errors.As(err, &typeErr):
+			response.Content = toolInputError(tools.GetToolDef(tc.Name), currentInput, err)
+			response.IsError = true
+		case err != nil:
 			response.Content = err.Error()
 			response.IsError = true
-		} else {
+		default:
 			response.Content = result
 			response.Metadata = meta
 		}
 	}
 
+	if repairs != nil {
+		if response.Metadata == nil {
+			response.Metadata = &ToolResultMetadata{}
+		} else {
+			cp := *response.Metadata
+			response.Metadata = &cp
+		}
+		response.Metadata.RepairedInput = currentInput
+		response.Metadata.InputRepairs = repairs
+	}
+
 	if metrics != nil {
 		metrics.recordToolEnd(tc.Name, tc.ID, response.IsError)
 	}
+	// If this fails the call counts as unfinished on resume.
+	finished := response
+	_ = journal.record(ctx, JournalEntry{Type: JournalToolEnd, ToolResult: &finished})
 
 	// Post-tool-use hooks
 	if hooks != nil {
@@ -127,6 +199,7 @@ func runToolsParallel(
 	hooks *Hooks,
 	canUseTool CanUseToolFunc,
 	retry *RetryConfig,
+	repair bool,
 	metrics *MetricsCollector,
 	events chan<- AgentEvent,
 ) []ToolResponse {
@@ -136,7 +209,7 @@ func runToolsParallel(
 		wg.Add(1)
 		go func(i int, tc ToolCall) {
 			defer wg.Done()
-			results[i] = executeOneTool(ctx, tc, tools, hooks, canUseTool, retry, metrics, events)
+			results[i] = executeOneTool(ctx, tc, tools, hooks, canUseTool, retry, repair, metrics, events)
 		}(i, tc)
 	}
 	wg.Wait()
@@ -169,6 +242,7 @@ func runToolsSmart(
 	hooks *Hooks,
 	canUseTool CanUseToolFunc,
 	retry *RetryConfig,
+	repair bool,
 	metrics *MetricsCollector,
 	events chan<- AgentEvent,
 	defaultParallel bool,
@@ -177,7 +251,7 @@ func runToolsSmart(
 	if len(toolCalls) <= 1 {
 		results := make([]ToolResponse, len(toolCalls))
 		for i, tc := range toolCalls {
-			results[i] = executeOneTool(ctx, tc, tools, hooks, canUseTool, retry, metrics, events)
+			results[i] = executeOneTool(ctx, tc, tools, hooks, canUseTool, retry, repair, metrics, events)
 		}
 		return results
 	}
@@ -195,12 +269,12 @@ func runToolsSmart(
 			}
 			// Run this batch in parallel.
 			batch := toolCalls[i:j]
-			batchResults := runToolsParallel(ctx, batch, tools, hooks, canUseTool, retry, metrics, events)
+			batchResults := runToolsParallel(ctx, batch, tools, hooks, canUseTool, retry, repair, metrics, events)
 			copy(results[i:j], batchResults)
 			i = j
 		} else {
 			// Unsafe tool: run sequentially.
-			results[i] = executeOneTool(ctx, toolCalls[i], tools, hooks, canUseTool, retry, metrics, events)
+			results[i] = executeOneTool(ctx, toolCalls[i], tools, hooks, canUseTool, retry, repair, metrics, events)
 			i++
 		}
 	}
@@ -3,6 +3,7 @@ package claudeagent
 import (
 	"context"
 	"fmt"
+	"unicode/utf8"
 )
 
 // HistorySummarizer summarizes a slice of conversation messages into a single
@@ -33,8 +34,34 @@ type HistoryConfig struct {
 	// triggers. For example, if MaxTurns=10 and SummarizeThreshold=5, the
 	// summarizer is called when history reaches 15 turns. Default: 0.
 	SummarizeThreshold int
+
+	// TokenBudget caps each LLM request (history, system prompt and tools)
+	// at TokenBudget - ReserveOutputTokens input tokens. Over budget, the
+	// oldest turns are dropped — or summarized, when Summarizer is set — and
+	// if the latest turn alone is still too large, its longest tool results
+	// are truncated. The initial prompt is always kept. Applied after
+	// MaxTurns. 0 = no token budget.
+	TokenBudget int
+
+	// ReserveOutputTokens is held back from TokenBudget for the response.
+	// When 0, APIAgent reserves its MaxTokens; Agent reserves nothing.
+	ReserveOutputTokens int
+
+	// TokenCounter counts request tokens for TokenBudget.
+	// Default: EstimateTokenCounter{}. Exact counters such as
+	// AnthropicProvider make a network call per check, and trimming may
+	// check several times in one turn.
+	TokenCounter TokenCounter
 }
 
+// historySummaryPrefix marks a user message that holds a summary of
+// compacted turns.
+const historySummaryPrefix = "[Previous conversation summary]\n"
+
+// minTruncatedToolResult is the shortest a tool result is cut down to
+// when fitting a token budget.
+const minTruncatedToolResult = 512
+
 // compactHistory returns a compacted view of the CLI agent's ConversationMessage
 // history. The original slice is never modified.
 func compactHistory(ctx context.Context, history []ConversationMessage, cfg *HistoryConfig) []ConversationMessage {
@@ -113,3 +140,148 @@ func compactHistory(ctx context.Context, history []ConversationMessage, cfg *His
 	}
 	return out
 }
+
+// fitChatHistoryToBudget trims req.Messages until the request fits
+// cfg.TokenBudget. defaultReserve is used when cfg.ReserveOutputTokens is 0.
+// The input slice is never modified.
+func fitChatHistoryToBudget(ctx context.Context, req ChatRequest, cfg *HistoryConfig, defaultReserve int) []ChatMessage {
+	if cfg == nil || cfg.TokenBudget <= 0 || len(req.Messages) == 0 {
+		return req.Messages
+	}
+	reserve := cfg.ReserveOutputTokens
+	if reserve == 0 {
+		reserve = defaultReserve
+	}
+	limit := cfg.TokenBudget - reserve
+	counter := cfg.TokenCounter
+	if counter == nil {
+		counter = EstimateTokenCounter{}
+	}
+	fits := func(msgs []ChatMessage) bool {
+		r := req
+		r.Messages = msgs
+		n, err := counter.CountTokens(ctx, r)
+		if err != nil {
+			n = EstimateTokens(r) // a failing exact counter should not disable the budget
+		}
+		return n <= limit
+	}
+	if fits(req.Messages) {
+		return req.Messages
+	}
+
+	initial := req.Messages[0]
+	header, turns := splitChatTurns(req.Messages[1:])
+	build := func(header []ChatMessage, turns [][]ChatMessage) []ChatMessage {
+		out := append([]ChatMessage{initial}, header...)
+		for _, t := range turns {
+			out = append(out, t...)
+		}
+		return out
+	}
+
+	// Drop the oldest turns until the request fits, always keeping the latest.
+	dropped := 0
+	for dropped < len(turns)-1 && !fits(build(header, turns[dropped:])) {
+		dropped++
+	}
+
+	if dropped > 0 && cfg.Summarizer != nil {
+		var old []ChatMessage
+		old = append(old, header...)
+		for _, t := range turns[:dropped] {
+			old = append(old, t...)
+		}
+		if summary, err := cfg.Summarizer(ctx, chatToConversation(old)); err == nil && summary != "" {
+			header = []ChatMessage{{Role: ChatRoleUser, Content: historySummaryPrefix + summary}}
+			// The summary takes space too; drop further turns if needed.
+			for dropped < len(turns)-1 && !fits(build(header, turns[dropped:])) {
+				dropped++
+			}
+		}
+	}
+
+	out := build(header, turns[dropped:])
+	if fits(out) {
+		return out
+	}
+	return truncateToolResults(out, fits)
+}
+
+// splitChatTurns groups messages into turns, each starting at an assistant
+// message. Messages before the first assistant message (such as a summary)
+// are returned as the header.
+func splitChatTurns(msgs []ChatMessage) (header []ChatMessage, turns [][]ChatMessage) {
+	for i, m := range msgs {
+		if m.Role == ChatRoleAssistant {
+			turns = append(turns, []ChatMessage{m})
+			continue
+		}
+		if len(turns) == 0 {
+			header = msgs[:i+1]
+			continue
+		}
+		turns[len(turns)-1] = append(turns[len(turns)-1], m)
+	}
+	return header, turns
+}
+
+// truncateToolResults repeatedly halves the longest tool result until fits
+// reports true or no result can be shortened further. msgs is modified.
+func truncateToolResults(msgs []ChatMessage, fits func([]ChatMessage) bool) []ChatMessage {
+	for !fits(msgs) {
+		longest := -1
+		for i, m := range msgs {
+			if m.Role == ChatRoleTool && len(m.Content) > minTruncatedToolResult &&
+				(longest < 0 || len(m.Content) > len(msgs[longest].Content)) {
+				longest = i
+			}
+		}
+		if longest < 0 {
+			return msgs
+		}
+		content := msgs[longest].Content
+		keep := max(len(content)/2, minTruncatedToolResult)
+		// Cut at a rune boundary so the kept text stays valid UTF-8.
+		for keep > 0 && !utf8.RuneStart(content[keep]) {
+			keep--
+		}
+		msgs[longest].Content = fmt.Sprintf("%s\n[tool result truncated: %d of %d bytes omitted]",
+			content[:keep], len(content)-keep, len(content))
+	}
+	return msgs
+}
+
+// fitHistoryToBudget applies the token budget to the CLI agent's history.
+func fitHistoryToBudget(ctx context.Context, history []ConversationMessage, tools *ToolRegistry, cfg *HistoryConfig) []ConversationMessage {
+	if cfg == nil || cfg.TokenBudget <= 0 {
+		return history
+	}
+	msgs := make([]ChatMessage, len(history))
+	for i, m := range history {
+		msgs[i] = ChatMessage{Role: ChatRole(m.Role), Content: m.Content, ToolCalls: m.ToolCalls, ToolCallID: m.ToolCallID}
+	}
+	req := ChatRequest{Messages: msgs}
+	if tools != nil {
+		req.Tools = tools.Definitions()
+	}
+	fitted := fitChatHistoryToBudget(ctx, req, cfg, 0)
+	out := make([]ConversationMessage, len(fitted))
+	for i, m := range fitted {
+		out[i] = ConversationMessage{Role: string(m.Role), Content: m.Content, ToolCalls: m.ToolCalls, ToolCallID: m.ToolCallID}
+	}
+	return out
+}
+
+// chatToConversation converts ChatMessages to ConversationMessages for a
+// HistorySummarizer.
+func chatToConversation(msgs []ChatMessage) []ConversationMessage {
+	conv := make([]ConversationMessage, 0, len(msgs))
+	for _, m := range msgs {
+		conv = append(conv, ConversationMessage{
+			Role:    string(m.Role),
+			Content: m.Content,
+		})
+	}
+	return conv
+}
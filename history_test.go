@@ -2,8 +2,12 @@ package claudeagent
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 func makeHistory(pairs int) []ConversationMessage {
@@ -249,3 +253,143 @@ func TestNilSummarizerPreservesExistingBehavior(t *testing.T) {
 		t.Errorf("second message should be assistant (no summary), got role: %s", got[1].Role)
 	}
 }
+
+// makeChatHistory returns an initial prompt plus turns whose tool results
+// are resultSize bytes each.
+func makeChatHistory(turns, resultSize int) []ChatMessage {
+	h := []ChatMessage{{Role: ChatRoleUser, Content: "initial"}}
+	for i := 0; i < turns; i++ {
+		id := fmt.Sprintf("tc%d", i)
+		h = append(h,
+			ChatMessage{Role: ChatRoleAssistant, ToolCalls: []ToolCall{{ID: id, Name: "t", Input: json.RawMessage(`{}`)}}},
+			ChatMessage{Role: ChatRoleTool, ToolCallID: id, Content: strings.Repeat("x", resultSize)},
+		)
+	}
+	return h
+}
+
+func TestTokenBudgetDropsOldestTurns(t *testing.T) {
+	h := makeChatHistory(5, 400) // ~100 tokens per tool result
+	cfg := &HistoryConfig{TokenBudget: 400, ReserveOutputTokens: 100}
+
+	got := fitChatHistoryToBudget(context.Background(), ChatRequest{Messages: h}, cfg, 0)
+
+	if got[0].Content != "initial" {
+		t.Errorf("initial prompt must be kept, got %+v", got[0])
+	}
+	if n := EstimateTokens(ChatRequest{Messages: got}); n > 300 {
+		t.Errorf("expected request within 300 tokens, got %d", n)
+	}
+	if last := got[len(got)-1]; last.ToolCallID != "tc4" {
+		t.Errorf("expected latest turn kept, got %+v", last)
+	}
+	if len(got) != 1+2*2 {
+		t.Errorf("expected 2 turns kept, got %d messages", len(got))
+	}
+	if len(h[10].Content) != 400 {
+		t.Error("original history must not be modified")
+	}
+}
+
+func TestTokenBudgetSummarizesDroppedTurns(t *testing.T) {
+	h := makeChatHistory(5, 400)
+	var summarized int
+	cfg := &HistoryConfig{
+		TokenBudget: 300,
+		Summarizer: func(_ context.Context, msgs []ConversationMessage) (string, error) {
+			summarized = len(msgs)
+			return "earlier tools returned x", nil
+		},
+	}
+
+	got := fitChatHistoryToBudget(context.Background(), ChatRequest{Messages: h}, cfg, 0)
+	if summarized == 0 {
+		t.Fatal("expected summarizer to be called")
+	}
+	if got[1].Role != ChatRoleUser || !strings.HasPrefix(got[1].Content, historySummaryPrefix) {
+		t.Errorf("expected summary after initial prompt, got %+v", got[1])
+	}
+	if n := EstimateTokens(ChatRequest{Messages: got}); n > 300 {
+		t.Errorf("expected request within budget, got %d tokens", n)
+	}
+}
+
+func TestTokenBudgetTruncatesHugeToolResult(t *testing.T) {
+	h := makeChatHistory(1, 40000) // ~10k tokens in a single result
+	cfg := &HistoryConfig{TokenBudget: 2000}
+
+	got := fitChatHistoryToBudget(context.Background(), ChatRequest{Messages: h}, cfg, 500)
+
+	if n := EstimateTokens(ChatRequest{Messages: got}); n > 1500 {
+		t.Errorf("expected request within 1500 tokens, got %d", n)
+	}
+	if !strings.Contains(got[2].Content, "[tool result truncated:") {
+		t.Errorf("expected truncation marker, got %q", got[2].Content[len(got[2].Content)-60:])
+	}
+	if len(h[2].Content) != 40000 {
+		t.Error("original history must not be modified")
+	}
+}
+
+func TestTokenBudgetTruncatesMultibyteToolResult(t *testing.T) {
+	h := makeChatHistory(1, 0)
+	// 3-byte runes, so half the content falls inside one.
+	h[2].Content = strings.Repeat("界", 13335)
+	cfg := &HistoryConfig{TokenBudget: 2000}
+
+	got := fitChatHistoryToBudget(context.Background(), ChatRequest{Messages: h}, cfg, 500)
+
+	if !strings.Contains(got[2].Content, "[tool result truncated:") {
+		t.Fatalf("expected truncation marker, got %d bytes", len(got[2].Content))
+	}
+	if !utf8.ValidString(got[2].Content) {
+		t.Error("truncated tool result should be valid UTF-8")
+	}
+}
+
+// countingTokenCounter returns a fixed per-message count, or an error.
+type countingTokenCounter struct {
+	perMessage int
+	err        error
+	calls      int
+}
+
+func (c *countingTokenCounter) CountTokens(_ context.Context, req ChatRequest) (int, error) {
+	c.calls++
+	return c.perMessage * len(req.Messages), c.err
+}
+
+func TestTokenBudgetUsesConfiguredCounter(t *testing.T) {
+	h := makeChatHistory(3, 10)
+	counter := &countingTokenCounter{perMessage: 100}
+	cfg := &HistoryConfig{TokenBudget: 350, TokenCounter: counter}
+
+	got := fitChatHistoryToBudget(context.Background(), ChatRequest{Messages: h}, cfg, 0)
+	if len(got) != 3 || counter.calls == 0 {
+		t.Errorf("expected initial + 1 turn by counter, got %d messages (%d calls)", len(got), counter.calls)
+	}
+
+	// A failing counter falls back to the estimator instead of disabling the budget.
+	failing := &countingTokenCounter{err: errors.New("offline")}
+	cfg = &HistoryConfig{TokenBudget: 150, TokenCounter: failing}
+	got = fitChatHistoryToBudget(context.Background(), ChatRequest{Messages: makeChatHistory(5, 400)}, cfg, 0)
+	if n := EstimateTokens(ChatRequest{Messages: got}); n > 150 {
+		t.Errorf("expected estimator fallback to enforce budget, got %d tokens", n)
+	}
+}
+
+func TestFitHistoryToBudgetCLIAgent(t *testing.T) {
+	h := makeHistory(20)
+	for i := range h {
+		if h[i].Role == "tool" {
+			h[i].Content = strings.Repeat("y", 200)
+		}
+	}
+	got := fitHistoryToBudget(context.Background(), h, nil, &HistoryConfig{TokenBudget: 200})
+	if got[0].Content != "initial" || len(got) >= len(h) {
+		t.Errorf("expected CLI history trimmed with prompt kept, got %d messages", len(got))
+	}
+	if got[len(got)-1].Role != "tool" {
+		t.Errorf("expected roles preserved, got %+v", got[len(got)-1])
+	}
+}
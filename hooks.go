@@ -116,6 +116,21 @@ type PostToolUseHook func(ctx context.Context, hookCtx HookContext, result strin
 // GenericHookHandler is a function called for lifecycle events.
 type GenericHookHandler func(ctx context.Context, data HookEventData)
 
+// PreRequestHook is called before each LLM request an APIAgent sends.
+// Turn is zero-based. The hook may modify req, for example to force a
+// tool call or change sampling for one turn.
+type PreRequestHook func(ctx context.Context, turn int, req *ChatRequest)
+
+// ForceToolOnTurn returns a PreRequestHook that requires the model to call
+// the named tool on the given zero-based turn.
+func ForceToolOnTurn(turn int, name string) PreRequestHook {
+	return func(_ context.Context, t int, req *ChatRequest) {
+		if t == turn {
+			req.ToolChoice = ToolChoiceFor(name)
+		}
+	}
+}
+
 // Hooks configures hook handlers for tool execution.
 // Internally backed by a Store for indexed lookups and removal support.
 type Hooks struct {
@@ -124,6 +139,8 @@ type Hooks struct {
 	// Kept as a direct map since event handlers are simple and don't need indexing.
 	EventHandlers map[HookEvent][]GenericHookHandler
 
+	preRequest []PreRequestHook
+
 	// compiledRegexes caches compiled regexes by pattern.
 	regexMu sync.RWMutex
 	regexes map[string]*regexp.Regexp
@@ -270,6 +287,22 @@ func (h *Hooks) EmitEvent(ctx context.Context, data HookEventData) {
 	}
 }
 
+// OnPreRequest registers a hook that runs before each APIAgent LLM request.
+// Hooks run in registration order.
+func (h *Hooks) OnPreRequest(hook PreRequestHook) {
+	h.preRequest = append(h.preRequest, hook)
+}
+
+// RunPreRequestHooks runs all pre-request hooks on req.
+func (h *Hooks) RunPreRequestHooks(ctx context.Context, turn int, req *ChatRequest) {
+	if h == nil {
+		return
+	}
+	for _, hook := range h.preRequest {
+		hook(ctx, turn, req)
+	}
+}
+
 // matchesToolName checks if a stored hook matches the tool name.
 func (h *Hooks) matchesToolName(sh *StoredHook, toolName string) bool {
 	if sh.Pattern == "*" {
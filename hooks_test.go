@@ -333,3 +333,53 @@ func TestHooksEnhancedResult(t *testing.T) {
 		t.Fatalf("expected continue to be true")
 	}
 }
+
+func TestPreRequestHooksPerTurn(t *testing.T) {
+	provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{ToolCalls: []ToolCall{{ID: "call_1", Name: "lookup", Input: json.RawMessage(`{"key":"a"}`)}}, StopReason: "tool_use"},
+		{Content: "done", StopReason: "end_turn"},
+	}}}
+	tools := NewToolRegistry()
+	tools.Register(ToolDefinition{Name: "lookup", InputSchema: ObjectSchema(map[string]any{"key": StringParam("key")})},
+		func(context.Context, json.RawMessage) (string, error) { return "v", nil })
+
+	hooks := NewHooks()
+	hooks.OnPreRequest(ForceToolOnTurn(0, "lookup"))
+	var turns []int
+	hooks.OnPreRequest(func(_ context.Context, turn int, req *ChatRequest) {
+		turns = append(turns, turn)
+		req.StopSequences = append(req.StopSequences, "STOP")
+	})
+
+	topK := 5
+	agent := NewAPIAgent(APIAgentConfig{
+		Provider:               provider,
+		Tools:                  tools,
+		Hooks:                  hooks,
+		TopK:                   &topK,
+		DisableParallelToolUse: true,
+		Metadata:               &RequestMetadata{UserID: "u"},
+	})
+	if _, err := agent.RunSync(context.Background(), "go"); err != nil {
+		t.Fatalf("RunSync: %v", err)
+	}
+
+	if len(turns) != 2 || turns[0] != 0 || turns[1] != 1 {
+		t.Errorf("turns = %v", turns)
+	}
+	first, second := provider.requests[0], provider.requests[1]
+	if first.ToolChoice == nil || first.ToolChoice.Name != "lookup" {
+		t.Errorf("turn 0 should force lookup, got %+v", first.ToolChoice)
+	}
+	if second.ToolChoice != nil {
+		t.Errorf("turn 1 should not force a tool, got %+v", second.ToolChoice)
+	}
+	for i, req := range provider.requests {
+		if req.TopK == nil || *req.TopK != 5 || !req.DisableParallelToolUse || req.Metadata.UserID != "u" {
+			t.Errorf("request %d missing config controls: %+v", i, req)
+		}
+		if len(req.StopSequences) != 1 {
+			t.Errorf("request %d: hook changes should not leak across turns, got %v", i, req.StopSequences)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package claudeagent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRunNotFound is returned by ResumeRun for a run with no journal.
+	ErrRunNotFound = errors.New("run not found")
+	// ErrRunFinished is returned by ResumeRun for a run that completed.
+	ErrRunFinished = errors.New("run already finished")
+	// ErrToolInterrupted is returned by ResumeRun when an
+	// UnfinishedToolPolicy aborts the resume.
+	ErrToolInterrupted = errors.New("tool call was interrupted")
+)
+
+// JournalEntryType identifies a step of the agent loop.
+type JournalEntryType string
+
+const (
+	// JournalRunStart records the history the run starts from.
+	JournalRunStart JournalEntryType = "run_start"
+	// JournalLLMRequest is written before each provider call.
+	JournalLLMRequest JournalEntryType = "llm_request"
+	// JournalLLMResponse records a provider response and its cost.
+	JournalLLMResponse JournalEntryType = "llm_response"
+	// JournalToolStart is written just before a tool executes.
+	JournalToolStart JournalEntryType = "tool_start"
+	// JournalToolEnd records a tool result.
+	JournalToolEnd JournalEntryType = "tool_end"
+	// JournalMessages records messages appended to the history: assistant
+	// replies, tool results and messages injected by tools.
+	JournalMessages JournalEntryType = "messages"
+	// JournalRunEnd marks a finished run.
+	JournalRunEnd JournalEntryType = "run_end"
+)
+
+// JournalEntry is one step of a journaled run.
+type JournalEntry struct {
+	Seq  int64            `json:"seq"`
+	Type JournalEntryType `json:"type"`
+	Time time.Time        `json:"time"`
+	Turn int              `json:"turn,omitempty"`
+
+	// Messages is set for run_start and messages entries.
+	Messages []ChatMessage `json:"messages,omitempty"`
+	// Model is set for llm_request entries.
+	Model string `json:"model,omitempty"`
+	// Response and CostUSD are set for llm_response entries.
+	Response *ChatResponse `json:"response,omitempty"`
+	CostUSD  float64       `json:"cost_usd,omitempty"`
+	// ToolCall is set for tool_start entries, with the input the tool ran
+	// with.
+	ToolCall *ToolCall `json:"tool_call,omitempty"`
+	// ToolResult is set for tool_end entries.
+	ToolResult *ToolResponse `json:"tool_result,omitempty"`
+	// StopReason is set for run_end entries.
+	StopReason string `json:"stop_reason,omitempty"`
+}
+
+// Journal is an append-only log of agent loop steps, keyed by run ID.
+// Append must be durable when it returns: a run that crashes afterwards is
+// resumed from the entries written so far.
+type Journal interface {
+	Append(ctx context.Context, runID string, entry JournalEntry) error
+	// Entries returns the run's entries in order, or ErrRunNotFound.
+	Entries(ctx context.Context, runID string) ([]JournalEntry, error)
+}
+
+// MemoryJournal is a Journal held in memory, for tests and for resuming
+// runs interrupted by an error rather than a crash.
+type MemoryJournal struct {
+	mu   sync.Mutex
+	runs map[string][]JournalEntry
+}
+
+// NewMemoryJournal creates an empty in-memory journal.
+func NewMemoryJournal() *MemoryJournal {
+	return &MemoryJournal{runs: make(map[string][]JournalEntry)}
+}
+
+// Append adds entry to the run.
+func (m *MemoryJournal) Append(_ context.Context, runID string, entry JournalEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs[runID] = append(m.runs[runID], entry)
+	return nil
+}
+
+// Entries returns a copy of the run's entries.
+func (m *MemoryJournal) Entries(_ context.Context, runID string) ([]JournalEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries, ok := m.runs[runID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+	}
+	return append([]JournalEntry(nil), entries...), nil
+}
+
+// FileJournal is a Journal that appends each run to <dir>/<runID>.jsonl,
+// one JSON entry per line, syncing after every write.
+type FileJournal struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileJournal creates a journal in dir, creating the directory if
+// needed.
+func NewFileJournal(dir string) (*FileJournal, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create journal dir: %w", err)
+	}
+	return &FileJournal{dir: dir}, nil
+}
+
+func (f *FileJournal) path(runID string) (string, error) {
+	if !storeIDPattern.MatchString(runID) {
+		return "", fmt.Errorf("invalid run ID %q", runID)
+	}
+	return filepath.Join(f.dir, runID+".jsonl"), nil
+}
+
+// Append writes entry as a line and syncs the file.
+func (f *FileJournal) Append(_ context.Context, runID string, entry JournalEntry) error {
+	path, err := f.path(runID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encode journal entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fh, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("open journal %s: %w", runID, err)
+	}
+	// Start a new line after a line torn by a crash.
+	if fi, err := fh.Stat(); err == nil && fi.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := fh.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+			data = append([]byte{'\n'}, data...)
+		}
+	}
+	if _, err := fh.Write(data); err != nil {
+		fh.Close()
+		return fmt.Errorf("write journal %s: %w", runID, err)
+	}
+	if err := fh.Sync(); err != nil {
+		fh.Close()
+		return fmt.Errorf("sync journal %s: %w", runID, err)
+	}
+	return fh.Close()
+}
+
+// Entries reads the run's file. Lines torn by a crash mid-write are
+// skipped.
+func (f *FileJournal) Entries(_ context.Context, runID string) ([]JournalEntry, error) {
+	path, err := f.path(runID)
+	if err != nil {
+		return nil, err
+	}
+	fh, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open journal %s: %w", runID, err)
+	}
+	defer fh.Close()
+
+	var entries []JournalEntry
+	sc := bufio.NewScanner(fh)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		var e JournalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read journal %s: %w", runID, err)
+	}
+	return entries, nil
+}
+
+// UnfinishedToolAction tells ResumeRun what to do with a tool call that
+// started but did not finish before the run was interrupted.
+type UnfinishedToolAction int
+
+const (
+	// UnfinishedToolSkip gives the model an error result saying the call was
+	// interrupted, without running it again.
+	UnfinishedToolSkip UnfinishedToolAction = iota
+	// UnfinishedToolRerun runs the call again.
+	UnfinishedToolRerun
+	// UnfinishedToolAbort makes ResumeRun fail with ErrToolInterrupted.
+	UnfinishedToolAbort
+)
+
+// UnfinishedToolPolicy decides how ResumeRun handles an interrupted call to
+// a tool that is not annotated Idempotent or ReadOnly. Such tools may have
+// had side effects before the crash.
+type UnfinishedToolPolicy func(ctx context.Context, call ToolCall) UnfinishedToolAction
+
+// interruptedToolResult is the result given to the model for a skipped
+// call.
+const interruptedToolResult = "Tool call was interrupted before it finished and was not retried; its effects are unknown."
+
+// runJournal writes one run's entries. A nil *runJournal records nothing.
+type runJournal struct {
+	journal Journal
+	runID   string
+
+	mu  sync.Mutex
+	seq int64
+}
+
+func (r *runJournal) record(ctx context.Context, e JournalEntry) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	e.Seq = r.seq
+	e.Time = time.Now().UTC()
+	// Record the end of a canceled run too.
+	if err := r.journal.Append(context.WithoutCancel(ctx), r.runID, e); err != nil {
+		return fmt.Errorf("journal run %s: %w", r.runID, err)
+	}
+	return nil
+}
+
+// runJournalKey is the context key for the run's journal, so tool
+// execution can record calls.
+type runJournalKey struct{}
+
+func withRunJournal(ctx context.Context, r *runJournal) context.Context {
+	return context.WithValue(ctx, runJournalKey{}, r)
+}
+
+func runJournalFor(ctx context.Context) *runJournal {
+	r, _ := ctx.Value(runJournalKey{}).(*runJournal)
+	return r
+}
+
+// pendingTools are the tool calls of the turn a run was interrupted in.
+type pendingTools struct {
+	calls []ToolCall
+	// results holds journaled results and results for skipped calls.
+	results map[string]ToolResponse
+}
+
+// journalReplay is the loop state rebuilt from a journal.
+type journalReplay struct {
+	history  []ChatMessage
+	usage    SessionUsage
+	turn     int
+	lastSeq  int64
+	finished bool
+	// started and ended hold the IDs of tool calls of the last turn.
+	started map[string]bool
+	ended   map[string]ToolResponse
+}
+
+func replayJournal(entries []JournalEntry) *journalReplay {
+	r := &journalReplay{started: map[string]bool{}, ended: map[string]ToolResponse{}}
+	turns := map[int]bool{}
+	var first, last time.Time
+	for _, e := range entries {
+		if first.IsZero() {
+			first = e.Time
+		}
+		last = e.Time
+		r.lastSeq = e.Seq
+		switch e.Type {
+		case JournalRunStart:
+			r.history = append(r.history[:0], e.Messages...)
+		case JournalLLMResponse:
+			if e.Response != nil {
+				r.usage.InputTokens += e.Response.Usage.InputTokens
+				r.usage.OutputTokens += e.Response.Usage.OutputTokens
+			}
+			r.usage.CostUSD += e.CostUSD
+			turns[e.Turn] = true
+			r.turn = e.Turn + 1
+		case JournalMessages:
+			for _, m := range e.Messages {
+				if m.Role == ChatRoleAssistant && len(m.ToolCalls) > 0 {
+					// A new tool turn: earlier tool entries are settled.
+					r.started = map[string]bool{}
+					r.ended = map[string]ToolResponse{}
+				}
+			}
+			r.history = append(r.history, e.Messages...)
+		case JournalToolStart:
+			if e.ToolCall != nil {
+				r.started[e.ToolCall.ID] = true
+			}
+		case JournalToolEnd:
+			if e.ToolResult != nil {
+				r.ended[e.ToolResult.ToolUseID] = *e.ToolResult
+			}
+		case JournalRunEnd:
+			r.finished = true
+		}
+	}
+	r.usage.Turns = len(turns)
+	r.usage.Duration = last.Sub(first)
+	return r
+}
+
+// ResumeRun continues a journaled run that was interrupted, e.g. by a
+// crash, an error or a canceled context. It rebuilds the history, usage and
+// turn count from the journal and carries on from the last step.
+//
+// If the run was interrupted during tool execution, journaled tool results
+// are reused rather than re-run. Calls that started but did not finish are
+// run again if their tool is annotated Idempotent or ReadOnly; otherwise
+// the agent's UnfinishedToolPolicy decides, defaulting to
+// UnfinishedToolSkip.
+func (a *APIAgent) ResumeRun(ctx context.Context, runID string) (<-chan AgentEvent, error) {
+	if a.journal == nil {
+		return nil, errors.New("agent has no Journal")
+	}
+	entries, err := a.journal.Entries(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	r := replayJournal(entries)
+	if r.finished {
+		return nil, fmt.Errorf("%w: %s", ErrRunFinished, runID)
+	}
+	if len(r.history) == 0 {
+		return nil, fmt.Errorf("journal for run %s has no run_start entry", runID)
+	}
+
+	st := &runState{
+		history:   r.history,
+		todos:     a.todoStore,
+		budget:    resumeBudgetTracker(a.budget, r.usage),
+		usage:     r.usage,
+		startTurn: r.turn,
+		journal:   &runJournal{journal: a.journal, runID: runID, seq: r.lastSeq},
+		steering:  steeringFor(ctx),
+	}
+	st.steering.open()
+	if last := r.history[len(r.history)-1]; last.Role == ChatRoleAssistant && len(last.ToolCalls) > 0 {
+		if st.pending, err = a.pendingTools(ctx, last.ToolCalls, r); err != nil {
+			return nil, err
+		}
+	}
+	events := make(chan AgentEvent, 100)
+	go a.runLoop(ctx, st, events)
+	return events, nil
+}
+
+// pendingTools sorts the calls of an interrupted turn into finished ones,
+// which keep their results, and ones to run now.
+func (a *APIAgent) pendingTools(ctx context.Context, calls []ToolCall, r *journalReplay) (*pendingTools, error) {
+	p := &pendingTools{calls: calls, results: make(map[string]ToolResponse)}
+	for _, tc := range calls {
+		if res, ok := r.ended[tc.ID]; ok {
+			p.results[tc.ID] = res
+			continue
+		}
+		if !r.started[tc.ID] {
+			continue
+		}
+		action := UnfinishedToolSkip
+		if ann := a.tools.ToolAnnotations(tc.Name); ann != nil && (ann.Idempotent || ann.ReadOnly) {
+			action = UnfinishedToolRerun
+		} else if a.unfinishedTools != nil {
+			action = a.unfinishedTools(ctx, tc)
+		}
+		switch action {
+		case UnfinishedToolRerun:
+		case UnfinishedToolAbort:
+			return nil, fmt.Errorf("%w: %s (%s)", ErrToolInterrupted, tc.Name, tc.ID)
+		default:
+			p.results[tc.ID] = ToolResponse{ToolUseID: tc.ID, Content: interruptedToolResult, IsError: true}
+		}
+	}
+	return p, nil
+}
+
+// resumeTools runs the calls of p that have no result yet and returns all
+// results in call order.
+func (a *APIAgent) resumeTools(ctx context.Context, p *pendingTools, events chan<- AgentEvent) []ToolResponse {
+	var toRun []ToolCall
+	for _, tc := range p.calls {
+		if _, ok := p.results[tc.ID]; !ok {
+			toRun = append(toRun, tc)
+		}
+	}
+	ran := a.executeTools(ctx, toRun, events)
+
+	results := make([]ToolResponse, len(p.calls))
+	for i, tc := range p.calls {
+		if res, ok := p.results[tc.ID]; ok {
+			res := res
+			results[i] = res
+			events <- AgentEvent{Type: AgentEventToolResult, ToolResponse: &res}
+			continue
+		}
+		results[i], ran = ran[0], ran[1:]
+	}
+	return results
+}
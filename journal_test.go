@@ -0,0 +1,321 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// countingTools registers tools that count their executions. "lookup" is
+// idempotent; "charge" and "email" are not.
+func countingTools() (*ToolRegistry, map[string]int) {
+	var mu sync.Mutex
+	runs := map[string]int{}
+	tools := NewToolRegistry()
+	for _, def := range []ToolDefinition{
+		{Name: "lookup", Annotations: &ToolAnnotations{Idempotent: true}},
+		{Name: "charge"},
+		{Name: "email"},
+	} {
+		name := def.Name
+		tools.Register(def, func(context.Context, json.RawMessage) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[name]++
+			return name + " ok", nil
+		})
+	}
+	return tools, runs
+}
+
+// collectRun waits for a run and returns its result and first error.
+func collectRun(events <-chan AgentEvent) (*ResultMessage, error) {
+	var result *ResultMessage
+	var err error
+	for e := range events {
+		if e.Error != nil && err == nil {
+			err = e.Error
+		}
+		if e.Result != nil {
+			result = e.Result
+		}
+	}
+	return result, err
+}
+
+func TestRunJournalsSteps(t *testing.T) {
+	tools, _ := countingTools()
+	journal := NewMemoryJournal()
+	agent := NewAPIAgent(APIAgentConfig{
+		Provider: &cassetteScriptProvider{responses: []ChatResponse{
+			{ToolCalls: []ToolCall{{ID: "t1", Name: "lookup", Input: json.RawMessage(`{}`)}}, StopReason: "tool_use"},
+			{Content: "Done.", StopReason: "end_turn"},
+		}},
+		Tools:   tools,
+		Journal: journal,
+	})
+
+	events, err := agent.RunWithID(context.Background(), "run_1", "go")
+	if err != nil {
+		t.Fatalf("RunWithID: %v", err)
+	}
+	result, err := collectRun(events)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if result.SessionID != "run_1" {
+		t.Errorf("result should carry the run ID, got %q", result.SessionID)
+	}
+
+	entries, err := journal.Entries(context.Background(), "run_1")
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	var types []JournalEntryType
+	for i, e := range entries {
+		if e.Seq != int64(i+1) {
+			t.Errorf("entry %d has seq %d", i, e.Seq)
+		}
+		types = append(types, e.Type)
+	}
+	want := []JournalEntryType{
+		JournalRunStart,
+		JournalLLMRequest, JournalLLMResponse, JournalMessages,
+		JournalToolStart, JournalToolEnd, JournalMessages,
+		JournalLLMRequest, JournalLLMResponse, JournalMessages, JournalRunEnd,
+	}
+	if len(types) != len(want) {
+		t.Fatalf("journal types = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("journal types = %v, want %v", types, want)
+		}
+	}
+
+	if _, err := agent.ResumeRun(context.Background(), "run_1"); !errors.Is(err, ErrRunFinished) {
+		t.Errorf("resuming a finished run: %v", err)
+	}
+	if _, err := agent.ResumeRun(context.Background(), "missing"); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("resuming an unknown run: %v", err)
+	}
+}
+
+func TestResumeRunAfterProviderFailure(t *testing.T) {
+	tools, runs := countingTools()
+	journal := NewMemoryJournal()
+	first := NewAPIAgent(APIAgentConfig{
+		Provider: &cassetteScriptProvider{responses: []ChatResponse{
+			{ToolCalls: []ToolCall{{ID: "t1", Name: "charge", Input: json.RawMessage(`{}`)}},
+				StopReason: "tool_use", Usage: ChatUsage{InputTokens: 10, OutputTokens: 5}},
+		}},
+		Tools:   tools,
+		Journal: journal,
+	})
+	events, _ := first.RunWithID(context.Background(), "run_2", "pay the invoice")
+	if _, err := collectRun(events); err == nil {
+		t.Fatal("expected the exhausted script to fail the run")
+	}
+
+	provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{Content: "Paid.", StopReason: "end_turn", Usage: ChatUsage{InputTokens: 20, OutputTokens: 2}},
+	}}}
+	second := NewAPIAgent(APIAgentConfig{Provider: provider, Tools: tools, Journal: journal})
+	events, err := second.ResumeRun(context.Background(), "run_2")
+	if err != nil {
+		t.Fatalf("ResumeRun: %v", err)
+	}
+	result, err := collectRun(events)
+	if err != nil {
+		t.Fatalf("resumed run: %v", err)
+	}
+
+	if runs["charge"] != 1 {
+		t.Errorf("completed tool call should not run again, ran %d times", runs["charge"])
+	}
+	msgs := provider.requests[0].Messages
+	if len(msgs) != 3 || msgs[0].Content != "pay the invoice" || msgs[2].Content != "charge ok" {
+		t.Errorf("resumed request should carry the journaled history, got %+v", msgs)
+	}
+	if result.NumTurns != 2 || result.InputTokens != 30 {
+		t.Errorf("result should include usage from before the resume: %+v", result)
+	}
+}
+
+func TestResumeSessionRun(t *testing.T) {
+	tools, runs := countingTools()
+	journal := NewMemoryJournal()
+	first := NewAPIAgent(APIAgentConfig{
+		Provider: &cassetteScriptProvider{responses: []ChatResponse{
+			{Content: "Hello.", StopReason: "end_turn"},
+			{ToolCalls: []ToolCall{{ID: "t1", Name: "charge", Input: json.RawMessage(`{}`)}}, StopReason: "tool_use"},
+		}},
+		Tools:   tools,
+		Journal: journal,
+	})
+	session := first.NewSession()
+	if _, err := session.SendSync(context.Background(), "hi"); err != nil {
+		t.Fatalf("SendSync: %v", err)
+	}
+	firstRun := session.RunID()
+	events, err := session.Send(context.Background(), "pay the invoice")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if _, err := collectRun(events); err == nil {
+		t.Fatal("expected the exhausted script to fail the run")
+	}
+	runID := session.RunID()
+	if runID == "" || runID == firstRun {
+		t.Fatalf("each session run should get its own run ID, got %q then %q", firstRun, runID)
+	}
+
+	provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{Content: "Paid.", StopReason: "end_turn"},
+	}}}
+	second := NewAPIAgent(APIAgentConfig{Provider: provider, Tools: tools, Journal: journal})
+	events, err = second.ResumeRun(context.Background(), runID)
+	if err != nil {
+		t.Fatalf("ResumeRun: %v", err)
+	}
+	result, err := collectRun(events)
+	if err != nil {
+		t.Fatalf("resumed run: %v", err)
+	}
+
+	if runs["charge"] != 1 {
+		t.Errorf("completed tool call should not run again, ran %d times", runs["charge"])
+	}
+	msgs := provider.requests[0].Messages
+	if len(msgs) != 5 || msgs[0].Content != "hi" || msgs[2].Content != "pay the invoice" || msgs[4].Content != "charge ok" {
+		t.Errorf("resumed request should carry the session history, got %+v", msgs)
+	}
+	if result.SessionID != runID {
+		t.Errorf("result should carry the run ID %q, got %q", runID, result.SessionID)
+	}
+}
+
+func TestResumeRunInterruptedTools(t *testing.T) {
+	tools, runs := countingTools()
+	journal := NewMemoryJournal()
+	ctx := context.Background()
+	calls := []ToolCall{
+		{ID: "a", Name: "charge", Input: json.RawMessage(`{}`)},
+		{ID: "b", Name: "lookup", Input: json.RawMessage(`{}`)},
+		{ID: "c", Name: "charge", Input: json.RawMessage(`{}`)},
+		{ID: "d", Name: "email", Input: json.RawMessage(`{}`)},
+	}
+	// The worker died while b and c were running; d never started.
+	for _, e := range []JournalEntry{
+		{Seq: 1, Type: JournalRunStart, Messages: []ChatMessage{{Role: ChatRoleUser, Content: "go"}}},
+		{Seq: 2, Type: JournalLLMResponse, Response: &ChatResponse{ToolCalls: calls}},
+		{Seq: 3, Type: JournalMessages, Messages: []ChatMessage{{Role: ChatRoleAssistant, ToolCalls: calls}}},
+		{Seq: 4, Type: JournalToolStart, ToolCall: &calls[0]},
+		{Seq: 5, Type: JournalToolEnd, ToolResult: &ToolResponse{ToolUseID: "a", Content: "charged once"}},
+		{Seq: 6, Type: JournalToolStart, ToolCall: &calls[1]},
+		{Seq: 7, Type: JournalToolStart, ToolCall: &calls[2]},
+	} {
+		if err := journal.Append(ctx, "run_3", e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var asked []string
+	provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{Content: "Done.", StopReason: "end_turn"},
+	}}}
+	agent := NewAPIAgent(APIAgentConfig{
+		Provider: provider,
+		Tools:    tools,
+		Journal:  journal,
+		UnfinishedToolPolicy: func(_ context.Context, call ToolCall) UnfinishedToolAction {
+			asked = append(asked, call.ID)
+			return UnfinishedToolSkip
+		},
+	})
+	events, err := agent.ResumeRun(ctx, "run_3")
+	if err != nil {
+		t.Fatalf("ResumeRun: %v", err)
+	}
+	if _, err := collectRun(events); err != nil {
+		t.Fatalf("resumed run: %v", err)
+	}
+
+	if len(asked) != 1 || asked[0] != "c" {
+		t.Errorf("policy should be asked about the unfinished non-idempotent call only, got %v", asked)
+	}
+	if runs["charge"] != 0 || runs["lookup"] != 1 || runs["email"] != 1 {
+		t.Errorf("unexpected executions: %v", runs)
+	}
+	msgs := provider.requests[0].Messages
+	if len(msgs) != 6 {
+		t.Fatalf("expected 4 tool results after the assistant message, got %+v", msgs)
+	}
+	if msgs[2].Content != "charged once" || msgs[3].Content != "lookup ok" ||
+		!msgs[4].IsError || msgs[4].Content != interruptedToolResult || msgs[5].Content != "email ok" {
+		t.Errorf("unexpected tool results: %+v", msgs[2:])
+	}
+
+	// A policy can refuse to continue.
+	abort := NewAPIAgent(APIAgentConfig{
+		Provider:             provider,
+		Tools:                tools,
+		Journal:              journal,
+		UnfinishedToolPolicy: func(context.Context, ToolCall) UnfinishedToolAction { return UnfinishedToolAbort },
+	})
+	for _, e := range []JournalEntry{
+		{Seq: 1, Type: JournalRunStart, Messages: []ChatMessage{{Role: ChatRoleUser, Content: "go"}}},
+		{Seq: 2, Type: JournalMessages, Messages: []ChatMessage{{Role: ChatRoleAssistant, ToolCalls: calls[:1]}}},
+		{Seq: 3, Type: JournalToolStart, ToolCall: &calls[0]},
+	} {
+		_ = journal.Append(ctx, "run_4", e)
+	}
+	if _, err := abort.ResumeRun(ctx, "run_4"); !errors.Is(err, ErrToolInterrupted) {
+		t.Errorf("abort policy should fail the resume, got %v", err)
+	}
+}
+
+func TestFileJournal(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := NewFileJournal(dir)
+	if err != nil {
+		t.Fatalf("NewFileJournal: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := journal.Entries(ctx, "run_x"); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("Entries of a missing run: %v", err)
+	}
+	for i := 1; i <= 2; i++ {
+		if err := journal.Append(ctx, "run_x", JournalEntry{Seq: int64(i), Type: JournalMessages,
+			Messages: []ChatMessage{{Role: ChatRoleUser, Content: "m"}}}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	// Simulate a crash in the middle of a write.
+	f, err := os.OpenFile(filepath.Join(dir, "run_x.jsonl"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"seq":3,"type":"mess`)
+	f.Close()
+
+	// A resumed run keeps appending after the torn line.
+	if err := journal.Append(ctx, "run_x", JournalEntry{Seq: 3, Type: JournalRunEnd}); err != nil {
+		t.Fatalf("Append after torn line: %v", err)
+	}
+
+	entries, err := journal.Entries(ctx, "run_x")
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(entries) != 3 || entries[1].Messages[0].Content != "m" || entries[2].Type != JournalRunEnd {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+	if err := journal.Append(ctx, "../x", JournalEntry{}); err == nil {
+		t.Error("path-like run IDs should be rejected")
+	}
+}
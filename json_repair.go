@@ -0,0 +1,401 @@
+package claudeagent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Fixes reported by RepairJSON.
+const (
+	repairEmptyInput     = "empty input replaced with {}"
+	repairCodeFence      = "removed markdown code fence"
+	repairComments       = "removed comments"
+	repairSingleQuotes   = "converted single-quoted strings"
+	repairUnquotedKeys   = "quoted object keys"
+	repairBareWords      = "quoted bare words"
+	repairPythonLiterals = "converted Python literals"
+	repairControlChars   = "escaped control characters in strings"
+	repairTrailingCommas = "removed trailing commas"
+	repairExtraClosers   = "removed unmatched closing brackets"
+	repairUnterminated   = "closed unterminated string"
+	repairIncompletePair = "removed incomplete trailing key"
+	repairUnclosed       = "closed unclosed brackets"
+)
+
+// RepairJSON fixes common breakage in model-written JSON: trailing commas,
+// single quotes, unquoted keys, Python literals, comments, code fences, raw
+// newlines in strings, and input truncated mid-object. It returns the input
+// unchanged with no fixes when it is already valid, and an error when it
+// cannot produce valid JSON.
+func RepairJSON(raw []byte) (json.RawMessage, []string, error) {
+	if json.Valid(raw) {
+		return json.RawMessage(raw), nil, nil
+	}
+	r := &jsonRepairer{src: strings.TrimSpace(string(raw)), keyStart: -1}
+	if r.src == "" {
+		return json.RawMessage(`{}`), []string{repairEmptyInput}, nil
+	}
+	r.stripFence()
+	r.run()
+	out := []byte(r.out.String())
+	if !json.Valid(out) {
+		return nil, r.fixes, errors.New("input could not be repaired")
+	}
+	return json.RawMessage(out), r.fixes, nil
+}
+
+// jsonRepairer rewrites src into out in a single pass.
+type jsonRepairer struct {
+	src   string
+	out   strings.Builder
+	fixes []string
+	// stack holds the open '{' and '[' brackets.
+	stack []byte
+	// expectKey is set inside an object where the next token is a key.
+	expectKey bool
+	// keyStart is the output offset just before the last key, while the
+	// key is waiting for its ':'; -1 otherwise.
+	keyStart int
+}
+
+func (r *jsonRepairer) fix(f string) {
+	for _, existing := range r.fixes {
+		if existing == f {
+			return
+		}
+	}
+	r.fixes = append(r.fixes, f)
+}
+
+func (r *jsonRepairer) stripFence() {
+	if !strings.HasPrefix(r.src, "```") {
+		return
+	}
+	if nl := strings.IndexByte(r.src, '\n'); nl >= 0 {
+		r.src = r.src[nl+1:]
+	} else {
+		r.src = strings.TrimLeft(r.src, "`")
+	}
+	r.src = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(r.src), "```"))
+	r.fix(repairCodeFence)
+}
+
+func (r *jsonRepairer) inObject() bool {
+	return len(r.stack) > 0 && r.stack[len(r.stack)-1] == '{'
+}
+
+// beginToken records where a key starts so a truncated pair can be dropped.
+func (r *jsonRepairer) beginToken() {
+	if r.inObject() && r.expectKey {
+		r.keyStart = r.out.Len()
+		r.expectKey = false
+	}
+}
+
+func (r *jsonRepairer) run() {
+	s := r.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			r.out.WriteByte(c)
+			i++
+		case c == '/' && i+1 < len(s) && (s[i+1] == '/' || s[i+1] == '*'):
+			i = r.skipComment(i)
+		case c == '"' || c == '\'':
+			r.beginToken()
+			i = r.readString(i)
+		case c == '{' || c == '[':
+			r.stack = append(r.stack, c)
+			r.expectKey = c == '{'
+			r.out.WriteByte(c)
+			i++
+		case c == '}' || c == ']':
+			r.closeBracket(c)
+			i++
+		case c == ',':
+			r.out.WriteByte(c)
+			r.expectKey = r.inObject()
+			r.keyStart = -1
+			i++
+		case c == ':':
+			r.out.WriteByte(c)
+			r.keyStart = -1
+			i++
+		case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+			r.beginToken()
+			j := i
+			for j < len(s) && strings.IndexByte("0123456789+-.eE", s[j]) >= 0 {
+				j++
+			}
+			r.out.WriteString(strings.TrimPrefix(s[i:j], "+"))
+			i = j
+		case isIdentStart(c):
+			wasKey := r.inObject() && r.expectKey
+			r.beginToken()
+			j := i
+			for j < len(s) && isIdentPart(s[j]) {
+				j++
+			}
+			r.writeWord(s[i:j], wasKey)
+			i = j
+		default:
+			r.out.WriteByte(c)
+			i++
+		}
+	}
+	r.finish()
+}
+
+func (r *jsonRepairer) skipComment(i int) int {
+	r.fix(repairComments)
+	if r.src[i+1] == '/' {
+		if nl := strings.IndexByte(r.src[i:], '\n'); nl >= 0 {
+			return i + nl
+		}
+		return len(r.src)
+	}
+	if end := strings.Index(r.src[i+2:], "*/"); end >= 0 {
+		return i + 2 + end + 2
+	}
+	return len(r.src)
+}
+
+// readString copies the string starting at src[i], converting single quotes
+// and escaping raw control characters. It returns the index after it.
+func (r *jsonRepairer) readString(i int) int {
+	quote := r.src[i]
+	if quote == '\'' {
+		r.fix(repairSingleQuotes)
+	}
+	r.out.WriteByte('"')
+	for i++; i < len(r.src); i++ {
+		c := r.src[i]
+		switch {
+		case c == '\\' && i+1 < len(r.src):
+			i++
+			if r.src[i] == '\'' {
+				r.out.WriteByte('\'')
+			} else {
+				r.out.WriteByte('\\')
+				r.out.WriteByte(r.src[i])
+			}
+		case c == '\\':
+			// A lone trailing backslash from truncation.
+		case c == quote:
+			r.out.WriteByte('"')
+			return i + 1
+		case c == '"':
+			r.out.WriteString(`\"`)
+		case c == '\n':
+			r.fix(repairControlChars)
+			r.out.WriteString(`\n`)
+		case c == '\r':
+			r.fix(repairControlChars)
+			r.out.WriteString(`\r`)
+		case c == '\t':
+			r.fix(repairControlChars)
+			r.out.WriteString(`\t`)
+		default:
+			r.out.WriteByte(c)
+		}
+	}
+	r.fix(repairUnterminated)
+	r.out.WriteByte('"')
+	return i
+}
+
+// writeWord writes a bare identifier as a literal, a quoted key or a
+// quoted string.
+func (r *jsonRepairer) writeWord(word string, isKey bool) {
+	if isKey {
+		r.fix(repairUnquotedKeys)
+		r.out.WriteString(jsonString(word))
+		return
+	}
+	switch word {
+	case "true", "false", "null":
+		r.out.WriteString(word)
+	case "True", "False", "None":
+		r.fix(repairPythonLiterals)
+		r.out.WriteString(map[string]string{"True": "true", "False": "false", "None": "null"}[word])
+	default:
+		r.fix(repairBareWords)
+		r.out.WriteString(jsonString(word))
+	}
+}
+
+// closeBracket writes c, dropping a trailing comma before it and closing
+// any brackets left open inside it.
+func (r *jsonRepairer) closeBracket(c byte) {
+	open := byte('{')
+	if c == ']' {
+		open = '['
+	}
+	if !containsByte(r.stack, open) {
+		r.fix(repairExtraClosers)
+		return
+	}
+	r.trimTrailingComma()
+	for r.stack[len(r.stack)-1] != open {
+		r.fix(repairUnclosed)
+		r.popBracket()
+	}
+	r.popBracket()
+}
+
+func (r *jsonRepairer) popBracket() {
+	top := r.stack[len(r.stack)-1]
+	r.stack = r.stack[:len(r.stack)-1]
+	if top == '{' {
+		r.out.WriteByte('}')
+	} else {
+		r.out.WriteByte(']')
+	}
+	r.expectKey = false
+	r.keyStart = -1
+}
+
+func (r *jsonRepairer) trimTrailingComma() {
+	s := strings.TrimRight(r.out.String(), " \t\r\n")
+	if strings.HasSuffix(s, ",") {
+		r.fix(repairTrailingCommas)
+		r.reset(strings.TrimSuffix(s, ","))
+	}
+}
+
+func (r *jsonRepairer) reset(s string) {
+	r.out.Reset()
+	r.out.WriteString(s)
+}
+
+// finish completes input that was cut off: it drops a key with no value,
+// fills in a missing value and closes open brackets.
+func (r *jsonRepairer) finish() {
+	if len(r.stack) == 0 {
+		return
+	}
+	if r.keyStart >= 0 {
+		r.fix(repairIncompletePair)
+		r.reset(r.out.String()[:r.keyStart])
+	}
+	s := strings.TrimRight(r.out.String(), " \t\r\n")
+	if strings.HasSuffix(s, ":") {
+		r.fix(repairIncompletePair)
+		// Drop the key too: find the string that precedes the colon.
+		s = strings.TrimRight(strings.TrimSuffix(s, ":"), " \t\r\n")
+		if j := lastStringStart(s); j >= 0 {
+			s = s[:j]
+		}
+	}
+	r.reset(s)
+	r.trimTrailingComma()
+	r.fix(repairUnclosed)
+	for len(r.stack) > 0 {
+		r.popBracket()
+	}
+}
+
+// lastStringStart returns the index of the opening quote of the JSON string
+// that ends s, or -1.
+func lastStringStart(s string) int {
+	if !strings.HasSuffix(s, `"`) {
+		return -1
+	}
+	for j := len(s) - 2; j >= 0; j-- {
+		if s[j] != '"' {
+			continue
+		}
+		backslashes := 0
+		for k := j - 1; k >= 0 && s[k] == '\\'; k-- {
+			backslashes++
+		}
+		if backslashes%2 == 0 {
+			return j
+		}
+	}
+	return -1
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+func containsByte(s []byte, c byte) bool {
+	for _, b := range s {
+		if b == c {
+			return true
+		}
+	}
+	return false
+}
+
+// toolInputError describes input that is not valid JSON, or that does not
+// match the tool's schema, in terms the model can act on.
+func toolInputError(def *ToolDefinition, input []byte, cause error) string {
+	var sb strings.Builder
+	sb.WriteString("Invalid tool input: ")
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(cause, &syntaxErr):
+		fmt.Fprintf(&sb, "not valid JSON (%s at byte %d, near %q)", syntaxErr.Error(), syntaxErr.Offset, snippetAround(input, int(syntaxErr.Offset)))
+	case errors.As(cause, &typeErr):
+		var v any
+		var problems []string
+		if def != nil && def.InputSchema != nil && json.Unmarshal(input, &v) == nil {
+			problems = ValidateJSONSchema(def.InputSchema, v)
+		}
+		if len(problems) > 0 {
+			sb.WriteString(strings.Join(problems, "; "))
+		} else {
+			fmt.Fprintf(&sb, "field %q must be %s, got %s", typeErr.Field, jsonKindOf(typeErr.Type.Kind().String()), typeErr.Value)
+		}
+	default:
+		sb.WriteString(cause.Error())
+	}
+
+	if def != nil && def.InputSchema != nil {
+		sb.WriteString(". Send a single JSON object matching this schema: ")
+		sb.WriteString(jsonString(def.InputSchema))
+	}
+	return sb.String()
+}
+
+// jsonKindOf names a Go kind as a JSON type.
+func jsonKindOf(kind string) string {
+	switch kind {
+	case "string":
+		return "a string"
+	case "bool":
+		return "a boolean"
+	case "slice", "array":
+		return "an array"
+	case "map", "struct":
+		return "an object"
+	case "float32", "float64":
+		return "a number"
+	default:
+		if strings.HasPrefix(kind, "int") || strings.HasPrefix(kind, "uint") {
+			return "an integer"
+		}
+		return kind
+	}
+}
+
+// snippetAround returns up to 20 bytes of input on each side of offset.
+func snippetAround(input []byte, offset int) string {
+	start := max(0, offset-20)
+	end := min(len(input), offset+20)
+	if start > end {
+		return ""
+	}
+	return string(input[start:end])
+}
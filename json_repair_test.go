@@ -0,0 +1,143 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRepairJSON(t *testing.T) {
+	cases := []struct {
+		in, want string
+		fix      string
+	}{
+		{`{"a": 1, "b": [1, 2,],}`, `{"a": 1, "b": [1, 2]}`, repairTrailingCommas},
+		{`{'city': 'Paris', 'note': 'say "hi"'}`, `{"city": "Paris", "note": "say \"hi\""}`, repairSingleQuotes},
+		{`{city: "Paris", days: 3}`, `{"city": "Paris", "days": 3}`, repairUnquotedKeys},
+		{`{"ok": True, "v": None}`, `{"ok": true, "v": null}`, repairPythonLiterals},
+		{`{"a": {"b": [1, 2`, `{"a": {"b": [1, 2]}}`, repairUnclosed},
+		{`{"a": 1, "b": "trunc`, `{"a": 1, "b": "trunc"}`, repairUnterminated},
+		{`{"a": 1, "b":`, `{"a": 1}`, repairIncompletePair},
+		{`{"a": 1, "b"`, `{"a": 1}`, repairIncompletePair},
+		{`{"a": [1, 2}`, `{"a": [1, 2]}`, repairUnclosed},
+		{"```json\n{\"a\": 1}\n```", `{"a": 1}`, repairCodeFence},
+		{"{\"a\": 1 // one\n}", "{\"a\": 1 \n}", repairComments},
+		{"{\"text\": \"line1\nline2\"}", `{"text": "line1\nline2"}`, repairControlChars},
+		{`{"a": 1}}`, `{"a": 1}`, repairExtraClosers},
+		{``, `{}`, repairEmptyInput},
+	}
+	for _, c := range cases {
+		got, fixes, err := RepairJSON([]byte(c.in))
+		if err != nil {
+			t.Errorf("%q: %v", c.in, err)
+			continue
+		}
+		if string(got) != c.want {
+			t.Errorf("%q: got %s, want %s", c.in, got, c.want)
+		}
+		if !containsString(fixes, c.fix) {
+			t.Errorf("%q: fixes %v missing %q", c.in, fixes, c.fix)
+		}
+	}
+
+	if got, fixes, err := RepairJSON([]byte(`{"a":1}`)); err != nil || fixes != nil || string(got) != `{"a":1}` {
+		t.Errorf("valid input should pass through: %s %v %v", got, fixes, err)
+	}
+	if _, _, err := RepairJSON([]byte(`{"a": @@}`)); err == nil {
+		t.Error("expected an error for unrepairable input")
+	}
+}
+
+type weatherInput struct {
+	City string `json:"city"`
+	Days int    `json:"days"`
+}
+
+func repairTestRegistry(got *weatherInput) *ToolRegistry {
+	tools := NewToolRegistry()
+	RegisterFunc(tools, ToolDefinition{
+		Name: "forecast",
+		InputSchema: ObjectSchema(map[string]any{
+			"city": map[string]any{"type": "string"},
+			"days": map[string]any{"type": "integer"},
+		}, "city"),
+	}, func(_ context.Context, in weatherInput) (string, error) {
+		*got = in
+		return "sunny", nil
+	})
+	return tools
+}
+
+func TestExecuteOneToolRepairsInput(t *testing.T) {
+	var got weatherInput
+	tools := repairTestRegistry(&got)
+	mc := NewMetricsCollector()
+	events := make(chan AgentEvent, 10)
+
+	tc := ToolCall{ID: "1", Name: "forecast", Input: json.RawMessage(`{'city': 'Oslo', days: 3,`)}
+	resp := executeOneTool(context.Background(), tc, tools, nil, nil, nil, true, mc, events)
+	if resp.IsError || resp.Content != "sunny" || got.City != "Oslo" || got.Days != 3 {
+		t.Fatalf("expected repaired call to succeed: %+v %+v", resp, got)
+	}
+	if resp.Metadata == nil || string(resp.Metadata.RepairedInput) != `{"city": "Oslo", "days": 3}` ||
+		!containsString(resp.Metadata.InputRepairs, repairSingleQuotes) {
+		t.Errorf("repair not recorded in metadata: %+v", resp.Metadata)
+	}
+
+	// Unrepairable input gets a schema-aware error.
+	tc = ToolCall{ID: "2", Name: "forecast", Input: json.RawMessage(`{"city": "Oslo", "days": #}`)}
+	resp = executeOneTool(context.Background(), tc, tools, nil, nil, nil, true, mc, events)
+	if !resp.IsError || !strings.Contains(resp.Content, "not valid JSON") || !strings.Contains(resp.Content, `"required":["city"]`) {
+		t.Errorf("unexpected error content: %s", resp.Content)
+	}
+
+	// Valid JSON of the wrong type is reported against the schema.
+	tc = ToolCall{ID: "3", Name: "forecast", Input: json.RawMessage(`{"city": "Oslo", "days": "three"}`)}
+	resp = executeOneTool(context.Background(), tc, tools, nil, nil, nil, true, mc, events)
+	if !resp.IsError || !strings.Contains(resp.Content, "$.days: expected integer, got string") {
+		t.Errorf("unexpected error content: %s", resp.Content)
+	}
+
+	// The permission callback sees the repaired input.
+	var checked json.RawMessage
+	canUse := func(_ context.Context, _, _ string, input json.RawMessage) PermissionDecision {
+		checked = input
+		return PermissionDecision{Allow: true}
+	}
+	tc = ToolCall{ID: "5", Name: "forecast", Input: json.RawMessage(`{'city': 'Bergen'}`)}
+	resp = executeOneTool(context.Background(), tc, tools, nil, canUse, nil, true, nil, events)
+	if resp.IsError || string(checked) != `{"city": "Bergen"}` {
+		t.Errorf("canUseTool saw %s, want the repaired input (%+v)", checked, resp)
+	}
+
+	stats := mc.Snapshot().ToolStats["forecast"]
+	if stats.InputRepairs != 1 || stats.InputRepairFailures != 1 {
+		t.Errorf("unexpected repair stats: %+v", stats)
+	}
+
+	// Without RepairToolInput the handler sees the raw input.
+	tc = ToolCall{ID: "4", Name: "forecast", Input: json.RawMessage(`{"city": "Oslo",}`)}
+	if resp = executeOneTool(context.Background(), tc, tools, nil, nil, nil, false, nil, events); !resp.IsError || resp.Metadata != nil {
+		t.Errorf("expected the unmarshal error without repair: %+v", resp)
+	}
+}
+
+func TestAPIAgentRepairsHistoryInput(t *testing.T) {
+	var got weatherInput
+	provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{ToolCalls: []ToolCall{{ID: "t1", Name: "forecast", Input: json.RawMessage(`{"city": "Oslo", "days": 2,}`)}}, StopReason: "tool_use"},
+		{Content: "Sunny.", StopReason: "end_turn"},
+	}}}
+	agent := NewAPIAgent(APIAgentConfig{Provider: provider, Tools: repairTestRegistry(&got), RepairToolInput: true})
+	if _, err := agent.RunSync(context.Background(), "weather?"); err != nil {
+		t.Fatalf("RunSync: %v", err)
+	}
+	if got.Days != 2 {
+		t.Errorf("handler got %+v", got)
+	}
+	assistant := provider.requests[1].Messages[1]
+	if string(assistant.ToolCalls[0].Input) != `{"city": "Oslo", "days": 2}` {
+		t.Errorf("history should hold the repaired input, got %s", assistant.ToolCalls[0].Input)
+	}
+}
@@ -15,6 +15,11 @@ type ToolStats struct {
 	Failures int
 	// TotalTime is the cumulative execution time across all calls.
 	TotalTime time.Duration
+	// InputRepairs counts calls whose malformed JSON input was repaired
+	// before execution; InputRepairFailures counts calls it could not fix.
+	// Only gathered with RepairToolInput.
+	InputRepairs        int
+	InputRepairFailures int
 }
 
 // AvgTime returns the average execution time per call.
@@ -33,6 +38,33 @@ type TurnMetrics struct {
 	LLMLatency time.Duration
 	// ToolsInvoked lists the names of tools called during this turn (in invocation order).
 	ToolsInvoked []string
+	// CostUSD is the priced cost of this turn's LLM calls. APIAgent only.
+	CostUSD float64
+	// Usage is the token usage of this turn's LLM calls, including prompt
+	// cache reads and writes. APIAgent only.
+	Usage ChatUsage
+}
+
+// PromptCacheStats summarizes provider-side prompt caching for a session.
+type PromptCacheStats struct {
+	// InputTokens are uncached input tokens.
+	InputTokens int
+	// CacheWriteTokens were written to the cache.
+	CacheWriteTokens int
+	// CacheReadTokens were served from the cache.
+	CacheReadTokens int
+	// SavingsUSD is the net saving from caching, priced with the agent's
+	// PricingTable. Negative while writes outweigh reads.
+	SavingsUSD float64
+}
+
+// HitRate returns the fraction of input tokens served from the cache.
+func (s PromptCacheStats) HitRate() float64 {
+	total := s.InputTokens + s.CacheWriteTokens + s.CacheReadTokens
+	if total == 0 {
+		return 0
+	}
+	return float64(s.CacheReadTokens) / float64(total)
 }
 
 // LoopMetrics is a point-in-time snapshot of session-level metrics.
@@ -43,6 +75,13 @@ type LoopMetrics struct {
 	Turns []TurnMetrics
 	// ToolStats maps tool name to aggregated call statistics.
 	ToolStats map[string]*ToolStats
+	// ResponseCacheHits and ResponseCacheMisses count CachingProvider
+	// lookups reported to this collector via ResponseCacheConfig.Metrics.
+	ResponseCacheHits   int
+	ResponseCacheMisses int
+	// PromptCache totals provider prompt-cache usage across every LLM
+	// response in the session. APIAgent only.
+	PromptCache PromptCacheStats
 }
 
 // MetricsCollector gathers per-turn and per-tool metrics during agent execution.
@@ -64,6 +103,9 @@ type MetricsCollector struct {
 	turns          []TurnMetrics
 	toolStats      map[string]*ToolStats
 	toolStartTimes map[string]time.Time // keyed by toolUseID
+	cacheHits      int
+	cacheMisses    int
+	promptCache    PromptCacheStats
 }
 
 // NewMetricsCollector creates a ready-to-use metrics collector.
@@ -90,6 +132,8 @@ func (m *MetricsCollector) Snapshot() LoopMetrics {
 		cp := TurnMetrics{
 			TurnIndex:  t.TurnIndex,
 			LLMLatency: t.LLMLatency,
+			CostUSD:    t.CostUSD,
+			Usage:      t.Usage,
 		}
 		if len(t.ToolsInvoked) > 0 {
 			cp.ToolsInvoked = append([]string(nil), t.ToolsInvoked...)
@@ -104,9 +148,12 @@ func (m *MetricsCollector) Snapshot() LoopMetrics {
 	}
 
 	return LoopMetrics{
-		SessionDuration: dur,
-		Turns:           turns,
-		ToolStats:       stats,
+		SessionDuration:     dur,
+		Turns:               turns,
+		ToolStats:           stats,
+		ResponseCacheHits:   m.cacheHits,
+		ResponseCacheMisses: m.cacheMisses,
+		PromptCache:         m.promptCache,
 	}
 }
 
@@ -134,6 +181,22 @@ func (m *MetricsCollector) recordToolStart(toolUseID string) {
 	m.mu.Unlock()
 }
 
+func (m *MetricsCollector) recordInputRepair(toolName string, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, exists := m.toolStats[toolName]
+	if !exists {
+		stats = &ToolStats{Name: toolName}
+		m.toolStats[toolName] = stats
+	}
+	if ok {
+		stats.InputRepairs++
+	} else {
+		stats.InputRepairFailures++
+	}
+}
+
 func (m *MetricsCollector) recordToolEnd(toolName, toolUseID string, isError bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -156,3 +219,22 @@ func (m *MetricsCollector) recordToolEnd(toolName, toolUseID string, isError boo
 		stats.Failures++
 	}
 }
+
+func (m *MetricsCollector) recordResponseCache(hit bool) {
+	m.mu.Lock()
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+	m.mu.Unlock()
+}
+
+func (m *MetricsCollector) recordPromptCache(u ChatUsage, savings float64) {
+	m.mu.Lock()
+	m.promptCache.InputTokens += u.InputTokens
+	m.promptCache.CacheWriteTokens += u.CacheCreationInputTokens
+	m.promptCache.CacheReadTokens += u.CacheReadInputTokens
+	m.promptCache.SavingsUSD += savings
+	m.mu.Unlock()
+}
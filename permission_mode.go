@@ -0,0 +1,58 @@
+package claudeagent
+
+import (
+	"context"
+	"fmt"
+)
+
+// Valid reports whether m is one of the PermissionMode constants.
+func (m PermissionMode) Valid() bool {
+	switch m {
+	case PermissionDefault, PermissionAcceptEdits, PermissionPlan, PermissionBypassAll:
+		return true
+	}
+	return false
+}
+
+// permissionModeKey is the context key for a run's permission mode.
+type permissionModeKey struct{}
+
+// withPermissionMode makes mode decide tool permissions for the run. It is
+// a function so that a mode changed mid-run applies to the next call.
+func withPermissionMode(ctx context.Context, mode func() PermissionMode) context.Context {
+	return context.WithValue(ctx, permissionModeKey{}, mode)
+}
+
+// applyPermissionMode applies the run's permission mode, if any, to a tool
+// call. It returns a reason to deny the call, or whether CanUseTool can be
+// skipped:
+//
+//   - PermissionPlan runs ReadOnly tools only.
+//   - PermissionAcceptEdits allows tools not marked Destructive.
+//   - PermissionBypassAll allows every tool.
+//   - PermissionDefault asks CanUseTool.
+func applyPermissionMode(ctx context.Context, tools *ToolRegistry, name string) (skipCheck bool, denial string) {
+	mode, _ := ctx.Value(permissionModeKey{}).(func() PermissionMode)
+	if mode == nil {
+		return false, ""
+	}
+	var ann ToolAnnotations
+	if tools != nil {
+		if def := tools.GetToolDef(name); def != nil && def.Annotations != nil {
+			ann = *def.Annotations
+		}
+	}
+	switch mode() {
+	case PermissionPlan:
+		if !ann.ReadOnly {
+			return false, fmt.Sprintf("%s is not read-only and the session is in plan mode", name)
+		}
+		return false, ""
+	case PermissionAcceptEdits:
+		return !ann.Destructive, ""
+	case PermissionBypassAll:
+		return true, ""
+	default:
+		return false, ""
+	}
+}
@@ -0,0 +1,82 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSessionPermissionMode(t *testing.T) {
+	tools := NewToolRegistry()
+	for _, def := range []ToolDefinition{
+		{Name: "read", Annotations: &ToolAnnotations{ReadOnly: true}},
+		{Name: "edit"},
+		{Name: "drop", Annotations: &ToolAnnotations{Destructive: true}},
+	} {
+		tools.Register(def, func(context.Context, json.RawMessage) (string, error) { return "ok", nil })
+	}
+	var asked []string
+	calls := []ToolCall{
+		{ID: "r", Name: "read", Input: json.RawMessage(`{}`)},
+		{ID: "e", Name: "edit", Input: json.RawMessage(`{}`)},
+		{ID: "d", Name: "drop", Input: json.RawMessage(`{}`)},
+	}
+	cases := []struct {
+		mode    PermissionMode
+		asked   string
+		results string
+	}{
+		{PermissionDefault, "read,edit,drop", "denied,denied,denied"},
+		{PermissionPlan, "read", "denied,plan,plan"},
+		{PermissionAcceptEdits, "drop", "ok,ok,denied"},
+		{PermissionBypassAll, "", "ok,ok,ok"},
+	}
+	for _, tc := range cases {
+		provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+			{ToolCalls: calls, StopReason: "tool_use"},
+			{Content: "done", StopReason: "end_turn"},
+		}}}
+		agent := NewAPIAgent(APIAgentConfig{
+			Provider: provider,
+			Tools:    tools,
+			CanUseTool: func(_ context.Context, name, _ string, _ json.RawMessage) PermissionDecision {
+				asked = append(asked, name)
+				return PermissionDecision{Reason: "denied"}
+			},
+		})
+		session := agent.NewSession()
+		if err := session.SetPermissionMode(tc.mode); err != nil {
+			t.Fatal(err)
+		}
+		asked = nil
+		if _, err := session.SendSync(context.Background(), "go"); err != nil {
+			t.Fatalf("%s: %v", tc.mode, err)
+		}
+
+		var results []string
+		for _, m := range provider.requests[1].Messages[2:] {
+			switch {
+			case !m.IsError:
+				results = append(results, m.Content)
+			case strings.Contains(m.Content, "plan mode"):
+				results = append(results, "plan")
+			default:
+				results = append(results, "denied")
+			}
+		}
+		if got := strings.Join(asked, ","); got != tc.asked {
+			t.Errorf("%s: CanUseTool asked for %q, want %q", tc.mode, got, tc.asked)
+		}
+		if got := strings.Join(results, ","); got != tc.results {
+			t.Errorf("%s: results %q, want %q", tc.mode, got, tc.results)
+		}
+		if session.State().PermissionMode != tc.mode {
+			t.Errorf("%s: mode not in state", tc.mode)
+		}
+	}
+
+	if err := NewAPIAgent(APIAgentConfig{}).NewSession().SetPermissionMode("yolo"); err == nil {
+		t.Error("invalid modes should be rejected")
+	}
+}
@@ -0,0 +1,172 @@
+package claudeagent
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ModelPricing holds USD prices per million tokens for one model.
+type ModelPricing struct {
+	InputPerMTok      float64
+	OutputPerMTok     float64
+	CacheWritePerMTok float64
+	// CacheWrite1hPerMTok prices cache writes with CacheTTL1h. Zero falls
+	// back to CacheWritePerMTok.
+	CacheWrite1hPerMTok float64
+	CacheReadPerMTok    float64
+}
+
+// Cost returns the USD cost of one response's usage.
+func (p ModelPricing) Cost(u ChatUsage) float64 {
+	return (float64(u.InputTokens)*p.InputPerMTok +
+		float64(u.OutputTokens)*p.OutputPerMTok +
+		p.cacheWriteCost(u) +
+		float64(u.CacheReadInputTokens)*p.CacheReadPerMTok) / 1e6
+}
+
+// cacheWriteCost returns the cache-write part of Cost, before the division
+// by one million, pricing 1h writes at their own rate.
+func (p ModelPricing) cacheWriteCost(u ChatUsage) float64 {
+	rate1h := p.CacheWrite1hPerMTok
+	if rate1h == 0 {
+		rate1h = p.CacheWritePerMTok
+	}
+	long := min(u.CacheCreation1hInputTokens, u.CacheCreationInputTokens)
+	return float64(u.CacheCreationInputTokens-long)*p.CacheWritePerMTok + float64(long)*rate1h
+}
+
+// CacheSavings returns how much less usage cost than it would have without
+// prompt caching: cache reads are discounted against the input rate and
+// cache writes carry a premium over it. Negative when writes outweigh reads.
+func (p ModelPricing) CacheSavings(u ChatUsage) float64 {
+	saved := float64(u.CacheReadInputTokens) * (p.InputPerMTok - p.CacheReadPerMTok)
+	premium := p.cacheWriteCost(u) - float64(u.CacheCreationInputTokens)*p.InputPerMTok
+	return (saved - premium) / 1e6
+}
+
+// PricingTable maps provider and model to ModelPricing. Model names match
+// by longest prefix, so "claude-sonnet-4" prices "claude-sonnet-4-20250514".
+// Entries registered with an empty provider apply to every provider and are
+// used when no provider-specific entry matches. Safe for concurrent use.
+type PricingTable struct {
+	mu     sync.RWMutex
+	prices map[string]map[string]ModelPricing // provider -> model prefix -> pricing
+}
+
+// NewPricingTable creates an empty pricing table.
+func NewPricingTable() *PricingTable {
+	return &PricingTable{prices: make(map[string]map[string]ModelPricing)}
+}
+
+// DefaultPricingTable returns a new table preloaded with list prices for
+// common Anthropic, OpenAI, DeepSeek and Mistral models. Prices change;
+// override entries with Set when they do.
+func DefaultPricingTable() *PricingTable {
+	t := NewPricingTable()
+	for model, p := range defaultModelPricing {
+		t.Set("", model, p)
+	}
+	return t
+}
+
+// Set registers pricing for a model name or prefix. An empty provider
+// applies to every provider.
+func (t *PricingTable) Set(provider, model string, p ModelPricing) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.prices[provider] == nil {
+		t.prices[provider] = make(map[string]ModelPricing)
+	}
+	t.prices[provider][model] = p
+}
+
+// Lookup returns the pricing for provider and model: the longest matching
+// prefix registered for provider, else for any provider.
+func (t *PricingTable) Lookup(provider, model string) (ModelPricing, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if p, ok := longestPrefix(t.prices[provider], model); ok {
+		return p, true
+	}
+	return longestPrefix(t.prices[""], model)
+}
+
+// Cost returns the USD cost of usage for provider and model, and false if
+// the model has no pricing.
+func (t *PricingTable) Cost(provider, model string, u ChatUsage) (float64, bool) {
+	p, ok := t.Lookup(provider, model)
+	if !ok {
+		return 0, false
+	}
+	return p.Cost(u), true
+}
+
+func longestPrefix(m map[string]ModelPricing, model string) (ModelPricing, bool) {
+	var best string
+	var found bool
+	for prefix := range m {
+		if strings.HasPrefix(model, prefix) && (!found || len(prefix) > len(best)) {
+			best, found = prefix, true
+		}
+	}
+	return m[best], found
+}
+
+// PricingNotFoundError is returned when a cost budget is set but the model
+// that served a request has no entry in the pricing table, so the budget
+// cannot be enforced.
+type PricingNotFoundError struct {
+	Provider string
+	Model    string
+}
+
+func (e *PricingNotFoundError) Error() string {
+	return fmt.Sprintf("no pricing for provider %q model %q: add it to APIAgentConfig.Pricing to enforce MaxCostUSD",
+		e.Provider, e.Model)
+}
+
+// defaultModelPricing lists USD per-million-token list prices.
+var defaultModelPricing = map[string]ModelPricing{
+	// Anthropic. 5-minute cache writes are 1.25x input, 1-hour writes 2x.
+	"claude-opus-4-5":   {InputPerMTok: 5, OutputPerMTok: 25, CacheWritePerMTok: 6.25, CacheWrite1hPerMTok: 10, CacheReadPerMTok: 0.50},
+	"claude-opus-4":     {InputPerMTok: 15, OutputPerMTok: 75, CacheWritePerMTok: 18.75, CacheWrite1hPerMTok: 30, CacheReadPerMTok: 1.50},
+	"claude-sonnet-4":   {InputPerMTok: 3, OutputPerMTok: 15, CacheWritePerMTok: 3.75, CacheWrite1hPerMTok: 6, CacheReadPerMTok: 0.30},
+	"claude-haiku-4-5":  {InputPerMTok: 1, OutputPerMTok: 5, CacheWritePerMTok: 1.25, CacheWrite1hPerMTok: 2, CacheReadPerMTok: 0.10},
+	"claude-3-7-sonnet": {InputPerMTok: 3, OutputPerMTok: 15, CacheWritePerMTok: 3.75, CacheWrite1hPerMTok: 6, CacheReadPerMTok: 0.30},
+	"claude-3-5-sonnet": {InputPerMTok: 3, OutputPerMTok: 15, CacheWritePerMTok: 3.75, CacheWrite1hPerMTok: 6, CacheReadPerMTok: 0.30},
+	"claude-3-5-haiku":  {InputPerMTok: 0.80, OutputPerMTok: 4, CacheWritePerMTok: 1, CacheWrite1hPerMTok: 1.60, CacheReadPerMTok: 0.08},
+	"claude-3-opus":     {InputPerMTok: 15, OutputPerMTok: 75, CacheWritePerMTok: 18.75, CacheWrite1hPerMTok: 30, CacheReadPerMTok: 1.50},
+	"claude-3-sonnet":   {InputPerMTok: 3, OutputPerMTok: 15, CacheWritePerMTok: 3.75, CacheWrite1hPerMTok: 6, CacheReadPerMTok: 0.30},
+	"claude-3-haiku":    {InputPerMTok: 0.25, OutputPerMTok: 1.25, CacheWritePerMTok: 0.30, CacheWrite1hPerMTok: 0.50, CacheReadPerMTok: 0.03},
+
+	// OpenAI. Pro variants get their own entries so the base model's
+	// prefix does not underprice them.
+	"gpt-5":         {InputPerMTok: 1.25, OutputPerMTok: 10, CacheReadPerMTok: 0.125},
+	"gpt-5-mini":    {InputPerMTok: 0.25, OutputPerMTok: 2, CacheReadPerMTok: 0.025},
+	"gpt-5-nano":    {InputPerMTok: 0.05, OutputPerMTok: 0.40, CacheReadPerMTok: 0.005},
+	"gpt-5-pro":     {InputPerMTok: 15, OutputPerMTok: 120},
+	"gpt-4o":        {InputPerMTok: 2.50, OutputPerMTok: 10, CacheReadPerMTok: 1.25},
+	"gpt-4o-mini":   {InputPerMTok: 0.15, OutputPerMTok: 0.60, CacheReadPerMTok: 0.075},
+	"gpt-4.1":       {InputPerMTok: 2, OutputPerMTok: 8, CacheReadPerMTok: 0.50},
+	"gpt-4.1-mini":  {InputPerMTok: 0.40, OutputPerMTok: 1.60, CacheReadPerMTok: 0.10},
+	"gpt-4.1-nano":  {InputPerMTok: 0.10, OutputPerMTok: 0.40, CacheReadPerMTok: 0.025},
+	"gpt-4-turbo":   {InputPerMTok: 10, OutputPerMTok: 30},
+	"gpt-3.5-turbo": {InputPerMTok: 0.50, OutputPerMTok: 1.50},
+	"o1":            {InputPerMTok: 15, OutputPerMTok: 60, CacheReadPerMTok: 7.50},
+	"o1-mini":       {InputPerMTok: 1.10, OutputPerMTok: 4.40, CacheReadPerMTok: 0.55},
+	"o1-preview":    {InputPerMTok: 15, OutputPerMTok: 60, CacheReadPerMTok: 7.50},
+	"o1-pro":        {InputPerMTok: 150, OutputPerMTok: 600},
+	"o3":            {InputPerMTok: 2, OutputPerMTok: 8, CacheReadPerMTok: 0.50},
+	"o3-mini":       {InputPerMTok: 1.10, OutputPerMTok: 4.40, CacheReadPerMTok: 0.55},
+	"o3-pro":        {InputPerMTok: 20, OutputPerMTok: 80},
+	"o4-mini":       {InputPerMTok: 1.10, OutputPerMTok: 4.40, CacheReadPerMTok: 0.275},
+
+	// DeepSeek and Mistral.
+	"deepseek-chat":     {InputPerMTok: 0.27, OutputPerMTok: 1.10, CacheReadPerMTok: 0.07},
+	"deepseek-reasoner": {InputPerMTok: 0.55, OutputPerMTok: 2.19, CacheReadPerMTok: 0.14},
+	"mistral-large":     {InputPerMTok: 2, OutputPerMTok: 6},
+	"mistral-small":     {InputPerMTok: 0.10, OutputPerMTok: 0.30},
+	"pixtral-large":     {InputPerMTok: 2, OutputPerMTok: 6},
+	"pixtral-12b":       {InputPerMTok: 0.15, OutputPerMTok: 0.15},
+}
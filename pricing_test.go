@@ -0,0 +1,131 @@
+package claudeagent
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestPricingTableLookup(t *testing.T) {
+	table := DefaultPricingTable()
+
+	p, ok := table.Lookup("anthropic", "claude-sonnet-4-20250514")
+	if !ok || p.InputPerMTok != 3 || p.OutputPerMTok != 15 {
+		t.Errorf("unexpected sonnet pricing %+v (found=%v)", p, ok)
+	}
+	if p, _ := table.Lookup("openai", "gpt-4o-mini-2024-07-18"); p.InputPerMTok != 0.15 {
+		t.Errorf("expected longest prefix gpt-4o-mini to win, got %+v", p)
+	}
+	if _, ok := table.Lookup("anthropic", "unknown-model"); ok {
+		t.Error("expected no pricing for unknown model")
+	}
+
+	table.Set("anthropic", "claude-sonnet-4", ModelPricing{InputPerMTok: 1})
+	if p, _ := table.Lookup("anthropic", "claude-sonnet-4-5"); p.InputPerMTok != 1 {
+		t.Errorf("expected provider override, got %+v", p)
+	}
+	if p, _ := table.Lookup("router", "claude-sonnet-4-5"); p.InputPerMTok != 3 {
+		t.Errorf("other providers should keep the default, got %+v", p)
+	}
+}
+
+func TestDefaultPricingCoversKnownModels(t *testing.T) {
+	table := DefaultPricingTable()
+	// One model per capabilities.go entry, except hosted open-weight
+	// models whose price depends on the host.
+	for _, model := range []string{
+		"claude-opus-4-5-20251101", "claude-opus-4-1-20250805", "claude-sonnet-4-5", "claude-haiku-4-5",
+		"claude-3-7-sonnet-latest", "claude-3-5-sonnet-20241022", "claude-3-5-haiku-latest",
+		"claude-3-opus-20240229", "claude-3-sonnet-20240229", "claude-3-haiku-20240307",
+		"gpt-5", "gpt-5-mini", "gpt-4.1", "gpt-4o", "gpt-4-turbo", "gpt-3.5-turbo",
+		"o1", "o1-mini", "o1-preview", "o3", "o4-mini",
+		"deepseek-chat", "deepseek-reasoner", "mistral-large-latest", "pixtral-large-latest",
+	} {
+		if _, ok := table.Lookup("openai", model); !ok {
+			t.Errorf("no default pricing for %s", model)
+		}
+	}
+	if p, _ := table.Lookup("openai", "o1-pro-2025-03-19"); p.InputPerMTok != 150 {
+		t.Errorf("o1-pro should not be priced as o1, got %+v", p)
+	}
+}
+
+func TestModelPricingCost(t *testing.T) {
+	p := ModelPricing{InputPerMTok: 3, OutputPerMTok: 15, CacheWritePerMTok: 3.75, CacheReadPerMTok: 0.30}
+	got := p.Cost(ChatUsage{InputTokens: 1000, OutputTokens: 500, CacheCreationInputTokens: 2000, CacheReadInputTokens: 10000})
+	want := (1000*3 + 500*15 + 2000*3.75 + 10000*0.30) / 1e6
+	if math.Abs(got-want) > 1e-12 {
+		t.Errorf("Cost = %v, want %v", got, want)
+	}
+
+	// 1h writes use their own rate, or the 5m rate when it is unset.
+	u := ChatUsage{CacheCreationInputTokens: 3000, CacheCreation1hInputTokens: 1000}
+	if got, want := p.Cost(u), (2000*3.75+1000*3.75)/1e6; math.Abs(got-want) > 1e-12 {
+		t.Errorf("Cost without a 1h rate = %v, want %v", got, want)
+	}
+	p.CacheWrite1hPerMTok = 6
+	if got, want := p.Cost(u), (2000*3.75+1000*6)/1e6; math.Abs(got-want) > 1e-12 {
+		t.Errorf("Cost with a 1h rate = %v, want %v", got, want)
+	}
+	if got, want := p.CacheSavings(u), -(2000*0.75+1000*3)/1e6; math.Abs(got-want) > 1e-12 {
+		t.Errorf("CacheSavings = %v, want %v", got, want)
+	}
+}
+
+// pricedProvider returns a fixed model and usage on every call.
+type pricedProvider struct {
+	model string
+	usage ChatUsage
+	calls int
+}
+
+func (p *pricedProvider) Name() string { return "anthropic" }
+
+func (p *pricedProvider) Complete(context.Context, ChatRequest, ChatStreamCallback) (ChatResponse, error) {
+	p.calls++
+	return ChatResponse{Content: "done", StopReason: "end_turn", Usage: p.usage, Model: p.model}, nil
+}
+
+func TestAPIAgentReportsCost(t *testing.T) {
+	p := &pricedProvider{model: "claude-sonnet-4-20250514", usage: ChatUsage{InputTokens: 1_000_000, OutputTokens: 100_000}}
+	agent := NewAPIAgent(APIAgentConfig{Provider: p})
+
+	events, _ := agent.Run(context.Background(), "hi")
+	var result *ResultMessage
+	for e := range events {
+		if e.Type == AgentEventComplete {
+			result = e.Result
+		}
+	}
+	if result == nil || math.Abs(result.Cost-4.5) > 1e-9 {
+		t.Fatalf("expected cost $4.50, got %+v", result)
+	}
+}
+
+func TestAPIAgentEnforcesMaxCost(t *testing.T) {
+	p := &pricedProvider{model: "claude-sonnet-4-20250514", usage: ChatUsage{InputTokens: 100_000}}
+	agent := NewAPIAgent(APIAgentConfig{
+		Provider: p,
+		Budget:   &BudgetConfig{MaxCostUSD: 0.10},
+	})
+	_, err := agent.RunSync(context.Background(), "hi")
+	var budgetErr *BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected BudgetExceededError for $0.30 spend, got %v", err)
+	}
+
+	p = &pricedProvider{model: "my-finetune", usage: ChatUsage{InputTokens: 10}}
+	agent = NewAPIAgent(APIAgentConfig{Provider: p, Budget: &BudgetConfig{MaxCostUSD: 1}})
+	_, err = agent.RunSync(context.Background(), "hi")
+	var missing *PricingNotFoundError
+	if !errors.As(err, &missing) || missing.Model != "my-finetune" {
+		t.Fatalf("expected PricingNotFoundError, got %v", err)
+	}
+
+	// Without a cost budget, unknown models just cost 0.
+	agent = NewAPIAgent(APIAgentConfig{Provider: p})
+	if _, err := agent.RunSync(context.Background(), "hi"); err != nil {
+		t.Fatalf("unexpected error without cost budget: %v", err)
+	}
+}
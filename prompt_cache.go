@@ -0,0 +1,117 @@
+package claudeagent
+
+// maxCacheBreakpoints is Anthropic's limit on cache_control markers per request.
+const maxCacheBreakpoints = 4
+
+// Cache TTL values for CacheControl.TTL and PromptCacheConfig.TTL.
+const (
+	// CacheTTL5m is the default five-minute cache lifetime.
+	CacheTTL5m = "5m"
+	// CacheTTL1h keeps cache entries for an hour, at a higher write price.
+	CacheTTL1h = "1h"
+)
+
+// PromptCacheConfig enables automatic prompt-cache breakpoints. The zero
+// value caches the tool list, the system prompt and a rolling point at the
+// end of the conversation, so each turn reads back what the previous turn
+// wrote instead of re-paying for the whole history.
+//
+// Breakpoints are placed in priority order (tools, system, latest message,
+// previous turn) until Anthropic's limit of four per request is reached.
+// Breakpoints already set on SystemPromptBlocks count toward the limit, and
+// when present the system prompt is left as configured.
+//
+// Only providers that report CapabilityPromptCaching apply it; it is dropped
+// for the rest.
+type PromptCacheConfig struct {
+	// DisableTools skips the breakpoint on the last tool definition.
+	DisableTools bool `json:"disable_tools,omitempty"`
+	// DisableSystem skips the breakpoint on the system prompt.
+	DisableSystem bool `json:"disable_system,omitempty"`
+	// DisableMessages skips the rolling breakpoints in the message history.
+	DisableMessages bool `json:"disable_messages,omitempty"`
+	// TTL is CacheTTL5m (default) or CacheTTL1h. Anthropic requires longer
+	// TTLs to come first, so the tools breakpoint is raised to 1h when a
+	// SystemPromptBlock carries a 1h breakpoint, and message breakpoints
+	// fall back to 5m when one carries a 5m breakpoint. 1h cache writes
+	// are priced at ModelPricing.CacheWrite1hPerMTok.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// cachePlan is where a request's automatic cache breakpoints go.
+type cachePlan struct {
+	tools    bool
+	system   bool
+	messages []int // indices into ChatRequest.Messages
+	ttl      string
+	toolsTTL string
+}
+
+// planCacheBreakpoints decides the automatic breakpoints for req. It returns
+// an empty plan when req.PromptCache is nil.
+func planCacheBreakpoints(req ChatRequest) cachePlan {
+	cfg := req.PromptCache
+	if cfg == nil {
+		return cachePlan{}
+	}
+	plan := cachePlan{ttl: cfg.TTL}
+
+	explicit := 0
+	shortExplicit, longExplicit := false, false
+	for _, b := range req.SystemBlocks {
+		if b.CacheControl != nil {
+			explicit++
+			if b.CacheControl.TTL == CacheTTL1h {
+				longExplicit = true
+			} else {
+				shortExplicit = true
+			}
+		}
+	}
+	// Anthropic requires longer TTLs first. The tools breakpoint comes
+	// before explicit system breakpoints, so it is raised to 1h if any of
+	// them is 1h; message breakpoints come after, so they fall back to 5m
+	// if any of them is 5m.
+	if cfg.TTL == CacheTTL1h && shortExplicit {
+		plan.ttl = ""
+	}
+	plan.toolsTTL = plan.ttl
+	if longExplicit {
+		plan.toolsTTL = CacheTTL1h
+	}
+	budget := maxCacheBreakpoints - explicit
+
+	if !cfg.DisableTools && len(req.Tools) > 0 && budget > 0 {
+		plan.tools = true
+		budget--
+	}
+	hasSystem := req.SystemPrompt != "" || len(req.SystemBlocks) > 0
+	if !cfg.DisableSystem && hasSystem && explicit == 0 && budget > 0 {
+		plan.system = true
+		budget--
+	}
+	if cfg.DisableMessages || len(req.Messages) == 0 {
+		return plan
+	}
+
+	// The latest message caches this turn's prefix for the next turn. The
+	// end of the previous turn (just before the latest assistant message)
+	// is where the previous request's breakpoint was, so marking it again
+	// guarantees a read even when this turn added many blocks.
+	last := len(req.Messages) - 1
+	if budget > 0 {
+		plan.messages = append(plan.messages, last)
+		budget--
+	}
+	if budget > 0 {
+		for i := last; i > 0; i-- {
+			if req.Messages[i].Role == ChatRoleAssistant {
+				if req.Messages[i-1].Role != ChatRoleSystem {
+					plan.messages = append(plan.messages, i-1)
+				}
+				break
+			}
+		}
+	}
+	return plan
+}
@@ -0,0 +1,207 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func promptCacheRequest() ChatRequest {
+	return ChatRequest{
+		Model:        "claude-sonnet-4-20250514",
+		SystemPrompt: "be helpful",
+		Tools: []ToolDefinition{
+			{Name: "a", InputSchema: ObjectSchema(map[string]any{})},
+			{Name: "b", InputSchema: ObjectSchema(map[string]any{})},
+		},
+		Messages: []ChatMessage{
+			{Role: ChatRoleUser, Content: "q"},
+			{Role: ChatRoleAssistant, ToolCalls: []ToolCall{{ID: "t1", Name: "a", Input: json.RawMessage(`{}`)}}},
+			{Role: ChatRoleTool, ToolCallID: "t1", Content: "r1"},
+			{Role: ChatRoleAssistant, ToolCalls: []ToolCall{{ID: "t2", Name: "b", Input: json.RawMessage(`{}`)}}},
+			{Role: ChatRoleTool, ToolCallID: "t2", Content: "r2"},
+		},
+		MaxTokens:   100,
+		PromptCache: &PromptCacheConfig{},
+	}
+}
+
+func TestPlanCacheBreakpoints(t *testing.T) {
+	req := promptCacheRequest()
+	plan := planCacheBreakpoints(req)
+	if !plan.tools || !plan.system || !reflect.DeepEqual(plan.messages, []int{4, 2}) {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+
+	// Explicit system breakpoints count toward the limit and keep the
+	// system prompt as configured.
+	req.SystemBlocks = []SystemPromptBlock{
+		{Text: "one", CacheControl: &CacheControl{Type: "ephemeral"}},
+		{Text: "two", CacheControl: &CacheControl{Type: "ephemeral"}},
+	}
+	plan = planCacheBreakpoints(req)
+	if !plan.tools || plan.system || !reflect.DeepEqual(plan.messages, []int{4}) {
+		t.Errorf("unexpected plan with explicit blocks: %+v", plan)
+	}
+
+	req.PromptCache = &PromptCacheConfig{DisableTools: true, DisableMessages: true}
+	if plan = planCacheBreakpoints(req); plan.tools || len(plan.messages) != 0 {
+		t.Errorf("disabled breakpoints were placed: %+v", plan)
+	}
+
+	req.PromptCache = nil
+	if plan = planCacheBreakpoints(req); plan.tools || plan.system || len(plan.messages) != 0 {
+		t.Errorf("nil config should place nothing: %+v", plan)
+	}
+}
+
+func TestPlanCacheBreakpointsTTLOrdering(t *testing.T) {
+	req := promptCacheRequest()
+	req.PromptCache = &PromptCacheConfig{TTL: CacheTTL1h}
+	if plan := planCacheBreakpoints(req); plan.ttl != CacheTTL1h || plan.toolsTTL != CacheTTL1h {
+		t.Errorf("expected 1h everywhere: %+v", plan)
+	}
+
+	req.SystemBlocks = []SystemPromptBlock{{Text: "s", CacheControl: &CacheControl{Type: "ephemeral"}}}
+	if plan := planCacheBreakpoints(req); !plan.tools || plan.ttl != "" || plan.toolsTTL != "" {
+		t.Errorf("breakpoints around a 5m system breakpoint must not be 1h: %+v", plan)
+	}
+	checkCacheTTLOrder(t, req)
+
+	// A 1h system breakpoint with the default 5m config raises the tools
+	// breakpoint before it, and leaves the messages after it at 5m.
+	req.PromptCache = &PromptCacheConfig{}
+	req.SystemBlocks = []SystemPromptBlock{{Text: "s", CacheControl: &CacheControl{Type: "ephemeral", TTL: CacheTTL1h}}}
+	if plan := planCacheBreakpoints(req); !plan.tools || plan.toolsTTL != CacheTTL1h || plan.ttl != "" {
+		t.Errorf("the tools breakpoint before a 1h system breakpoint must be 1h: %+v", plan)
+	}
+	checkCacheTTLOrder(t, req)
+}
+
+// checkCacheTTLOrder checks the request as sent: there must be tools,
+// system and message breakpoints, and no 5m breakpoint may come before a
+// 1h one.
+func checkCacheTTLOrder(t *testing.T, req ChatRequest) {
+	t.Helper()
+	body, err := json.Marshal(buildAnthropicParams(req))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var ttls []string
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if cc, ok := v["cache_control"].(map[string]any); ok {
+				ttl, _ := cc["ttl"].(string)
+				ttls = append(ttls, ttl)
+			}
+			for _, k := range []string{"tools", "system", "messages", "content"} {
+				walk(v[k])
+			}
+		case []any:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	var params map[string]any
+	_ = json.Unmarshal(body, &params)
+	walk(params)
+	if len(ttls) < 3 {
+		t.Fatalf("expected tools, system and message breakpoints, got %v", ttls)
+	}
+	for i := 1; i < len(ttls); i++ {
+		if ttls[i] == CacheTTL1h && ttls[i-1] != CacheTTL1h {
+			t.Errorf("1h breakpoint after a shorter one: %v", ttls)
+		}
+	}
+}
+
+func TestBuildAnthropicParamsCacheBreakpoints(t *testing.T) {
+	req := promptCacheRequest()
+	req.PromptCache.TTL = CacheTTL1h
+	body, err := json.Marshal(buildAnthropicParams(req))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var got struct {
+		System   []map[string]any `json:"system"`
+		Tools    []map[string]any `json:"tools"`
+		Messages []struct {
+			Content []map[string]any `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	count := 0
+	has := func(block map[string]any) bool {
+		cc, ok := block["cache_control"].(map[string]any)
+		if ok {
+			count++
+			if cc["ttl"] != "1h" {
+				t.Errorf("expected 1h ttl, got %v", cc)
+			}
+		}
+		return ok
+	}
+	if has(got.Tools[0]) || !has(got.Tools[1]) {
+		t.Error("expected a breakpoint on the last tool only")
+	}
+	if !has(got.System[0]) {
+		t.Error("expected a breakpoint on the system prompt")
+	}
+	for i, m := range got.Messages {
+		want := i == 2 || i == 4
+		if has(m.Content[len(m.Content)-1]) != want {
+			t.Errorf("message %d: breakpoint = %v, want %v", i, !want, want)
+		}
+	}
+	if count != maxCacheBreakpoints {
+		t.Errorf("expected %d breakpoints, got %d", maxCacheBreakpoints, count)
+	}
+}
+
+func TestAdaptChatRequestDropsPromptCache(t *testing.T) {
+	req := promptCacheRequest()
+	out, err := adaptChatRequest(req, ProviderCapabilities{Tools: true}, "p")
+	if err != nil {
+		t.Fatalf("adapt: %v", err)
+	}
+	if out.PromptCache != nil {
+		t.Error("PromptCache should be dropped without prompt caching support")
+	}
+	if out, _ = adaptChatRequest(req, ProviderCapabilities{Tools: true, PromptCaching: true}, "p"); out.PromptCache == nil {
+		t.Error("PromptCache should be kept with prompt caching support")
+	}
+}
+
+func TestAPIAgentPromptCacheMetrics(t *testing.T) {
+	p := &pricedProvider{model: "claude-sonnet-4-20250514", usage: ChatUsage{
+		InputTokens:              1_000,
+		OutputTokens:             10,
+		CacheCreationInputTokens: 1_000_000,
+		CacheReadInputTokens:     2_000_000,
+	}}
+	mc := NewMetricsCollector()
+	agent := NewAPIAgent(APIAgentConfig{Provider: p, Metrics: mc, PromptCache: &PromptCacheConfig{}})
+	if _, err := agent.RunSync(context.Background(), "hi"); err != nil {
+		t.Fatalf("RunSync: %v", err)
+	}
+
+	stats := mc.Snapshot().PromptCache
+	if stats.CacheReadTokens != 2_000_000 || stats.CacheWriteTokens != 1_000_000 || stats.InputTokens != 1_000 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	// Sonnet: reads save $3.00 - $0.30 per MTok, writes cost $3.75 - $3.00 extra.
+	if want := 2*2.70 - 0.75; math.Abs(stats.SavingsUSD-want) > 1e-9 {
+		t.Errorf("SavingsUSD = %v, want %v", stats.SavingsUSD, want)
+	}
+	if rate := stats.HitRate(); math.Abs(rate-2_000_000.0/3_001_000.0) > 1e-9 {
+		t.Errorf("HitRate = %v", rate)
+	}
+}
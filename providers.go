@@ -57,3 +57,17 @@ func QwenProvider(model string) LLMProvider {
 		Model:   model,
 	}).WithName("qwen")
 }
+
+// AzureProvider returns an LLMProvider for an Azure OpenAI deployment.
+// Uses AZURE_OPENAI_ENDPOINT and AZURE_OPENAI_API_KEY environment variables.
+//
+//	agent := claude.NewAPIAgent(claude.APIAgentConfig{
+//	    Provider: claude.AzureProvider("gpt-4o-prod"),
+//	})
+func AzureProvider(deployment string) LLMProvider {
+	return NewAzureOpenAIProvider(AzureOpenAIConfig{
+		Endpoint:   os.Getenv("AZURE_OPENAI_ENDPOINT"),
+		APIKey:     os.Getenv("AZURE_OPENAI_API_KEY"),
+		Deployment: deployment,
+	})
+}
@@ -0,0 +1,505 @@
+package claudeagent
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedResponse is a stored provider response together with the stream
+// events that produced it, so a cache hit can be replayed through onEvent.
+type CachedResponse struct {
+	Response ChatResponse      `json:"response"`
+	Events   []ChatStreamEvent `json:"events,omitempty"`
+	// Provider is the name of the provider that produced the response.
+	Provider  string    `json:"provider,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is when the entry stops being served. Zero means never.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// expired reports whether the entry is past its TTL.
+func (c *CachedResponse) expired(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && now.After(c.ExpiresAt)
+}
+
+// ResponseCacheKey identifies a cached response.
+//
+// Prefix hashes the parts of a request that stay fixed across a
+// conversation (model, system prompt, tools, temperature); Exact hashes the
+// prefix together with the messages. Stores key entries by
+// "Prefix/Exact", so every response produced under one system prompt and
+// tool set can be dropped at once with DeletePrefix.
+type ResponseCacheKey struct {
+	Prefix string
+	Exact  string
+}
+
+// String returns the store key, "Prefix/Exact".
+func (k ResponseCacheKey) String() string { return k.Prefix + "/" + k.Exact }
+
+// cacheKeyPrefix is the part of a request covered by ResponseCacheKey.Prefix.
+type cacheKeyPrefix struct {
+	Model       string           `json:"model"`
+	System      string           `json:"system,omitempty"`
+	Tools       []ToolDefinition `json:"tools,omitempty"`
+	Temperature *float64         `json:"temperature,omitempty"`
+}
+
+// cacheKeyExact is the part of a request covered by ResponseCacheKey.Exact,
+// in addition to the prefix.
+type cacheKeyExact struct {
+	Prefix                 string        `json:"prefix"`
+	Messages               []ChatMessage `json:"messages"`
+	TopP                   *float64      `json:"top_p,omitempty"`
+	TopK                   *int          `json:"top_k,omitempty"`
+	StopSequences          []string      `json:"stop_sequences,omitempty"`
+	ToolChoice             *ToolChoice   `json:"tool_choice,omitempty"`
+	DisableParallelToolUse bool          `json:"disable_parallel_tool_use,omitempty"`
+}
+
+// DefaultResponseCacheKey computes the canonical key for req from its model,
+// system prompt (blocks are flattened, so cache control does not affect the
+// key), tools, sampling and tool choice controls, and messages. MaxTokens and
+// Metadata are deliberately excluded. A request that cannot be encoded as
+// JSON gets the zero key, which CachingProvider never caches.
+func DefaultResponseCacheKey(req ChatRequest) ResponseCacheKey {
+	system := req.SystemPrompt
+	if len(req.SystemBlocks) > 0 {
+		system = flattenSystemBlocks(req.SystemBlocks)
+	}
+	prefix, err := hashJSON(cacheKeyPrefix{
+		Model:       req.Model,
+		System:      system,
+		Tools:       req.Tools,
+		Temperature: req.Temperature,
+	})
+	if err != nil {
+		return ResponseCacheKey{}
+	}
+	exact, err := hashJSON(cacheKeyExact{
+		Prefix:                 prefix,
+		Messages:               req.Messages,
+		TopP:                   req.TopP,
+		TopK:                   req.TopK,
+		StopSequences:          req.StopSequences,
+		ToolChoice:             req.ToolChoice,
+		DisableParallelToolUse: req.DisableParallelToolUse,
+	})
+	if err != nil {
+		return ResponseCacheKey{}
+	}
+	return ResponseCacheKey{Prefix: prefix, Exact: exact}
+}
+
+// ResponseCacheStore persists cached responses. Implementations must be
+// safe for concurrent use.
+type ResponseCacheStore interface {
+	// Get returns the entry for key, or found=false on a miss.
+	Get(ctx context.Context, key string) (entry *CachedResponse, found bool, err error)
+	// Put stores entry under key, replacing any existing entry.
+	Put(ctx context.Context, key string, entry *CachedResponse) error
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes every key starting with prefix and returns how
+	// many were removed.
+	DeletePrefix(ctx context.Context, prefix string) (int, error)
+}
+
+// ResponseCacheConfig configures a CachingProvider.
+type ResponseCacheConfig struct {
+	// Store holds cached responses. Default: NewMemoryResponseCache(1000).
+	Store ResponseCacheStore
+
+	// TTL is how long entries are served after being written. Zero means
+	// entries never expire.
+	TTL time.Duration
+
+	// Key computes the cache key for a request. Default: DefaultResponseCacheKey.
+	// Override to normalize requests, e.g. to ignore temperature.
+	Key func(req ChatRequest) ResponseCacheKey
+
+	// ReadOnly serves hits but never writes new entries, for CI runs against
+	// a committed cache directory.
+	ReadOnly bool
+
+	// Metrics, if set, receives a hit or miss for every request.
+	Metrics *MetricsCollector
+}
+
+// ResponseCacheStats counts cache outcomes for a CachingProvider.
+type ResponseCacheStats struct {
+	Hits   int
+	Misses int
+	// Errors counts store failures. They are treated as misses (on read) or
+	// ignored (on write) so a broken cache never fails a run.
+	Errors int
+	// Saved is the usage of the responses served from the cache. Hits
+	// report zero usage, so budgets and metrics only count real calls.
+	Saved ChatUsage
+}
+
+// CachingProvider wraps an LLMProvider and serves repeated requests from a
+// ResponseCacheStore. Hits replay the recorded stream events through onEvent
+// before returning, so streaming consumers see the same sequence as the
+// original call.
+//
+// Only successful responses are cached; errors and responses truncated by
+// max_tokens always go to the wrapped provider.
+//
+//	cache := claude.NewCachingProvider(claude.NewAnthropicProvider(cfg), claude.ResponseCacheConfig{
+//	    Store: claude.NewDirResponseCache("testdata/llm-cache"),
+//	    TTL:   24 * time.Hour,
+//	})
+//	agent := claude.NewAPIAgent(claude.APIAgentConfig{Provider: cache})
+type CachingProvider struct {
+	inner LLMProvider
+	cfg   ResponseCacheConfig
+
+	mu    sync.Mutex
+	stats ResponseCacheStats
+}
+
+// NewCachingProvider creates a caching wrapper around inner.
+func NewCachingProvider(inner LLMProvider, cfg ResponseCacheConfig) *CachingProvider {
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryResponseCache(1000)
+	}
+	if cfg.Key == nil {
+		cfg.Key = DefaultResponseCacheKey
+	}
+	return &CachingProvider{inner: inner, cfg: cfg}
+}
+
+// Name returns the wrapped provider's name.
+func (p *CachingProvider) Name() string { return p.inner.Name() }
+
+// Capabilities forwards to the wrapped provider's descriptor. Providers
+// without one are reported as supporting everything.
+func (p *CachingProvider) Capabilities(model string) ProviderCapabilities {
+	return wrappedCapabilities(p.inner, model)
+}
+
+// Complete serves req from the cache, or forwards it and caches the result.
+func (p *CachingProvider) Complete(ctx context.Context, req ChatRequest, onEvent ChatStreamCallback) (ChatResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ChatResponse{}, err
+	}
+	k := p.cfg.Key(req)
+	if k == (ResponseCacheKey{}) {
+		// Not cacheable; the provider reports why the request is invalid.
+		return p.inner.Complete(ctx, req, onEvent)
+	}
+	key := k.String()
+
+	entry, found, err := p.cfg.Store.Get(ctx, key)
+	if err != nil {
+		p.record(func(s *ResponseCacheStats) { s.Errors++ })
+		found = false
+	}
+	if found && entry.expired(time.Now()) {
+		_ = p.cfg.Store.Delete(ctx, key)
+		found = false
+	}
+	if found {
+		p.record(func(s *ResponseCacheStats) {
+			s.Hits++
+			s.Saved.InputTokens += entry.Response.Usage.InputTokens
+			s.Saved.OutputTokens += entry.Response.Usage.OutputTokens
+			s.Saved.CacheCreationInputTokens += entry.Response.Usage.CacheCreationInputTokens
+			s.Saved.CacheCreation1hInputTokens += entry.Response.Usage.CacheCreation1hInputTokens
+			s.Saved.CacheReadInputTokens += entry.Response.Usage.CacheReadInputTokens
+		})
+		if p.cfg.Metrics != nil {
+			p.cfg.Metrics.recordResponseCache(true)
+		}
+		if onEvent != nil {
+			for _, e := range entry.Events {
+				onEvent(cloneStreamEvent(e))
+			}
+		}
+		resp := cloneChatResponse(entry.Response)
+		resp.Usage = ChatUsage{}
+		return resp, nil
+	}
+
+	p.record(func(s *ResponseCacheStats) { s.Misses++ })
+	if p.cfg.Metrics != nil {
+		p.cfg.Metrics.recordResponseCache(false)
+	}
+
+	var events []ChatStreamEvent
+	resp, err := p.inner.Complete(ctx, req, func(e ChatStreamEvent) {
+		events = append(events, cloneStreamEvent(e))
+		if onEvent != nil {
+			onEvent(e)
+		}
+	})
+	if err != nil || p.cfg.ReadOnly || resp.StopReason == "max_tokens" {
+		return resp, err
+	}
+
+	now := time.Now()
+	entry = &CachedResponse{
+		Response:  cloneChatResponse(resp),
+		Events:    events,
+		Provider:  p.inner.Name(),
+		CreatedAt: now,
+	}
+	if p.cfg.TTL > 0 {
+		entry.ExpiresAt = now.Add(p.cfg.TTL)
+	}
+	if err := p.cfg.Store.Put(ctx, key, entry); err != nil {
+		p.record(func(s *ResponseCacheStats) { s.Errors++ })
+	}
+	return resp, nil
+}
+
+// cloneChatResponse copies resp's tool calls, which the agent loop may
+// modify (e.g., repairToolCallInputs), so a cached entry is never shared.
+func cloneChatResponse(resp ChatResponse) ChatResponse {
+	if resp.ToolCalls != nil {
+		calls := make([]ToolCall, len(resp.ToolCalls))
+		for i, tc := range resp.ToolCalls {
+			tc.Input = slices.Clone(tc.Input)
+			calls[i] = tc
+		}
+		resp.ToolCalls = calls
+	}
+	return resp
+}
+
+// Invalidate removes every cached response that shares req's key prefix
+// (same model, system prompt, tools and temperature).
+func (p *CachingProvider) Invalidate(ctx context.Context, req ChatRequest) (int, error) {
+	return p.cfg.Store.DeletePrefix(ctx, p.cfg.Key(req).Prefix+"/")
+}
+
+// Stats returns the hit, miss and error counts so far.
+func (p *CachingProvider) Stats() ResponseCacheStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+func (p *CachingProvider) record(fn func(*ResponseCacheStats)) {
+	p.mu.Lock()
+	fn(&p.stats)
+	p.mu.Unlock()
+}
+
+// MemoryResponseCache is an in-memory LRU ResponseCacheStore.
+type MemoryResponseCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List // front = most recently used
+	items      map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *CachedResponse
+}
+
+// NewMemoryResponseCache creates an LRU cache holding at most maxEntries
+// responses. maxEntries <= 0 means unbounded.
+func NewMemoryResponseCache(maxEntries int) *MemoryResponseCache {
+	return &MemoryResponseCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Get returns the entry for key and marks it most recently used.
+func (c *MemoryResponseCache) Get(_ context.Context, key string) (*CachedResponse, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*memoryCacheItem).entry, true, nil
+}
+
+// Put stores entry, evicting the least recently used entry when full.
+func (c *MemoryResponseCache) Put(_ context.Context, key string, entry *CachedResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*memoryCacheItem).entry = entry
+		c.order.MoveToFront(el)
+		return nil
+	}
+	c.items[key] = c.order.PushFront(&memoryCacheItem{key: key, entry: entry})
+	if c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*memoryCacheItem).key)
+	}
+	return nil
+}
+
+// Delete removes key.
+func (c *MemoryResponseCache) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+	return nil
+}
+
+// DeletePrefix removes every key starting with prefix.
+func (c *MemoryResponseCache) DeletePrefix(_ context.Context, prefix string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key, el := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(el)
+			delete(c.items, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Len returns the number of cached entries.
+func (c *MemoryResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// DirResponseCache is a ResponseCacheStore that keeps one JSON file per
+// entry under a directory. Key segments separated by "/" become
+// subdirectories, so with the default key every prefix gets its own folder.
+// Files are written atomically and can be committed to version control.
+type DirResponseCache struct {
+	dir string
+}
+
+// NewDirResponseCache creates a directory-backed store rooted at dir.
+// The directory is created on first write.
+func NewDirResponseCache(dir string) *DirResponseCache {
+	return &DirResponseCache{dir: dir}
+}
+
+// validCacheKey restricts keys to safe path segments.
+var validCacheKey = regexp.MustCompile(`^[A-Za-z0-9_\-]+(/[A-Za-z0-9_\-]+)*$`)
+
+// path maps key to its file path.
+func (c *DirResponseCache) path(key string) (string, error) {
+	if !validCacheKey.MatchString(key) {
+		return "", fmt.Errorf("invalid response cache key %q", key)
+	}
+	return filepath.Join(c.dir, filepath.FromSlash(key)+".json"), nil
+}
+
+// Get reads the entry for key.
+func (c *DirResponseCache) Get(_ context.Context, key string) (*CachedResponse, bool, error) {
+	path, err := c.path(key)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := os.ReadFile(path) // #nosec G304 -- path is derived from a validated key
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("read cache entry: %w", err)
+	}
+	var entry CachedResponse
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false, fmt.Errorf("decode cache entry: %w", err)
+	}
+	return &entry, true, nil
+}
+
+// Put writes the entry for key atomically.
+func (c *DirResponseCache) Put(_ context.Context, key string, entry *CachedResponse) error {
+	path, err := c.path(key)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encode cache entry: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("create cache dir: %w", err)
+	}
+	// A temp file per write, so concurrent writers of one key never share
+	// a half-written file; the last rename wins.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write cache entry: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("write cache entry: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the file for key.
+func (c *DirResponseCache) Delete(_ context.Context, key string) error {
+	path, err := c.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// DeletePrefix removes every entry whose key starts with prefix.
+func (c *DirResponseCache) DeletePrefix(_ context.Context, prefix string) (int, error) {
+	n := 0
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		rel, err := filepath.Rel(c.dir, path)
+		if err != nil {
+			return err
+		}
+		key := strings.TrimSuffix(filepath.ToSlash(rel), ".json")
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
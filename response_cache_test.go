@@ -0,0 +1,266 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func cacheTestRequest(content string) ChatRequest {
+	return ChatRequest{
+		Model:        "m",
+		SystemPrompt: "sys",
+		Messages:     []ChatMessage{{Role: ChatRoleUser, Content: content}},
+	}
+}
+
+func collectEvents(p LLMProvider, req ChatRequest) (ChatResponse, []ChatStreamEvent, error) {
+	var events []ChatStreamEvent
+	resp, err := p.Complete(context.Background(), req, func(e ChatStreamEvent) {
+		events = append(events, cloneStreamEvent(e))
+	})
+	return resp, events, err
+}
+
+func TestCachingProvider_HitReplaysEvents(t *testing.T) {
+	inner := &cassetteScriptProvider{responses: []ChatResponse{{
+		Content:    "calling",
+		ToolCalls:  []ToolCall{{ID: "call_1", Name: "lookup", Input: json.RawMessage(`{"key":"a"}`)}},
+		StopReason: "tool_use",
+		Usage:      ChatUsage{InputTokens: 3, OutputTokens: 2},
+	}}}
+	mc := NewMetricsCollector()
+	p := NewCachingProvider(inner, ResponseCacheConfig{Metrics: mc})
+
+	first, firstEvents, err := collectEvents(p, cacheTestRequest("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, secondEvents, err := collectEvents(p, cacheTestRequest("hi"))
+	if err != nil {
+		t.Fatalf("expected cache hit, got %v", err)
+	}
+
+	if inner.calls != 1 {
+		t.Errorf("expected 1 inner call, got %d", inner.calls)
+	}
+	if second.Usage != (ChatUsage{}) {
+		t.Errorf("a hit should report no usage, got %+v", second.Usage)
+	}
+	first.Usage = ChatUsage{}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("cached response differs:\n%+v\n%+v", first, second)
+	}
+	if len(firstEvents) != 3 || !reflect.DeepEqual(firstEvents, secondEvents) {
+		t.Errorf("replayed events differ:\n%+v\n%+v", firstEvents, secondEvents)
+	}
+
+	if s := p.Stats(); s.Hits != 1 || s.Misses != 1 || s.Saved.InputTokens != 3 || s.Saved.OutputTokens != 2 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if snap := mc.Snapshot(); snap.ResponseCacheHits != 1 || snap.ResponseCacheMisses != 1 {
+		t.Errorf("unexpected metrics %+v", snap)
+	}
+}
+
+func TestCachingProvider_HitsDoNotShareToolCalls(t *testing.T) {
+	inner := &cassetteScriptProvider{responses: []ChatResponse{{
+		ToolCalls:  []ToolCall{{ID: "call_1", Name: "lookup", Input: json.RawMessage(`{"key":"a"`)}},
+		StopReason: "tool_use",
+	}}}
+	p := NewCachingProvider(inner, ResponseCacheConfig{})
+
+	for i := 0; i < 3; i++ {
+		resp, _, err := collectEvents(p, cacheTestRequest("hi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(resp.ToolCalls[0].Input); got != `{"key":"a"` {
+			t.Fatalf("call %d: cached input was modified: %s", i, got)
+		}
+		// As repairToolCallInputs does.
+		resp.ToolCalls[0].Input = json.RawMessage(`{"key":"a"}`)
+	}
+}
+
+func TestCachingProvider_SkipsErrorsAndTruncation(t *testing.T) {
+	inner := &cassetteScriptProvider{responses: []ChatResponse{
+		{Content: "cut", StopReason: "max_tokens"},
+		{Content: "full", StopReason: "end_turn"},
+	}}
+	p := NewCachingProvider(inner, ResponseCacheConfig{})
+
+	resp, _, _ := collectEvents(p, cacheTestRequest("q"))
+	if resp.Content != "cut" {
+		t.Fatalf("unexpected first response %q", resp.Content)
+	}
+	resp, _, _ = collectEvents(p, cacheTestRequest("q"))
+	if resp.Content != "full" {
+		t.Errorf("truncated response should not be cached, got %q", resp.Content)
+	}
+
+	// The script is exhausted: a new request errors and is not cached.
+	if _, _, err := collectEvents(p, cacheTestRequest("other")); err == nil {
+		t.Fatal("expected inner error")
+	}
+	if _, found, _ := p.cfg.Store.Get(context.Background(), DefaultResponseCacheKey(cacheTestRequest("other")).String()); found {
+		t.Error("errors must not be cached")
+	}
+}
+
+func TestCachingProvider_TTLAndReadOnly(t *testing.T) {
+	store := NewMemoryResponseCache(0)
+	req := cacheTestRequest("q")
+	key := DefaultResponseCacheKey(req).String()
+	_ = store.Put(context.Background(), key, &CachedResponse{
+		Response:  ChatResponse{Content: "stale"},
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+
+	inner := &cassetteScriptProvider{responses: []ChatResponse{{Content: "fresh", StopReason: "end_turn"}}}
+	p := NewCachingProvider(inner, ResponseCacheConfig{Store: store, ReadOnly: true})
+
+	resp, _, err := collectEvents(p, req)
+	if err != nil || resp.Content != "fresh" {
+		t.Fatalf("expected expired entry to be skipped, got %q (%v)", resp.Content, err)
+	}
+	if store.Len() != 0 {
+		t.Errorf("expected expired entry removed and nothing written in read-only mode, got %d entries", store.Len())
+	}
+}
+
+func TestDefaultResponseCacheKey(t *testing.T) {
+	base := cacheTestRequest("hello")
+	k := DefaultResponseCacheKey(base)
+
+	blocks := base
+	blocks.SystemPrompt = ""
+	blocks.SystemBlocks = []SystemPromptBlock{{Text: "sys", CacheControl: &CacheControl{Type: "ephemeral"}}}
+	if DefaultResponseCacheKey(blocks) != k {
+		t.Error("system blocks with the same text should produce the same key")
+	}
+
+	longer := base
+	longer.MaxTokens = 9000
+	if DefaultResponseCacheKey(longer) != k {
+		t.Error("MaxTokens should not affect the key")
+	}
+
+	next := cacheTestRequest("goodbye")
+	if nk := DefaultResponseCacheKey(next); nk.Prefix != k.Prefix || nk.Exact == k.Exact {
+		t.Errorf("different messages should share the prefix only: %+v vs %+v", nk, k)
+	}
+
+	temp := 0.2
+	warm := base
+	warm.Temperature = &temp
+	if DefaultResponseCacheKey(warm).Prefix == k.Prefix {
+		t.Error("temperature should change the prefix")
+	}
+}
+
+func TestMemoryResponseCache_LRU(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryResponseCache(2)
+	_ = c.Put(ctx, "a", &CachedResponse{})
+	_ = c.Put(ctx, "b", &CachedResponse{})
+	_, _, _ = c.Get(ctx, "a") // a is now most recently used
+	_ = c.Put(ctx, "c", &CachedResponse{})
+
+	if _, found, _ := c.Get(ctx, "b"); found {
+		t.Error("expected b to be evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, found, _ := c.Get(ctx, k); !found {
+			t.Errorf("expected %s to be kept", k)
+		}
+	}
+}
+
+func TestDirResponseCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	inner := &cassetteScriptProvider{responses: []ChatResponse{
+		{Content: "one", StopReason: "end_turn"},
+		{Content: "two", StopReason: "end_turn"},
+	}}
+	p := NewCachingProvider(inner, ResponseCacheConfig{Store: NewDirResponseCache(dir)})
+
+	_, _, _ = collectEvents(p, cacheTestRequest("a"))
+	_, _, _ = collectEvents(p, cacheTestRequest("b"))
+
+	// A fresh provider over the same directory serves both from disk.
+	reopened := NewCachingProvider(&cassetteScriptProvider{}, ResponseCacheConfig{Store: NewDirResponseCache(dir)})
+	resp, _, err := collectEvents(reopened, cacheTestRequest("b"))
+	if err != nil || resp.Content != "two" {
+		t.Fatalf("expected disk hit, got %q (%v)", resp.Content, err)
+	}
+
+	n, err := reopened.Invalidate(ctx, cacheTestRequest("anything"))
+	if err != nil || n != 2 {
+		t.Errorf("expected 2 entries invalidated, got %d (%v)", n, err)
+	}
+	if _, _, err := collectEvents(reopened, cacheTestRequest("a")); err == nil {
+		t.Error("expected miss after invalidation")
+	}
+
+	if err := NewDirResponseCache(dir).Put(ctx, "../escape", &CachedResponse{}); err == nil {
+		t.Error("expected invalid key to be rejected")
+	}
+}
+
+func TestDirResponseCacheConcurrentPut(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := NewDirResponseCache(dir)
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			content := strings.Repeat(string(rune('a'+i)), 1000+i)
+			errs <- store.Put(ctx, "p/x", &CachedResponse{Response: ChatResponse{Content: content}})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	entry, found, err := store.Get(ctx, "p/x")
+	if err != nil || !found {
+		t.Fatalf("Get after concurrent writes: %v, %v", found, err)
+	}
+	if c := entry.Response.Content; c == "" || strings.Trim(c, c[:1]) != "" {
+		t.Errorf("entry should be one writer's whole content, got %d bytes", len(c))
+	}
+	files, _ := os.ReadDir(filepath.Join(dir, "p"))
+	if len(files) != 1 {
+		t.Errorf("temp files should not be left behind, got %d files", len(files))
+	}
+}
+
+func TestCachingProvider_StoreErrorsAreMisses(t *testing.T) {
+	inner := &cassetteScriptProvider{responses: []ChatResponse{{Content: "ok", StopReason: "end_turn"}}}
+	p := NewCachingProvider(inner, ResponseCacheConfig{
+		Store: NewDirResponseCache(t.TempDir()),
+		Key:   func(ChatRequest) ResponseCacheKey { return ResponseCacheKey{Prefix: "..", Exact: "x"} },
+	})
+	resp, _, err := collectEvents(p, cacheTestRequest("q"))
+	if err != nil || resp.Content != "ok" {
+		t.Fatalf("store errors should not fail the call: %q (%v)", resp.Content, err)
+	}
+	if s := p.Stats(); s.Errors != 2 || s.Misses != 1 {
+		t.Errorf("expected read and write errors counted, got %+v", s)
+	}
+}
@@ -0,0 +1,453 @@
+package claudeagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RoutingStrategy selects the order in which a RouterProvider tries backends.
+type RoutingStrategy string
+
+const (
+	// RouteWeightedRoundRobin spreads requests across backends in proportion
+	// to RouterBackend.Weight. This is the default.
+	RouteWeightedRoundRobin RoutingStrategy = "weighted_round_robin"
+	// RouteLeastLatency prefers the backend with the lowest observed latency.
+	// Backends with no samples yet are tried first.
+	RouteLeastLatency RoutingStrategy = "least_latency"
+	// RouteCostFirst prefers the backend with the lowest configured token price.
+	RouteCostFirst RoutingStrategy = "cost_first"
+)
+
+// RouterBackend is one provider behind a RouterProvider.
+type RouterBackend struct {
+	// Provider serves requests for this backend.
+	Provider LLMProvider
+	// Name identifies the backend in Health reports. Defaults to Provider.Name().
+	Name string
+	// Model, if set, replaces ChatRequest.Model for requests sent to this
+	// backend. Model names rarely carry across providers.
+	Model string
+	// Weight is the relative share of traffic under RouteWeightedRoundRobin.
+	// Default: 1.
+	Weight int
+	// InputCostPerMTok and OutputCostPerMTok are USD prices per million tokens,
+	// used by RouteCostFirst.
+	InputCostPerMTok  float64
+	OutputCostPerMTok float64
+	// Capabilities lists the features this backend supports. Capability-based
+	// routing skips backends missing anything the request requires. When
+	// empty, the provider's CapabilityReporter descriptor for the backend's
+	// Model, or else the request's model, is used; backends with neither are
+	// assumed to support everything.
+	Capabilities []Capability
+}
+
+// RouterConfig configures a RouterProvider.
+type RouterConfig struct {
+	// Backends are the providers to route across, in priority order.
+	Backends []RouterBackend
+
+	// Strategy selects the routing policy. Default: RouteWeightedRoundRobin.
+	Strategy RoutingStrategy
+
+	// Requirements, if set, adds capabilities a request needs beyond those
+	// inferred automatically (tools are inferred from ChatRequest.Tools).
+	Requirements func(req ChatRequest) []Capability
+
+	// RetryOn decides whether an error should fail over to the next backend.
+	// Default: IsRetryableError.
+	RetryOn func(err error) bool
+
+	// MaxAttempts caps how many backends are tried per request.
+	// Default: all eligible backends.
+	MaxAttempts int
+
+	// UnhealthyAfter is the number of consecutive retryable failures after
+	// which a backend is taken out of rotation. Default: 3.
+	UnhealthyAfter int
+
+	// Cooldown is how long an unhealthy backend stays out of rotation before
+	// it is tried again. Default: 30 seconds.
+	Cooldown time.Duration
+}
+
+// BackendHealth is a point-in-time snapshot of one backend's state.
+type BackendHealth struct {
+	Name                string
+	Healthy             bool
+	Requests            int
+	Failures            int
+	ConsecutiveFailures int
+	// AvgLatency is an exponentially weighted moving average of successful calls.
+	AvgLatency time.Duration
+	// LastError is the message of the most recent failure, if any.
+	LastError string
+	// UnhealthyUntil is when an unhealthy backend re-enters rotation.
+	UnhealthyUntil time.Time
+}
+
+// NoBackendError is returned when no backend can serve a request, either
+// because none has the required capabilities or because all attempts failed.
+type NoBackendError struct {
+	// Required lists the capabilities the request needed.
+	Required []Capability
+	// Errors holds the failure from each attempted backend, keyed by name.
+	Errors map[string]error
+}
+
+func (e *NoBackendError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("router: no backend supports required capabilities %v", e.Required)
+	}
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msg := "router: all backends failed:"
+	for _, name := range names {
+		msg += fmt.Sprintf(" %s: %v;", name, e.Errors[name])
+	}
+	return msg
+}
+
+// Unwrap returns the per-backend errors so errors.Is/As can inspect them.
+func (e *NoBackendError) Unwrap() []error {
+	out := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		out = append(out, err)
+	}
+	return out
+}
+
+// latencyEWMAAlpha weights the newest latency sample in the moving average.
+const latencyEWMAAlpha = 0.3
+
+// routerBackend is a RouterBackend plus its mutable health state.
+type routerBackend struct {
+	RouterBackend
+	caps map[Capability]bool
+
+	// Guarded by RouterProvider.mu.
+	currentWeight       int
+	requests            int
+	failures            int
+	consecutiveFailures int
+	avgLatency          time.Duration
+	lastError           string
+	unhealthyUntil      time.Time
+}
+
+// RouterProvider implements LLMProvider by routing each request to one of
+// several backend providers. It fails over to the next backend on retryable
+// errors and tracks per-backend health.
+//
+// Failover only happens before the first stream event reaches onEvent; once a
+// backend has started streaming, its error is returned as-is so callers never
+// see interleaved output from two backends.
+//
+//	router := claude.NewRouterProvider(claude.RouterConfig{
+//	    Strategy: claude.RouteLeastLatency,
+//	    Backends: []claude.RouterBackend{
+//	        {Provider: claude.NewAnthropicProvider(claude.AnthropicProviderConfig{}), Model: "claude-sonnet-4-20250514"},
+//	        {Provider: claude.OpenAIProvider("gpt-4o")},
+//	    },
+//	})
+//	agent := claude.NewAPIAgent(claude.APIAgentConfig{Provider: router})
+type RouterProvider struct {
+	cfg      RouterConfig
+	mu       sync.Mutex
+	backends []*routerBackend
+}
+
+// NewRouterProvider creates a router over the configured backends.
+func NewRouterProvider(cfg RouterConfig) *RouterProvider {
+	if cfg.Strategy == "" {
+		cfg.Strategy = RouteWeightedRoundRobin
+	}
+	if cfg.RetryOn == nil {
+		cfg.RetryOn = IsRetryableError
+	}
+	if cfg.UnhealthyAfter == 0 {
+		cfg.UnhealthyAfter = 3
+	}
+	if cfg.Cooldown == 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+
+	r := &RouterProvider{cfg: cfg}
+	for _, b := range cfg.Backends {
+		if b.Name == "" {
+			b.Name = b.Provider.Name()
+		}
+		if b.Weight <= 0 {
+			b.Weight = 1
+		}
+		caps := make(map[Capability]bool, len(b.Capabilities))
+		for _, c := range b.Capabilities {
+			caps[c] = true
+		}
+		r.backends = append(r.backends, &routerBackend{RouterBackend: b, caps: caps})
+	}
+	return r
+}
+
+// Name returns "router".
+func (r *RouterProvider) Name() string { return "router" }
+
+// Complete routes the request to a backend, failing over on retryable errors.
+func (r *RouterProvider) Complete(ctx context.Context, req ChatRequest, onEvent ChatStreamCallback) (ChatResponse, error) {
+	required := r.requiredCapabilities(req)
+	order := r.plan(required, req.Model)
+	if len(order) == 0 {
+		return ChatResponse{}, &NoBackendError{Required: required}
+	}
+	if r.cfg.MaxAttempts > 0 && len(order) > r.cfg.MaxAttempts {
+		order = order[:r.cfg.MaxAttempts]
+	}
+
+	failures := make(map[string]error)
+	for _, b := range order {
+		if err := ctx.Err(); err != nil {
+			return ChatResponse{}, err
+		}
+
+		backendReq := req
+		if b.Model != "" {
+			backendReq.Model = b.Model
+		}
+		if desc, ok := CapabilitiesOf(b.Provider, backendReq.Model); ok {
+			adapted, err := adaptChatRequest(backendReq, desc, b.Name)
+			if err != nil {
+				failures[b.Name] = err
+				continue
+			}
+			backendReq = adapted
+		}
+
+		streamed := false
+		wrapped := onEvent
+		if onEvent != nil {
+			wrapped = func(e ChatStreamEvent) {
+				streamed = true
+				onEvent(e)
+			}
+		}
+
+		start := time.Now()
+		resp, err := b.Provider.Complete(ctx, backendReq, wrapped)
+		elapsed := time.Since(start)
+		if err == nil {
+			r.recordSuccess(b, elapsed)
+			return resp, nil
+		}
+
+		retryable := r.cfg.RetryOn(err)
+		r.recordFailure(b, err, retryable)
+		if !retryable || streamed {
+			return ChatResponse{}, err
+		}
+		failures[b.Name] = err
+	}
+	return ChatResponse{}, &NoBackendError{Required: required, Errors: failures}
+}
+
+// Capabilities reports what at least one backend supports, so APIAgent
+// passes through anything the router can route. Each backend's request is
+// then adapted to that backend's own descriptor. Limits are the largest
+// across backends, or 0 if any backend's limit is unknown.
+func (r *RouterProvider) Capabilities(model string) ProviderCapabilities {
+	var out ProviderCapabilities
+	contextKnown, outputKnown := true, true
+	for _, b := range r.backends {
+		m := model
+		if b.Model != "" {
+			m = b.Model
+		}
+		for _, c := range allCapabilities {
+			if b.supports([]Capability{c}, model) {
+				out.set(c)
+			}
+		}
+		desc, _ := CapabilitiesOf(b.Provider, m)
+		if desc.ContextWindow == 0 {
+			contextKnown = false
+		}
+		if desc.MaxOutputTokens == 0 {
+			outputKnown = false
+		}
+		out.ContextWindow = max(out.ContextWindow, desc.ContextWindow)
+		out.MaxOutputTokens = max(out.MaxOutputTokens, desc.MaxOutputTokens)
+	}
+	if !contextKnown {
+		out.ContextWindow = 0
+	}
+	if !outputKnown {
+		out.MaxOutputTokens = 0
+	}
+	return out
+}
+
+// Health returns a snapshot of every backend's health, in configuration order.
+func (r *RouterProvider) Health() []BackendHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	out := make([]BackendHealth, 0, len(r.backends))
+	for _, b := range r.backends {
+		out = append(out, BackendHealth{
+			Name:                b.Name,
+			Healthy:             !now.Before(b.unhealthyUntil),
+			Requests:            b.requests,
+			Failures:            b.failures,
+			ConsecutiveFailures: b.consecutiveFailures,
+			AvgLatency:          b.avgLatency,
+			LastError:           b.lastError,
+			UnhealthyUntil:      b.unhealthyUntil,
+		})
+	}
+	return out
+}
+
+// requiredCapabilities infers what a request needs from a backend.
+func (r *RouterProvider) requiredCapabilities(req ChatRequest) []Capability {
+	var caps []Capability
+	if len(req.Tools) > 0 {
+		caps = append(caps, CapabilityTools)
+	}
+	if countImages(req.Messages) > 0 {
+		caps = append(caps, CapabilityVision)
+	}
+	if r.cfg.Requirements != nil {
+		caps = append(caps, r.cfg.Requirements(req)...)
+	}
+	return caps
+}
+
+// supports reports whether a backend has every required capability when
+// serving model. Without declared capabilities, the provider's descriptor
+// for the backend's model, or else model, is consulted per request;
+// backends with neither are assumed to support everything.
+func (b *routerBackend) supports(required []Capability, model string) bool {
+	has := func(c Capability) bool { return b.caps[c] }
+	if len(b.caps) == 0 {
+		if b.Model != "" {
+			model = b.Model
+		}
+		desc, ok := CapabilitiesOf(b.Provider, model)
+		if !ok {
+			return true
+		}
+		has = desc.Has
+	}
+	for _, c := range required {
+		if !has(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// plan returns eligible backends in the order they should be tried.
+// Healthy backends come first in strategy order; unhealthy ones follow,
+// soonest-to-recover first, so a request is never refused outright just
+// because every backend is cooling down.
+func (r *RouterProvider) plan(required []Capability, model string) []*routerBackend {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var healthy, unhealthy []*routerBackend
+	for _, b := range r.backends {
+		if !b.supports(required, model) {
+			continue
+		}
+		if now.Before(b.unhealthyUntil) {
+			unhealthy = append(unhealthy, b)
+		} else {
+			healthy = append(healthy, b)
+		}
+	}
+
+	switch r.cfg.Strategy {
+	case RouteLeastLatency:
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].avgLatency < healthy[j].avgLatency
+		})
+	case RouteCostFirst:
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].InputCostPerMTok+healthy[i].OutputCostPerMTok <
+				healthy[j].InputCostPerMTok+healthy[j].OutputCostPerMTok
+		})
+	default:
+		healthy = r.weightedOrder(healthy)
+	}
+
+	sort.SliceStable(unhealthy, func(i, j int) bool {
+		return unhealthy[i].unhealthyUntil.Before(unhealthy[j].unhealthyUntil)
+	})
+	return append(healthy, unhealthy...)
+}
+
+// weightedOrder picks the next backend by smooth weighted round-robin and
+// returns it first, followed by the remaining candidates in config order.
+// Caller must hold r.mu.
+func (r *RouterProvider) weightedOrder(candidates []*routerBackend) []*routerBackend {
+	if len(candidates) <= 1 {
+		return candidates
+	}
+	total := 0
+	var best *routerBackend
+	for _, b := range candidates {
+		b.currentWeight += b.Weight
+		total += b.Weight
+		if best == nil || b.currentWeight > best.currentWeight {
+			best = b
+		}
+	}
+	best.currentWeight -= total
+
+	out := make([]*routerBackend, 0, len(candidates))
+	out = append(out, best)
+	for _, b := range candidates {
+		if b != best {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+func (r *RouterProvider) recordSuccess(b *routerBackend, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b.requests++
+	b.consecutiveFailures = 0
+	b.unhealthyUntil = time.Time{}
+	if b.avgLatency == 0 {
+		b.avgLatency = latency
+	} else {
+		b.avgLatency = time.Duration(latencyEWMAAlpha*float64(latency) + (1-latencyEWMAAlpha)*float64(b.avgLatency))
+	}
+}
+
+func (r *RouterProvider) recordFailure(b *routerBackend, err error, retryable bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b.requests++
+	b.failures++
+	b.lastError = err.Error()
+	// Only retryable errors (outages, rate limits) count against health;
+	// a bad request would fail on any backend.
+	if !retryable || errors.Is(err, context.Canceled) {
+		return
+	}
+	b.consecutiveFailures++
+	if b.consecutiveFailures >= r.cfg.UnhealthyAfter {
+		b.unhealthyUntil = time.Now().Add(r.cfg.Cooldown)
+	}
+}
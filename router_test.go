@@ -0,0 +1,266 @@
+package claudeagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// routerFakeProvider returns a canned response or error and counts calls.
+type routerFakeProvider struct {
+	name    string
+	err     error
+	delay   time.Duration
+	stream  bool
+	calls   int
+	lastReq ChatRequest
+}
+
+func (p *routerFakeProvider) Name() string { return p.name }
+
+func (p *routerFakeProvider) Complete(ctx context.Context, req ChatRequest, onEvent ChatStreamCallback) (ChatResponse, error) {
+	p.calls++
+	p.lastReq = req
+	if p.delay > 0 {
+		time.Sleep(p.delay)
+	}
+	if p.stream && onEvent != nil {
+		onEvent(ChatStreamEvent{Type: ChatStreamContentDelta, Content: "partial"})
+	}
+	if p.err != nil {
+		return ChatResponse{}, p.err
+	}
+	return ChatResponse{Content: p.name, StopReason: "end_turn"}, nil
+}
+
+func TestRouterProvider_WeightedRoundRobin(t *testing.T) {
+	a := &routerFakeProvider{name: "a"}
+	b := &routerFakeProvider{name: "b"}
+	r := NewRouterProvider(RouterConfig{
+		Backends: []RouterBackend{
+			{Provider: a, Weight: 3},
+			{Provider: b, Weight: 1},
+		},
+	})
+
+	for i := 0; i < 8; i++ {
+		if _, err := r.Complete(context.Background(), ChatRequest{}, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if a.calls != 6 || b.calls != 2 {
+		t.Errorf("expected 6/2 split, got a=%d b=%d", a.calls, b.calls)
+	}
+}
+
+func TestRouterProvider_FailoverOnRetryableError(t *testing.T) {
+	a := &routerFakeProvider{name: "a", err: &APIError{StatusCode: http.StatusServiceUnavailable}}
+	b := &routerFakeProvider{name: "b"}
+	r := NewRouterProvider(RouterConfig{
+		Strategy: RouteCostFirst,
+		Backends: []RouterBackend{
+			{Provider: a, Model: "model-a"},
+			{Provider: b, Model: "model-b", InputCostPerMTok: 1},
+		},
+	})
+
+	resp, err := r.Complete(context.Background(), ChatRequest{Model: "ignored"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Content != "b" {
+		t.Errorf("expected failover to b, got %q", resp.Content)
+	}
+	if a.lastReq.Model != "model-a" || b.lastReq.Model != "model-b" {
+		t.Errorf("expected per-backend models, got %q / %q", a.lastReq.Model, b.lastReq.Model)
+	}
+}
+
+func TestRouterProvider_NoFailoverOnNonRetryable(t *testing.T) {
+	a := &routerFakeProvider{name: "a", err: &APIError{StatusCode: http.StatusBadRequest}}
+	b := &routerFakeProvider{name: "b"}
+	r := NewRouterProvider(RouterConfig{
+		Strategy: RouteCostFirst,
+		Backends: []RouterBackend{{Provider: a}, {Provider: b, InputCostPerMTok: 1}},
+	})
+
+	_, err := r.Complete(context.Background(), ChatRequest{}, nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected the 400 error, got %v", err)
+	}
+	if b.calls != 0 {
+		t.Errorf("expected no failover, b called %d times", b.calls)
+	}
+	if !r.Health()[0].Healthy {
+		t.Error("non-retryable errors should not mark a backend unhealthy")
+	}
+}
+
+func TestRouterProvider_NoFailoverAfterStreaming(t *testing.T) {
+	a := &routerFakeProvider{name: "a", stream: true, err: &APIError{StatusCode: http.StatusBadGateway}}
+	b := &routerFakeProvider{name: "b"}
+	r := NewRouterProvider(RouterConfig{
+		Strategy: RouteCostFirst,
+		Backends: []RouterBackend{{Provider: a}, {Provider: b, InputCostPerMTok: 1}},
+	})
+
+	_, err := r.Complete(context.Background(), ChatRequest{}, func(ChatStreamEvent) {})
+	if err == nil {
+		t.Fatal("expected error once backend started streaming")
+	}
+	if b.calls != 0 {
+		t.Errorf("expected no failover after streaming began, b called %d times", b.calls)
+	}
+}
+
+func TestRouterProvider_HealthTracking(t *testing.T) {
+	a := &routerFakeProvider{name: "a", err: &APIError{StatusCode: http.StatusTooManyRequests}}
+	b := &routerFakeProvider{name: "b"}
+	r := NewRouterProvider(RouterConfig{
+		Strategy:       RouteCostFirst,
+		UnhealthyAfter: 2,
+		Cooldown:       time.Hour,
+		Backends:       []RouterBackend{{Provider: a}, {Provider: b, InputCostPerMTok: 1}},
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := r.Complete(context.Background(), ChatRequest{}, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// a fails twice, is marked unhealthy, and is skipped on the third request.
+	if a.calls != 2 {
+		t.Errorf("expected a to be skipped once unhealthy, got %d calls", a.calls)
+	}
+	health := r.Health()
+	if health[0].Healthy || health[0].ConsecutiveFailures != 2 || health[0].LastError == "" {
+		t.Errorf("unexpected health for a: %+v", health[0])
+	}
+	if !health[1].Healthy || health[1].Requests != 3 {
+		t.Errorf("unexpected health for b: %+v", health[1])
+	}
+}
+
+func TestRouterProvider_LeastLatency(t *testing.T) {
+	slow := &routerFakeProvider{name: "slow", delay: 20 * time.Millisecond}
+	fast := &routerFakeProvider{name: "fast"}
+	r := NewRouterProvider(RouterConfig{
+		Strategy: RouteLeastLatency,
+		Backends: []RouterBackend{{Provider: slow}, {Provider: fast}},
+	})
+
+	// First two requests sample each backend (untried backends go first).
+	for i := 0; i < 2; i++ {
+		_, _ = r.Complete(context.Background(), ChatRequest{}, nil)
+	}
+	resp, _ := r.Complete(context.Background(), ChatRequest{}, nil)
+	if resp.Content != "fast" {
+		t.Errorf("expected fast backend, got %q", resp.Content)
+	}
+}
+
+func TestRouterProvider_CapabilityRouting(t *testing.T) {
+	noTools := &routerFakeProvider{name: "no-tools"}
+	vision := &routerFakeProvider{name: "vision"}
+	r := NewRouterProvider(RouterConfig{
+		Strategy: RouteCostFirst,
+		Requirements: func(req ChatRequest) []Capability {
+			if req.Model == "needs-vision" {
+				return []Capability{CapabilityVision}
+			}
+			return nil
+		},
+		Backends: []RouterBackend{
+			{Provider: noTools, Capabilities: []Capability{CapabilityVision}},
+			{Provider: vision, Capabilities: []Capability{CapabilityTools, CapabilityVision}, InputCostPerMTok: 1},
+		},
+	})
+
+	resp, err := r.Complete(context.Background(), ChatRequest{Tools: []ToolDefinition{{Name: "t"}}}, nil)
+	if err != nil || resp.Content != "vision" {
+		t.Fatalf("expected tools request routed to vision backend, got %q, %v", resp.Content, err)
+	}
+
+	r2 := NewRouterProvider(RouterConfig{
+		Requirements: func(ChatRequest) []Capability { return []Capability{CapabilityThinking} },
+		Backends:     []RouterBackend{{Provider: noTools, Capabilities: []Capability{CapabilityTools}}},
+	})
+	_, err = r2.Complete(context.Background(), ChatRequest{}, nil)
+	var nbErr *NoBackendError
+	if !errors.As(err, &nbErr) || len(nbErr.Required) != 1 {
+		t.Fatalf("expected NoBackendError, got %v", err)
+	}
+}
+
+// modelCapsProvider reports vision only for visionModel.
+type modelCapsProvider struct {
+	routerFakeProvider
+	visionModel string
+}
+
+func (p *modelCapsProvider) Capabilities(model string) ProviderCapabilities {
+	return ProviderCapabilities{Tools: true, Vision: model == p.visionModel}
+}
+
+func TestRouterProvider_CapabilitiesPerRequestModel(t *testing.T) {
+	multi := &modelCapsProvider{routerFakeProvider: routerFakeProvider{name: "multi"}, visionModel: "big"}
+	fallback := &routerFakeProvider{name: "fallback"}
+	r := NewRouterProvider(RouterConfig{
+		Strategy: RouteCostFirst,
+		Backends: []RouterBackend{
+			{Provider: multi},
+			{Provider: fallback, InputCostPerMTok: 1},
+		},
+	})
+	image := []ChatMessage{{Role: ChatRoleUser, Content: "what is this?", Images: []ChatImage{{URL: "https://example.com/cat.png"}}}}
+
+	resp, err := r.Complete(context.Background(), ChatRequest{Model: "big", Messages: image}, nil)
+	if err != nil || resp.Content != "multi" {
+		t.Errorf("vision request for a vision model: got %q, %v", resp.Content, err)
+	}
+	resp, err = r.Complete(context.Background(), ChatRequest{Model: "small", Messages: image}, nil)
+	if err != nil || resp.Content != "fallback" {
+		t.Errorf("vision request for a text-only model should skip the backend: got %q, %v", resp.Content, err)
+	}
+}
+
+func TestRouterProvider_AllBackendsFail(t *testing.T) {
+	a := &routerFakeProvider{name: "a", err: &APIError{StatusCode: http.StatusInternalServerError}}
+	b := &routerFakeProvider{name: "b", err: fmt.Errorf("http request: %w", context.DeadlineExceeded)}
+	r := NewRouterProvider(RouterConfig{Backends: []RouterBackend{{Provider: a}, {Provider: b}}})
+
+	_, err := r.Complete(context.Background(), ChatRequest{}, nil)
+	var nbErr *NoBackendError
+	if !errors.As(err, &nbErr) || len(nbErr.Errors) != 2 {
+		t.Fatalf("expected NoBackendError with 2 failures, got %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Error("expected NoBackendError to unwrap to the backend errors")
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, true},
+		{&APIError{StatusCode: 429}, true},
+		{&APIError{StatusCode: 503}, true},
+		{&APIError{StatusCode: 400}, false},
+		{&ContentFilterError{}, false},
+		{errors.New("boom"), false},
+	}
+	for _, c := range cases {
+		if got := IsRetryableError(c.err); got != c.want {
+			t.Errorf("IsRetryableError(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
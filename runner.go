@@ -0,0 +1,37 @@
+package claudeagent
+
+import "context"
+
+// Runner runs a prompt and streams AgentEvents. *Agent (CLI-backed) and
+// *APIAgent (provider-backed) both implement it, so code that serves or
+// dispatches agents, such as AgentHTTPHandler and subagents, can take
+// either backend.
+type Runner interface {
+	// Run starts the agent loop and streams its events. The channel closes
+	// when the run ends.
+	Run(ctx context.Context, prompt string) (<-chan AgentEvent, error)
+	// RunSync runs the agent loop and returns the assistant text it
+	// produced, without reasoning or tool input.
+	RunSync(ctx context.Context, prompt string) (string, error)
+}
+
+var (
+	_ Runner = (*Agent)(nil)
+	_ Runner = (*APIAgent)(nil)
+)
+
+// collectText drains events and returns the concatenated content deltas
+// and the first error reported.
+func collectText(events <-chan AgentEvent) (string, error) {
+	var content string
+	var runErr error
+	for event := range events {
+		if event.Error != nil && runErr == nil {
+			runErr = event.Error
+		}
+		if event.Type == AgentEventContentDelta {
+			content += event.Content
+		}
+	}
+	return content, runErr
+}
@@ -0,0 +1,100 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAgentHTTPHandlerServesAPIAgent(t *testing.T) {
+	agent := NewAPIAgent(APIAgentConfig{Provider: &cassetteScriptProvider{responses: []ChatResponse{
+		{Content: "Hello over SSE.", StopReason: "end_turn"},
+	}}})
+	rec := httptest.NewRecorder()
+	AgentHTTPHandler(agent)(rec, httptest.NewRequest("GET", "/run?prompt=hi", nil))
+
+	body := rec.Body.String()
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	for _, want := range []string{"event: content_delta", "Hello over SSE.", "event: complete", "event: close"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("response missing %q:\n%s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	AgentHTTPHandler(agent)(rec, httptest.NewRequest("GET", "/run", nil))
+	if rec.Code != 400 {
+		t.Errorf("missing prompt should be a bad request, got %d", rec.Code)
+	}
+}
+
+func TestSubagentRunner(t *testing.T) {
+	researcher := NewAPIAgent(APIAgentConfig{Provider: &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{Content: "Found 3 papers.", StopReason: "end_turn"},
+	}}}})
+	sc := NewSubagentConfig()
+	sc.Add(&AgentDefinition{Name: "researcher", Description: "Finds papers", Runner: researcher})
+
+	var started []string
+	hooks := NewHooks()
+	hooks.OnEvent(HookSubagentStart, func(_ context.Context, data HookEventData) {
+		started = append(started, data.SubagentName+": "+data.Message)
+	})
+	registry := NewToolRegistry()
+	registerTaskTool(registry, sc, Options{}, hooks)
+
+	out, err := registry.Execute(context.Background(), "Task",
+		json.RawMessage(`{"description":"find papers on RAG","subagent_name":"researcher"}`))
+	if err != nil {
+		t.Fatalf("Task: %v", err)
+	}
+	if out != "Found 3 papers." {
+		t.Errorf("Task result = %q", out)
+	}
+	if len(started) != 1 || started[0] != "researcher: find papers on RAG" {
+		t.Errorf("SubagentStart hook not emitted: %v", started)
+	}
+}
+
+// noisyRunner streams reasoning and tool input around its answer, and its
+// RunSync returns all of it, as a careless Runner might.
+type noisyRunner struct{}
+
+func (noisyRunner) Run(context.Context, string) (<-chan AgentEvent, error) {
+	ch := make(chan AgentEvent, 4)
+	ch <- AgentEvent{Type: AgentEventReasoningDelta, Content: "Let me search. "}
+	ch <- AgentEvent{Type: AgentEventToolUseDelta, Content: `{"q":"RAG"}`}
+	ch <- AgentEvent{Type: AgentEventContentDelta, Content: "Found 3 papers."}
+	ch <- AgentEvent{Type: AgentEventComplete}
+	close(ch)
+	return ch, nil
+}
+
+func (r noisyRunner) RunSync(ctx context.Context, prompt string) (string, error) {
+	events, _ := r.Run(ctx, prompt)
+	var all string
+	for e := range events {
+		all += e.Content
+	}
+	return all, nil
+}
+
+func TestSubagentReturnsOnlyContent(t *testing.T) {
+	sc := NewSubagentConfig()
+	sc.Add(&AgentDefinition{Name: "researcher", Runner: noisyRunner{}})
+	registry := NewToolRegistry()
+	registerTaskTool(registry, sc, Options{}, nil)
+
+	out, err := registry.Execute(context.Background(), "Task",
+		json.RawMessage(`{"description":"find papers","subagent_name":"researcher"}`))
+	if err != nil {
+		t.Fatalf("Task: %v", err)
+	}
+	if out != "Found 3 papers." {
+		t.Errorf("Task result = %q, want only the content deltas", out)
+	}
+}
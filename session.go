@@ -0,0 +1,472 @@
+package claudeagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrSessionBusy is returned when a Session is used while a Send is still
+// running, including, with a SessionStore, a run claimed by another worker.
+var ErrSessionBusy = errors.New("session is busy")
+
+// SessionUsage is the cumulative usage of a Session across Send calls.
+// Budget limits apply to these totals.
+type SessionUsage struct {
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+	// Turns counts LLM turns.
+	Turns int `json:"turns"`
+	// Duration is the time spent inside Send calls. BudgetConfig.MaxDuration
+	// limits it, so idle time between messages does not count.
+	Duration time.Duration `json:"duration"`
+}
+
+// SessionState is the serializable state of a Session. Save it between
+// requests and restore it with APIAgent.ResumeSession, or configure a
+// SessionStore and use APIAgent.LoadSession.
+type SessionState struct {
+	ID      string        `json:"id"`
+	History []ChatMessage `json:"history"`
+	// Todos is the session's todo list when the agent has EnableTodos.
+	Todos []TodoItem `json:"todos,omitempty"`
+	// Artifacts are the session's artifacts when the agent has Artifacts.
+	Artifacts []Artifact   `json:"artifacts,omitempty"`
+	Usage     SessionUsage `json:"usage"`
+	// Pending is set while the session waits for tool approvals.
+	Pending *PendingApproval `json:"pending,omitempty"`
+	// PermissionMode is set by Session.SetPermissionMode.
+	PermissionMode PermissionMode `json:"permission_mode,omitempty"`
+	// Metadata is free-form application data, such as a user ID or title.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// RunningUntil is set while a worker runs the session and holds its
+	// lease (APIAgentConfig.SessionLease). Send and Resolve on another copy
+	// return ErrSessionBusy until the run ends or the lease expires.
+	RunningUntil time.Time `json:"running_until"`
+	// Version is the SessionStore version this state was loaded at.
+	Version   int64     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Session is a multi-turn conversation with an APIAgent. It keeps the
+// message history, todo list and budget usage across Send calls, while
+// the agent's configuration (provider, tools, hooks) is shared by all of
+// its sessions.
+//
+// A Session handles one Send at a time; its methods are safe for
+// concurrent use.
+//
+// Example:
+//
+//	session := agent.NewSession()
+//	reply, err := session.SendSync(ctx, "My name is Ada.")
+//	reply, err = session.SendSync(ctx, "What is my name?")
+//
+//	state := session.State() // JSON-serializable
+//	later := agent.ResumeSession(state)
+type Session struct {
+	agent *APIAgent
+
+	mu        sync.Mutex
+	id        string
+	history   []ChatMessage
+	todos     *TodoStore
+	artifacts *ArtifactRegistry
+	usage     SessionUsage
+	pending   *PendingApproval
+	steering  *SteeringQueue
+	mode      PermissionMode
+	metadata  map[string]string
+	version   int64
+	createdAt time.Time
+	updatedAt time.Time
+	running   bool
+	// runID is the journal run ID of the latest run.
+	runID string
+	// leaseUntil is the stored run lease; see SessionState.RunningUntil.
+	leaseUntil time.Time
+	// stopRenew stops the running run's lease renewal; leaseLost is set
+	// when a renewal found the session taken over.
+	stopRenew chan struct{}
+	leaseLost bool
+}
+
+// NewSession starts an empty conversation with the agent.
+func (a *APIAgent) NewSession() *Session {
+	return a.ResumeSession(SessionState{ID: "sess_" + randomID(24)})
+}
+
+// ResumeSession continues a conversation from a saved SessionState.
+func (a *APIAgent) ResumeSession(state SessionState) *Session {
+	s := &Session{
+		agent:     a,
+		id:        state.ID,
+		history:   append([]ChatMessage(nil), state.History...),
+		usage:     state.Usage,
+		pending:   state.Pending,
+		mode:      state.PermissionMode,
+		metadata:  copyMetadata(state.Metadata),
+		version:   state.Version,
+		createdAt: state.CreatedAt,
+		updatedAt: state.UpdatedAt,
+
+		leaseUntil: state.RunningUntil,
+	}
+	if a.todoStore != nil {
+		s.todos = NewTodoStore()
+		s.todos.Write(state.Todos)
+	}
+	if a.artifacts != nil {
+		s.artifacts = NewArtifactRegistry()
+		s.artifacts.restore(state.Artifacts)
+	}
+	return s
+}
+
+// LoadSession resumes a session from the agent's SessionStore.
+func (a *APIAgent) LoadSession(ctx context.Context, id string) (*Session, error) {
+	if a.sessionStore == nil {
+		return nil, errors.New("agent has no SessionStore")
+	}
+	state, err := a.sessionStore.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return a.ResumeSession(state), nil
+}
+
+// ID returns the session ID.
+func (s *Session) ID() string { return s.id }
+
+// Send appends message to the conversation and runs the agent loop from the
+// full history. Events are streamed as with APIAgent.Run. The session's
+// state is updated before the channel closes, including when the run
+// fails part-way; completed turns are kept.
+//
+// With a SessionStore, Send saves the new message before running and the
+// result afterwards. If another worker saved the session since it was
+// loaded, Send returns an error wrapping ErrSessionConflict without
+// running; reload the session and retry. A failed final save is sent as
+// an AgentEventError.
+func (s *Session) Send(ctx context.Context, message string, images ...ChatImage) (<-chan AgentEvent, error) {
+	a := s.agent
+	if err := a.checkCapabilities(images); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busyLocked() {
+		return nil, ErrSessionBusy
+	}
+	if s.pending != nil {
+		return nil, ErrApprovalPending
+	}
+
+	history := make([]ChatMessage, len(s.history), len(s.history)+1)
+	copy(history, s.history)
+	history = append(history, ChatMessage{Role: ChatRoleUser, Content: message, Images: images})
+	return s.startLocked(ctx, s.newRunState(ctx, history))
+}
+
+// newRunState prepares a run of the session from history. Its done
+// callback folds the result back into the session.
+func (s *Session) newRunState(ctx context.Context, history []ChatMessage) *runState {
+	a := s.agent
+	start := time.Now()
+	s.steering = steeringFor(ctx)
+	if s.steering == nil {
+		s.steering = NewSteeringQueue()
+	}
+	s.steering.open()
+	var journal *runJournal
+	if a.journal != nil {
+		journal = &runJournal{journal: a.journal, runID: "run_" + randomID(24)}
+	}
+	return &runState{
+		history:   history,
+		journal:   journal,
+		todos:     s.todos,
+		artifacts: s.artifacts,
+		steering:  s.steering,
+		budget:    resumeBudgetTracker(a.budget, s.usage),
+		// Read at every tool call, so a change applies mid-run.
+		permissionMode: s.PermissionMode,
+		done: func(st *runState) error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.history = st.history
+			s.pending = st.paused
+			s.usage.InputTokens += st.usage.InputTokens
+			s.usage.OutputTokens += st.usage.OutputTokens
+			s.usage.CostUSD += st.usage.CostUSD
+			s.usage.Turns += st.usage.Turns
+			s.usage.Duration += time.Since(start)
+			s.running = false
+			if a.sessionStore == nil {
+				return nil
+			}
+			close(s.stopRenew)
+			s.stopRenew = nil
+			s.leaseUntil = time.Time{}
+			if s.leaseLost {
+				// Another worker owns the session now; the run already
+				// reported the conflict.
+				s.leaseLost = false
+				return nil
+			}
+			// Save even if the run was canceled.
+			return s.saveLocked(context.WithoutCancel(ctx), s.stateLocked())
+		},
+	}
+}
+
+// busyLocked reports whether this copy is running, or, with a
+// SessionStore, whether another worker held an unexpired run lease when
+// the session was loaded.
+func (s *Session) busyLocked() bool {
+	return s.running || (s.agent.sessionStore != nil && time.Now().Before(s.leaseUntil))
+}
+
+// startLocked journals the run's start, claims the session in the store
+// and starts the run.
+func (s *Session) startLocked(ctx context.Context, st *runState) (<-chan AgentEvent, error) {
+	if err := st.journal.record(ctx, JournalEntry{Type: JournalRunStart, Messages: st.history}); err != nil {
+		return nil, err
+	}
+	if st.journal != nil {
+		s.runID = st.journal.runID
+	}
+	if s.agent.sessionStore != nil {
+		// Claim the session with a lease: copies loaded before the claim
+		// conflict on save, and copies loaded after it see the lease.
+		claim := s.stateLocked()
+		claim.History = st.history
+		claim.RunningUntil = time.Now().Add(s.agent.sessionLease)
+		if err := s.saveLocked(ctx, claim); err != nil {
+			return nil, err
+		}
+		s.leaseUntil = claim.RunningUntil
+
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		s.stopRenew = make(chan struct{})
+		go s.renewLease(ctx, claim, s.stopRenew, cancel)
+	}
+	s.running = true
+
+	events := make(chan AgentEvent, 100)
+	go s.agent.runLoop(ctx, st, events)
+	return events, nil
+}
+
+// renewLease extends the run lease every third of its length until stop
+// is closed. If the session was saved by another worker in the meantime,
+// it stops the run with an error wrapping ErrSessionConflict rather than
+// let two workers run it.
+func (s *Session) renewLease(ctx context.Context, claim SessionState, stop <-chan struct{}, cancel context.CancelCauseFunc) {
+	defer cancel(nil)
+	lease := s.agent.sessionLease
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		if s.stopRenew != stop {
+			s.mu.Unlock()
+			return
+		}
+		claim.Version = s.version
+		claim.RunningUntil = time.Now().Add(lease)
+		err := s.saveLocked(context.WithoutCancel(ctx), claim)
+		if err == nil {
+			s.leaseUntil = claim.RunningUntil
+		} else if errors.Is(err, ErrSessionConflict) {
+			s.leaseLost = true
+		}
+		s.mu.Unlock()
+
+		if errors.Is(err, ErrSessionConflict) {
+			cancel(fmt.Errorf("session lease lost: %w", err))
+			return
+		}
+		// Other store errors are retried at the next tick; the lease
+		// is still held until it expires.
+	}
+}
+
+// RunID returns the journal run ID of the session's latest run, or ""
+// if the agent has no Journal. Pass it to APIAgent.ResumeRun to continue
+// a run that was interrupted.
+func (s *Session) RunID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runID
+}
+
+// SendSync is Send that waits for the reply and returns its text.
+func (s *Session) SendSync(ctx context.Context, message string) (string, error) {
+	events, err := s.Send(ctx, message)
+	if err != nil {
+		return "", err
+	}
+	return collectText(events)
+}
+
+// History returns a copy of the conversation so far.
+func (s *Session) History() []ChatMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ChatMessage(nil), s.history...)
+}
+
+// SetHistory replaces the conversation, e.g. to edit or truncate it. It
+// returns ErrSessionBusy while a Send is running.
+func (s *Session) SetHistory(history []ChatMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return ErrSessionBusy
+	}
+	s.history = append([]ChatMessage(nil), history...)
+	return nil
+}
+
+// Todos returns the session's todo list, or nil if the agent does not
+// have EnableTodos.
+func (s *Session) Todos() []TodoItem {
+	if s.todos == nil {
+		return nil
+	}
+	return s.todos.List()
+}
+
+// Artifacts returns the session's artifacts, or nil if the agent does not
+// have Artifacts.
+func (s *Session) Artifacts() []Artifact {
+	if s.artifacts == nil {
+		return nil
+	}
+	return s.artifacts.All()
+}
+
+// Usage returns the session's cumulative usage.
+func (s *Session) Usage() SessionUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage
+}
+
+// Metadata returns a copy of the session's metadata.
+func (s *Session) Metadata() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copyMetadata(s.metadata)
+}
+
+// SetMetadata sets a metadata value. It is persisted by the next save.
+func (s *Session) SetMetadata(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.metadata == nil {
+		s.metadata = make(map[string]string)
+	}
+	s.metadata[key] = value
+}
+
+// PermissionMode returns the session's permission mode. It is
+// PermissionDefault unless changed with SetPermissionMode.
+func (s *Session) PermissionMode() PermissionMode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mode == "" {
+		return PermissionDefault
+	}
+	return s.mode
+}
+
+// SetPermissionMode changes how the session's tool calls are permitted,
+// including those of a running Send, from its next tool call on:
+// PermissionPlan runs only tools annotated ReadOnly, PermissionAcceptEdits
+// skips CanUseTool for tools not annotated Destructive, and
+// PermissionBypassAll skips CanUseTool entirely. Calls already approved
+// with Resolve are not affected. The mode is persisted by the next save.
+func (s *Session) SetPermissionMode(mode PermissionMode) error {
+	if !mode.Valid() {
+		return fmt.Errorf("invalid permission mode %q", mode)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mode = mode
+	return nil
+}
+
+// State returns a snapshot of the session for serialization. Take it
+// between Send calls; a running Send's history and usage are not included
+// until it finishes.
+func (s *Session) State() SessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stateLocked()
+}
+
+func (s *Session) stateLocked() SessionState {
+	return SessionState{
+		ID:             s.id,
+		History:        append([]ChatMessage(nil), s.history...),
+		Todos:          s.Todos(),
+		Artifacts:      s.Artifacts(),
+		Usage:          s.usage,
+		Pending:        s.pending,
+		PermissionMode: s.mode,
+		Metadata:       copyMetadata(s.metadata),
+		RunningUntil:   s.leaseUntil,
+		Version:        s.version,
+		CreatedAt:      s.createdAt,
+		UpdatedAt:      s.updatedAt,
+	}
+}
+
+// Save writes the session to the agent's SessionStore, e.g. after
+// SetHistory or SetMetadata. Send saves automatically.
+func (s *Session) Save(ctx context.Context) error {
+	if s.agent.sessionStore == nil {
+		return errors.New("agent has no SessionStore")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return ErrSessionBusy
+	}
+	return s.saveLocked(ctx, s.stateLocked())
+}
+
+func (s *Session) saveLocked(ctx context.Context, state SessionState) error {
+	if err := s.agent.sessionStore.Save(ctx, &state); err != nil {
+		return err
+	}
+	s.version = state.Version
+	s.createdAt = state.CreatedAt
+	s.updatedAt = state.UpdatedAt
+	return nil
+}
+
+func copyMetadata(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,315 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSessionNotFound is returned by SessionStore.Load and Delete for an
+	// unknown session ID.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionConflict is returned by SessionStore.Save when the stored
+	// session has changed since it was loaded.
+	ErrSessionConflict = errors.New("session was modified concurrently")
+)
+
+// SessionStore persists SessionState values by ID.
+//
+// Save uses optimistic concurrency: it succeeds only if the stored version
+// equals state.Version (0 for a session that has never been saved), then
+// increments state.Version and sets UpdatedAt. Otherwise it returns an
+// error wrapping ErrSessionConflict and the caller should reload.
+type SessionStore interface {
+	Load(ctx context.Context, id string) (SessionState, error)
+	Save(ctx context.Context, state *SessionState) error
+	// List returns all sessions, most recently updated first.
+	List(ctx context.Context) ([]SessionInfo, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// SessionInfo summarizes a stored session.
+type SessionInfo struct {
+	ID        string            `json:"id"`
+	Version   int64             `json:"version"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	// Messages is the number of messages in the history.
+	Messages int `json:"messages"`
+}
+
+func (s *SessionState) info() SessionInfo {
+	return SessionInfo{
+		ID:        s.ID,
+		Version:   s.Version,
+		Metadata:  s.Metadata,
+		CreatedAt: s.CreatedAt,
+		UpdatedAt: s.UpdatedAt,
+		Messages:  len(s.History),
+	}
+}
+
+// prepareSave checks the expected version against the stored one and
+// advances state for writing.
+func prepareSave(state *SessionState, stored int64) error {
+	if stored != state.Version {
+		return fmt.Errorf("%w: %s is at version %d, not %d", ErrSessionConflict, state.ID, stored, state.Version)
+	}
+	now := time.Now().UTC()
+	if state.CreatedAt.IsZero() {
+		state.CreatedAt = now
+	}
+	state.UpdatedAt = now
+	state.Version++
+	return nil
+}
+
+func sortSessionInfos(infos []SessionInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].UpdatedAt.After(infos[j].UpdatedAt)
+	})
+}
+
+// MemorySessionStore is a SessionStore held in memory. States are stored
+// as JSON, so loaded values never alias saved ones.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string][]byte
+	infos    map[string]SessionInfo
+}
+
+// NewMemorySessionStore creates an empty in-memory store.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string][]byte),
+		infos:    make(map[string]SessionInfo),
+	}
+}
+
+// Load returns the stored session.
+func (m *MemorySessionStore) Load(_ context.Context, id string) (SessionState, error) {
+	m.mu.Lock()
+	data, ok := m.sessions[id]
+	m.mu.Unlock()
+	if !ok {
+		return SessionState{}, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	var state SessionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return SessionState{}, fmt.Errorf("decode session %s: %w", id, err)
+	}
+	return state, nil
+}
+
+// Save stores the session if its version matches.
+func (m *MemorySessionStore) Save(_ context.Context, state *SessionState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	next := *state
+	if err := prepareSave(&next, m.infos[state.ID].Version); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&next)
+	if err != nil {
+		return fmt.Errorf("encode session %s: %w", state.ID, err)
+	}
+	m.sessions[state.ID] = data
+	m.infos[state.ID] = next.info()
+	*state = next
+	return nil
+}
+
+// List returns all sessions, most recently updated first.
+func (m *MemorySessionStore) List(_ context.Context) ([]SessionInfo, error) {
+	m.mu.Lock()
+	infos := make([]SessionInfo, 0, len(m.infos))
+	for _, info := range m.infos {
+		infos = append(infos, info)
+	}
+	m.mu.Unlock()
+	sortSessionInfos(infos)
+	return infos, nil
+}
+
+// Delete removes the session.
+func (m *MemorySessionStore) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	delete(m.sessions, id)
+	delete(m.infos, id)
+	return nil
+}
+
+// storeIDPattern restricts IDs used as file names.
+var storeIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// staleLockAge is how old a lock file must be before it is assumed to be
+// left over from a crashed process.
+const staleLockAge = 30 * time.Second
+
+// FileSessionStore is a SessionStore that keeps each session in
+// <dir>/<id>.json. Writes are atomic, and a lock file per session makes
+// Save safe across processes sharing the directory.
+type FileSessionStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileSessionStore creates a store in dir, creating the directory if
+// needed.
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create session dir: %w", err)
+	}
+	return &FileSessionStore{dir: dir}, nil
+}
+
+func (f *FileSessionStore) path(id string) (string, error) {
+	if !storeIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid session ID %q", id)
+	}
+	return filepath.Join(f.dir, id+".json"), nil
+}
+
+// Load reads the session file.
+func (f *FileSessionStore) Load(_ context.Context, id string) (SessionState, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return SessionState{}, err
+	}
+	return readSessionFile(path, id)
+}
+
+func readSessionFile(path, id string) (SessionState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return SessionState{}, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	if err != nil {
+		return SessionState{}, fmt.Errorf("read session %s: %w", id, err)
+	}
+	var state SessionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return SessionState{}, fmt.Errorf("decode session %s: %w", id, err)
+	}
+	return state, nil
+}
+
+// Save writes the session file if its version matches.
+func (f *FileSessionStore) Save(ctx context.Context, state *SessionState) error {
+	path, err := f.path(state.ID)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	unlock, err := lockFile(ctx, path+".lock")
+	if err != nil {
+		return fmt.Errorf("lock session %s: %w", state.ID, err)
+	}
+	defer unlock()
+
+	var stored int64
+	current, err := readSessionFile(path, state.ID)
+	switch {
+	case err == nil:
+		stored = current.Version
+	case !errors.Is(err, ErrSessionNotFound):
+		return err
+	}
+
+	next := *state
+	if err := prepareSave(&next, stored); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(&next, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode session %s: %w", state.ID, err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write session %s: %w", state.ID, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write session %s: %w", state.ID, err)
+	}
+	*state = next
+	return nil
+}
+
+// lockFile creates path exclusively, retrying until ctx is done. Locks
+// older than staleLockAge are removed.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	for {
+		fh, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			fh.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if fi, statErr := os.Stat(path); statErr == nil && time.Since(fi.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// List reads every session file, most recently updated first.
+func (f *FileSessionStore) List(_ context.Context) ([]SessionInfo, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	var infos []SessionInfo
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() || !storeIDPattern.MatchString(id) {
+			continue
+		}
+		state, err := readSessionFile(filepath.Join(f.dir, e.Name()), id)
+		if errors.Is(err, ErrSessionNotFound) {
+			continue // deleted while listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, state.info())
+	}
+	sortSessionInfos(infos)
+	return infos, nil
+}
+
+// Delete removes the session file.
+func (f *FileSessionStore) Delete(_ context.Context, id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+		}
+		return fmt.Errorf("delete session %s: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSessionStore(t *testing.T, store SessionStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	if _, err := store.Load(ctx, "missing"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Load missing: %v", err)
+	}
+
+	state := SessionState{
+		ID:       "sess_a",
+		History:  []ChatMessage{{Role: ChatRoleUser, Content: "hi"}},
+		Metadata: map[string]string{"user": "ada"},
+	}
+	if err := store.Save(ctx, &state); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if state.Version != 1 || state.CreatedAt.IsZero() || state.UpdatedAt.IsZero() {
+		t.Errorf("Save should advance version and timestamps: %+v", state)
+	}
+
+	loaded, err := store.Load(ctx, "sess_a")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if loaded.Version != 1 || len(loaded.History) != 1 || loaded.Metadata["user"] != "ada" {
+		t.Errorf("unexpected loaded state: %+v", loaded)
+	}
+
+	// A second writer holding the old version loses.
+	stale := loaded
+	loaded.History = append(loaded.History, ChatMessage{Role: ChatRoleAssistant, Content: "hello"})
+	if err := store.Save(ctx, &loaded); err != nil {
+		t.Fatalf("Save loaded: %v", err)
+	}
+	stale.History = append(stale.History, ChatMessage{Role: ChatRoleUser, Content: "other"})
+	if err := store.Save(ctx, &stale); !errors.Is(err, ErrSessionConflict) {
+		t.Fatalf("stale Save should conflict, got %v", err)
+	}
+	if stale.Version != 1 {
+		t.Errorf("failed Save must not change the version, got %d", stale.Version)
+	}
+	if fresh := (SessionState{ID: "sess_a"}); !errors.Is(store.Save(ctx, &fresh), ErrSessionConflict) {
+		t.Error("creating an existing session should conflict")
+	}
+
+	other := SessionState{ID: "sess_b"}
+	if err := store.Save(ctx, &other); err != nil {
+		t.Fatalf("Save other: %v", err)
+	}
+	infos, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(infos) != 2 || infos[0].ID != "sess_b" || infos[1].ID != "sess_a" || infos[1].Messages != 2 || infos[1].Version != 2 {
+		t.Errorf("unexpected list: %+v", infos)
+	}
+
+	if err := store.Delete(ctx, "sess_a"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Load(ctx, "sess_a"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Load after Delete: %v", err)
+	}
+	if err := store.Delete(ctx, "sess_a"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("second Delete: %v", err)
+	}
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	testSessionStore(t, NewMemorySessionStore())
+}
+
+func TestFileSessionStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileSessionStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileSessionStore: %v", err)
+	}
+	testSessionStore(t, store)
+
+	if _, err := store.Load(context.Background(), "../escape"); err == nil {
+		t.Error("path-like IDs should be rejected")
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".json" {
+			t.Errorf("leftover file %s", e.Name())
+		}
+	}
+}
+
+func TestFileSessionStoreWaitsForLock(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileSessionStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileSessionStore: %v", err)
+	}
+	// Another process holds the lock.
+	if err := os.WriteFile(filepath.Join(dir, "sess_a.json.lock"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.Save(ctx, &SessionState{ID: "sess_a"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Save should give up when ctx is done, got %v", err)
+	}
+}
+
+func TestLoadSessionRestoresState(t *testing.T) {
+	provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{ToolCalls: []ToolCall{
+			{ID: "t1", Name: TodoToolName, Input: json.RawMessage(
+				`{"todos":[{"id":"1","description":"ship it","status":"pending","priority":"high"}]}`)},
+			{ID: "t2", Name: "create_artifact", Input: json.RawMessage(`{"type":"text","title":"Notes","content":"v1"}`)},
+		}, StopReason: "tool_use", Usage: ChatUsage{InputTokens: 10, OutputTokens: 3}},
+		{Content: "Done.", StopReason: "end_turn", Usage: ChatUsage{InputTokens: 20, OutputTokens: 2}},
+		{Content: "Still done.", StopReason: "end_turn"},
+	}}}
+	store := NewMemorySessionStore()
+	agent := NewAPIAgent(APIAgentConfig{
+		Provider:     provider,
+		EnableTodos:  true,
+		Artifacts:    NewArtifactRegistry(),
+		SessionStore: store,
+	})
+	ctx := context.Background()
+
+	session := agent.NewSession()
+	session.SetMetadata("user", "ada")
+	if _, err := session.SendSync(ctx, "plan"); err != nil {
+		t.Fatalf("SendSync: %v", err)
+	}
+
+	// A different worker picks the session up by ID.
+	resumed, err := agent.LoadSession(ctx, session.ID())
+	if err != nil {
+		t.Fatalf("LoadSession: %v", err)
+	}
+	state := resumed.State()
+	if len(state.History) != 5 || len(state.Todos) != 1 || len(state.Artifacts) != 1 || state.Artifacts[0].Content != "v1" {
+		t.Errorf("state not restored: %+v", state)
+	}
+	if state.Usage.InputTokens != 30 || state.Usage.Turns != 2 || state.Metadata["user"] != "ada" || state.Version != 2 || state.UpdatedAt.IsZero() {
+		t.Errorf("usage, metadata or version not restored: %+v", state)
+	}
+	if agent.artifacts.Count() != 0 {
+		t.Error("artifacts leaked out of the session")
+	}
+
+	if _, err := resumed.SendSync(ctx, "status?"); err != nil {
+		t.Fatalf("SendSync after load: %v", err)
+	}
+	if msgs := provider.requests[2].Messages; len(msgs) != 6 || msgs[5].Content != "status?" {
+		t.Errorf("resumed request should carry the stored history, got %+v", msgs)
+	}
+
+	// The original copy is now stale and must not overwrite the newer turn.
+	if _, err := session.Send(ctx, "again"); !errors.Is(err, ErrSessionConflict) {
+		t.Errorf("stale session should conflict, got %v", err)
+	}
+	if err := session.SetHistory(nil); err != nil {
+		t.Errorf("conflict should leave the session usable: %v", err)
+	}
+	stored, _ := store.Load(ctx, session.ID())
+	if len(stored.History) != 7 {
+		t.Errorf("stored history should be the resumed session's, got %d messages", len(stored.History))
+	}
+
+	if _, err := NewAPIAgent(APIAgentConfig{Provider: provider}).LoadSession(ctx, "x"); err == nil {
+		t.Error("LoadSession without a store should fail")
+	}
+}
+
+func TestSessionLeaseAcrossCopies(t *testing.T) {
+	release := make(chan struct{})
+	provider := &steeredProvider{
+		recordingScriptProvider: recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+			{Content: "first", StopReason: "end_turn"},
+			{Content: "second", StopReason: "end_turn"},
+		}}},
+		before: func(call int) {
+			if call == 0 {
+				<-release
+			}
+		},
+	}
+	store := NewMemorySessionStore()
+	agent := NewAPIAgent(APIAgentConfig{Provider: provider, SessionStore: store})
+	ctx := context.Background()
+
+	session := agent.NewSession()
+	if err := session.Save(ctx); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	// Worker A loads the session and starts a run that blocks in the provider.
+	a, err := agent.LoadSession(ctx, session.ID())
+	if err != nil {
+		t.Fatalf("LoadSession: %v", err)
+	}
+	events, err := a.Send(ctx, "one")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	// Worker B loads the session after A claimed it and must not run it.
+	b, err := agent.LoadSession(ctx, session.ID())
+	if err != nil {
+		t.Fatalf("LoadSession: %v", err)
+	}
+	if b.State().RunningUntil.IsZero() {
+		t.Error("the claim should store a run lease")
+	}
+	if _, err := b.Send(ctx, "two"); !errors.Is(err, ErrSessionBusy) {
+		t.Errorf("Send on a leased session: err = %v, want ErrSessionBusy", err)
+	}
+	if _, err := b.Resolve(ctx, nil); !errors.Is(err, ErrSessionBusy) {
+		t.Errorf("Resolve on a leased session: err = %v, want ErrSessionBusy", err)
+	}
+
+	close(release)
+	for e := range events {
+		if e.Error != nil {
+			t.Fatalf("run error: %v", e.Error)
+		}
+	}
+
+	// The final save releases the lease, so a fresh copy can run.
+	c, err := agent.LoadSession(ctx, session.ID())
+	if err != nil {
+		t.Fatalf("LoadSession: %v", err)
+	}
+	if !c.State().RunningUntil.IsZero() {
+		t.Errorf("the final save should clear the lease, got %v", c.State().RunningUntil)
+	}
+	if reply, err := c.SendSync(ctx, "two"); err != nil || reply != "second" {
+		t.Errorf("SendSync after release = %q, %v", reply, err)
+	}
+
+	// An expired lease, such as one left by a crashed worker, is taken over.
+	state, _ := store.Load(ctx, session.ID())
+	state.RunningUntil = time.Now().Add(-time.Second)
+	if err := store.Save(ctx, &state); err != nil {
+		t.Fatalf("store.Save: %v", err)
+	}
+	d, _ := agent.LoadSession(ctx, session.ID())
+	events, err = d.Send(ctx, "three")
+	if err != nil {
+		t.Fatalf("an expired lease should not block the session: %v", err)
+	}
+	for range events {
+	}
+}
+
+// ctxBlockingProvider answers after delay, or fails when ctx is canceled.
+type ctxBlockingProvider struct {
+	delay time.Duration
+}
+
+func (p ctxBlockingProvider) Name() string { return "blocking" }
+
+func (p ctxBlockingProvider) Complete(ctx context.Context, _ ChatRequest, onEvent ChatStreamCallback) (ChatResponse, error) {
+	select {
+	case <-time.After(p.delay):
+	case <-ctx.Done():
+		return ChatResponse{}, context.Cause(ctx)
+	}
+	if onEvent != nil {
+		onEvent(ChatStreamEvent{Type: ChatStreamContentDelta, Content: "done"})
+	}
+	return ChatResponse{Content: "done", StopReason: "end_turn"}, nil
+}
+
+func TestSessionLeaseRenewal(t *testing.T) {
+	store := NewMemorySessionStore()
+	agent := NewAPIAgent(APIAgentConfig{
+		Provider:     ctxBlockingProvider{delay: 300 * time.Millisecond},
+		SessionStore: store,
+		SessionLease: 90 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	// A run longer than the lease keeps renewing it.
+	session := agent.NewSession()
+	events, err := session.Send(ctx, "slow")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	other, err := agent.LoadSession(ctx, session.ID())
+	if err != nil {
+		t.Fatalf("LoadSession: %v", err)
+	}
+	if _, err := other.Send(ctx, "mine"); !errors.Is(err, ErrSessionBusy) {
+		t.Errorf("Send during a renewed lease: err = %v, want ErrSessionBusy", err)
+	}
+	for e := range events {
+		if e.Error != nil {
+			t.Fatalf("run error: %v", e.Error)
+		}
+	}
+
+	// A run whose session is saved elsewhere stops at the next renewal.
+	events, err = session.Send(ctx, "again")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	state, _ := store.Load(ctx, session.ID())
+	if err := store.Save(ctx, &state); err != nil {
+		t.Fatalf("store.Save: %v", err)
+	}
+	var runErr error
+	for e := range events {
+		if e.Error != nil && runErr == nil {
+			runErr = e.Error
+		}
+	}
+	if !errors.Is(runErr, ErrSessionConflict) {
+		t.Errorf("run after losing the lease: err = %v, want ErrSessionConflict", runErr)
+	}
+	if stored, _ := store.Load(ctx, session.ID()); stored.Version != state.Version {
+		t.Errorf("the stopped run should not save over the new owner: version %d, want %d", stored.Version, state.Version)
+	}
+}
@@ -0,0 +1,113 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestSessionKeepsHistoryAcrossSends(t *testing.T) {
+	provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{Content: "Hi Ada.", StopReason: "end_turn", Usage: ChatUsage{InputTokens: 5, OutputTokens: 2}},
+		{Content: "Your name is Ada.", StopReason: "end_turn", Usage: ChatUsage{InputTokens: 12, OutputTokens: 4}},
+	}}}
+	agent := NewAPIAgent(APIAgentConfig{Provider: provider})
+	session := agent.NewSession()
+
+	if reply, err := session.SendSync(context.Background(), "My name is Ada."); err != nil || reply != "Hi Ada." {
+		t.Fatalf("first send: %q, %v", reply, err)
+	}
+	if reply, err := session.SendSync(context.Background(), "What is my name?"); err != nil || reply != "Your name is Ada." {
+		t.Fatalf("second send: %q, %v", reply, err)
+	}
+
+	msgs := provider.requests[1].Messages
+	if len(msgs) != 3 || msgs[0].Content != "My name is Ada." || msgs[1].Content != "Hi Ada." || msgs[2].Content != "What is my name?" {
+		t.Errorf("second request should carry the conversation, got %+v", msgs)
+	}
+	if h := session.History(); len(h) != 4 || h[3].Role != ChatRoleAssistant || h[3].Content != "Your name is Ada." {
+		t.Errorf("unexpected history: %+v", h)
+	}
+	if u := session.Usage(); u.InputTokens != 17 || u.OutputTokens != 6 || u.Turns != 2 || u.Duration <= 0 {
+		t.Errorf("unexpected usage: %+v", u)
+	}
+
+	// Replacing history starts the next Send from it.
+	if err := session.SetHistory(nil); err != nil {
+		t.Fatalf("SetHistory: %v", err)
+	}
+	if len(session.History()) != 0 {
+		t.Error("history not replaced")
+	}
+}
+
+func TestSessionStateRoundTrip(t *testing.T) {
+	provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{ToolCalls: []ToolCall{{ID: "t1", Name: TodoToolName, Input: json.RawMessage(
+			`{"todos":[{"id":"1","description":"write tests","status":"pending","priority":"high"}]}`)}}, StopReason: "tool_use"},
+		{Content: "Planned.", StopReason: "end_turn"},
+		{Content: "Still planned.", StopReason: "end_turn"},
+	}}}
+	agent := NewAPIAgent(APIAgentConfig{Provider: provider, EnableTodos: true})
+	session := agent.NewSession()
+	other := agent.NewSession()
+	if session.ID() == "" || session.ID() == other.ID() {
+		t.Errorf("sessions need distinct IDs: %q %q", session.ID(), other.ID())
+	}
+
+	if _, err := session.SendSync(context.Background(), "plan"); err != nil {
+		t.Fatalf("SendSync: %v", err)
+	}
+	if todos := session.Todos(); len(todos) != 1 || todos[0].Description != "write tests" {
+		t.Errorf("session todos not updated: %+v", todos)
+	}
+	if len(other.Todos()) != 0 || len(agent.TodoStore().List()) != 0 {
+		t.Error("todos leaked out of the session")
+	}
+
+	data, err := json.Marshal(session.State())
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var state SessionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	resumed := agent.ResumeSession(state)
+	if resumed.ID() != session.ID() || len(resumed.Todos()) != 1 || resumed.Usage().Turns != 2 {
+		t.Errorf("state not restored: %+v", resumed.State())
+	}
+
+	if _, err := resumed.SendSync(context.Background(), "status?"); err != nil {
+		t.Fatalf("SendSync after resume: %v", err)
+	}
+	msgs := provider.requests[2].Messages
+	if len(msgs) != 5 || msgs[1].ToolCalls[0].Name != TodoToolName || msgs[4].Content != "status?" {
+		t.Errorf("resumed request should carry the saved history, got %+v", msgs)
+	}
+}
+
+func TestSessionBudgetSpansSends(t *testing.T) {
+	provider := &cassetteScriptProvider{responses: []ChatResponse{
+		{Content: "one", StopReason: "end_turn", Usage: ChatUsage{InputTokens: 8, OutputTokens: 2}},
+		{Content: "two", StopReason: "end_turn", Usage: ChatUsage{InputTokens: 8, OutputTokens: 2}},
+	}}
+	agent := NewAPIAgent(APIAgentConfig{Provider: provider, Budget: &BudgetConfig{MaxTokens: 15}})
+	session := agent.NewSession()
+
+	if _, err := session.SendSync(context.Background(), "first"); err != nil {
+		t.Fatalf("first send: %v", err)
+	}
+	_, err := session.SendSync(context.Background(), "second")
+	var be *BudgetExceededError
+	if !errors.As(err, &be) {
+		t.Fatalf("expected the budget to span sends, got %v", err)
+	}
+
+	// A plain Run starts with a fresh budget.
+	provider.responses = append(provider.responses, ChatResponse{Content: "three", StopReason: "end_turn"})
+	if _, err := agent.RunSync(context.Background(), "third"); err != nil {
+		t.Errorf("Run should not share session budget: %v", err)
+	}
+}
@@ -1,9 +1,11 @@
 package claudeagent
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 )
 
 // SSEWriter wraps an http.ResponseWriter for Server-Sent Events.
@@ -30,6 +32,22 @@ func NewSSEWriter(w http.ResponseWriter) (*SSEWriter, error) {
 
 // WriteEvent writes an SSE event with the given type and data.
 func (s *SSEWriter) WriteEvent(eventType string, data any) error {
+	return s.writeEvent("", eventType, data)
+}
+
+// WriteEventWithID writes an SSE event with an "id:" field. Browsers send
+// the last ID they received as the Last-Event-ID header when reconnecting.
+func (s *SSEWriter) WriteEventWithID(id int64, eventType string, data any) error {
+	return s.writeEvent(strconv.FormatInt(id, 10), eventType, data)
+}
+
+// WriteData writes an SSE message with only a data field, for clients
+// that expect unnamed events, such as OpenAI-style streams.
+func (s *SSEWriter) WriteData(data any) error {
+	return s.writeEvent("", "", data)
+}
+
+func (s *SSEWriter) writeEvent(id, eventType string, data any) error {
 	var dataStr string
 
 	switch v := data.(type) {
@@ -45,8 +63,17 @@ func (s *SSEWriter) WriteEvent(eventType string, data any) error {
 		dataStr = string(jsonData)
 	}
 
-	_, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", eventType, dataStr)
-	if err != nil {
+	if id != "" {
+		if _, err := fmt.Fprintf(s.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if eventType != "" {
+		if _, err := fmt.Fprintf(s.w, "event: %s\n", eventType); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", dataStr); err != nil {
 		return err
 	}
 
@@ -54,8 +81,29 @@ func (s *SSEWriter) WriteEvent(eventType string, data any) error {
 	return nil
 }
 
+// WriteComment writes an SSE comment line, which clients ignore. Send one
+// periodically as a heartbeat so proxies do not close idle streams.
+func (s *SSEWriter) WriteComment(text string) error {
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
 // WriteAgentEvent writes an AgentEvent as an SSE event.
 func (s *SSEWriter) WriteAgentEvent(event AgentEvent) error {
+	return s.WriteEvent(string(event.Type), AgentEventData(event))
+}
+
+// WriteAgentEventWithID writes an AgentEvent as an SSE event with an ID.
+func (s *SSEWriter) WriteAgentEventWithID(id int64, event AgentEvent) error {
+	return s.WriteEventWithID(id, string(event.Type), AgentEventData(event))
+}
+
+// AgentEventData returns the JSON object WriteAgentEvent sends for event.
+// Empty fields are omitted.
+func AgentEventData(event AgentEvent) map[string]any {
 	eventData := map[string]any{
 		"type": event.Type,
 	}
@@ -75,8 +123,28 @@ func (s *SSEWriter) WriteAgentEvent(event AgentEvent) error {
 	if event.Error != nil {
 		eventData["error"] = event.Error.Error()
 	}
-
-	return s.WriteEvent(string(event.Type), eventData)
+	if event.ParentToolUseID != "" {
+		eventData["parent_tool_use_id"] = event.ParentToolUseID
+	}
+	if event.SubagentName != "" {
+		eventData["subagent_name"] = event.SubagentName
+	}
+	if event.Todos != nil {
+		eventData["todos"] = event.Todos
+	}
+	if event.TurnMetrics != nil {
+		eventData["turn_metrics"] = event.TurnMetrics
+	}
+	if event.Approvals != nil {
+		eventData["approvals"] = event.Approvals
+	}
+	if event.MessageID != "" {
+		eventData["message_id"] = event.MessageID
+	}
+	if event.ContentFilter != nil {
+		eventData["content_filter"] = event.ContentFilter
+	}
+	return eventData
 }
 
 // Close sends a final close event.
@@ -94,8 +162,19 @@ func StreamAgentToSSE(events <-chan AgentEvent, sse *SSEWriter) error {
 	return sse.Close()
 }
 
+// RunToSSE runs prompt on runner and streams its events to sse. Errors
+// starting the run are written as an "error" event.
+func RunToSSE(ctx context.Context, runner Runner, prompt string, sse *SSEWriter) error {
+	events, err := runner.Run(ctx, prompt)
+	if err != nil {
+		return sse.WriteEvent("error", map[string]string{"error": err.Error()})
+	}
+	return StreamAgentToSSE(events, sse)
+}
+
 // AgentHTTPHandler creates an HTTP handler that runs an agent and streams SSE.
-func AgentHTTPHandler(agent *Agent) http.HandlerFunc {
+// The agent can be any Runner, such as *Agent or *APIAgent.
+func AgentHTTPHandler(agent Runner) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		prompt := r.URL.Query().Get("prompt")
 		if prompt == "" {
@@ -109,12 +188,6 @@ func AgentHTTPHandler(agent *Agent) http.HandlerFunc {
 			return
 		}
 
-		events, err := agent.Run(r.Context(), prompt)
-		if err != nil {
-			_ = sse.WriteEvent("error", map[string]string{"error": err.Error()})
-			return
-		}
-
-		_ = StreamAgentToSSE(events, sse)
+		_ = RunToSSE(r.Context(), agent, prompt, sse)
 	}
 }
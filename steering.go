@@ -0,0 +1,172 @@
+package claudeagent
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// QueuedMessage is a user message waiting to be delivered to a running
+// agent.
+type QueuedMessage struct {
+	ID       string    `json:"id"`
+	Content  string    `json:"content"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// SteeringQueue holds user messages sent while an agent is running, such
+// as a correction typed during a long tool loop. The agent delivers them
+// at the next turn boundary: after the current tool results, or, if the
+// model has just finished its reply, as a new user turn that continues
+// the run. Each delivery emits an AgentEventMessageDelivered.
+//
+// A run closes its queue when it ends, and Push then fails with
+// ErrNotRunning rather than queue a message nothing will deliver. Starting
+// another run with the queue reopens it.
+//
+// A SteeringQueue is safe for concurrent use.
+type SteeringQueue struct {
+	mu       sync.Mutex
+	messages []QueuedMessage
+	closed   bool
+}
+
+// NewSteeringQueue creates an empty queue.
+func NewSteeringQueue() *SteeringQueue {
+	return &SteeringQueue{}
+}
+
+// Push queues a message and returns it with its ID. It returns
+// ErrNotRunning once the run using the queue has ended.
+func (q *SteeringQueue) Push(content string) (QueuedMessage, error) {
+	msg := QueuedMessage{ID: "msg_" + randomID(16), Content: content, QueuedAt: time.Now()}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return QueuedMessage{}, ErrNotRunning
+	}
+	q.messages = append(q.messages, msg)
+	return msg, nil
+}
+
+// Len returns the number of undelivered messages.
+func (q *SteeringQueue) Len() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages)
+}
+
+// open lets a new run accept messages. It is safe on a nil queue.
+func (q *SteeringQueue) open() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.closed = false
+	q.mu.Unlock()
+}
+
+// close makes Push fail once the run has ended. It is safe on a nil queue.
+func (q *SteeringQueue) close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+}
+
+// closeIfEmpty closes the queue unless messages are waiting, and reports
+// whether it did. A run calls it when the model has finished: checking and
+// closing under one lock means a Push either lands before the check and
+// continues the run, or fails with ErrNotRunning. It returns true on a nil
+// queue.
+func (q *SteeringQueue) closeIfEmpty() bool {
+	if q == nil {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.messages) > 0 {
+		return false
+	}
+	q.closed = true
+	return true
+}
+
+// drain removes and returns the queued messages. It is safe on a nil queue.
+func (q *SteeringQueue) drain() []QueuedMessage {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	msgs := q.messages
+	q.messages = nil
+	return msgs
+}
+
+// steeringKey is the context key for a run's SteeringQueue.
+type steeringKey struct{}
+
+// WithSteering attaches q to ctx so that a run started with ctx delivers
+// q's messages. Use it with APIAgent.Run; Agent.Steer and Session.Steer
+// manage a queue for you.
+func WithSteering(ctx context.Context, q *SteeringQueue) context.Context {
+	return context.WithValue(ctx, steeringKey{}, q)
+}
+
+func steeringFor(ctx context.Context) *SteeringQueue {
+	q, _ := ctx.Value(steeringKey{}).(*SteeringQueue)
+	return q
+}
+
+// emitDelivered acknowledges delivered messages.
+func emitDelivered(msgs []QueuedMessage, events chan<- AgentEvent) {
+	for _, m := range msgs {
+		events <- AgentEvent{Type: AgentEventMessageDelivered, Content: m.Content, MessageID: m.ID}
+	}
+}
+
+// deliverSteering appends queued messages to history as user messages and
+// reports whether there were any.
+func (a *APIAgent) deliverSteering(ctx context.Context, st *runState, history []ChatMessage, turn int, events chan<- AgentEvent) ([]ChatMessage, bool, error) {
+	msgs := st.steering.drain()
+	if len(msgs) == 0 {
+		return history, false, nil
+	}
+	start := len(history)
+	for _, m := range msgs {
+		history = append(history, ChatMessage{Role: ChatRoleUser, Content: m.Content})
+	}
+	if err := st.journal.record(ctx, JournalEntry{Type: JournalMessages, Turn: turn, Messages: history[start:]}); err != nil {
+		return history, true, err
+	}
+	emitDelivered(msgs, events)
+	return history, true, nil
+}
+
+// Steer queues a message for the running Send or Resolve. It returns
+// ErrNotRunning if the session is idle; use Send instead.
+func (s *Session) Steer(content string) (QueuedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running || s.steering == nil {
+		return QueuedMessage{}, ErrNotRunning
+	}
+	return s.steering.Push(content)
+}
+
+// Steer queues a message for the running Run. It returns ErrNotRunning if
+// the agent is idle.
+func (a *Agent) Steer(content string) (QueuedMessage, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.running || a.steering == nil {
+		return QueuedMessage{}, ErrNotRunning
+	}
+	return a.steering.Push(content)
+}
@@ -0,0 +1,219 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// steeredProvider calls before with the request index before answering.
+type steeredProvider struct {
+	recordingScriptProvider
+	before func(call int)
+}
+
+func (p *steeredProvider) Complete(ctx context.Context, req ChatRequest, onEvent ChatStreamCallback) (ChatResponse, error) {
+	if p.before != nil {
+		p.before(len(p.requests))
+	}
+	return p.recordingScriptProvider.Complete(ctx, req, onEvent)
+}
+
+func TestSteeringAfterToolResults(t *testing.T) {
+	queue := NewSteeringQueue()
+	var queued QueuedMessage
+	tools := NewToolRegistry()
+	tools.Register(ToolDefinition{Name: "query_db"}, func(context.Context, json.RawMessage) (string, error) {
+		// The user types a correction while the tool runs.
+		queued, _ = queue.Push("actually use the staging DB")
+		return "42 rows", nil
+	})
+	provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{ToolCalls: []ToolCall{{ID: "t1", Name: "query_db", Input: json.RawMessage(`{}`)}}, StopReason: "tool_use"},
+		{Content: "Switching to staging.", StopReason: "end_turn"},
+	}}}
+	agent := NewAPIAgent(APIAgentConfig{Provider: provider, Tools: tools})
+
+	events, err := agent.Run(WithSteering(context.Background(), queue), "count users")
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var delivered []AgentEvent
+	for e := range events {
+		if e.Error != nil {
+			t.Fatalf("run error: %v", e.Error)
+		}
+		if e.Type == AgentEventMessageDelivered {
+			delivered = append(delivered, e)
+		}
+	}
+
+	if len(delivered) != 1 || delivered[0].MessageID != queued.ID || delivered[0].Content != "actually use the staging DB" {
+		t.Errorf("expected one delivery acknowledgement, got %+v", delivered)
+	}
+	msgs := provider.requests[1].Messages
+	if len(msgs) != 4 || msgs[2].Role != ChatRoleTool || msgs[3].Role != ChatRoleUser || msgs[3].Content != queued.Content {
+		t.Errorf("steering message should follow the tool results, got %+v", msgs)
+	}
+	if queue.Len() != 0 {
+		t.Error("delivered messages should leave the queue")
+	}
+}
+
+func TestSteeringContinuesFinishedReply(t *testing.T) {
+	provider := &steeredProvider{recordingScriptProvider: recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{Content: "Here is the prod report.", StopReason: "end_turn"},
+		{Content: "Here is the staging report.", StopReason: "end_turn"},
+	}}}}
+	agent := NewAPIAgent(APIAgentConfig{Provider: provider})
+	session := agent.NewSession()
+	if _, err := session.Steer("too early"); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Steer on an idle session: %v", err)
+	}
+
+	provider.before = func(call int) {
+		if call == 0 {
+			if _, err := session.Steer("use staging instead"); err != nil {
+				t.Errorf("Steer: %v", err)
+			}
+		}
+	}
+	reply, err := session.SendSync(context.Background(), "report")
+	if err != nil {
+		t.Fatalf("SendSync: %v", err)
+	}
+	if reply != "Here is the prod report.Here is the staging report." {
+		t.Errorf("run should continue with the steering message, got %q", reply)
+	}
+	h := session.History()
+	if len(h) != 4 || h[1].Role != ChatRoleAssistant || h[2].Content != "use staging instead" || h[3].Content != "Here is the staging report." {
+		t.Errorf("unexpected history: %+v", h)
+	}
+	if u := session.Usage(); u.Turns != 2 {
+		t.Errorf("expected 2 turns, got %d", u.Turns)
+	}
+}
+
+func TestAgentSteerRequiresRun(t *testing.T) {
+	agent := NewAgent(AgentConfig{})
+	if _, err := agent.Steer("hello"); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Steer on an idle agent: %v", err)
+	}
+}
+
+// endHookJournal calls onEnd when a run records its end.
+type endHookJournal struct {
+	*MemoryJournal
+	onEnd func()
+}
+
+func (j endHookJournal) Append(ctx context.Context, runID string, entry JournalEntry) error {
+	if entry.Type == JournalRunEnd {
+		j.onEnd()
+	}
+	return j.MemoryJournal.Append(ctx, runID, entry)
+}
+
+func TestSteeringRejectedAfterFinalCheck(t *testing.T) {
+	queue := NewSteeringQueue()
+	var lateErr, reopenErr error
+	provider := &steeredProvider{
+		recordingScriptProvider: recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+			{Content: "Done.", StopReason: "end_turn"},
+			{Content: "Again.", StopReason: "end_turn"},
+			{Content: "Steered.", StopReason: "end_turn"},
+		}}},
+		before: func(call int) {
+			if call == 1 {
+				_, reopenErr = queue.Push("steer")
+			}
+		},
+	}
+	agent := NewAPIAgent(APIAgentConfig{Provider: provider, Journal: endHookJournal{
+		MemoryJournal: NewMemoryJournal(),
+		// The run has decided to finish but has not returned yet.
+		onEnd: func() {
+			if lateErr == nil {
+				_, lateErr = queue.Push("one more thing")
+			}
+		},
+	}})
+	run := func(prompt string) {
+		t.Helper()
+		events, err := agent.Run(WithSteering(context.Background(), queue), prompt)
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		for e := range events {
+			if e.Error != nil {
+				t.Fatalf("run error: %v", e.Error)
+			}
+		}
+	}
+
+	run("hi")
+	if !errors.Is(lateErr, ErrNotRunning) {
+		t.Errorf("Push after the final check: err = %v, want ErrNotRunning", lateErr)
+	}
+	if queue.Len() != 0 {
+		t.Error("a rejected message should not stay queued")
+	}
+	if _, err := queue.Push("after the run"); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Push after the run: err = %v, want ErrNotRunning", err)
+	}
+
+	// Starting another run with the queue reopens it.
+	run("again")
+	if reopenErr != nil {
+		t.Errorf("Push during a later run: %v", reopenErr)
+	}
+	if len(provider.requests) != 3 {
+		t.Errorf("the steered message should continue the run, got %d requests", len(provider.requests))
+	}
+}
+
+func TestSteeringResumedRun(t *testing.T) {
+	queue := NewSteeringQueue()
+	ctx := WithSteering(context.Background(), queue)
+	journal := NewMemoryJournal()
+	first := NewAPIAgent(APIAgentConfig{Provider: &cassetteScriptProvider{}, Journal: journal})
+	events, err := first.RunWithID(ctx, "run_steer", "summarize the report")
+	if err != nil {
+		t.Fatalf("RunWithID: %v", err)
+	}
+	if _, err := collectRun(events); err == nil {
+		t.Fatal("expected the empty script to fail the run")
+	}
+
+	var pushErr error
+	provider := &steeredProvider{
+		recordingScriptProvider: recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+			{Content: "Here is the summary.", StopReason: "end_turn"},
+			{Content: "Shorter: all good.", StopReason: "end_turn"},
+		}}},
+		before: func(call int) {
+			if call == 0 {
+				_, pushErr = queue.Push("keep it short")
+			}
+		},
+	}
+	second := NewAPIAgent(APIAgentConfig{Provider: provider, Journal: journal})
+	events, err = second.ResumeRun(ctx, "run_steer")
+	if err != nil {
+		t.Fatalf("ResumeRun: %v", err)
+	}
+	if _, err := collectRun(events); err != nil {
+		t.Fatalf("resumed run: %v", err)
+	}
+
+	if pushErr != nil {
+		t.Fatalf("Push during the resumed run: %v", pushErr)
+	}
+	if len(provider.requests) != 2 {
+		t.Fatalf("steering message should continue the resumed run, got %d requests", len(provider.requests))
+	}
+	if msgs := provider.requests[1].Messages; msgs[len(msgs)-1].Content != "keep it short" {
+		t.Errorf("steering message should end the next request, got %+v", msgs)
+	}
+}
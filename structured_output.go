@@ -0,0 +1,638 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StructuredConfig configures RunStructured.
+type StructuredConfig struct {
+	// MaxRepairs is how many times validation errors are sent back to the
+	// model before giving up. Default: 2. Use a negative value for none.
+	MaxRepairs int
+	// ToolName is the name of the synthetic final-answer tool registered on
+	// an APIAgent. Default: "final_answer".
+	ToolName string
+	// Description is the final-answer tool description, or the instruction
+	// given to runners that answer in text.
+	// Default: "Submit the final answer. Call this exactly once, when done."
+	Description string
+}
+
+func (c *StructuredConfig) withDefaults() StructuredConfig {
+	var out StructuredConfig
+	if c != nil {
+		out = *c
+	}
+	switch {
+	case out.MaxRepairs == 0:
+		out.MaxRepairs = 2
+	case out.MaxRepairs < 0:
+		out.MaxRepairs = 0
+	}
+	if out.ToolName == "" {
+		out.ToolName = "final_answer"
+	}
+	if out.Description == "" {
+		out.Description = "Submit the final answer. Call this exactly once, when done."
+	}
+	return out
+}
+
+// StructuredOutputError is returned by RunStructured when the model did not
+// produce a valid answer within the repair budget.
+type StructuredOutputError struct {
+	// Attempts is the number of answers the model submitted.
+	Attempts int
+	// Errors are the validation errors for the last answer.
+	Errors []string
+	// Raw is the last answer as submitted, if any.
+	Raw json.RawMessage
+}
+
+func (e *StructuredOutputError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("structured output: no valid answer after %d attempts", e.Attempts)
+	}
+	return fmt.Sprintf("structured output: no valid answer after %d attempts: %s",
+		e.Attempts, strings.Join(e.Errors, "; "))
+}
+
+// wrappedValueKey holds non-object answers, since tool input must be an object.
+const wrappedValueKey = "value"
+
+// RunStructured runs the agent and decodes its final answer into T.
+//
+// A JSON schema is derived from T with SchemaFor. An *APIAgent is given a
+// synthetic final-answer tool taking that schema, which ToolChoice forces,
+// and the run ends once it calls it with a valid answer; other runners (such
+// as the CLI-based *Agent) are asked to reply with only a JSON document.
+// Answers are checked against the schema and, if T implements
+// Validate() error, by Validate. Invalid answers are sent back to the model
+// with the errors, up to cfg.MaxRepairs times, after which a
+// *StructuredOutputError is returned. A nil cfg uses the defaults.
+func RunStructured[T any](ctx context.Context, runner Runner, prompt string, cfg *StructuredConfig) (T, error) {
+	var zero T
+	c := cfg.withDefaults()
+	schema := SchemaFor[T]()
+
+	check := func(raw json.RawMessage) (T, []string) {
+		var v T
+		doc := raw
+		if schemaType(schema) != "object" {
+			var env map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &env); err == nil && len(env) == 1 && env[wrappedValueKey] != nil {
+				doc = env[wrappedValueKey]
+			}
+		}
+		var parsed any
+		if err := json.Unmarshal(doc, &parsed); err != nil {
+			return v, []string{fmt.Sprintf("invalid JSON: %v", err)}
+		}
+		if errs := ValidateJSONSchema(schema, parsed); len(errs) > 0 {
+			return v, errs
+		}
+		if err := json.Unmarshal(doc, &v); err != nil {
+			return v, []string{err.Error()}
+		}
+		if val, ok := any(&v).(interface{ Validate() error }); ok {
+			if err := val.Validate(); err != nil {
+				return v, []string{err.Error()}
+			}
+		}
+		return v, nil
+	}
+
+	var (
+		out T
+		err error
+	)
+	if agent, ok := runner.(*APIAgent); ok {
+		out, err = runStructuredTool(ctx, agent, prompt, c, schema, check)
+	} else {
+		out, err = runStructuredText(ctx, runner, prompt, c, schema, check)
+	}
+	if err != nil {
+		return zero, err
+	}
+	return out, nil
+}
+
+// runStructuredTool drives an APIAgent through a synthetic final-answer tool.
+// The tool's ValidateInput rejects invalid answers, so the model sees the
+// errors as a tool result and can retry within the same run.
+func runStructuredTool[T any](
+	ctx context.Context,
+	agent *APIAgent,
+	prompt string,
+	c StructuredConfig,
+	schema map[string]any,
+	check func(json.RawMessage) (T, []string),
+) (T, error) {
+	var zero T
+
+	inputSchema := schema
+	if schemaType(schema) != "object" {
+		inputSchema = ObjectSchema(map[string]any{wrappedValueKey: schema}, wrappedValueKey)
+	}
+
+	var (
+		mu       sync.Mutex
+		attempts int
+		lastErrs []string
+		lastRaw  json.RawMessage
+		answer   T
+		done     bool
+		failed   bool
+	)
+
+	tools := NewToolRegistry()
+	tools.Merge(agent.tools)
+	tools.Register(ToolDefinition{
+		Name:        c.ToolName,
+		Description: c.Description,
+		InputSchema: inputSchema,
+		ValidateInput: func(_ context.Context, input json.RawMessage) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts++
+			lastRaw = append(json.RawMessage(nil), input...)
+			v, errs := check(input)
+			if len(errs) == 0 {
+				answer, done, lastErrs = v, true, nil
+				return nil
+			}
+			lastErrs = errs
+			failed = attempts > c.MaxRepairs
+			return errors.New(strings.Join(errs, "; "))
+		},
+	}, func(context.Context, json.RawMessage) (string, error) {
+		// The answer has been captured by ValidateInput.
+		return "Answer accepted.", nil
+	})
+
+	// Force the final-answer tool and end the run once it has an answer,
+	// or once the repairs run out.
+	clone := *agent
+	clone.tools = tools
+	clone.toolChoice = &ToolChoice{Type: ToolChoiceTool, Name: c.ToolName}
+	clone.stopAfterTools = func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return done || failed
+	}
+
+	instruction := fmt.Sprintf("\n\nWhen you have the final answer, call the %s tool with it. Do not answer in plain text.", c.ToolName)
+	next := prompt + instruction
+
+	for {
+		events, err := clone.Run(ctx, next)
+		if err != nil {
+			return zero, err
+		}
+		var text string
+		var runErr error
+		for ev := range events {
+			switch ev.Type { //nolint:exhaustive // Only final text and errors matter here
+			case AgentEventContentDelta:
+				text += ev.Content
+			case AgentEventMessageStart:
+				text = ""
+			case AgentEventError:
+				if runErr == nil {
+					runErr = ev.Error
+				}
+			}
+		}
+
+		mu.Lock()
+		gotAnswer, gaveUp := done, failed
+		failure := &StructuredOutputError{Attempts: attempts, Errors: lastErrs, Raw: lastRaw}
+		mu.Unlock()
+		if gotAnswer {
+			return answer, nil
+		}
+		if gaveUp {
+			return zero, failure
+		}
+		if runErr != nil {
+			return zero, runErr
+		}
+
+		// The model ended its turn without calling the tool. Accept a JSON
+		// reply if it is valid, otherwise ask again.
+		mu.Lock()
+		attempts++
+		raw := extractJSON(text)
+		lastRaw = raw
+		v, errs := check(raw)
+		if len(raw) == 0 {
+			errs = []string{fmt.Sprintf("no answer was submitted: call the %s tool", c.ToolName)}
+		}
+		lastErrs = errs
+		n := attempts
+		mu.Unlock()
+		if len(errs) == 0 {
+			return v, nil
+		}
+		if n > c.MaxRepairs {
+			return zero, &StructuredOutputError{Attempts: n, Errors: errs, Raw: raw}
+		}
+		next = repairPrompt(prompt, text, errs) + instruction
+	}
+}
+
+// runStructuredText asks the runner for a JSON reply and re-prompts with the
+// validation errors until the reply is valid or the repairs run out.
+func runStructuredText[T any](
+	ctx context.Context,
+	runner Runner,
+	prompt string,
+	c StructuredConfig,
+	schema map[string]any,
+	check func(json.RawMessage) (T, []string),
+) (T, error) {
+	var zero T
+
+	schemaJSON, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return zero, fmt.Errorf("marshal schema: %w", err)
+	}
+	instruction := fmt.Sprintf("\n\n%s Reply with only a JSON document matching this JSON schema, without commentary:\n%s",
+		c.Description, schemaJSON)
+
+	next := prompt + instruction
+	for attempt := 1; ; attempt++ {
+		events, err := runner.Run(ctx, next)
+		if err != nil {
+			return zero, err
+		}
+		var text string
+		var runErr error
+		for ev := range events {
+			if ev.Error != nil && runErr == nil {
+				runErr = ev.Error
+			}
+			switch ev.Type { //nolint:exhaustive // Only the final message text matters here
+			case AgentEventMessageStart:
+				text = ""
+			case AgentEventContentDelta:
+				text += ev.Content
+			}
+		}
+		if runErr != nil {
+			return zero, runErr
+		}
+
+		raw := extractJSON(text)
+		v, errs := check(raw)
+		if len(errs) == 0 {
+			return v, nil
+		}
+		if attempt > c.MaxRepairs {
+			return zero, &StructuredOutputError{Attempts: attempt, Errors: errs, Raw: raw}
+		}
+		next = repairPrompt(prompt, text, errs) + instruction
+	}
+}
+
+// repairPrompt restates the task with the rejected answer and its errors.
+func repairPrompt(prompt, previous string, errs []string) string {
+	var b strings.Builder
+	b.WriteString(prompt)
+	b.WriteString("\n\nA previous answer to this task was rejected.\n")
+	if strings.TrimSpace(previous) != "" {
+		b.WriteString("Previous answer:\n")
+		b.WriteString(previous)
+		b.WriteString("\n")
+	}
+	b.WriteString("Errors:\n")
+	for _, e := range errs {
+		b.WriteString("- ")
+		b.WriteString(e)
+		b.WriteString("\n")
+	}
+	b.WriteString("Fix the errors and answer again.")
+	return b.String()
+}
+
+// extractJSON returns the JSON document in a model reply, tolerating Markdown
+// code fences and surrounding prose. Returns the trimmed text if no object or
+// array is found.
+func extractJSON(text string) json.RawMessage {
+	s := strings.TrimSpace(text)
+	if i := strings.Index(s, "```"); i >= 0 {
+		rest := s[i+3:]
+		if nl := strings.IndexByte(rest, '\n'); nl >= 0 {
+			rest = rest[nl+1:]
+		}
+		if j := strings.Index(rest, "```"); j >= 0 {
+			s = strings.TrimSpace(rest[:j])
+		}
+	}
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	start := strings.IndexAny(s, "{[")
+	if start < 0 {
+		return json.RawMessage(s)
+	}
+	closer := byte('}')
+	if s[start] == '[' {
+		closer = ']'
+	}
+	if end := strings.LastIndexByte(s, closer); end > start {
+		return json.RawMessage(s[start : end+1])
+	}
+	return json.RawMessage(s[start:])
+}
+
+// SchemaFor derives a JSON schema from T.
+//
+// Struct fields are named by their json tag and are required unless tagged
+// omitempty or declared as pointers. Pointer, slice and map fields also
+// accept null, since that is how their nil values marshal. A
+// `description:"..."` tag sets the property description and an
+// `enum:"a,b,c"` tag restricts a string field to the listed values. Structs
+// disallow additional properties; maps become objects with typed
+// additionalProperties; time.Time is a date-time string.
+func SchemaFor[T any]() map[string]any {
+	return SchemaForType(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// SchemaForType derives a JSON schema from t. See SchemaFor.
+func SchemaForType(t reflect.Type) map[string]any {
+	return schemaForType(t, map[reflect.Type]bool{})
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+func schemaForType(t reflect.Type, seen map[reflect.Type]bool) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() { //nolint:exhaustive // Unsupported kinds accept any value
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte marshals as a base64 string.
+			return map[string]any{"type": "string"}
+		}
+		return map[string]any{"type": "array", "items": schemaForType(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaForType(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			// Recursive type: stop descending.
+			return map[string]any{"type": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		props := map[string]any{}
+		var required []string
+		addStructFields(t, props, &required, seen)
+		schema := map[string]any{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			sort.Strings(required)
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return map[string]any{}
+	}
+}
+
+func addStructFields(t reflect.Type, props map[string]any, required *[]string, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addStructFields(ft, props, required, seen)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := schemaForType(f.Type, seen)
+		switch f.Type.Kind() { //nolint:exhaustive // Only kinds that marshal as null
+		case reflect.Pointer, reflect.Slice, reflect.Map:
+			// A nil pointer, slice or map marshals as null.
+			if typ, ok := prop["type"].(string); ok {
+				prop["type"] = []string{typ, "null"}
+			}
+		}
+		if d := f.Tag.Get("description"); d != "" {
+			prop["description"] = d
+		}
+		if e := f.Tag.Get("enum"); e != "" {
+			prop["enum"] = strings.Split(e, ",")
+		}
+		props[name] = prop
+
+		optional := strings.Contains(","+opts+",", ",omitempty,") || f.Type.Kind() == reflect.Pointer
+		if !optional {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// ValidateJSONSchema checks a decoded JSON value (as produced by
+// json.Unmarshal into any) against schema and returns one message per
+// violation. It supports the subset of JSON Schema produced by SchemaFor and
+// the parameter helpers: type, properties, required, additionalProperties,
+// items and enum.
+func ValidateJSONSchema(schema map[string]any, value any) []string {
+	var errs []string
+	validateSchemaValue(schema, value, "$", &errs)
+	return errs
+}
+
+func validateSchemaValue(schema map[string]any, value any, path string, errs *[]string) {
+	if len(schema) == 0 {
+		return
+	}
+	if types := schemaTypes(schema); len(types) > 0 {
+		if !slices.ContainsFunc(types, func(typ string) bool { return jsonTypeMatches(typ, value) }) {
+			*errs = append(*errs, fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonTypeName(value)))
+			return
+		}
+		if value == nil {
+			return
+		}
+	}
+	if enum := stringList(schema["enum"]); len(enum) > 0 {
+		if s, ok := value.(string); !ok || !containsString(enum, s) {
+			*errs = append(*errs, fmt.Sprintf("%s: must be one of %s", path, strings.Join(enum, ", ")))
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		props, _ := schema["properties"].(map[string]any)
+		for _, name := range stringList(schema["required"]) {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, fmt.Sprintf("%s: missing required property %q", path, name))
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := path + "." + k
+			if ps, ok := props[k].(map[string]any); ok {
+				validateSchemaValue(ps, v[k], child, errs)
+				continue
+			}
+			switch ap := schema["additionalProperties"].(type) {
+			case bool:
+				if !ap {
+					*errs = append(*errs, fmt.Sprintf("%s: unexpected property", child))
+				}
+			case map[string]any:
+				validateSchemaValue(ap, v[k], child, errs)
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				validateSchemaValue(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	}
+}
+
+func schemaType(schema map[string]any) string {
+	t, _ := schema["type"].(string)
+	return t
+}
+
+// schemaTypes returns the schema's type, which may be a list such as
+// ["array", "null"] for nullable fields.
+func schemaTypes(schema map[string]any) []string {
+	if t := schemaType(schema); t != "" {
+		return []string{t}
+	}
+	return stringList(schema["type"])
+}
+
+func jsonTypeMatches(typ string, value any) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func jsonTypeName(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// stringList accepts both []string (schemas built in Go) and []any (schemas
+// decoded from JSON).
+func stringList(v any) []string {
+	switch l := v.(type) {
+	case []string:
+		return l
+	case []any:
+		out := make([]string, 0, len(l))
+		for _, s := range l {
+			if str, ok := s.(string); ok {
+				out = append(out, str)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type structuredReport struct {
+	Title    string            `json:"title" description:"short title"`
+	Severity string            `json:"severity" enum:"low,medium,high"`
+	Score    int               `json:"score"`
+	Tags     []string          `json:"tags,omitempty"`
+	Owner    *string           `json:"owner"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Seen     time.Time         `json:"seen,omitempty"`
+}
+
+func (r *structuredReport) Validate() error {
+	if r.Score < 0 {
+		return errors.New("score must not be negative")
+	}
+	return nil
+}
+
+// recordingScriptProvider records requests on top of cassetteScriptProvider.
+type recordingScriptProvider struct {
+	cassetteScriptProvider
+	requests []ChatRequest
+}
+
+func (p *recordingScriptProvider) Complete(ctx context.Context, req ChatRequest, onEvent ChatStreamCallback) (ChatResponse, error) {
+	p.requests = append(p.requests, req)
+	return p.cassetteScriptProvider.Complete(ctx, req, onEvent)
+}
+
+func finalAnswerCall(id, input string) ChatResponse {
+	return ChatResponse{
+		ToolCalls:  []ToolCall{{ID: id, Name: "final_answer", Input: json.RawMessage(input)}},
+		StopReason: "tool_use",
+	}
+}
+
+func TestSchemaFor(t *testing.T) {
+	s := SchemaFor[structuredReport]()
+	if s["type"] != "object" || s["additionalProperties"] != false {
+		t.Fatalf("unexpected root schema: %v", s)
+	}
+	if got, want := s["required"], []string{"score", "severity", "title"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("required = %v, want %v", got, want)
+	}
+	props := s["properties"].(map[string]any)
+	sev := props["severity"].(map[string]any)
+	if !reflect.DeepEqual(sev["enum"], []string{"low", "medium", "high"}) {
+		t.Errorf("severity enum = %v", sev["enum"])
+	}
+	if props["title"].(map[string]any)["description"] != "short title" {
+		t.Errorf("title description missing: %v", props["title"])
+	}
+	if !reflect.DeepEqual(props["tags"].(map[string]any)["type"], []string{"array", "null"}) {
+		t.Errorf("tags should be an array: %v", props["tags"])
+	}
+	if props["seen"].(map[string]any)["format"] != "date-time" {
+		t.Errorf("seen should be date-time: %v", props["seen"])
+	}
+	if props["labels"].(map[string]any)["additionalProperties"].(map[string]any)["type"] != "string" {
+		t.Errorf("labels should map to strings: %v", props["labels"])
+	}
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema := SchemaFor[structuredReport]()
+	var v any
+	_ = json.Unmarshal([]byte(`{"title":1,"severity":"urgent","score":1.5,"extra":true}`), &v)
+
+	errs := ValidateJSONSchema(schema, v)
+	joined := strings.Join(errs, "\n")
+	for _, want := range []string{
+		"$.title: expected string, got integer",
+		"$.severity: must be one of low, medium, high",
+		"$.score: expected integer, got number",
+		"$.extra: unexpected property",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("missing %q in:\n%s", want, joined)
+		}
+	}
+
+	_ = json.Unmarshal([]byte(`{"title":"t","severity":"low","score":3}`), &v)
+	if errs := ValidateJSONSchema(schema, v); len(errs) != 0 {
+		t.Errorf("expected valid, got %v", errs)
+	}
+}
+
+func TestValidateJSONSchemaNullableFields(t *testing.T) {
+	schema := SchemaFor[structuredReport]()
+	raw, _ := json.Marshal(structuredReport{Title: "t", Severity: "low", Score: 1})
+	var v any
+	_ = json.Unmarshal(raw, &v)
+	if errs := ValidateJSONSchema(schema, v); len(errs) != 0 {
+		t.Errorf("nil pointer, slice and map fields should validate as null, got %v", errs)
+	}
+	if _, err := RunStructured[structuredReport](context.Background(),
+		&textRunner{replies: []string{string(raw)}}, "triage", nil); err != nil {
+		t.Errorf("RunStructured with null fields: %v", err)
+	}
+
+	_ = json.Unmarshal([]byte(`{"title":"t","severity":null,"score":1,"tags":"a"}`), &v)
+	joined := strings.Join(ValidateJSONSchema(schema, v), "\n")
+	for _, want := range []string{
+		"$.severity: expected string, got null",
+		"$.tags: expected array or null, got string",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("missing %q in:\n%s", want, joined)
+		}
+	}
+}
+
+func TestRunStructuredAPIAgentRepairs(t *testing.T) {
+	provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		finalAnswerCall("call_1", `{"title":"Disk full","severity":"urgent","score":-1}`),
+		finalAnswerCall("call_2", `{"title":"Disk full","severity":"high","score":-1}`),
+		finalAnswerCall("call_3", `{"title":"Disk full","severity":"high","score":7}`),
+	}}}
+	journal := NewMemoryJournal()
+	agent := NewAPIAgent(APIAgentConfig{Provider: provider, Journal: journal})
+
+	report, err := RunStructured[structuredReport](context.Background(), agent, "triage the incident", nil)
+	if err != nil {
+		t.Fatalf("RunStructured: %v", err)
+	}
+	if report.Title != "Disk full" || report.Severity != "high" || report.Score != 7 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if provider.calls != 3 {
+		t.Errorf("expected the run to stop after the valid answer, got %d calls", provider.calls)
+	}
+	for _, entries := range journal.runs {
+		if last := entries[len(entries)-1]; last.Type != JournalRunEnd || last.StopReason != "tool_use" {
+			t.Errorf("the run should end normally after the answer, last entry %+v", last)
+		}
+	}
+
+	first := provider.requests[0]
+	if len(first.Tools) != 1 || first.Tools[0].Name != "final_answer" {
+		t.Fatalf("expected final_answer tool, got %+v", first.Tools)
+	}
+	if tc := first.ToolChoice; tc == nil || tc.Type != ToolChoiceTool || tc.Name != "final_answer" {
+		t.Errorf("final_answer should be forced, got %+v", tc)
+	}
+	msgs := provider.requests[1].Messages
+	last := msgs[len(msgs)-1]
+	if last.Role != ChatRoleTool || !last.IsError || !strings.Contains(last.Content, "must be one of") {
+		t.Errorf("expected validation error tool result, got %+v", last)
+	}
+	msgs = provider.requests[2].Messages
+	if last := msgs[len(msgs)-1]; !strings.Contains(last.Content, "score must not be negative") {
+		t.Errorf("expected Validate error in tool result, got %q", last.Content)
+	}
+
+	if agent.tools.Has("final_answer") {
+		t.Error("final_answer tool leaked into the agent's registry")
+	}
+}
+
+func TestRunStructuredAPIAgentGivesUp(t *testing.T) {
+	provider := &cassetteScriptProvider{responses: []ChatResponse{
+		finalAnswerCall("call_1", `{"title":"x"}`),
+		finalAnswerCall("call_2", `{"title":"x"}`),
+	}}
+	agent := NewAPIAgent(APIAgentConfig{Provider: provider})
+
+	_, err := RunStructured[structuredReport](context.Background(), agent, "triage", &StructuredConfig{MaxRepairs: 1})
+	var se *StructuredOutputError
+	if !errors.As(err, &se) {
+		t.Fatalf("expected StructuredOutputError, got %v", err)
+	}
+	if se.Attempts != 2 || string(se.Raw) != `{"title":"x"}` {
+		t.Errorf("unexpected error: %+v", se)
+	}
+	if !strings.Contains(se.Error(), `missing required property "score"`) {
+		t.Errorf("error should list validation problems: %v", se)
+	}
+}
+
+func TestRunStructuredAPIAgentTextFallback(t *testing.T) {
+	provider := &recordingScriptProvider{cassetteScriptProvider: cassetteScriptProvider{responses: []ChatResponse{
+		{Content: "I think it is fine.", StopReason: "end_turn"},
+		{Content: "```json\n[\"a\",\"b\"]\n```", StopReason: "end_turn"},
+	}}}
+	agent := NewAPIAgent(APIAgentConfig{Provider: provider})
+
+	tags, err := RunStructured[[]string](context.Background(), agent, "list tags", nil)
+	if err != nil {
+		t.Fatalf("RunStructured: %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"a", "b"}) {
+		t.Errorf("tags = %v", tags)
+	}
+	schema := provider.requests[0].Tools[0].InputSchema
+	if schema["type"] != "object" || schema["properties"].(map[string]any)["value"] == nil {
+		t.Errorf("non-object answers should be wrapped, got %v", schema)
+	}
+	if !strings.Contains(provider.requests[1].Messages[0].Content, "A previous answer to this task was rejected") {
+		t.Errorf("expected repair prompt, got %q", provider.requests[1].Messages[0].Content)
+	}
+}
+
+// textRunner answers each Run with the next scripted reply.
+type textRunner struct {
+	replies []string
+	prompts []string
+}
+
+func (r *textRunner) Run(_ context.Context, prompt string) (<-chan AgentEvent, error) {
+	r.prompts = append(r.prompts, prompt)
+	ch := make(chan AgentEvent, 3)
+	ch <- AgentEvent{Type: AgentEventMessageStart}
+	ch <- AgentEvent{Type: AgentEventContentDelta, Content: r.replies[len(r.prompts)-1]}
+	ch <- AgentEvent{Type: AgentEventMessageEnd}
+	close(ch)
+	return ch, nil
+}
+
+func (r *textRunner) RunSync(ctx context.Context, prompt string) (string, error) {
+	events, _ := r.Run(ctx, prompt)
+	var content string
+	for e := range events {
+		content += e.Content
+	}
+	return content, nil
+}
+
+func TestRunStructuredTextRunner(t *testing.T) {
+	r := &textRunner{replies: []string{
+		`Here you go: {"title":"t","severity":"low"}`,
+		`{"title":"t","severity":"low","score":2}`,
+	}}
+
+	report, err := RunStructured[structuredReport](context.Background(), r, "summarize", nil)
+	if err != nil {
+		t.Fatalf("RunStructured: %v", err)
+	}
+	if report.Score != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if !strings.Contains(r.prompts[0], `"severity"`) {
+		t.Errorf("first prompt should include the schema: %q", r.prompts[0])
+	}
+	if !strings.Contains(r.prompts[1], `missing required property "score"`) {
+		t.Errorf("repair prompt should include errors: %q", r.prompts[1])
+	}
+}
@@ -23,6 +23,11 @@ type AgentDefinition struct {
 	MaxTurns int
 	// Hooks configures lifecycle hooks for the subagent.
 	Hooks *Hooks
+	// Runner, if set, runs the subagent's tasks, e.g. an *APIAgent or a
+	// remote agent. Prompt, Tools, Model and MaxTurns are then ignored: the
+	// Runner carries its own configuration. If nil, a CLI-backed *Agent is
+	// created for each task.
+	Runner Runner
 }
 
 // SubagentConfig holds the set of available subagent definitions.
@@ -84,8 +89,48 @@ func registerTaskTool(registry *ToolRegistry, subagents *SubagentConfig, parentO
 	})
 }
 
-// runSubagent creates and runs a child agent with the given definition.
+// runSubagent runs a task on the subagent's Runner.
 func runSubagent(ctx context.Context, def *AgentDefinition, task string, parentOpts Options, parentHooks *Hooks) (string, error) {
+	child := def.Runner
+	if child == nil {
+		child = newSubagent(def, parentOpts)
+	}
+
+	// Emit SubagentStart hook on the parent's hooks
+	if parentHooks != nil {
+		parentHooks.EmitEvent(ctx, HookEventData{
+			Event:        HookSubagentStart,
+			SubagentName: def.Name,
+			Message:      task,
+		})
+	}
+
+	// Collect the answer from the event stream rather than RunSync, so the
+	// parent only sees the child's text whatever the Runner implementation.
+	var result string
+	events, err := child.Run(ctx, task)
+	if err == nil {
+		result, err = collectText(events)
+	}
+
+	// Emit SubagentStop hook on the parent's hooks
+	if parentHooks != nil {
+		parentHooks.EmitEvent(ctx, HookEventData{
+			Event:        HookSubagentStop,
+			SubagentName: def.Name,
+			Message:      result,
+		})
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("subagent %s failed: %w", def.Name, err)
+	}
+
+	return result, nil
+}
+
+// newSubagent creates a CLI-backed child agent from the definition.
+func newSubagent(def *AgentDefinition, parentOpts Options) *Agent {
 	// Determine model - use parent's if "inherit" or empty
 	model := def.Model
 	if model == "" || model == "inherit" {
@@ -118,36 +163,10 @@ func runSubagent(ctx context.Context, def *AgentDefinition, task string, parentO
 		MaxTurns:       maxTurns,
 	}
 
-	child := NewAgent(AgentConfig{
+	return NewAgent(AgentConfig{
 		Options:  childOpts,
 		Tools:    def.Tools,
 		Hooks:    def.Hooks,
 		MaxTurns: maxTurns,
 	})
-
-	// Emit SubagentStart hook on the parent's hooks
-	if parentHooks != nil {
-		parentHooks.EmitEvent(ctx, HookEventData{
-			Event:        HookSubagentStart,
-			SubagentName: def.Name,
-			Message:      task,
-		})
-	}
-
-	result, err := child.RunSync(ctx, task)
-
-	// Emit SubagentStop hook on the parent's hooks
-	if parentHooks != nil {
-		parentHooks.EmitEvent(ctx, HookEventData{
-			Event:        HookSubagentStop,
-			SubagentName: def.Name,
-			Message:      result,
-		})
-	}
-
-	if err != nil {
-		return "", fmt.Errorf("subagent %s failed: %w", def.Name, err)
-	}
-
-	return result, nil
 }
@@ -0,0 +1,391 @@
+package claudeagent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TextToolFormat describes how an open-weight model writes tool calls as
+// text, for servers (vLLM, llama.cpp, TGI) that return them in the message
+// content instead of structured tool_calls.
+//
+// Set OpenAICompatConfig.TextToolCalls to extract the calls from the
+// response, and OpenAICompatConfig.ToolsInPrompt to also describe tools and
+// replay tool history in the same text format, for servers that reject the
+// tools parameter.
+type TextToolFormat interface {
+	// Name identifies the format (e.g., "hermes").
+	Name() string
+	// NewParser returns a parser for one response.
+	NewParser() TextToolCallParser
+	// FormatTools returns system prompt text that lists tools and explains
+	// the call syntax.
+	FormatTools(tools []ToolDefinition) string
+	// FormatCalls renders an assistant turn's tool calls as message text.
+	FormatCalls(calls []ToolCall) string
+	// FormatResult renders a tool result as user message text.
+	FormatResult(call ToolCall, content string, isError bool) string
+}
+
+// TextToolCallParser extracts tool calls from streamed content. Text that
+// cannot be part of a tool call is returned as soon as possible; text that
+// might be is held back until the call completes or the stream ends.
+type TextToolCallParser interface {
+	// Feed consumes a content delta and returns the text to show and any
+	// tool calls completed by it.
+	Feed(delta string) (text string, calls []ToolCall)
+	// Close is called at the end of the response. It returns held-back text
+	// and any final tool calls. A call that fails to parse is returned as
+	// text, so no output is lost.
+	Close() (text string, calls []ToolCall)
+}
+
+// HermesToolFormat is the Hermes / Qwen format: each call is a JSON object
+// with "name" and "arguments" inside <tool_call>...</tool_call>.
+func HermesToolFormat() TextToolFormat { return hermesFormat{} }
+
+// LlamaToolFormat is the Llama 3.1+ format: <|python_tag|> followed by JSON
+// objects with "name" and "parameters", separated by semicolons.
+func LlamaToolFormat() TextToolFormat { return llamaFormat{} }
+
+// MistralToolFormat is the Mistral format: [TOOL_CALLS] followed by a JSON
+// array of calls, or by name[ARGS]{...} in newer tokenizer versions.
+func MistralToolFormat() TextToolFormat { return mistralFormat{} }
+
+// textToolCall is the JSON shape shared by the built-in formats. Llama uses
+// "parameters" where the others use "arguments".
+type textToolCall struct {
+	ID         string          `json:"id,omitempty"`
+	Name       string          `json:"name"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+func (c textToolCall) toolCall(idLen int) (ToolCall, error) {
+	if c.Name == "" {
+		return ToolCall{}, errors.New("tool call has no name")
+	}
+	input := c.Arguments
+	if len(input) == 0 {
+		input = c.Parameters
+	}
+	if len(input) == 0 || string(input) == "null" {
+		input = json.RawMessage(`{}`)
+	}
+	// Some models encode arguments as a JSON string.
+	var s string
+	if json.Unmarshal(input, &s) == nil {
+		input = json.RawMessage(s)
+	}
+	if !json.Valid(input) {
+		return ToolCall{}, fmt.Errorf("tool call %q has invalid arguments", c.Name)
+	}
+	id := c.ID
+	if id == "" {
+		id = randomID(idLen)
+	}
+	return ToolCall{ID: id, Name: c.Name, Input: input}, nil
+}
+
+// decodeTextToolCalls decodes a sequence of JSON objects or arrays of
+// objects, separated by whitespace, commas or semicolons.
+func decodeTextToolCalls(body string, idLen int) ([]ToolCall, error) {
+	var calls []ToolCall
+	rest := body
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\n,;")
+		if rest == "" {
+			break
+		}
+		dec := json.NewDecoder(strings.NewReader(rest))
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		rest = rest[dec.InputOffset():]
+
+		var batch []textToolCall
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+			if err := json.Unmarshal(raw, &batch); err != nil {
+				return nil, err
+			}
+		} else {
+			var one textToolCall
+			if err := json.Unmarshal(raw, &one); err != nil {
+				return nil, err
+			}
+			batch = append(batch, one)
+		}
+		for _, c := range batch {
+			tc, err := c.toolCall(idLen)
+			if err != nil {
+				return nil, err
+			}
+			calls = append(calls, tc)
+		}
+	}
+	if len(calls) == 0 {
+		return nil, errors.New("no tool calls found")
+	}
+	return calls, nil
+}
+
+// randomID returns a random alphanumeric ID of length n.
+func randomID(n int) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b)
+}
+
+// markerParser is a TextToolCallParser for formats that open tool calls
+// with a start marker. With an end marker each call is parsed as soon as it
+// closes; without one, everything after the start marker is parsed when the
+// response ends.
+type markerParser struct {
+	start, end string
+	parse      func(body string) ([]ToolCall, error)
+
+	buf    strings.Builder
+	inCall bool
+}
+
+func (p *markerParser) Feed(delta string) (string, []ToolCall) {
+	p.buf.WriteString(delta)
+	var text strings.Builder
+	var calls []ToolCall
+	for {
+		s := p.buf.String()
+		if !p.inCall {
+			i := strings.Index(s, p.start)
+			if i < 0 {
+				// Hold back a suffix that could begin the start marker.
+				keep := partialPrefixLen(s, p.start)
+				text.WriteString(s[:len(s)-keep])
+				p.reset(s[len(s)-keep:])
+				return text.String(), calls
+			}
+			text.WriteString(s[:i])
+			p.reset(s[i+len(p.start):])
+			p.inCall = true
+			continue
+		}
+		if p.end == "" {
+			return text.String(), calls
+		}
+		j := strings.Index(s, p.end)
+		if j < 0 {
+			return text.String(), calls
+		}
+		body := s[:j]
+		p.reset(s[j+len(p.end):])
+		p.inCall = false
+		parsed, err := p.parse(body)
+		if err != nil {
+			text.WriteString(p.start + body + p.end)
+			continue
+		}
+		calls = append(calls, parsed...)
+	}
+}
+
+func (p *markerParser) Close() (string, []ToolCall) {
+	s := p.buf.String()
+	p.reset("")
+	if !p.inCall {
+		return s, nil
+	}
+	p.inCall = false
+	calls, err := p.parse(s)
+	if err != nil {
+		return p.start + s, nil
+	}
+	return "", calls
+}
+
+func (p *markerParser) reset(s string) {
+	p.buf.Reset()
+	p.buf.WriteString(s)
+}
+
+// partialPrefixLen returns the length of the longest suffix of s that is a
+// proper prefix of marker.
+func partialPrefixLen(s, marker string) int {
+	for n := min(len(s), len(marker)-1); n > 0; n-- {
+		if strings.HasSuffix(s, marker[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// openAITools returns tools in the OpenAI function-calling shape that
+// open-weight chat templates embed in their prompts.
+func openAITools(tools []ToolDefinition) []openAITool {
+	out := make([]openAITool, 0, len(tools))
+	for _, def := range tools {
+		out = append(out, openAITool{
+			Type: "function",
+			Function: openAIFunctionDef{
+				Name:        def.Name,
+				Description: def.Description,
+				Parameters:  def.InputSchema,
+			},
+		})
+	}
+	return out
+}
+
+// jsonString marshals v, which must not fail to encode.
+func jsonString(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// toolResultText prefixes an error result so the model can tell it apart.
+func toolResultText(content string, isError bool) string {
+	if isError {
+		return "Error: " + content
+	}
+	return content
+}
+
+type hermesFormat struct{}
+
+func (hermesFormat) Name() string { return "hermes" }
+
+func (hermesFormat) NewParser() TextToolCallParser {
+	return &markerParser{start: "<tool_call>", end: "</tool_call>", parse: func(body string) ([]ToolCall, error) {
+		return decodeTextToolCalls(body, 16)
+	}}
+}
+
+func (hermesFormat) FormatTools(tools []ToolDefinition) string {
+	var sb strings.Builder
+	sb.WriteString("# Tools\n\nYou may call one or more functions to assist with the user query.\n\n")
+	sb.WriteString("You are provided with function signatures within <tools></tools> XML tags:\n<tools>\n")
+	for _, t := range openAITools(tools) {
+		sb.WriteString(jsonString(t))
+		sb.WriteString("\n")
+	}
+	sb.WriteString("</tools>\n\n")
+	sb.WriteString("For each function call, return a json object with function name and arguments within <tool_call></tool_call> XML tags:\n")
+	sb.WriteString("<tool_call>\n{\"name\": <function-name>, \"arguments\": <args-json-object>}\n</tool_call>")
+	return sb.String()
+}
+
+func (hermesFormat) FormatCalls(calls []ToolCall) string {
+	parts := make([]string, 0, len(calls))
+	for _, c := range calls {
+		parts = append(parts, "<tool_call>\n"+jsonString(textToolCall{Name: c.Name, Arguments: c.Input})+"\n</tool_call>")
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (hermesFormat) FormatResult(_ ToolCall, content string, isError bool) string {
+	return "<tool_response>\n" + toolResultText(content, isError) + "\n</tool_response>"
+}
+
+type llamaFormat struct{}
+
+func (llamaFormat) Name() string { return "llama" }
+
+func (llamaFormat) NewParser() TextToolCallParser {
+	return &markerParser{start: "<|python_tag|>", parse: func(body string) ([]ToolCall, error) {
+		body = strings.TrimSpace(body)
+		for _, tok := range []string{"<|eom_id|>", "<|eot_id|>"} {
+			body = strings.TrimSuffix(body, tok)
+		}
+		return decodeTextToolCalls(body, 16)
+	}}
+}
+
+func (llamaFormat) FormatTools(tools []ToolDefinition) string {
+	var sb strings.Builder
+	sb.WriteString("You have access to the following functions. To call a function, respond with <|python_tag|> ")
+	sb.WriteString("followed by a JSON object of the form {\"name\": function name, \"parameters\": dictionary of argument name and its value}. ")
+	sb.WriteString("Separate multiple calls with semicolons. Do not use variables.\n\n")
+	for _, t := range openAITools(tools) {
+		sb.WriteString(jsonString(t))
+		sb.WriteString("\n\n")
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+func (llamaFormat) FormatCalls(calls []ToolCall) string {
+	parts := make([]string, 0, len(calls))
+	for _, c := range calls {
+		parts = append(parts, jsonString(textToolCall{Name: c.Name, Parameters: c.Input}))
+	}
+	return "<|python_tag|>" + strings.Join(parts, "; ")
+}
+
+func (llamaFormat) FormatResult(call ToolCall, content string, isError bool) string {
+	return "Output of " + call.Name + ":\n" + toolResultText(content, isError)
+}
+
+type mistralFormat struct{}
+
+func (mistralFormat) Name() string { return "mistral" }
+
+func (mistralFormat) NewParser() TextToolCallParser {
+	return &markerParser{start: "[TOOL_CALLS]", parse: parseMistralToolCalls}
+}
+
+// parseMistralToolCalls parses the text after the first [TOOL_CALLS]: a
+// JSON array, or one or more name[ARGS]{...} calls separated by further
+// [TOOL_CALLS] markers.
+func parseMistralToolCalls(body string) ([]ToolCall, error) {
+	var calls []ToolCall
+	for _, part := range strings.Split(body, "[TOOL_CALLS]") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, args, ok := strings.Cut(part, "[ARGS]")
+		if !ok {
+			parsed, err := decodeTextToolCalls(part, 9)
+			if err != nil {
+				return nil, err
+			}
+			calls = append(calls, parsed...)
+			continue
+		}
+		tc, err := textToolCall{Name: strings.TrimSpace(name), Arguments: json.RawMessage(strings.TrimSpace(args))}.toolCall(9)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, tc)
+	}
+	if len(calls) == 0 {
+		return nil, errors.New("no tool calls found")
+	}
+	return calls, nil
+}
+
+func (mistralFormat) FormatTools(tools []ToolDefinition) string {
+	return "[AVAILABLE_TOOLS]" + jsonString(openAITools(tools)) + "[/AVAILABLE_TOOLS]\n\n" +
+		"To call tools, respond with [TOOL_CALLS] followed by a JSON array of objects with \"name\" and \"arguments\"."
+}
+
+func (mistralFormat) FormatCalls(calls []ToolCall) string {
+	out := make([]textToolCall, 0, len(calls))
+	for _, c := range calls {
+		out = append(out, textToolCall{ID: c.ID, Name: c.Name, Arguments: c.Input})
+	}
+	return "[TOOL_CALLS]" + jsonString(out)
+}
+
+func (mistralFormat) FormatResult(call ToolCall, content string, isError bool) string {
+	return "[TOOL_RESULTS]" + jsonString(map[string]string{
+		"call_id": call.ID,
+		"content": toolResultText(content, isError),
+	}) + "[/TOOL_RESULTS]"
+}
@@ -0,0 +1,178 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// feedChunks runs text through a parser in chunks of n bytes.
+func feedChunks(p TextToolCallParser, text string, n int) (string, []ToolCall) {
+	var out strings.Builder
+	var calls []ToolCall
+	for i := 0; i < len(text); i += n {
+		t, c := p.Feed(text[i:min(i+n, len(text))])
+		out.WriteString(t)
+		calls = append(calls, c...)
+	}
+	t, c := p.Close()
+	out.WriteString(t)
+	return out.String(), append(calls, c...)
+}
+
+func TestTextToolCallParsers(t *testing.T) {
+	cases := []struct {
+		name   string
+		format TextToolFormat
+		text   string
+		want   string
+		calls  []string // name + input
+	}{
+		{
+			name:   "hermes",
+			format: HermesToolFormat(),
+			text: "Let me check.\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call>\n" +
+				"<tool_call>{\"name\": \"get_time\", \"arguments\": \"{\\\"tz\\\": \\\"CET\\\"}\"}</tool_call>",
+			want:  "Let me check.\n\n",
+			calls: []string{`get_weather {"city": "Paris"}`, `get_time {"tz": "CET"}`},
+		},
+		{
+			name:   "hermes text only",
+			format: HermesToolFormat(),
+			text:   "a < b and <tool is not a call",
+			want:   "a < b and <tool is not a call",
+		},
+		{
+			name:   "hermes invalid call kept as text",
+			format: HermesToolFormat(),
+			text:   "x<tool_call>{oops}</tool_call>y",
+			want:   "x<tool_call>{oops}</tool_call>y",
+		},
+		{
+			name:   "llama",
+			format: LlamaToolFormat(),
+			text:   `<|python_tag|>{"name": "search", "parameters": {"q": "go; generics"}}; {"name": "noop", "parameters": {}}<|eom_id|>`,
+			calls:  []string{`search {"q": "go; generics"}`, `noop {}`},
+		},
+		{
+			name:   "mistral json",
+			format: MistralToolFormat(),
+			text:   `[TOOL_CALLS][{"name": "search", "arguments": {"q": "x"}, "id": "abc123xyz"}]`,
+			calls:  []string{`search {"q": "x"}`},
+		},
+		{
+			name:   "mistral args",
+			format: MistralToolFormat(),
+			text:   `Sure.[TOOL_CALLS]search[ARGS]{"q": "x"}[TOOL_CALLS]noop[ARGS]{}`,
+			want:   "Sure.",
+			calls:  []string{`search {"q": "x"}`, `noop {}`},
+		},
+		{
+			name:   "mistral unterminated kept as text",
+			format: MistralToolFormat(),
+			text:   `[TOOL_CALLS][{"name": "sea`,
+			want:   `[TOOL_CALLS][{"name": "sea`,
+		},
+	}
+	for _, c := range cases {
+		for _, n := range []int{1, 3, len(c.text)} {
+			text, calls := feedChunks(c.format.NewParser(), c.text, n)
+			if text != c.want {
+				t.Errorf("%s/%d: text = %q, want %q", c.name, n, text, c.want)
+			}
+			var got []string
+			for _, tc := range calls {
+				if tc.ID == "" {
+					t.Errorf("%s/%d: call without ID", c.name, n)
+				}
+				got = append(got, tc.Name+" "+string(tc.Input))
+			}
+			if strings.Join(got, "|") != strings.Join(c.calls, "|") {
+				t.Errorf("%s/%d: calls = %v, want %v", c.name, n, got, c.calls)
+			}
+		}
+	}
+
+	_, calls := feedChunks(MistralToolFormat().NewParser(), `[TOOL_CALLS][{"name": "a", "arguments": {}, "id": "abc123xyz"}]`, 5)
+	if calls[0].ID != "abc123xyz" {
+		t.Errorf("model-provided ID not kept: %q", calls[0].ID)
+	}
+}
+
+func TestOpenAICompatProvider_TextToolCalls(t *testing.T) {
+	chunks := []string{
+		sseChunk("assistant", "Checking.<tool", ""),
+		sseChunk("", "_call>{\"name\": \"get_weather\", ", ""),
+		sseChunk("", "\"arguments\": {\"city\": \"Paris\"}}</tool_call>", ""),
+		sseChunk("", "", "stop"),
+	}
+	var reqBody struct {
+		Tools    []any           `json:"tools"`
+		Messages []openAIMessage `json:"messages"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&reqBody)
+		mockSSEResponse(chunks)(w, r)
+	}))
+	defer srv.Close()
+
+	p := NewOpenAICompatProvider(OpenAICompatConfig{
+		BaseURL:       srv.URL,
+		Model:         "hermes-3",
+		TextToolCalls: HermesToolFormat(),
+		ToolsInPrompt: true,
+	})
+	var content strings.Builder
+	var ended []ToolCall
+	resp, err := p.Complete(context.Background(), ChatRequest{
+		SystemPrompt: "Be brief.",
+		Tools:        []ToolDefinition{{Name: "get_weather", Description: "Weather by city", InputSchema: ObjectSchema(map[string]any{})}},
+		Messages: []ChatMessage{
+			{Role: ChatRoleUser, Content: "time and weather?"},
+			{Role: ChatRoleAssistant, ToolCalls: []ToolCall{
+				{ID: "c1", Name: "get_time", Input: json.RawMessage(`{}`)},
+				{ID: "c2", Name: "get_date", Input: json.RawMessage(`{}`)},
+			}},
+			{Role: ChatRoleTool, ToolCallID: "c1", Content: "12:00"},
+			{Role: ChatRoleTool, ToolCallID: "c2", Content: "no calendar", IsError: true},
+		},
+	}, func(e ChatStreamEvent) {
+		switch e.Type {
+		case ChatStreamContentDelta:
+			content.WriteString(e.Content)
+		case ChatStreamToolUseEnd:
+			ended = append(ended, *e.ToolCall)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	if resp.Content != "Checking." || content.String() != "Checking." {
+		t.Errorf("tool call markup leaked into content: %q / %q", resp.Content, content.String())
+	}
+	if resp.StopReason != "tool_use" || len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Name != "get_weather" ||
+		string(resp.ToolCalls[0].Input) != `{"city": "Paris"}` {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if len(ended) != 1 || ended[0].ID != resp.ToolCalls[0].ID {
+		t.Errorf("expected a ToolUseEnd event for the call, got %+v", ended)
+	}
+
+	if reqBody.Tools != nil {
+		t.Errorf("tools parameter sent with ToolsInPrompt: %v", reqBody.Tools)
+	}
+	msgs := reqBody.Messages
+	if len(msgs) != 4 || !strings.Contains(msgs[0].Content, "Be brief.\n\n# Tools") || !strings.Contains(msgs[0].Content, `"name":"get_weather"`) {
+		t.Fatalf("unexpected messages: %+v", msgs)
+	}
+	if msgs[2].Role != "assistant" || !strings.Contains(msgs[2].Content, `<tool_call>`) || len(msgs[2].ToolCalls) != 0 {
+		t.Errorf("tool calls not rendered as text: %+v", msgs[2])
+	}
+	if msgs[3].Role != "user" || msgs[3].Content != "<tool_response>\n12:00\n</tool_response>\n<tool_response>\nError: no calendar\n</tool_response>" {
+		t.Errorf("tool results not merged into one user message: %+v", msgs[3])
+	}
+}
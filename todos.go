@@ -122,17 +122,17 @@ func ReadTodosToolDefinition() ToolDefinition {
 // given registry, backed by the provided TodoStore.
 func RegisterTodosTools(registry *ToolRegistry, store *TodoStore) {
 	// write_todos
-	RegisterFunc(registry, TodosToolDefinition(), func(_ context.Context, input writeTodosInput) (string, error) {
+	RegisterFunc(registry, TodosToolDefinition(), func(ctx context.Context, input writeTodosInput) (string, error) {
 		if err := validateTodos(input.Todos); err != nil {
 			return "", err
 		}
-		store.Write(input.Todos)
+		todoStoreFor(ctx, store).Write(input.Todos)
 		return formatTodoSummary(input.Todos), nil
 	})
 
 	// read_todos
-	registry.Register(ReadTodosToolDefinition(), func(_ context.Context, _ json.RawMessage) (string, error) {
-		items := store.List()
+	registry.Register(ReadTodosToolDefinition(), func(ctx context.Context, _ json.RawMessage) (string, error) {
+		items := todoStoreFor(ctx, store).List()
 		if len(items) == 0 {
 			return "No todos.", nil
 		}
@@ -144,6 +144,23 @@ func RegisterTodosTools(registry *ToolRegistry, store *TodoStore) {
 	})
 }
 
+// todoStoreKey is the context key for a run's TodoStore.
+type todoStoreKey struct{}
+
+// withTodoStore directs the todo tools to store for calls made with ctx, so
+// each Session keeps its own list while sharing the agent's registry.
+func withTodoStore(ctx context.Context, store *TodoStore) context.Context {
+	return context.WithValue(ctx, todoStoreKey{}, store)
+}
+
+// todoStoreFor returns the TodoStore set on ctx, or fallback.
+func todoStoreFor(ctx context.Context, fallback *TodoStore) *TodoStore {
+	if s, ok := ctx.Value(todoStoreKey{}).(*TodoStore); ok {
+		return s
+	}
+	return fallback
+}
+
 // validateTodos checks all items for required fields, valid enums,
 // duplicate IDs, and dangling parent_id references.
 func validateTodos(items []TodoItem) error {
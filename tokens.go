@@ -0,0 +1,69 @@
+package claudeagent
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+)
+
+// TokenCounter counts the input tokens a request would consume.
+//
+// EstimateTokenCounter is a fast local approximation; AnthropicProvider
+// implements TokenCounter exactly via the count_tokens endpoint.
+type TokenCounter interface {
+	CountTokens(ctx context.Context, req ChatRequest) (int, error)
+}
+
+// Per-item overheads used by EstimateTokenCounter.
+const (
+	estimateMessageOverhead = 4    // role and framing tokens per message
+	estimateImageTokens     = 1600 // roughly a 1.15 megapixel image on Claude
+)
+
+// EstimateTokenCounter approximates token counts from byte length. It never
+// calls the network and errs on the high side for non-Latin text, which
+// makes it safe for budgeting.
+type EstimateTokenCounter struct {
+	// CharsPerToken is the average number of bytes per token. Default: 4.
+	CharsPerToken float64
+}
+
+// CountTokens estimates the tokens in req. It never returns an error.
+func (e EstimateTokenCounter) CountTokens(_ context.Context, req ChatRequest) (int, error) {
+	return e.estimate(req), nil
+}
+
+func (e EstimateTokenCounter) estimate(req ChatRequest) int {
+	perToken := e.CharsPerToken
+	if perToken <= 0 {
+		perToken = 4
+	}
+	chars := len(req.SystemPrompt)
+	for _, b := range req.SystemBlocks {
+		chars += len(b.Text)
+	}
+	for _, def := range req.Tools {
+		data, _ := json.Marshal(def)
+		chars += len(data)
+	}
+	tokens := 0
+	for _, m := range req.Messages {
+		chars += messageChars(m)
+		tokens += estimateMessageOverhead + len(m.Images)*estimateImageTokens
+	}
+	return tokens + int(math.Ceil(float64(chars)/perToken))
+}
+
+// messageChars returns the text length of a message, including tool call inputs.
+func messageChars(m ChatMessage) int {
+	n := len(m.Content)
+	for _, tc := range m.ToolCalls {
+		n += len(tc.Name) + len(tc.Input)
+	}
+	return n
+}
+
+// EstimateTokens is EstimateTokenCounter{}.CountTokens without the context.
+func EstimateTokens(req ChatRequest) int {
+	return EstimateTokenCounter{}.estimate(req)
+}
@@ -0,0 +1,39 @@
+package claudeagent
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	req := ChatRequest{
+		SystemPrompt: strings.Repeat("s", 40),
+		Messages: []ChatMessage{
+			{Role: ChatRoleUser, Content: strings.Repeat("u", 400)},
+			{Role: ChatRoleUser, Images: []ChatImage{{URL: "https://x/y.png"}}},
+		},
+	}
+	// (40 + 400) / 4 chars + 2 messages * 4 overhead + 1 image
+	want := 110 + 2*estimateMessageOverhead + estimateImageTokens
+	if got := EstimateTokens(req); got != want {
+		t.Errorf("EstimateTokens = %d, want %d", got, want)
+	}
+
+	withTools := req
+	withTools.Tools = []ToolDefinition{{Name: "search", Description: "search the web",
+		InputSchema: ObjectSchema(map[string]any{"q": StringParam("query")}, "q")}}
+	if EstimateTokens(withTools) <= want {
+		t.Error("tools should add to the estimate")
+	}
+
+	dense, err := EstimateTokenCounter{CharsPerToken: 2}.CountTokens(context.Background(), req)
+	if err != nil || dense <= want {
+		t.Errorf("lower CharsPerToken should raise the estimate, got %d (%v)", dense, err)
+	}
+}
+
+func TestAnthropicProviderIsTokenCounter(t *testing.T) {
+	var _ TokenCounter = NewAnthropicProvider(AnthropicProviderConfig{APIKey: "k"})
+	var _ TokenCounter = EstimateTokenCounter{}
+}
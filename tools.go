@@ -22,6 +22,9 @@ type ToolAnnotations struct {
 	ReadOnly bool `json:"read_only,omitempty"`
 	// Destructive indicates the tool makes hard-to-reverse changes.
 	Destructive bool `json:"destructive,omitempty"`
+	// Idempotent indicates repeating a call with the same input has no
+	// further effect, so ResumeRun may re-run an interrupted call.
+	Idempotent bool `json:"idempotent,omitempty"`
 	// ConcurrencySafe indicates the tool can run in parallel with other
 	// concurrency-safe tools. Tools without this annotation (or with it
 	// set to false) are assumed unsafe for parallel execution.
@@ -71,6 +74,10 @@ type ToolResultMetadata struct {
 	SystemContext string
 	// SuggestFollowUp is a hint for the agent about what to do next.
 	SuggestFollowUp string
+	// RepairedInput is the input the tool ran with when RepairToolInput
+	// fixed malformed JSON from the model; InputRepairs lists the fixes.
+	RepairedInput json.RawMessage
+	InputRepairs  []string
 }
 
 // ToolResponse is the result of executing a tool.
@@ -79,6 +86,9 @@ type ToolResponse struct {
 	Content   string              `json:"content"`
 	IsError   bool                `json:"is_error,omitempty"`
 	Metadata  *ToolResultMetadata `json:"-"` // not serialized
+
+	// approval is set when CanUseTool asked for a person's approval.
+	approval *ApprovalRequest
 }
 
 // StructuredToolHandler returns content + optional metadata alongside the result.
@@ -209,6 +209,10 @@ type PermissionDecision struct {
 	Reason string
 	// ModifiedInput optionally replaces the tool input.
 	ModifiedInput json.RawMessage
+	// Ask pauses an APIAgent run until a person approves or denies the
+	// call; see Session.Resolve. Allow is ignored. Agent treats Ask as a
+	// denial.
+	Ask bool
 }
 
 // CanUseToolFunc is a callback invoked before tool execution to get permission.
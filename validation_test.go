@@ -25,7 +25,7 @@ func TestValidateInput_RejectsPreventsExecution(t *testing.T) {
 
 	events := make(chan AgentEvent, 10)
 	tc := ToolCall{ID: "1", Name: "guarded", Input: json.RawMessage(`{}`)}
-	resp := executeOneTool(context.Background(), tc, tools, nil, nil, nil, nil, events)
+	resp := executeOneTool(context.Background(), tc, tools, nil, nil, nil, false, nil, events)
 
 	if !resp.IsError {
 		t.Fatal("expected error response")
@@ -55,7 +55,7 @@ func TestCheckPermissions_RejectsPreventsExecution(t *testing.T) {
 
 	events := make(chan AgentEvent, 10)
 	tc := ToolCall{ID: "1", Name: "restricted", Input: json.RawMessage(`{}`)}
-	resp := executeOneTool(context.Background(), tc, tools, nil, nil, nil, nil, events)
+	resp := executeOneTool(context.Background(), tc, tools, nil, nil, nil, false, nil, events)
 
 	if !resp.IsError {
 		t.Fatal("expected error response")
@@ -82,7 +82,7 @@ func TestNilValidators_AllowExecution(t *testing.T) {
 
 	events := make(chan AgentEvent, 10)
 	tc := ToolCall{ID: "1", Name: "open", Input: json.RawMessage(`{}`)}
-	resp := executeOneTool(context.Background(), tc, tools, nil, nil, nil, nil, events)
+	resp := executeOneTool(context.Background(), tc, tools, nil, nil, nil, false, nil, events)
 
 	if resp.IsError {
 		t.Fatalf("unexpected error: %s", resp.Content)
@@ -121,7 +121,7 @@ func TestCheckPermissions_RunsBeforeValidateInput(t *testing.T) {
 
 	events := make(chan AgentEvent, 10)
 	tc := ToolCall{ID: "1", Name: "ordered", Input: json.RawMessage(`{}`)}
-	resp := executeOneTool(context.Background(), tc, tools, nil, nil, nil, nil, events)
+	resp := executeOneTool(context.Background(), tc, tools, nil, nil, nil, false, nil, events)
 
 	if !resp.IsError {
 		t.Fatal("expected error")
@@ -164,7 +164,7 @@ func TestValidators_RunAfterPreHooks(t *testing.T) {
 
 	events := make(chan AgentEvent, 10)
 	tc := ToolCall{ID: "1", Name: "hooked", Input: json.RawMessage(`{"original":true}`)}
-	resp := executeOneTool(context.Background(), tc, tools, hooks, nil, nil, nil, events)
+	resp := executeOneTool(context.Background(), tc, tools, hooks, nil, nil, false, nil, events)
 
 	if resp.IsError {
 		t.Fatalf("unexpected error: %s", resp.Content)